@@ -13,18 +13,28 @@ var envVarNamePrefix = "DFS_" // so that all env variables start with the same t
 var disableAssertionsEnvVarName = envVarNamePrefix + "DISABLE_ASSERTIONS"
 var defaultDebugLevelEnvVarName = envVarNamePrefix + "DEFAULT_DEBUG_LEVEL"
 
-// e.g, the debug level for the fsraft package is named DFS_FSRAFT_DEBUG_LEVEL
+// e.g, the debug level for the fsraft package is named DFS_FSRAFT_DEBUG_LEVEL,
+// and the one for dfs-server is named DFS_DFS_SERVER_DEBUG_LEVEL
 func packageDebugLevelEnvVarName(pkg string) string {
-	return envVarNamePrefix + strings.ToUpper(pkg) + "_DEBUG_LEVEL"
+	return envVarNamePrefix + strings.ToUpper(strings.Replace(pkg, "-", "_", -1)) + "_DEBUG_LEVEL"
 }
 
 // Package-wide variables =====================================================
 
 var packageNamesToDebugLevels = map[string]int{
 	// -1 = unset
-	"raft":     -1,
-	"fsraft":   -1,
-	"memoryfs": -1,
+	"raft":       -1,
+	"fsraft":     -1,
+	"memoryfs":   -1,
+	"dfs-server": -1,
+	"socketfs":   -1,
+	"ninepfs":    -1,
+	"webdavfs":   -1,
+	"s3fs":       -1,
+	"fusefs":     -1,
+	"dfs-mount":  -1,
+	"adminapi":   -1,
+	"iofs":       -1,
 }
 
 // exported so Raft can use it to skip assertions
@@ -37,11 +47,12 @@ var defaultDebugLevel = RPC
 
 func init() {
 	// Will be automatically run at the beginning of every run.
+	// Everything it prints goes to stderr, so that it doesn't end up in the output of command-line tools.
 
 	AssertionsEnabled = true
 	disableAssertionsVar := os.Getenv(disableAssertionsEnvVarName)
 	if strings.ToLower(disableAssertionsVar) == "true" {
-		fmt.Printf("Disabling assertions because $%v==true\n", disableAssertionsEnvVarName)
+		fmt.Fprintf(os.Stderr, "Disabling assertions because $%v==true\n", disableAssertionsEnvVarName)
 		AssertionsEnabled = false
 	}
 
@@ -52,13 +63,13 @@ func init() {
 			continue
 		}
 		if intValue < 0 || intValue >= len(loggingLevelNames) {
-			fmt.Printf("Environment variable %v tried to set debug level of package %v to %d, but "+
+			fmt.Fprintf(os.Stderr, "Environment variable %v tried to set debug level of package %v to %d, but "+
 				"valid debug levels are 0 through %d inclusive. Ignoring it.\n", envVarName, packageName,
 				intValue, len(loggingLevelNames)-1)
 			continue
 		}
 		packageNamesToDebugLevels[packageName] = intValue
-		fmt.Printf("Setting debug level for package %v to %v through environment variable %v.\n",
+		fmt.Fprintf(os.Stderr, "Setting debug level for package %v to %v through environment variable %v.\n",
 			packageName, debugLevelName(packageNamesToDebugLevels[packageName]), envVarName)
 	}
 
@@ -80,7 +91,7 @@ func init() {
 				explanation = fmt.Sprintf(" because neither %v or %v are set", packageDebugLevelEnvVarName(packageName),
 					defaultDebugLevelEnvVarName)
 			}
-			fmt.Printf("Setting debug level for package %-8v to %v%v.\n",
+			fmt.Fprintf(os.Stderr, "Setting debug level for package %-8v to %v%v.\n",
 				packageName, debugLevelName(packageNamesToDebugLevels[packageName]), explanation)
 		}
 	}
@@ -98,7 +109,7 @@ func envVarIntValueAndIsInt(envVarName string) (intValue int, isInt bool) {
 	}
 	int64Value, err := strconv.ParseInt(envVarValue, 10, 8) // base 10, 8-bit integer
 	if err != nil {
-		fmt.Printf("Tried to parse environment variable %v as a number. Ignoring it.\n",
+		fmt.Fprintf(os.Stderr, "Tried to parse environment variable %v as a number. Ignoring it.\n",
 			envVarName)
 		return 0, false
 	}
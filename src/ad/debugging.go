@@ -2,6 +2,8 @@ package ad
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"path"
 	"runtime"
 	"strings"
@@ -36,7 +38,27 @@ func debugLevelName(level int) string {
 // Make sure that logging a debug message is atomic
 var debugMutex sync.Mutex
 
-// Write some stuff to stdout
+// Where debug messages go. Stdout unless a program calls SetDebugOutput.
+var debugOutput io.Writer = os.Stdout
+
+// Send debug messages to w instead of stdout, e.g. so that a command-line tool can keep its output clean.
+func SetDebugOutput(w io.Writer) {
+	debugMutex.Lock()
+	defer debugMutex.Unlock()
+	debugOutput = w
+}
+
+// Set the debug level of every package, overriding the environment variables.
+func SetDebugLevel(level int) {
+	Assert(level >= NONE && level < len(loggingLevelNames))
+	debugMutex.Lock()
+	defer debugMutex.Unlock()
+	for packageName := range packageNamesToDebugLevels {
+		packageNamesToDebugLevels[packageName] = level
+	}
+}
+
+// Write some stuff to stdout, or wherever SetDebugOutput said to
 func Debug(level int, formatStr string, a ...interface{}) {
 	debugPrivate(level, "", formatStr, a...)
 }
@@ -63,7 +85,7 @@ func debugPrivate(level int, stateStr string, formatStr string, a ...interface{}
 		fileWithPadding := fmt.Sprintf("%12v", file)
 		levelNameWithPadding := fmt.Sprintf("%-5v", levelName)
 
-		fmt.Printf("[%v %v] %v %v:%03d [%v] %v\n", packageWithPadding, levelNameWithPadding, t, fileWithPadding, lineNum, stateStr,
+		fmt.Fprintf(debugOutput, "[%v %v] %v %v:%03d [%v] %v\n", packageWithPadding, levelNameWithPadding, t, fileWithPadding, lineNum, stateStr,
 			fmt.Sprintf(formatStr, a...))
 	}
 }
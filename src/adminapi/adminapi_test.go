@@ -0,0 +1,312 @@
+package adminapi
+
+import (
+	"ad"
+	"filesystem"
+	"fmt"
+	"fsraft"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	if _, isSet := os.LookupEnv("DFS_DEFAULT_DEBUG_LEVEL"); !isSet {
+		ad.SetDebugLevel(ad.WARN)
+	}
+	os.Exit(m.Run())
+}
+
+// Three FileServers, the last of them a learner, each with an admin API in front of it.
+type testCluster struct {
+	*fsraft.TestCluster
+	servers []*httptest.Server
+	clients []*Client
+}
+
+const (
+	nservers = 3
+	learner  = 2
+)
+
+func startTestCluster(t *testing.T) *testCluster {
+	config := fsraft.DefaultFileServerConfig()
+	config.Raft.Learners = []int{learner}
+	cluster := &testCluster{
+		TestCluster: fsraft.StartTestCluster(t, nservers, config),
+		servers:     make([]*httptest.Server, nservers),
+		clients:     make([]*Client, nservers),
+	}
+	for i, fileServer := range cluster.FileServers {
+		cluster.servers[i] = httptest.NewServer(MakeHandler(fileServer))
+		cluster.clients[i] = MakeClient(cluster.servers[i].URL)
+	}
+	return cluster
+}
+
+func (cluster *testCluster) stop() {
+	for _, server := range cluster.servers {
+		server.Close()
+	}
+}
+
+// Write a file through a new clerk, so that there is a session and something in the log, and wait until every
+// server has executed it.
+func (cluster *testCluster) writeFile(t *testing.T, path string, contents string) {
+	clerk := cluster.MakeClerk()
+	fd, err := clerk.Open(path, filesystem.ReadWrite, filesystem.Create)
+	if err != nil {
+		t.Fatalf("Open(%v) failed: %v", path, err)
+	}
+	if _, err := clerk.Write(fd, len(contents), []byte(contents)); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if _, err := clerk.Close(fd); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	leaderStatus, err := cluster.clients[cluster.leader(t)].Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, client := range cluster.clients {
+		cluster.waitFor(t, "the log to be applied", func() bool {
+			status, err := client.Status()
+			return err == nil && status.AppliedIndex >= leaderStatus.AppliedIndex
+		})
+	}
+}
+
+// Wait for every server to agree on a leader, and return it.
+func (cluster *testCluster) leader(t *testing.T) int {
+	leader := -1
+	cluster.waitFor(t, "a leader", func() bool {
+		leader = -1
+		for _, client := range cluster.clients {
+			membership, err := client.Membership()
+			if err != nil || membership.Leader == -1 || (leader != -1 && membership.Leader != leader) {
+				return false
+			}
+			leader = membership.Leader
+		}
+		return true
+	})
+	return leader
+}
+
+func (cluster *testCluster) waitFor(t *testing.T, what string, condition func() bool) {
+	for start := time.Now(); !condition(); time.Sleep(20 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+	}
+}
+
+// Check that err is an *Error with the given status code.
+func checkError(t *testing.T, err error, statusCode int, what string) *Error {
+	apiErr, isAPIError := err.(*Error)
+	if !isAPIError || apiErr.StatusCode != statusCode {
+		t.Fatalf("%v returned %v, expected an error with status %d", what, err, statusCode)
+	}
+	return apiErr
+}
+
+func TestStatusAndMembership(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+
+	leader := cluster.leader(t)
+	for i, client := range cluster.clients {
+		status, err := client.Status()
+		if err != nil {
+			t.Fatalf("Status() from %d failed: %v", i, err)
+		}
+		expectedRole := "Follower"
+		if i == leader {
+			expectedRole = "Leader"
+		} else if i == learner {
+			expectedRole = "Learner"
+		}
+		if status.Id != i || status.Role != expectedRole || status.Leader != leader || status.Peers != nservers {
+			t.Fatalf("server %d returned status %+v, expected id %d, role %v and leader %d", i, status, i,
+				expectedRole, leader)
+		}
+		if (i == leader) != (status.MatchIndex != nil) {
+			t.Fatalf("server %d returned match indices %v", i, status.MatchIndex)
+		}
+
+		membership, err := client.Membership()
+		if err != nil {
+			t.Fatalf("Membership() from %d failed: %v", i, err)
+		}
+		if fmt.Sprint(membership) != fmt.Sprintf("{%d [0 1] [2]}", leader) {
+			t.Fatalf("server %d returned membership %+v", i, membership)
+		}
+	}
+}
+
+func TestSessionsAndUsage(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+
+	for i, client := range cluster.clients {
+		if sessions, err := client.Sessions(); err != nil || len(sessions) != 0 {
+			t.Fatalf("Sessions() from %d returned %v, %v before any clerk did anything", i, sessions, err)
+		}
+	}
+	cluster.writeFile(t, "/a", "hello")
+	cluster.writeFile(t, "/b", "world!")
+
+	for i, client := range cluster.clients {
+		sessions, err := client.Sessions()
+		if err != nil {
+			t.Fatalf("Sessions() from %d failed: %v", i, err)
+		}
+		// Open, Write and Close for each clerk
+		if len(sessions) != 2 || sessions[0].CommandsExecuted != 3 || sessions[1].CommandsExecuted != 3 ||
+			sessions[0].ClerkId >= sessions[1].ClerkId {
+			t.Fatalf("server %d returned sessions %+v", i, sessions)
+		}
+
+		usage, err := client.Usage()
+		if err != nil {
+			t.Fatalf("Usage() from %d failed: %v", i, err)
+		}
+		if usage != (Usage{Inodes: 3, Bytes: 11, OpenFiles: 0}) {
+			t.Fatalf("server %d returned usage %+v", i, usage)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+
+	cluster.writeFile(t, "/a", "hello")
+	for i, client := range cluster.clients {
+		result, err := client.Snapshot()
+		if err != nil {
+			t.Fatalf("Snapshot() on %d failed: %v", i, err)
+		}
+		status, err := client.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Index == 0 || result.Index != status.SnapshotIndex || result.Bytes == 0 || status.SnapshotsWritten != 1 {
+			t.Fatalf("server %d returned %+v from Snapshot() and then status %+v", i, result, status)
+		}
+
+		// nothing has happened since, so another snapshot would be the same one.
+		again, err := client.Snapshot()
+		if err != nil || again != result {
+			t.Fatalf("second Snapshot() on %d returned %+v, %v, expected %+v", i, again, err, result)
+		}
+		if status, _ := client.Status(); status.SnapshotsWritten != 1 {
+			t.Fatalf("server %d wrote %d snapshots, expected 1", i, status.SnapshotsWritten)
+		}
+	}
+}
+
+func TestMembershipChanges(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+
+	cluster.writeFile(t, "/a", "hello")
+	leader := cluster.leader(t)
+	follower := 1 - leader
+
+	apiErr := checkError(t, errorOf(cluster.clients[follower].SetRole(learner, Voter)), http.StatusMisdirectedRequest,
+		"SetRole() on a follower")
+	if apiErr.Leader != leader || !strings.Contains(apiErr.Error(), fmt.Sprintf("the leader is %d", leader)) {
+		t.Fatalf("SetRole() on a follower returned %v with leader %d, expected leader %d", apiErr, apiErr.Leader, leader)
+	}
+	checkError(t, errorOf(cluster.clients[leader].SetRole(learner, "boss")), http.StatusBadRequest, "SetRole() with a bad role")
+	checkError(t, errorOf(cluster.clients[leader].SetRole(nservers, Voter)), http.StatusBadRequest, "SetRole() of a bad peer")
+	checkError(t, errorOf(cluster.clients[leader].SetRole(leader, Learner)), http.StatusConflict, "SetRole() of the leader")
+	checkError(t, errorOf(cluster.clients[leader].TransferLeadership(learner)), http.StatusConflict,
+		"TransferLeadership() to a learner")
+
+	// promoting twice is the same as promoting once.
+	for attempt := 0; attempt < 2; attempt++ {
+		cluster.waitFor(t, "the learner to be promoted", func() bool {
+			membership, err := cluster.clients[leader].SetRole(learner, Voter)
+			if apiErr, isAPIError := err.(*Error); isAPIError && apiErr.StatusCode == http.StatusConflict {
+				return false
+			} else if err != nil {
+				t.Fatalf("SetRole() failed: %v", err)
+			}
+			if fmt.Sprint(membership) != fmt.Sprintf("{%d [0 1 2] []}", leader) {
+				t.Fatalf("SetRole() returned membership %+v", membership)
+			}
+			return true
+		})
+	}
+	cluster.writeFile(t, "/b", "world")
+	cluster.waitFor(t, "the learner to become a follower", func() bool {
+		status, err := cluster.clients[learner].Status()
+		return err == nil && status.Role == "Follower"
+	})
+
+	// the new voter can lead.
+	cluster.waitFor(t, "leadership to be transferred", func() bool {
+		membership, err := cluster.clients[cluster.leader(t)].TransferLeadership(learner)
+		return err == nil && membership.Leader == learner && cluster.leader(t) == learner
+	})
+	if _, err := cluster.clients[learner].TransferLeadership(learner); err != nil {
+		t.Fatalf("TransferLeadership() to the leader failed: %v", err)
+	}
+
+	// and the old leader can be demoted, twice.
+	for attempt := 0; attempt < 2; attempt++ {
+		membership, err := cluster.clients[learner].SetRole(leader, Learner)
+		if err != nil {
+			t.Fatalf("SetRole() failed: %v", err)
+		}
+		if fmt.Sprint(membership.Learners) != fmt.Sprintf("[%d]", leader) {
+			t.Fatalf("SetRole() returned membership %+v", membership)
+		}
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+
+	url := cluster.servers[0].URL
+	for _, request := range []struct {
+		method     string
+		path       string
+		body       string
+		statusCode int
+	}{
+		{http.MethodGet, "/nothing", "", http.StatusNotFound},
+		{http.MethodPost, "/status", "", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/membership", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/snapshot", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/leader", "{", http.StatusBadRequest},
+		{http.MethodPost, "/leader", `{"peer": "one"}`, http.StatusBadRequest},
+		{http.MethodPost, "/membership", `{"peer": 1, "role": "voter", "force": true}`, http.StatusBadRequest},
+	} {
+		httpRequest, err := http.NewRequest(request.method, url+request.path, strings.NewReader(request.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := http.DefaultClient.Do(httpRequest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != request.statusCode ||
+			response.Header.Get("Content-Type") != "application/json" {
+			t.Fatalf("%v %v %q returned %v with content type %q, expected %d", request.method, request.path,
+				request.body, response.Status, response.Header.Get("Content-Type"), request.statusCode)
+		}
+	}
+}
+
+// Drop the result of a Client call and keep its error.
+func errorOf(_ interface{}, err error) error {
+	return err
+}
@@ -0,0 +1,112 @@
+package adminapi
+
+// An HTTP service for operating a cluster, which each dfs-server can run next to its FileServer (see Handler), and a
+// client for it (see Client), which dfsctl uses. Requests and responses are JSON:
+//
+//	GET  /status      Status: this server's Raft peer and FileServer
+//	GET  /membership  Membership: who leads and who votes, as far as this server knows
+//	GET  /sessions    []Session: the clerks this server has executed commands for
+//	GET  /usage       Usage: how much the filesystem holds
+//	POST /snapshot    SnapshotResult: snapshot everything this server has executed
+//	POST /leader      LeaderRequest -> Membership: hand leadership to a peer
+//	POST /membership  MembershipRequest -> Membership: make a peer a voter or a learner
+//
+// Every POST says what the cluster should look like rather than what to do to it, so repeating one that succeeded
+// does nothing and succeeds again. POST /leader and POST /membership only work on the leader; anywhere else they fail
+// with 421 Misdirected Request and an Error naming the leader, if the server knows it. Other failures are 400 for a
+// malformed request, 404 and 405 for unknown paths and methods, 409 when the cluster isn't in a state where the
+// change can be made yet, and 503 when a leadership transfer didn't start.
+
+import (
+	"fmt"
+	"raft"
+)
+
+// The roles in a MembershipRequest and in Membership.
+const (
+	Voter   = "voter"
+	Learner = "learner"
+)
+
+type Status struct {
+	Id                 int    `json:"id"`
+	Role               string `json:"role"` // "Leader", "Candidate", "Follower" or "Learner"
+	Term               int    `json:"term"`
+	Leader             int    `json:"leader"` // -1 if this server doesn't know
+	LeaderChanges      int    `json:"leader_changes"`
+	CommitIndex        int    `json:"commit_index"`
+	LastApplied        int    `json:"last_applied"`
+	SnapshotIndex      int    `json:"snapshot_index"`
+	LastLogIndex       int    `json:"last_log_index"`
+	LogBytes           int    `json:"log_bytes"`
+	Peers              int    `json:"peers"`
+	Learners           []int  `json:"learners"`
+	MatchIndex         []int  `json:"match_index,omitempty"` // only on the leader
+	AppliedIndex       int    `json:"applied_index"`         // the last index the FileServer executed
+	PendingOperations  int    `json:"pending_operations"`
+	DuplicateHits      int    `json:"duplicate_hits"`
+	SnapshotsWritten   int    `json:"snapshots_written"`
+	SnapshotsInstalled int    `json:"snapshots_installed"`
+	LastSnapshotBytes  int    `json:"last_snapshot_bytes"`
+}
+
+type Membership struct {
+	Leader   int   `json:"leader"` // -1 if the server doesn't know
+	Voters   []int `json:"voters"`
+	Learners []int `json:"learners"`
+}
+
+type Session struct {
+	ClerkId           int64 `json:"clerk_id,string"` // a string, because JavaScript can't hold every int64
+	CommandsExecuted  int   `json:"commands_executed"`
+	PendingOperations int   `json:"pending_operations"`
+}
+
+type Usage struct {
+	Inodes    int `json:"inodes"` // files and directories, including the root
+	Bytes     int `json:"bytes"`  // in files
+	OpenFiles int `json:"open_files"`
+}
+
+type SnapshotResult struct {
+	Index int `json:"index"` // the last index in this server's snapshot
+	Bytes int `json:"bytes"` // the size of the last snapshot this server wrote or installed
+}
+
+type LeaderRequest struct {
+	Peer int `json:"peer"`
+}
+
+type MembershipRequest struct {
+	Peer int    `json:"peer"`
+	Role string `json:"role"` // Voter or Learner
+}
+
+// What a failed request returns.
+type Error struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"error"`
+	Leader     int    `json:"leader"` // -1 if the server doesn't know
+}
+
+func (err *Error) Error() string {
+	if err.StatusCode == 421 && err.Leader != -1 {
+		return fmt.Sprintf("%v (the leader is %d)", err.Message, err.Leader)
+	}
+	return err.Message
+}
+
+// Work out the Membership from a Raft status.
+func membershipFor(status raft.Status) Membership {
+	membership := Membership{Leader: status.Leader, Voters: make([]int, 0), Learners: status.Learners}
+	isLearner := make(map[int]bool)
+	for _, learner := range status.Learners {
+		isLearner[learner] = true
+	}
+	for peer := 0; peer < status.Peers; peer++ {
+		if !isLearner[peer] {
+			membership.Voters = append(membership.Voters, peer)
+		}
+	}
+	return membership
+}
@@ -0,0 +1,110 @@
+package adminapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Talks to the admin API of one server. Errors the server returns are *Error, so a caller that asked the wrong server
+// to change the cluster can find the leader in them.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// How long to wait for a server. A leadership transfer can take a couple of election timeouts.
+const clientTimeout = 10 * time.Second
+
+// Make a client for the server whose admin API listens on address, which is host:port or a URL.
+func MakeClient(address string) *Client {
+	baseURL := address
+	if !strings.Contains(address, "://") {
+		baseURL = "http://" + address
+	}
+	return &Client{strings.TrimSuffix(baseURL, "/"), &http.Client{Timeout: clientTimeout}}
+}
+
+func (client *Client) Status() (Status, error) {
+	var status Status
+	err := client.call(http.MethodGet, "/status", nil, &status)
+	return status, err
+}
+
+func (client *Client) Membership() (Membership, error) {
+	var membership Membership
+	err := client.call(http.MethodGet, "/membership", nil, &membership)
+	return membership, err
+}
+
+func (client *Client) Sessions() ([]Session, error) {
+	var sessions []Session
+	err := client.call(http.MethodGet, "/sessions", nil, &sessions)
+	return sessions, err
+}
+
+func (client *Client) Usage() (Usage, error) {
+	var usage Usage
+	err := client.call(http.MethodGet, "/usage", nil, &usage)
+	return usage, err
+}
+
+func (client *Client) Snapshot() (SnapshotResult, error) {
+	var result SnapshotResult
+	err := client.call(http.MethodPost, "/snapshot", nil, &result)
+	return result, err
+}
+
+// Ask the server, which must be the leader, to hand leadership to peer.
+func (client *Client) TransferLeadership(peer int) (Membership, error) {
+	var membership Membership
+	err := client.call(http.MethodPost, "/leader", LeaderRequest{peer}, &membership)
+	return membership, err
+}
+
+// Ask the server, which must be the leader, to make peer a Voter or a Learner.
+func (client *Client) SetRole(peer int, role string) (Membership, error) {
+	var membership Membership
+	err := client.call(http.MethodPost, "/membership", MembershipRequest{peer, role}, &membership)
+	return membership, err
+}
+
+func (client *Client) call(method string, path string, request interface{}, response interface{}) error {
+	var body io.Reader
+	if request != nil {
+		encoded, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	httpRequest, err := http.NewRequest(method, client.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if request != nil {
+		httpRequest.Header.Set("Content-Type", "application/json")
+	}
+	httpResponse, err := client.httpClient.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+
+	decoder := json.NewDecoder(httpResponse.Body)
+	if httpResponse.StatusCode != http.StatusOK {
+		apiErr := &Error{StatusCode: httpResponse.StatusCode, Leader: -1}
+		if err := decoder.Decode(apiErr); err != nil || apiErr.Message == "" {
+			apiErr.Message = fmt.Sprintf("%v %v: %v", method, path, httpResponse.Status)
+		}
+		return apiErr
+	}
+	if err := decoder.Decode(response); err != nil {
+		return fmt.Errorf("%v %v: malformed response: %v", method, path, err)
+	}
+	return nil
+}
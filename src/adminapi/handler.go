@@ -0,0 +1,187 @@
+package adminapi
+
+import (
+	"ad"
+	"encoding/json"
+	"fmt"
+	"fsraft"
+	"net/http"
+	"raft"
+)
+
+// Serves the admin API (see api.go) for one FileServer.
+// There's no access control, so only listen on an address that operators, and nobody else, can reach.
+type Handler struct {
+	fs *fsraft.FileServer
+}
+
+func MakeHandler(fs *fsraft.FileServer) *Handler {
+	return &Handler{fs}
+}
+
+// The most a request body can hold. Every request is a few small numbers.
+const maxRequestBytes = 4096
+
+// The HTTP status codes for the errors from changing the cluster.
+var raftErrorsToStatusCodes = map[error]int{
+	raft.ErrNotLeader:                  http.StatusMisdirectedRequest,
+	raft.ErrNoSuchPeer:                 http.StatusBadRequest,
+	raft.ErrLeaderCannotBeDemoted:      http.StatusConflict,
+	raft.ErrMembershipChangeInProgress: http.StatusConflict,
+	raft.ErrNotCaughtUp:                http.StatusConflict,
+	raft.ErrPeerIsLearner:              http.StatusConflict,
+	raft.ErrTransferFailed:             http.StatusServiceUnavailable,
+}
+
+func (handler *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	ad.Debug(ad.TRACE, "%v %v", request.Method, request.URL.Path)
+	response, err := handler.route(request)
+	if err != nil {
+		apiErr, isAPIError := err.(*Error)
+		if !isAPIError {
+			apiErr = &Error{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+			if statusCode, found := raftErrorsToStatusCodes[err]; found {
+				apiErr.StatusCode = statusCode
+			}
+		}
+		apiErr.Leader = handler.fs.Raft().Status().Leader
+		ad.Debug(ad.RPC, "%v %v failed with %d: %v", request.Method, request.URL.Path, apiErr.StatusCode, apiErr.Message)
+		writeJSON(writer, apiErr.StatusCode, apiErr)
+		return
+	}
+	writeJSON(writer, http.StatusOK, response)
+}
+
+func (handler *Handler) route(request *http.Request) (interface{}, error) {
+	switch request.URL.Path {
+	case "/status":
+		if err := checkMethod(request, http.MethodGet); err != nil {
+			return nil, err
+		}
+		return statusFor(handler.fs.Stats()), nil
+
+	case "/membership":
+		switch request.Method {
+		case http.MethodGet:
+			return membershipFor(handler.fs.Raft().Status()), nil
+		case http.MethodPost:
+			var membershipRequest MembershipRequest
+			if err := readJSON(request, &membershipRequest); err != nil {
+				return nil, err
+			}
+			return handler.setRole(membershipRequest)
+		}
+		return nil, checkMethod(request, http.MethodGet, http.MethodPost)
+
+	case "/sessions":
+		if err := checkMethod(request, http.MethodGet); err != nil {
+			return nil, err
+		}
+		sessions := make([]Session, 0)
+		for _, session := range handler.fs.Sessions() {
+			sessions = append(sessions, Session{session.ClerkId, session.CommandsExecuted, session.PendingOperations})
+		}
+		return sessions, nil
+
+	case "/usage":
+		if err := checkMethod(request, http.MethodGet); err != nil {
+			return nil, err
+		}
+		stats := handler.fs.Stats()
+		return Usage{stats.Inodes, stats.FileBytes, stats.OpenFiles}, nil
+
+	case "/snapshot":
+		if err := checkMethod(request, http.MethodPost); err != nil {
+			return nil, err
+		}
+		index := handler.fs.ForceSnapshot()
+		ad.Debug(ad.RPC, "Snapshotted up to index %d", index)
+		return SnapshotResult{index, handler.fs.Stats().LastSnapshotBytes}, nil
+
+	case "/leader":
+		if err := checkMethod(request, http.MethodPost); err != nil {
+			return nil, err
+		}
+		var leaderRequest LeaderRequest
+		if err := readJSON(request, &leaderRequest); err != nil {
+			return nil, err
+		}
+		if err := handler.fs.TransferLeadership(leaderRequest.Peer); err != nil {
+			return nil, err
+		}
+		ad.Debug(ad.RPC, "Transferred leadership to %d", leaderRequest.Peer)
+		return membershipFor(handler.fs.Raft().Status()), nil
+	}
+	return nil, &Error{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("no such path %v", request.URL.Path)}
+}
+
+func (handler *Handler) setRole(request MembershipRequest) (interface{}, error) {
+	var err error
+	switch request.Role {
+	case Voter:
+		err = handler.fs.Promote(request.Peer)
+	case Learner:
+		err = handler.fs.Demote(request.Peer)
+	default:
+		return nil, &Error{StatusCode: http.StatusBadRequest,
+			Message: fmt.Sprintf("role must be %q or %q, not %q", Voter, Learner, request.Role)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	ad.Debug(ad.RPC, "Made %d a %v", request.Peer, request.Role)
+	return membershipFor(handler.fs.Raft().Status()), nil
+}
+
+func statusFor(stats fsraft.Stats) Status {
+	return Status{
+		Id:                 stats.Me,
+		Role:               stats.Raft.Role.String(),
+		Term:               stats.Raft.Term,
+		Leader:             stats.Raft.Leader,
+		LeaderChanges:      stats.Raft.LeaderChanges,
+		CommitIndex:        stats.Raft.CommitIndex,
+		LastApplied:        stats.Raft.LastApplied,
+		SnapshotIndex:      stats.Raft.SnapshotIndex,
+		LastLogIndex:       stats.Raft.LastLogIndex,
+		LogBytes:           stats.Raft.LogSizeBytes,
+		Peers:              stats.Raft.Peers,
+		Learners:           stats.Raft.Learners,
+		MatchIndex:         stats.Raft.MatchIndex,
+		AppliedIndex:       stats.AppliedIndex,
+		PendingOperations:  stats.PendingOperations,
+		DuplicateHits:      stats.DuplicateHits,
+		SnapshotsWritten:   stats.SnapshotsWritten,
+		SnapshotsInstalled: stats.SnapshotsInstalled,
+		LastSnapshotBytes:  stats.LastSnapshotBytes,
+	}
+}
+
+func checkMethod(request *http.Request, allowed ...string) error {
+	for _, method := range allowed {
+		if request.Method == method {
+			return nil
+		}
+	}
+	return &Error{StatusCode: http.StatusMethodNotAllowed,
+		Message: fmt.Sprintf("%v %v is not allowed", request.Method, request.URL.Path)}
+}
+
+func readJSON(request *http.Request, value interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, request.Body, maxRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		return &Error{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf("malformed request: %v", err)}
+	}
+	return nil
+}
+
+func writeJSON(writer http.ResponseWriter, statusCode int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(statusCode)
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		ad.Debug(ad.WARN, "Couldn't write a response: %v", err)
+	}
+}
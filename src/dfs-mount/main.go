@@ -0,0 +1,85 @@
+//go:build linux
+
+package main
+
+// dfs-mount mounts a cluster of FileServers, such as one started with dfs-server, with FUSE.
+//
+// Usage:
+//
+//	dfs-mount -config cluster.conf [-entry-timeout 1s] [-attr-timeout 1s] /mnt/dfs
+//
+// It has to run as root (see fusefs.Mount), and serves the mount through one clerk until it is unmounted with
+// umount(8), or until SIGTERM or SIGINT, which unmount it. The timeouts say how long the kernel may cache names and
+// attributes before asking again, so they bound how long it takes to see changes that other clients make. Logging
+// goes through package ad; set DFS_DFS_MOUNT_DEBUG_LEVEL and friends to change how much.
+
+import (
+	"ad"
+	"flag"
+	"fmt"
+	"fsraft"
+	"fusefs"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// Serve the mount until it is unmounted, and return the exit status.
+func run(args []string) int {
+	config := fusefs.DefaultConfig()
+	flags := flag.NewFlagSet("dfs-mount", flag.ContinueOnError)
+	configPath := flags.String("config", "", "the cluster config file (required)")
+	flags.DurationVar(&config.EntryTimeout, "entry-timeout", config.EntryTimeout,
+		"how long the kernel may remember what a name refers to")
+	flags.DurationVar(&config.AttrTimeout, "attr-timeout", config.AttrTimeout,
+		"how long the kernel may remember a file's size and type")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *configPath == "" || flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: dfs-mount -config cluster.conf [flags] <mountpoint>")
+		flags.PrintDefaults()
+		return 2
+	}
+	if config.EntryTimeout < 0 || config.AttrTimeout < 0 {
+		fmt.Fprintln(os.Stderr, "dfs-mount: the timeouts can't be negative")
+		return 2
+	}
+	mountpoint := flags.Arg(0)
+
+	cluster, err := fsraft.ReadClusterConfig(*configPath)
+	if err != nil {
+		return fail("Couldn't read the cluster config: %v", err)
+	}
+	server, err := fusefs.Mount(mountpoint, fsraft.MakeFsClerk(cluster.MakeEnds()), config)
+	if err != nil {
+		return fail("Couldn't mount %v: %v", mountpoint, err)
+	}
+	ad.Debug(ad.RPC, "Mounted the cluster at %v", mountpoint)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		received := <-signals
+		ad.Debug(ad.RPC, "Got %v, unmounting", received)
+		if err := fusefs.Unmount(mountpoint); err != nil {
+			ad.Debug(ad.WARN, "Couldn't unmount %v: %v", mountpoint, err)
+		}
+	}()
+	if err := server.Wait(); err != nil {
+		return fail("Stopped serving %v: %v", mountpoint, err)
+	}
+	ad.Debug(ad.RPC, "Unmounted %v", mountpoint)
+	return 0
+}
+
+// Report an error that stops the mount from being served and return the exit status for it.
+func fail(format string, a ...interface{}) int {
+	ad.Debug(ad.WARN, format, a...)
+	fmt.Fprintf(os.Stderr, "dfs-mount: "+format+"\n", a...)
+	return 1
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package main
+
+import (
+	"ad"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	if _, isSet := os.LookupEnv("DFS_DEFAULT_DEBUG_LEVEL"); !isSet {
+		ad.SetDebugLevel(ad.NONE)
+	}
+	os.Exit(m.Run())
+}
+
+func TestBadArguments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfs-mount-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "cluster.conf")
+	if err := ioutil.WriteFile(configPath, []byte("0 127.0.0.1:1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		args   []string
+		status int
+	}{
+		{[]string{}, 2},
+		{[]string{"-config", configPath}, 2},
+		{[]string{"-config", configPath, "-attr-timeout", "-1s", dir}, 2},
+		{[]string{"-config", "/does/not/exist", dir}, 1},
+		{[]string{"-config", configPath, filepath.Join(dir, "missing")}, 1},
+	} {
+		if status := run(test.args); status != test.status {
+			t.Errorf("dfs-mount %v returned %d, expected %d", strings.Join(test.args, " "), status, test.status)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+// dfs-server runs one FileServer of a cluster, talking to its peers and to clerks over TCP.
+//
+// Usage:
+//
+//	dfs-server -config cluster.conf -id 0 -data /var/lib/dfs/0 [flags]
+//
+// The cluster config lists every peer's id and address (see fsraft.ClusterConfig), and the server listens on the
+// address of its own id. Raft's state and the snapshots are kept in the data directory, so a server that is
+// stopped and started again with the same directory picks up where it left off. With -disk-log, Raft's log is also
+// kept in a scratch file there rather than in memory (see raft.LogFile). The other flags (see -help) serve
+// the cluster over more protocols and set limits, which must match across the cluster. SIGTERM or SIGINT stops the
+// server cleanly. Logging goes through package ad; set DFS_DFS_SERVER_DEBUG_LEVEL and friends to change how much.
+
+import (
+	"ad"
+	"adminapi"
+	"context"
+	"filesystem"
+	"flag"
+	"fmt"
+	"fsraft"
+	"labrpc"
+	"net/http"
+	"ninepfs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"raft"
+	"s3fs"
+	"socketfs"
+	"syscall"
+	"tcprpc"
+	"time"
+	"webdavfs"
+)
+
+// How long to wait, on SIGTERM, for each HTTP server's requests in progress to finish.
+const httpShutdownTimeout = 5 * time.Second
+
+// The name of the file in the data directory that -disk-log keeps Raft's log in.
+const logFileName = "raft-log"
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// Run the server until it is signalled to stop, and return the exit status.
+func run(args []string) int {
+	flags := flag.NewFlagSet("dfs-server", flag.ContinueOnError)
+	configPath := flags.String("config", "", "the cluster config file (required)")
+	id := flags.Int("id", -1, "this server's id in the cluster config (required)")
+	dataDir := flags.String("data", "", "the directory to keep Raft's state and snapshots in (required)")
+	metricsAddress := flags.String("metrics", "", "serve Prometheus metrics on this address at /metrics, e.g. :9100")
+	socketAddress := flags.String("socket", "", "serve the socketfs protocol on this address, e.g. :7000")
+	ninePAddress := flags.String("9p", "", "serve 9P2000.L on this address, e.g. :5640")
+	webdavAddress := flags.String("webdav", "", "serve files over HTTP and WebDAV on this address, e.g. :8080")
+	s3Address := flags.String("s3", "", "serve an S3-compatible API on this address, e.g. :9000")
+	adminAddress := flags.String("admin", "", "serve the admin API (no access control) on this address, e.g. 127.0.0.1:9200")
+	diskLog := flags.Bool("disk-log", false, "keep Raft's log in a file in the data directory rather than in memory")
+	maxRaftState := flags.Int("max-raft-state", -1, "snapshot when Raft's state grows this many bytes, -1 for never")
+	maxBytes := flags.Int("max-bytes", 0, "the most bytes the files may hold altogether, 0 for no limit")
+	maxInodes := flags.Int("max-inodes", 0, "the most files and directories there may be, 0 for no limit")
+	maxFileSize := flags.Int("max-file-size", 0, "the most bytes any one file may hold, 0 for no limit")
+	maxDirEntries := flags.Int("max-dir-entries", 0, "the most entries any one directory may hold, 0 for no limit")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *configPath == "" || *id == -1 || *dataDir == "" {
+		fmt.Fprintln(os.Stderr, "dfs-server: -config, -id and -data are required")
+		flags.Usage()
+		return 2
+	}
+
+	cluster, err := fsraft.ReadClusterConfig(*configPath)
+	if err != nil {
+		return fail("Couldn't read the cluster config: %v", err)
+	}
+	if *id < 0 || *id >= len(cluster.Addresses) {
+		return fail("Id %d is not in the cluster config, which has ids 0 through %d", *id, len(cluster.Addresses)-1)
+	}
+
+	persister, err := raft.MakeFilePersister(*dataDir)
+	if err != nil {
+		return fail("Couldn't open the data directory %v: %v", *dataDir, err)
+	}
+	rpcServer := labrpc.MakeServer()
+	listener, err := tcprpc.Listen(cluster.Addresses[*id], rpcServer)
+	if err != nil {
+		return fail("Couldn't listen on %v: %v", cluster.Addresses[*id], err)
+	}
+
+	config := fsraft.DefaultFileServerConfig()
+	config.Raft.Learners = cluster.Learners
+	var logFile *raft.LogFile
+	if *diskLog {
+		logFile, err = raft.MakeLogFile(filepath.Join(*dataDir, logFileName))
+		if err != nil {
+			listener.Close()
+			persister.Close()
+			return fail("Couldn't make the log file in %v: %v", *dataDir, err)
+		}
+		config.Raft.Log = logFile
+	}
+	config.MaxRaftState = *maxRaftState
+	config.Limits = filesystem.Limits{MaxBytes: *maxBytes, MaxInodes: *maxInodes, MaxFileSize: *maxFileSize,
+		MaxDirEntries: *maxDirEntries}
+	if err := config.Validate(); err != nil {
+		closeLogFile(logFile)
+		listener.Close()
+		persister.Close()
+		return fail("Invalid settings: %v", err)
+	}
+	fileServer := fsraft.StartFileServer(cluster.MakeEnds(), *id, persister, config)
+	rpcServer.AddService(labrpc.MakeService(fileServer))
+	rpcServer.AddService(labrpc.MakeService(fileServer.Raft()))
+	ad.Debug(ad.RPC, "Server %d of %d is listening on %v with data in %v", *id, len(cluster.Addresses),
+		listener.Addr(), *dataDir)
+
+	var gateway *socketfs.Gateway
+	if *socketAddress != "" {
+		gateway, err = socketfs.Listen(*socketAddress, func() filesystem.FileSystem {
+			return fsraft.MakeFsClerk(cluster.MakeEnds())
+		})
+		if err != nil {
+			listener.Close()
+			fileServer.Kill()
+			closeLogFile(logFile)
+			persister.Close()
+			return fail("Couldn't serve the socket protocol on %v: %v", *socketAddress, err)
+		}
+		ad.Debug(ad.RPC, "Serving the socket protocol on %v", gateway.Addr())
+	}
+
+	var ninePServer *ninepfs.Server
+	if *ninePAddress != "" {
+		ninePServer, err = ninepfs.Listen(*ninePAddress, func() filesystem.FileSystem {
+			return fsraft.MakeFsClerk(cluster.MakeEnds())
+		})
+		if err != nil {
+			if gateway != nil {
+				gateway.Close()
+			}
+			listener.Close()
+			fileServer.Kill()
+			closeLogFile(logFile)
+			persister.Close()
+			return fail("Couldn't serve 9P on %v: %v", *ninePAddress, err)
+		}
+		ad.Debug(ad.RPC, "Serving 9P on %v", ninePServer.Addr())
+	}
+
+	// the HTTP servers start last, so that if the ones above fail to start, there are none to shut down.
+	var httpServers []*http.Server
+	if *metricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", fsraft.MakeMetricsExporter(fileServer, rpcServer))
+		httpServers = append(httpServers, serveHTTP(*metricsAddress, mux, "metrics"))
+	}
+	if *adminAddress != "" {
+		httpServers = append(httpServers, serveHTTP(*adminAddress, adminapi.MakeHandler(fileServer), "the admin API"))
+	}
+	if *webdavAddress != "" {
+		handler := webdavfs.MakeHandler(fsraft.MakeFsClerk(cluster.MakeEnds()))
+		httpServers = append(httpServers, serveHTTP(*webdavAddress, handler, "WebDAV"))
+	}
+	if *s3Address != "" {
+		handler := s3fs.MakeHandler(fsraft.MakeFsClerk(cluster.MakeEnds()))
+		httpServers = append(httpServers, serveHTTP(*s3Address, handler, "S3"))
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	received := <-signals
+	ad.Debug(ad.RPC, "Got %v, shutting down", received)
+
+	// stop taking requests first, so nothing new starts while Raft stops, and then close the files it writes to.
+	for _, server := range httpServers {
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		if err := server.Shutdown(ctx); err != nil {
+			ad.Debug(ad.WARN, "Couldn't shut down the HTTP server on %v cleanly: %v", server.Addr, err)
+		}
+		cancel()
+	}
+	if gateway != nil {
+		gateway.Close()
+	}
+	if ninePServer != nil {
+		ninePServer.Close()
+	}
+	listener.Close()
+	fileServer.Kill()
+	closeLogFile(logFile)
+	if err := persister.Close(); err != nil {
+		return fail("Couldn't close the data directory: %v", err)
+	}
+	ad.Debug(ad.RPC, "Shut down cleanly")
+	return 0
+}
+
+// Serve handler over HTTP on address in the background, and return the server so that it can be shut down.
+// what names the service in the log if the server stops on its own.
+func serveHTTP(address string, handler http.Handler, what string) *http.Server {
+	server := &http.Server{Addr: address, Handler: handler}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			ad.Debug(ad.WARN, "Stopped serving %v on %v: %v", what, address, err)
+		}
+	}()
+	return server
+}
+
+// Close the -disk-log file, if there is one. It's scratch space, so there's nothing to lose if closing it fails.
+func closeLogFile(logFile *raft.LogFile) {
+	if logFile != nil {
+		logFile.Close()
+	}
+}
+
+// Report an error that stops the server from running and return the exit status for it.
+func fail(format string, a ...interface{}) int {
+	ad.Debug(ad.WARN, format, a...)
+	fmt.Fprintf(os.Stderr, "dfs-server: "+format+"\n", a...)
+	return 1
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"filesystem"
+	"fmt"
+	"fsraft"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Set in the environment of the daemons the tests start, which are copies of the test binary.
+const runServerEnvVarName = "DFS_TEST_RUN_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runServerEnvVarName) == "true" {
+		os.Exit(run(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// Three daemons on localhost serve a clerk, stop cleanly on SIGTERM, and still have the data when restarted.
+func TestThreeDaemons(t *testing.T) {
+	const nservers = 3
+	dir, err := ioutil.TempDir("", "dfs-server-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "cluster.conf")
+	config := "# id address\n"
+	for id := 0; id < nservers; id++ {
+		config += fmt.Sprintf("%d %v\n", id, freeAddress(t))
+	}
+	if err := ioutil.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cluster, err := fsraft.ReadClusterConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemons := startDaemons(t, dir, configPath, nservers)
+	clerk := fsraft.MakeFsClerk(cluster.MakeEnds())
+	fd, err := clerk.Open("/daemons.txt", filesystem.ReadWrite, filesystem.Create)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := clerk.Write(fd, 5, []byte("hello")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := clerk.Close(fd); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	stopDaemons(t, daemons)
+
+	daemons = startDaemons(t, dir, configPath, nservers)
+	defer stopDaemons(t, daemons)
+	clerk = fsraft.MakeFsClerk(cluster.MakeEnds())
+	fd, err = clerk.Open("/daemons.txt", filesystem.ReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open after restarting failed: %v", err)
+	}
+	// opening a file doesn't move its offset, which the write left at the end
+	if _, err := clerk.Seek(fd, 0, filesystem.FromBeginning); err != nil {
+		t.Fatalf("Seek after restarting failed: %v", err)
+	}
+	if _, data, err := clerk.Read(fd, 100); err != nil || string(data) != "hello" {
+		t.Fatalf("after restarting, read (%q, %v), expected %q", data, err, "hello")
+	}
+	clerk.Close(fd)
+}
+
+// A daemon shuts down its HTTP servers on SIGTERM, freeing their addresses.
+func TestStopHTTPServers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfs-server-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "cluster.conf")
+	if err := ioutil.WriteFile(configPath, []byte("0 "+freeAddress(t)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	metricsAddress, adminAddress := freeAddress(t), freeAddress(t)
+	daemon := runDaemon([]string{"-config", configPath, "-id", "0", "-data", filepath.Join(dir, "data"),
+		"-metrics", metricsAddress, "-admin", adminAddress})
+	if err := daemon.Start(); err != nil {
+		t.Fatalf("couldn't start the server: %v", err)
+	}
+	for start := time.Now(); ; time.Sleep(50 * time.Millisecond) {
+		response, err := http.Get("http://" + metricsAddress + "/metrics")
+		if err == nil {
+			response.Body.Close()
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("the server never served metrics: %v", err)
+		}
+	}
+	stopDaemons(t, []*exec.Cmd{daemon})
+
+	for _, address := range []string{metricsAddress, adminAddress} {
+		if _, err := http.Get("http://" + address + "/"); err == nil {
+			t.Errorf("%v is still being served after SIGTERM", address)
+		}
+	}
+}
+
+func TestBadArguments(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"-config", "/does/not/exist", "-id", "0", "-data", "/tmp"},
+	} {
+		if status := runDaemon(args).Run(); status == nil {
+			t.Fatalf("dfs-server %v succeeded", strings.Join(args, " "))
+		}
+	}
+}
+
+// Return a localhost address that nothing is listening on.
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func runDaemon(args []string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runServerEnvVarName+"=true", "DFS_DEFAULT_DEBUG_LEVEL=1")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+func startDaemons(t *testing.T, dir string, configPath string, n int) []*exec.Cmd {
+	daemons := make([]*exec.Cmd, n)
+	for id := range daemons {
+		dataDir := filepath.Join(dir, fmt.Sprintf("data%d", id))
+		args := []string{"-config", configPath, "-id", fmt.Sprint(id), "-data", dataDir}
+		if id == 0 {
+			// one of them keeps its log on disk, which the others can't tell apart from keeping it in memory
+			args = append(args, "-disk-log")
+		}
+		daemons[id] = runDaemon(args)
+		if err := daemons[id].Start(); err != nil {
+			t.Fatalf("couldn't start server %d: %v", id, err)
+		}
+	}
+	return daemons
+}
+
+// Send SIGTERM to every daemon and make sure each one exits cleanly.
+func stopDaemons(t *testing.T, daemons []*exec.Cmd) {
+	for _, daemon := range daemons {
+		daemon.Process.Signal(syscall.SIGTERM)
+	}
+	for id, daemon := range daemons {
+		exited := make(chan error, 1)
+		go func() { exited <- daemon.Wait() }()
+		select {
+		case err := <-exited:
+			if err != nil {
+				t.Errorf("server %d exited with %v", id, err)
+			}
+		case <-time.After(10 * time.Second):
+			daemon.Process.Kill()
+			t.Errorf("server %d didn't exit after SIGTERM", id)
+		}
+	}
+}
@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bufio"
+	"filesystem"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Exit statuses.
+const (
+	exitOK     = 0
+	exitFailed = 1 // the filesystem returned an error, or a local file couldn't be read or written
+	exitUsage  = 2 // the command line was wrong
+)
+
+// How many bytes cat, get and put move per Read or Write.
+const chunkSize = 64 * 1024
+
+// A filesystem and the fds the shell has open on it.
+type session struct {
+	fs      filesystem.FileSystem
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+	openFDs map[int]string // fd -> path, for the fds opened in the shell and not closed yet
+}
+
+func makeSession(fs filesystem.FileSystem, stdin io.Reader, stdout io.Writer, stderr io.Writer) *session {
+	return &session{fs, stdin, stdout, stderr, make(map[int]string)}
+}
+
+// Returned by a command whose arguments don't make sense. The message explains what is wrong.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+type command struct {
+	name       string
+	args       string // how the arguments are shown in the usage message
+	help       string
+	minArgs    int
+	maxArgs    int
+	restOfLine bool // in the shell, the last argument is the rest of the line, spaces and all
+	shellOnly  bool
+	run        func(sess *session, args []string) error
+}
+
+var commands []command
+
+// Filled in by init because help refers to commands.
+func init() {
+	commands = []command{
+		{"ls", "[path]", "list a directory, marking directories with a trailing /", 0, 1, false, false, (*session).ls},
+		{"mkdir", "<path>", "make a directory", 1, 1, false, false, (*session).mkdir},
+		{"cat", "<path>", "print a file", 1, 1, false, false, (*session).cat},
+		{"put", "<local> <remote>", "copy a local file (or - for stdin) into the cluster, replacing the remote file",
+			2, 2, false, false, (*session).put},
+		{"get", "<remote> <local>", "copy a file out of the cluster into a local file (or - for stdout)",
+			2, 2, false, false, (*session).get},
+		{"rm", "<path>", "delete a file or an empty directory", 1, 1, false, false, (*session).rm},
+		{"stat", "<path>", "describe a file or directory", 1, 1, false, false, (*session).stat},
+		{"tree", "[path]", "list a directory and everything below it", 0, 1, false, false, (*session).tree},
+		{"df", "", "print how much the filesystem holds and how much it may hold", 0, 0, false, false, (*session).df},
+		{"quota", "<path> [<maxBytes> <maxInodes>]", "print a directory's quota and usage, or set its quota (0 for none)",
+			1, 3, false, false, (*session).quota},
+		{"open", "<path> [r|w|rw] [create,append,truncate,block]", "open a file (read-only by default) and print its fd",
+			1, 3, false, true, (*session).open},
+		{"seek", "<fd> <offset> [begin|current|end]", "move an fd's offset and print where it ended up",
+			2, 3, false, true, (*session).seek},
+		{"read", "<fd> <numBytes>", "read from an fd and print what was read", 2, 2, false, true, (*session).read},
+		{"write", "<fd> <text>", "write the rest of the line to an fd", 2, 2, true, true, (*session).write},
+		{"close", "<fd>", "close an fd", 1, 1, false, true, (*session).close},
+		{"help", "", "list the commands", 0, 0, false, true, (*session).help},
+	}
+}
+
+func lookupCommand(name string) (cmd command, found bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// Print a line about each command, including the shell-only ones if inShell.
+func printCommands(w io.Writer, inShell bool) {
+	for _, cmd := range commands {
+		if cmd.shellOnly && !inShell {
+			continue
+		}
+		fmt.Fprintf(w, "  %-50v %v\n", cmd.name+" "+cmd.args, cmd.help)
+	}
+	if inShell {
+		fmt.Fprintf(w, "  %-50v %v\n", "exit", "close every fd that is still open and leave the shell")
+	} else {
+		fmt.Fprintf(w, "  %-50v %v\n", "shell", "read commands from stdin, one per line (try help)")
+	}
+}
+
+// Find the command that words, e.g. {"cat", "/foo"}, asks for, and check that it has the right number of arguments.
+// If it doesn't, explain why on stderr and return found=false.
+func checkCommand(words []string, inShell bool, stderr io.Writer) (cmd command, found bool) {
+	cmd, found = lookupCommand(words[0])
+	if !found {
+		fmt.Fprintf(stderr, "dfs: unknown command %q\n", words[0])
+		return command{}, false
+	}
+	if cmd.shellOnly && !inShell {
+		fmt.Fprintf(stderr, "dfs: %v only works in the shell, since its fd would be closed when dfs exits\n",
+			cmd.name)
+		return command{}, false
+	}
+	if numArgs := len(words) - 1; numArgs < cmd.minArgs || numArgs > cmd.maxArgs {
+		fmt.Fprintf(stderr, "dfs: usage: %v %v\n", cmd.name, cmd.args)
+		return command{}, false
+	}
+	return cmd, true
+}
+
+// Run a command given as words, e.g. {"cat", "/foo"}, print any error, and return the exit status.
+func (sess *session) runCommand(words []string, inShell bool) int {
+	cmd, found := checkCommand(words, inShell, sess.stderr)
+	if !found {
+		return exitUsage
+	}
+
+	err := cmd.run(sess, words[1:])
+	switch err := err.(type) {
+	case nil:
+		return exitOK
+	case usageError:
+		fmt.Fprintf(sess.stderr, "dfs: %v: %v (usage: %v %v)\n", cmd.name, err, cmd.name, cmd.args)
+		return exitUsage
+	default:
+		// the text written by write could be long, so leave it out
+		if cmd.restOfLine {
+			words = words[:len(words)-1]
+		}
+		fmt.Fprintf(sess.stderr, "dfs: %v: %v\n", strings.Join(words, " "), err)
+		return exitFailed
+	}
+}
+
+// Run commands from stdin until it ends or says exit, then close the fds that are still open.
+// Returns exitFailed if any command failed.
+func (sess *session) runShell() int {
+	status := exitOK
+	scanner := bufio.NewScanner(sess.stdin)
+	scanner.Buffer(make([]byte, 0, chunkSize), 16*chunkSize)
+	for sess.prompt(); scanner.Scan(); sess.prompt() {
+		words := strings.Fields(scanner.Text())
+		if len(words) == 0 {
+			continue
+		}
+		if words[0] == "exit" || words[0] == "quit" {
+			break
+		}
+		if cmd, found := lookupCommand(words[0]); found && cmd.restOfLine {
+			words = splitFields(scanner.Text(), cmd.maxArgs+1)
+		}
+		if sess.runCommand(words, true) != exitOK {
+			status = exitFailed
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(sess.stderr, "dfs: couldn't read stdin: %v\n", err)
+		status = exitFailed
+	}
+
+	fds := make([]int, 0, len(sess.openFDs))
+	for fd := range sess.openFDs {
+		fds = append(fds, fd)
+	}
+	sort.Ints(fds)
+	for _, fd := range fds {
+		if sess.runCommand([]string{"close", strconv.Itoa(fd)}, true) != exitOK {
+			status = exitFailed
+		}
+	}
+	return status
+}
+
+// Print a prompt, but only when someone is typing.
+func (sess *session) prompt() {
+	if file, isFile := sess.stdin.(*os.File); isFile {
+		if info, err := file.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprint(sess.stdout, "dfs> ")
+		}
+	}
+}
+
+// Split line into at most n whitespace-separated fields, where the last field is the rest of the line.
+func splitFields(line string, n int) []string {
+	fields := make([]string, 0, n)
+	rest := strings.TrimLeft(line, " \t")
+	for len(rest) > 0 && len(fields) < n-1 {
+		end := strings.IndexAny(rest, " \t")
+		if end < 0 {
+			end = len(rest)
+		}
+		fields = append(fields, rest[:end])
+		rest = strings.TrimLeft(rest[end:], " \t")
+	}
+	if len(rest) > 0 {
+		fields = append(fields, rest)
+	}
+	return fields
+}
+
+// Commands ============================================================================================================
+
+func (sess *session) ls(args []string) error {
+	dirPath := "/"
+	if len(args) > 0 {
+		dirPath = args[0]
+	}
+	entries, err := sess.fs.ReadDir(dirPath)
+	if err == filesystem.NotFound {
+		// like ls, list a file as itself
+		if info, statErr := sess.fs.Stat(dirPath); statErr == nil && !info.IsDir {
+			entries, err = []filesystem.FileInfo{info}, nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fmt.Fprintln(sess.stdout, displayName(entry))
+	}
+	return nil
+}
+
+func (sess *session) mkdir(args []string) error {
+	_, err := sess.fs.Mkdir(args[0])
+	return err
+}
+
+func (sess *session) cat(args []string) error {
+	return sess.copyOut(args[0], sess.stdout)
+}
+
+func (sess *session) put(args []string) (err error) {
+	var source io.Reader = sess.stdin
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		source = file
+	}
+
+	fd, err := sess.fs.Open(args[1], filesystem.WriteOnly, filesystem.Create|filesystem.Truncate)
+	if err != nil {
+		return err
+	}
+	defer sess.closeAndKeepFirstError(fd, &err)
+
+	buffer := make([]byte, chunkSize)
+	for {
+		n, readErr := source.Read(buffer)
+		if n > 0 {
+			bytesWritten, err := sess.fs.Write(fd, n, buffer[:n])
+			if err != nil {
+				return err
+			}
+			if bytesWritten != n {
+				return io.ErrShortWrite
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		} else if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+func (sess *session) get(args []string) error {
+	if args[1] == "-" {
+		return sess.copyOut(args[0], sess.stdout)
+	}
+	file, err := os.Create(args[1])
+	if err != nil {
+		return err
+	}
+	err = sess.copyOut(args[0], file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// don't leave half a file behind
+		os.Remove(args[1])
+	}
+	return err
+}
+
+func (sess *session) rm(args []string) error {
+	_, err := sess.fs.Delete(args[0])
+	return err
+}
+
+func (sess *session) stat(args []string) error {
+	info, err := sess.fs.Stat(args[0])
+	if err != nil {
+		return err
+	}
+	kind := "file"
+	if info.IsDir {
+		kind = "directory"
+	}
+	fmt.Fprintf(sess.stdout, "name: %v\ntype: %v\nsize: %d\nopen: %t\n", info.Name, kind, info.Size, info.IsOpen)
+	return nil
+}
+
+func (sess *session) df(args []string) error {
+	info, err := sess.fs.Statfs()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sess.stdout, "bytes: %d of %v\ninodes: %d of %v\nmax file size: %v\nmax directory entries: %v\n",
+		info.Bytes, describeLimit(info.Limits.MaxBytes), info.Inodes, describeLimit(info.Limits.MaxInodes),
+		describeLimit(info.Limits.MaxFileSize), describeLimit(info.Limits.MaxDirEntries))
+	return nil
+}
+
+func (sess *session) quota(args []string) error {
+	if len(args) == 2 {
+		return usageError("give both maxBytes and maxInodes to set a quota")
+	}
+	if len(args) == 3 {
+		maxBytes, err := parseInt("maxBytes", args[1])
+		if err != nil {
+			return err
+		}
+		maxInodes, err := parseInt("maxInodes", args[2])
+		if err != nil {
+			return err
+		}
+		_, err = sess.fs.SetQuota(args[0], maxBytes, maxInodes)
+		return err
+	}
+	quota, err := sess.fs.GetQuota(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sess.stdout, "bytes: %d of %v\ninodes: %d of %v\n", quota.Bytes, describeLimit(quota.MaxBytes),
+		quota.Inodes, describeLimit(quota.MaxInodes))
+	return nil
+}
+
+// A limit from filesystem.Limits or a quota, which is 0 if there is none.
+func describeLimit(limit int) string {
+	if limit == 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(limit)
+}
+
+func (sess *session) tree(args []string) error {
+	dirPath := "/"
+	if len(args) > 0 {
+		dirPath = args[0]
+	}
+	if _, err := sess.fs.ReadDir(dirPath); err != nil {
+		return err
+	}
+	fmt.Fprintln(sess.stdout, dirPath)
+	numDirs, numFiles, err := sess.printTree(dirPath, "")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sess.stdout, "\n%d directories, %d files\n", numDirs, numFiles)
+	return nil
+}
+
+// Print everything below dirPath, with prefix in front of every line, and count what was printed.
+func (sess *session) printTree(dirPath string, prefix string) (numDirs int, numFiles int, err error) {
+	entries, err := sess.fs.ReadDir(dirPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	for i, entry := range entries {
+		branch, indent := "├── ", "│   "
+		if i == len(entries)-1 {
+			branch, indent = "└── ", "    "
+		}
+		fmt.Fprintln(sess.stdout, prefix+branch+displayName(entry))
+		if !entry.IsDir {
+			numFiles++
+			continue
+		}
+		numDirs++
+		childDirs, childFiles, err := sess.printTree(path.Join(dirPath, entry.Name), prefix+indent)
+		if err != nil {
+			return 0, 0, err
+		}
+		numDirs += childDirs
+		numFiles += childFiles
+	}
+	return numDirs, numFiles, nil
+}
+
+func (sess *session) open(args []string) error {
+	mode := filesystem.ReadOnly
+	if len(args) > 1 {
+		switch args[1] {
+		case "r":
+			mode = filesystem.ReadOnly
+		case "w":
+			mode = filesystem.WriteOnly
+		case "rw":
+			mode = filesystem.ReadWrite
+		default:
+			return usageError(fmt.Sprintf("%q is not r, w or rw", args[1]))
+		}
+	}
+	var flags filesystem.OpenFlags
+	if len(args) > 2 {
+		for _, flagName := range strings.Split(args[2], ",") {
+			switch flagName {
+			case "create":
+				flags |= filesystem.Create
+			case "append":
+				flags |= filesystem.Append
+			case "truncate":
+				flags |= filesystem.Truncate
+			case "block":
+				flags |= filesystem.Block
+			default:
+				return usageError(fmt.Sprintf("%q is not create, append, truncate or block", flagName))
+			}
+		}
+	}
+
+	fd, err := sess.fs.Open(args[0], mode, flags)
+	if err != nil {
+		return err
+	}
+	sess.openFDs[fd] = args[0]
+	fmt.Fprintln(sess.stdout, fd)
+	return nil
+}
+
+func (sess *session) seek(args []string) error {
+	fd, err := parseInt("fd", args[0])
+	if err != nil {
+		return err
+	}
+	offset, err := parseInt("offset", args[1])
+	if err != nil {
+		return err
+	}
+	base := filesystem.FromBeginning
+	if len(args) > 2 {
+		switch args[2] {
+		case "begin":
+			base = filesystem.FromBeginning
+		case "current":
+			base = filesystem.FromCurrent
+		case "end":
+			base = filesystem.FromEnd
+		default:
+			return usageError(fmt.Sprintf("%q is not begin, current or end", args[2]))
+		}
+	}
+
+	newPosition, err := sess.fs.Seek(fd, offset, base)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(sess.stdout, newPosition)
+	return nil
+}
+
+func (sess *session) read(args []string) error {
+	fd, err := parseInt("fd", args[0])
+	if err != nil {
+		return err
+	}
+	numBytes, err := parseInt("numBytes", args[1])
+	if err != nil {
+		return err
+	}
+	_, data, err := sess.fs.Read(fd, numBytes)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sess.stdout, "%s\n", data)
+	return nil
+}
+
+func (sess *session) write(args []string) error {
+	fd, err := parseInt("fd", args[0])
+	if err != nil {
+		return err
+	}
+	bytesWritten, err := sess.fs.Write(fd, len(args[1]), []byte(args[1]))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sess.stdout, "wrote %d bytes\n", bytesWritten)
+	return nil
+}
+
+func (sess *session) close(args []string) error {
+	fd, err := parseInt("fd", args[0])
+	if err != nil {
+		return err
+	}
+	if _, err := sess.fs.Close(fd); err != nil {
+		return err
+	}
+	delete(sess.openFDs, fd)
+	return nil
+}
+
+func (sess *session) help(args []string) error {
+	printCommands(sess.stdout, true)
+	return nil
+}
+
+// Helpers =============================================================================================================
+
+// Write the whole file at filePath to w.
+func (sess *session) copyOut(filePath string, w io.Writer) (err error) {
+	fd, err := sess.fs.Open(filePath, filesystem.ReadOnly, 0)
+	if err != nil {
+		return err
+	}
+	defer sess.closeAndKeepFirstError(fd, &err)
+
+	// opening a file doesn't move its offset back to the start
+	if _, err := sess.fs.Seek(fd, 0, filesystem.FromBeginning); err != nil {
+		return err
+	}
+	for {
+		bytesRead, data, err := sess.fs.Read(fd, chunkSize)
+		if err != nil {
+			return err
+		}
+		if bytesRead == 0 {
+			return nil
+		}
+		if _, err := w.Write(data[:bytesRead]); err != nil {
+			return err
+		}
+	}
+}
+
+// Close fd, and if *err is nil, set it to whatever went wrong closing it. For use with defer.
+func (sess *session) closeAndKeepFirstError(fd int, err *error) {
+	if _, closeErr := sess.fs.Close(fd); *err == nil {
+		*err = closeErr
+	}
+}
+
+// A name as ls and tree show it, with a trailing / on directories.
+func displayName(info filesystem.FileInfo) string {
+	if info.IsDir && info.Name != "/" {
+		return info.Name + "/"
+	}
+	return info.Name
+}
+
+func parseInt(what string, str string) (int, error) {
+	n, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, usageError(fmt.Sprintf("%v %q is not a number", what, str))
+	}
+	return n, nil
+}
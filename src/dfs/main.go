@@ -0,0 +1,85 @@
+package main
+
+// dfs is a command-line client for a cluster of FileServers, such as one started with dfs-server.
+//
+// Usage:
+//
+//	dfs -config cluster.conf <command> [arguments]
+//
+// where the commands are
+//
+//	ls [path]               list a directory, marking directories with a trailing /
+//	mkdir <path>            make a directory
+//	cat <path>              print a file
+//	put <local> <remote>    copy a local file (or - for stdin) into the cluster, replacing the remote file
+//	get <remote> <local>    copy a file out of the cluster into a local file (or - for stdout)
+//	rm <path>               delete a file or an empty directory
+//	stat <path>             describe a file or directory
+//	tree [path]             list a directory and everything below it
+//	df                      print how much the filesystem holds and how much it may hold
+//	quota <path> [<maxBytes> <maxInodes>]
+//	                        print a directory's quota and usage, or set its quota (0 for none)
+//	shell                   read commands from stdin, one per line
+//
+// The shell also has open, seek, read, write and close, which work on file descriptors that stay open from one line
+// to the next; "help" lists them. Errors from the filesystem are reported by their filesystem.ErrorCode names, e.g.
+// "dfs: cat /missing: NotFound". The exit status is 0 on success, 1 if the filesystem returned an error, and 2 for
+// bad usage. The shell exits with status 1 if any of its commands failed.
+
+import (
+	"ad"
+	"flag"
+	"fmt"
+	"fsraft"
+	"io"
+	"os"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// Run one command, or a shell, against the cluster, and return the exit status.
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("dfs", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "the cluster config file (required)")
+	debugLevel := flags.Int("debug", ad.WARN, "how much to log to stderr, from 0 (nothing) to 3 (everything)")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: dfs -config cluster.conf <command> [arguments]")
+		fmt.Fprintln(stderr, "commands:")
+		printCommands(stderr, false)
+		fmt.Fprintln(stderr, "flags:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *configPath == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	if *debugLevel < ad.NONE || *debugLevel > ad.TRACE {
+		fmt.Fprintf(stderr, "dfs: -debug must be between %d and %d\n", ad.NONE, ad.TRACE)
+		return exitUsage
+	}
+	isShell := flags.Arg(0) == "shell" && flags.NArg() == 1
+	if !isShell {
+		if _, found := checkCommand(flags.Args(), false, stderr); !found {
+			return exitUsage
+		}
+	}
+	ad.SetDebugOutput(stderr)
+	ad.SetDebugLevel(*debugLevel)
+
+	cluster, err := fsraft.ReadClusterConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "dfs: couldn't read the cluster config: %v\n", err)
+		return exitFailed
+	}
+	sess := makeSession(fsraft.MakeFsClerk(cluster.MakeEnds()), stdin, stdout, stderr)
+	if isShell {
+		return sess.runShell()
+	}
+	return sess.runCommand(flags.Args(), false)
+}
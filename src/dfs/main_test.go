@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"fsraft"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Start a three-server cluster on localhost, and return the path of its config file.
+func startCluster(t *testing.T, dir string) (configPath string) {
+	cluster := fsraft.StartTestCluster(t, 3, fsraft.DefaultFileServerConfig())
+	config := ""
+	for i, address := range cluster.Addresses {
+		config += fmt.Sprintf("%d %v\n", i, address)
+	}
+	configPath = filepath.Join(dir, "cluster.conf")
+	if err := ioutil.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return configPath
+}
+
+// Run dfs with stdin as its input, and check its exit status and output.
+func runDfs(t *testing.T, configPath string, stdin string, expectedStatus int, expectedStdout string,
+	expectedStderr string, args ...string) {
+	var stdout, stderr bytes.Buffer
+	status := run(append([]string{"-config", configPath}, args...), strings.NewReader(stdin), &stdout, &stderr)
+	if status != expectedStatus || stdout.String() != expectedStdout || stderr.String() != expectedStderr {
+		t.Fatalf("dfs %v exited with %d, printing\n%v\nand\n%v\nbut expected %d, printing\n%v\nand\n%v",
+			strings.Join(args, " "), status, stdout.String(), stderr.String(), expectedStatus, expectedStdout,
+			expectedStderr)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := startCluster(t, dir)
+
+	runDfs(t, configPath, "", exitOK, "", "", "mkdir", "/dir")
+	runDfs(t, configPath, "", exitOK, "", "", "mkdir", "/dir/sub")
+	runDfs(t, configPath, "hello", exitOK, "", "", "put", "-", "/dir/greeting")
+	localPath := filepath.Join(dir, "local.txt")
+	if err := ioutil.WriteFile(localPath, []byte("from a local file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runDfs(t, configPath, "", exitOK, "", "", "put", localPath, "/dir/sub/copy")
+
+	runDfs(t, configPath, "", exitOK, "hello", "", "cat", "/dir/greeting")
+	runDfs(t, configPath, "", exitOK, "dir/\n", "", "ls")
+	runDfs(t, configPath, "", exitOK, "greeting\nsub/\n", "", "ls", "/dir")
+	runDfs(t, configPath, "", exitOK, "greeting\n", "", "ls", "/dir/greeting")
+	runDfs(t, configPath, "", exitOK, "name: greeting\ntype: file\nsize: 5\nopen: false\n", "",
+		"stat", "/dir/greeting")
+	runDfs(t, configPath, "", exitOK, "/\n"+
+		"└── dir/\n"+
+		"    ├── greeting\n"+
+		"    └── sub/\n"+
+		"        └── copy\n"+
+		"\n2 directories, 2 files\n", "", "tree")
+	runDfs(t, configPath, "", exitOK, "bytes: 22 of unlimited\ninodes: 5 of unlimited\nmax file size: unlimited\n"+
+		"max directory entries: unlimited\n", "", "df")
+	runDfs(t, configPath, "", exitOK, "", "", "quota", "/dir", "100", "0")
+	runDfs(t, configPath, "", exitOK, "bytes: 22 of 100\ninodes: 3 of unlimited\n", "", "quota", "/dir")
+	runDfs(t, configPath, "", exitUsage, "", "dfs: quota: give both maxBytes and maxInodes to set a quota "+
+		"(usage: quota <path> [<maxBytes> <maxInodes>])\n", "quota", "/dir", "100")
+	runDfs(t, configPath, "", exitFailed, "", "dfs: quota /dir/greeting: NotFound\n", "quota", "/dir/greeting")
+
+	copyPath := filepath.Join(dir, "copy.txt")
+	runDfs(t, configPath, "", exitOK, "", "", "get", "/dir/sub/copy", copyPath)
+	if contents, err := ioutil.ReadFile(copyPath); err != nil || string(contents) != "from a local file" {
+		t.Fatalf("get wrote (%q, %v)", contents, err)
+	}
+
+	runDfs(t, configPath, "", exitFailed, "", "dfs: rm /dir: DirectoryNotEmpty\n", "rm", "/dir")
+	runDfs(t, configPath, "", exitOK, "", "", "rm", "/dir/greeting")
+	runDfs(t, configPath, "", exitFailed, "", "dfs: cat /dir/greeting: NotFound\n", "cat", "/dir/greeting")
+	runDfs(t, configPath, "", exitFailed, "", "dfs: mkdir /dir: AlreadyExists\n", "mkdir", "/dir")
+	runDfs(t, configPath, "", exitFailed, "", "dfs: cat /dir: IsDirectory\n", "cat", "/dir")
+}
+
+func TestShell(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := startCluster(t, dir)
+
+	script := `
+open /notes rw create
+write 3 hello,   world
+seek 3 -5 end
+read 3 100
+read 3 100
+close 3
+read 3 1
+open /notes r
+seek 3 0
+read 3 5
+`
+	// the last open is still open when the script ends, and the shell closes it
+	runDfs(t, configPath, script, exitFailed, "3\nwrote 14 bytes\n9\nworld\n\n3\n0\nhello\n",
+		"dfs: read 3 1: InactiveFD\n", "shell")
+	runDfs(t, configPath, "open /notes\nexit\n", exitOK, "3\n", "", "shell")
+	runDfs(t, configPath, "seek 3 x\n", exitFailed, "",
+		"dfs: seek: offset \"x\" is not a number (usage: seek <fd> <offset> [begin|current|end])\n", "shell")
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"cat"},
+		{"-config", "cluster.conf"},
+		{"-config", "cluster.conf", "frobnicate"},
+		{"-config", "cluster.conf", "cat"},
+		{"-config", "cluster.conf", "cat", "/a", "/b"},
+		{"-config", "cluster.conf", "open", "/a"},
+		{"-config", "cluster.conf", "-debug", "7", "ls"},
+	} {
+		var stdout, stderr bytes.Buffer
+		if status := run(args, strings.NewReader(""), &stdout, &stderr); status != exitUsage {
+			t.Fatalf("dfs %v exited with %d, expected %d", strings.Join(args, " "), status, exitUsage)
+		}
+		if stderr.Len() == 0 {
+			t.Fatalf("dfs %v didn't explain what was wrong", strings.Join(args, " "))
+		}
+	}
+}
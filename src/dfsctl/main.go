@@ -0,0 +1,353 @@
+package main
+
+// dfsctl operates a cluster of FileServers through the admin API that dfs-server serves with -admin.
+//
+// Usage:
+//
+//	dfsctl -servers host0:9200,host1:9200,... [-json] <command> [arguments]
+//
+// where -servers lists every server's admin address in order of id, and the commands are
+//
+//	status [id]      show each server's Raft peer and FileServer, or just one server's
+//	members          show the leader, the voters and the learners
+//	sessions [id]    list the clerks a server (by default the leader) has executed commands for
+//	usage [id]       show how much the filesystem on a server (by default the leader) holds
+//	snapshot [id]    snapshot everything each server, or just one server, has executed
+//	transfer <id>    make a server the leader
+//	promote <id>     make a learner a voter
+//	demote <id>      make a voter a learner
+//
+// transfer, promote and demote are sent to the leader, wherever it is, and like snapshot they can be repeated safely:
+// asking for what is already true does nothing. With -json, the admin API's responses are printed as they are,
+// one per line, instead of as text. The exit status is 0 on success, 1 if a server couldn't be reached or refused, and
+// 2 for bad usage.
+
+import (
+	"adminapi"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Exit statuses.
+const (
+	exitOK     = 0
+	exitFailed = 1 // a server couldn't be reached or refused the request
+	exitUsage  = 2 // the command line was wrong
+)
+
+// How many times to follow a server that says another one is the leader.
+const maxRedirects = 3
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+type command struct {
+	name    string
+	args    string // how the arguments are shown in the usage message
+	help    string
+	minArgs int
+	maxArgs int
+	run     func(ctl *ctl, ids []int) error
+}
+
+var commands = []command{
+	{"status", "[id]", "show each server's Raft peer and FileServer, or just one server's", 0, 1, (*ctl).status},
+	{"members", "", "show the leader, the voters and the learners", 0, 0, (*ctl).members},
+	{"sessions", "[id]", "list the clerks a server (by default the leader) has executed commands for", 0, 1,
+		(*ctl).sessions},
+	{"usage", "[id]", "show how much the filesystem on a server (by default the leader) holds", 0, 1, (*ctl).usage},
+	{"snapshot", "[id]", "snapshot everything each server, or just one server, has executed", 0, 1, (*ctl).snapshot},
+	{"transfer", "<id>", "make a server the leader", 1, 1, (*ctl).transfer},
+	{"promote", "<id>", "make a learner a voter", 1, 1, (*ctl).promote},
+	{"demote", "<id>", "make a voter a learner", 1, 1, (*ctl).demote},
+}
+
+// Run one command against the cluster and return the exit status.
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("dfsctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	servers := flags.String("servers", "", "every server's admin address, in order of id (required)")
+	asJSON := flags.Bool("json", false, "print the admin API's responses as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: dfsctl -servers host0:9200,host1:9200,... [-json] <command> [arguments]")
+		fmt.Fprintln(stderr, "commands:")
+		for _, cmd := range commands {
+			fmt.Fprintf(stderr, "  %-16s %v\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.help)
+		}
+		fmt.Fprintln(stderr, "flags:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *servers == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	addresses := strings.Split(*servers, ",")
+
+	cmd, found := findCommand(flags.Arg(0))
+	if !found {
+		fmt.Fprintf(stderr, "dfsctl: unknown command %q\n", flags.Arg(0))
+		return exitUsage
+	}
+	cmdArgs := flags.Args()[1:]
+	if len(cmdArgs) < cmd.minArgs || len(cmdArgs) > cmd.maxArgs {
+		fmt.Fprintf(stderr, "dfsctl: usage: %v\n", strings.TrimSpace(cmd.name+" "+cmd.args))
+		return exitUsage
+	}
+	ids := make([]int, len(cmdArgs))
+	for i, arg := range cmdArgs {
+		id, err := strconv.Atoi(arg)
+		if err != nil || id < 0 || id >= len(addresses) {
+			fmt.Fprintf(stderr, "dfsctl: %v: %q is not a server id between 0 and %d\n", cmd.name, arg,
+				len(addresses)-1)
+			return exitUsage
+		}
+		ids[i] = id
+	}
+
+	ctl := &ctl{make([]*adminapi.Client, len(addresses)), *asJSON, stdout}
+	for i, address := range addresses {
+		ctl.clients[i] = adminapi.MakeClient(address)
+	}
+	if err := cmd.run(ctl, ids); err != nil {
+		fmt.Fprintf(stderr, "dfsctl: %v: %v\n", cmd.name, err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// The clients for every server, and where to print what they return.
+type ctl struct {
+	clients []*adminapi.Client // indexed by id
+	asJSON  bool
+	stdout  io.Writer
+}
+
+// Print a response as JSON, or as text with printText.
+func (ctl *ctl) print(response interface{}, printText func(out io.Writer)) error {
+	if ctl.asJSON {
+		return json.NewEncoder(ctl.stdout).Encode(response)
+	}
+	out := tabwriter.NewWriter(ctl.stdout, 0, 8, 2, ' ', 0)
+	printText(out)
+	return out.Flush()
+}
+
+// The ids of the servers a command should go to: the one given, or else all of them.
+func (ctl *ctl) idsOrAll(ids []int) []int {
+	if len(ids) > 0 {
+		return ids
+	}
+	all := make([]int, len(ctl.clients))
+	for id := range all {
+		all[id] = id
+	}
+	return all
+}
+
+// Find the leader by asking each server in turn until one knows.
+func (ctl *ctl) findLeader() (int, error) {
+	var lastErr error
+	for id, client := range ctl.clients {
+		membership, err := client.Membership()
+		if err != nil {
+			lastErr = fmt.Errorf("server %d: %v", id, err)
+			continue
+		}
+		if membership.Leader >= 0 && membership.Leader < len(ctl.clients) {
+			return membership.Leader, nil
+		}
+	}
+	if lastErr != nil {
+		return -1, fmt.Errorf("couldn't find the leader (%v)", lastErr)
+	}
+	return -1, fmt.Errorf("no server knows who the leader is")
+}
+
+// The id given, or else the leader's.
+func (ctl *ctl) idOrLeader(ids []int) (int, error) {
+	if len(ids) > 0 {
+		return ids[0], nil
+	}
+	return ctl.findLeader()
+}
+
+// Send a change to the leader, following the servers that say the leader is somewhere else.
+func (ctl *ctl) atLeader(change func(client *adminapi.Client) (adminapi.Membership, error)) error {
+	leader, err := ctl.findLeader()
+	if err != nil {
+		return err
+	}
+	for redirects := 0; ; redirects++ {
+		membership, err := change(ctl.clients[leader])
+		apiErr, isAPIError := err.(*adminapi.Error)
+		if isAPIError && apiErr.StatusCode == http.StatusMisdirectedRequest && apiErr.Leader >= 0 &&
+			apiErr.Leader < len(ctl.clients) && redirects < maxRedirects {
+			leader = apiErr.Leader
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("server %d: %v", leader, err)
+		}
+		return ctl.printMembership(membership)
+	}
+}
+
+func (ctl *ctl) status(ids []int) error {
+	statuses := make([]adminapi.Status, 0)
+	var errs []string
+	for _, id := range ctl.idsOrAll(ids) {
+		status, err := ctl.clients[id].Status()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("server %d: %v", id, err))
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	if ctl.asJSON {
+		for _, status := range statuses {
+			if err := json.NewEncoder(ctl.stdout).Encode(status); err != nil {
+				return err
+			}
+		}
+	} else if len(statuses) > 0 {
+		out := tabwriter.NewWriter(ctl.stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(out, "ID\tROLE\tTERM\tLEADER\tCOMMIT\tAPPLIED\tSNAPSHOT\tLAST\tLOG BYTES\tPENDING")
+		for _, s := range statuses {
+			fmt.Fprintf(out, "%d\t%v\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n", s.Id, s.Role, s.Term, s.Leader,
+				s.CommitIndex, s.AppliedIndex, s.SnapshotIndex, s.LastLogIndex, s.LogBytes, s.PendingOperations)
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (ctl *ctl) members(ids []int) error {
+	leader, err := ctl.findLeader()
+	if err != nil {
+		return err
+	}
+	membership, err := ctl.clients[leader].Membership()
+	if err != nil {
+		return fmt.Errorf("server %d: %v", leader, err)
+	}
+	return ctl.printMembership(membership)
+}
+
+func (ctl *ctl) printMembership(membership adminapi.Membership) error {
+	return ctl.print(membership, func(out io.Writer) {
+		fmt.Fprintf(out, "leader:\t%d\n", membership.Leader)
+		fmt.Fprintf(out, "voters:\t%v\n", joinInts(membership.Voters))
+		fmt.Fprintf(out, "learners:\t%v\n", joinInts(membership.Learners))
+	})
+}
+
+func (ctl *ctl) sessions(ids []int) error {
+	id, err := ctl.idOrLeader(ids)
+	if err != nil {
+		return err
+	}
+	sessions, err := ctl.clients[id].Sessions()
+	if err != nil {
+		return fmt.Errorf("server %d: %v", id, err)
+	}
+	return ctl.print(sessions, func(out io.Writer) {
+		fmt.Fprintln(out, "CLERK\tCOMMANDS\tPENDING")
+		for _, session := range sessions {
+			fmt.Fprintf(out, "%d\t%d\t%d\n", session.ClerkId, session.CommandsExecuted, session.PendingOperations)
+		}
+	})
+}
+
+func (ctl *ctl) usage(ids []int) error {
+	id, err := ctl.idOrLeader(ids)
+	if err != nil {
+		return err
+	}
+	usage, err := ctl.clients[id].Usage()
+	if err != nil {
+		return fmt.Errorf("server %d: %v", id, err)
+	}
+	return ctl.print(usage, func(out io.Writer) {
+		fmt.Fprintf(out, "inodes:\t%d\n", usage.Inodes)
+		fmt.Fprintf(out, "bytes:\t%d\n", usage.Bytes)
+		fmt.Fprintf(out, "open files:\t%d\n", usage.OpenFiles)
+	})
+}
+
+func (ctl *ctl) snapshot(ids []int) error {
+	var errs []string
+	for _, id := range ctl.idsOrAll(ids) {
+		result, err := ctl.clients[id].Snapshot()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("server %d: %v", id, err))
+			continue
+		}
+		if ctl.asJSON {
+			err = json.NewEncoder(ctl.stdout).Encode(result)
+		} else {
+			_, err = fmt.Fprintf(ctl.stdout, "server %d: snapshot up to index %d (%d bytes)\n", id, result.Index,
+				result.Bytes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (ctl *ctl) transfer(ids []int) error {
+	return ctl.atLeader(func(client *adminapi.Client) (adminapi.Membership, error) {
+		return client.TransferLeadership(ids[0])
+	})
+}
+
+func (ctl *ctl) promote(ids []int) error {
+	return ctl.atLeader(func(client *adminapi.Client) (adminapi.Membership, error) {
+		return client.SetRole(ids[0], adminapi.Voter)
+	})
+}
+
+func (ctl *ctl) demote(ids []int) error {
+	return ctl.atLeader(func(client *adminapi.Client) (adminapi.Membership, error) {
+		return client.SetRole(ids[0], adminapi.Learner)
+	})
+}
+
+func joinInts(ints []int) string {
+	if len(ints) == 0 {
+		return "none"
+	}
+	strs := make([]string, len(ints))
+	for i, n := range ints {
+		strs[i] = strconv.Itoa(n)
+	}
+	return strings.Join(strs, ",")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"ad"
+	"adminapi"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"fsraft"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	if _, isSet := os.LookupEnv("DFS_DEFAULT_DEBUG_LEVEL"); !isSet {
+		ad.SetDebugLevel(ad.WARN)
+	}
+	os.Exit(m.Run())
+}
+
+const nservers = 3
+
+// Start three FileServers, the last of them a learner, each with an admin API on localhost, and return the -servers
+// flag for them.
+func startCluster(t *testing.T) (servers string) {
+	config := fsraft.DefaultFileServerConfig()
+	config.Raft.Learners = []int{nservers - 1}
+	cluster := fsraft.StartTestCluster(t, nservers, config)
+	addresses := make([]string, nservers)
+	for i, fileServer := range cluster.FileServers {
+		httpServer := httptest.NewServer(adminapi.MakeHandler(fileServer))
+		t.Cleanup(httpServer.Close)
+		addresses[i] = httpServer.Listener.Addr().String()
+	}
+	return strings.Join(addresses, ",")
+}
+
+// Run dfsctl and check its exit status, and return its stdout and stderr.
+func runDfsctl(t *testing.T, expectedStatus int, args ...string) (string, string) {
+	var stdout, stderr bytes.Buffer
+	if status := run(args, &stdout, &stderr); status != expectedStatus {
+		t.Fatalf("dfsctl %v exited with %d, expected %d\nstdout:\n%v\nstderr:\n%v", strings.Join(args, " "), status,
+			expectedStatus, stdout.String(), stderr.String())
+	}
+	return stdout.String(), stderr.String()
+}
+
+// Run dfsctl -json members until what it prints satisfies condition, and return that.
+func waitForMembership(t *testing.T, servers string,
+	condition func(membership adminapi.Membership) bool) adminapi.Membership {
+	for start := time.Now(); ; time.Sleep(50 * time.Millisecond) {
+		var stdout, stderr bytes.Buffer
+		if run([]string{"-servers", servers, "-json", "members"}, &stdout, &stderr) == exitOK {
+			var membership adminapi.Membership
+			if err := json.Unmarshal(stdout.Bytes(), &membership); err != nil {
+				t.Fatalf("dfsctl -json members printed %q: %v", stdout.String(), err)
+			}
+			if condition(membership) {
+				return membership
+			}
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("timed out waiting for the membership; dfsctl printed %q and %q", stdout.String(),
+				stderr.String())
+		}
+	}
+}
+
+func TestBadArguments(t *testing.T) {
+	servers := "127.0.0.1:1,127.0.0.1:2"
+	for _, args := range [][]string{
+		{},
+		{"status"},
+		{"-servers", servers},
+		{"-servers", servers, "frobnicate"},
+		{"-servers", servers, "transfer"},
+		{"-servers", servers, "transfer", "1", "2"},
+		{"-servers", servers, "promote", "two"},
+		{"-servers", servers, "demote", "2"},
+		{"-servers", servers, "members", "1"},
+	} {
+		runDfsctl(t, exitUsage, args...)
+	}
+
+	// nothing is listening.
+	if _, stderr := runDfsctl(t, exitFailed, "-servers", servers, "status", "1"); !strings.HasPrefix(stderr,
+		"dfsctl: status: server 1: ") {
+		t.Fatalf("dfsctl status printed %q", stderr)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	servers := startCluster(t)
+
+	membership := waitForMembership(t, servers, func(membership adminapi.Membership) bool {
+		return membership.Leader != -1
+	})
+	leader := membership.Leader
+	if fmt.Sprint(membership.Voters, membership.Learners) != "[0 1] [2]" {
+		t.Fatalf("dfsctl members printed %+v", membership)
+	}
+
+	stdout, _ := runDfsctl(t, exitOK, "-servers", servers, "status")
+	if lines := strings.Split(strings.TrimSpace(stdout), "\n"); len(lines) != nservers+1 ||
+		!strings.HasPrefix(lines[0], "ID") || !strings.Contains(lines[leader+1], "Leader") ||
+		!strings.Contains(lines[nservers], "Learner") {
+		t.Fatalf("dfsctl status printed\n%v", stdout)
+	}
+	stdout, _ = runDfsctl(t, exitOK, "-servers", servers, "usage")
+	if stdout != "inodes:      1\nbytes:       0\nopen files:  0\n" {
+		t.Fatalf("dfsctl usage printed %q", stdout)
+	}
+	stdout, _ = runDfsctl(t, exitOK, "-servers", servers, "-json", "sessions", "1")
+	if stdout != "[]\n" {
+		t.Fatalf("dfsctl -json sessions printed %q", stdout)
+	}
+	stdout, _ = runDfsctl(t, exitOK, "-servers", servers, "snapshot")
+	if lines := strings.Split(strings.TrimSpace(stdout), "\n"); len(lines) != nservers ||
+		!strings.HasPrefix(lines[0], "server 0: snapshot up to index ") {
+		t.Fatalf("dfsctl snapshot printed\n%v", stdout)
+	}
+
+	// every change goes to the leader wherever it is, and can be repeated.
+	for attempt := 0; attempt < 2; attempt++ {
+		waitForMembership(t, servers, func(adminapi.Membership) bool {
+			var stdout, stderr bytes.Buffer
+			return run([]string{"-servers", servers, "promote", "2"}, &stdout, &stderr) == exitOK
+		})
+	}
+	waitForMembership(t, servers, func(membership adminapi.Membership) bool {
+		return len(membership.Learners) == 0
+	})
+	waitForMembership(t, servers, func(adminapi.Membership) bool {
+		var stdout, stderr bytes.Buffer
+		return run([]string{"-servers", servers, "transfer", "2"}, &stdout, &stderr) == exitOK
+	})
+	waitForMembership(t, servers, func(membership adminapi.Membership) bool {
+		return membership.Leader == 2
+	})
+	// the new leader may first have to commit the promotion in its own term.
+	waitForMembership(t, servers, func(adminapi.Membership) bool {
+		var stdout, stderr bytes.Buffer
+		return run([]string{"-servers", servers, "demote", fmt.Sprint(leader)}, &stdout, &stderr) == exitOK
+	})
+	stdout, _ = runDfsctl(t, exitOK, "-servers", servers, "demote", fmt.Sprint(leader))
+	if stdout != fmt.Sprintf("leader:    2\nvoters:    %v\nlearners:  %d\n", map[int]string{0: "1,2", 1: "0,2"}[leader],
+		leader) {
+		t.Fatalf("dfsctl demote printed %q", stdout)
+	}
+	if _, stderr := runDfsctl(t, exitFailed, "-servers", servers, "demote", "2"); !strings.Contains(stderr,
+		"server 2: ") {
+		t.Fatalf("dfsctl demote of the leader printed %q", stderr)
+	}
+}
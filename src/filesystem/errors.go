@@ -1,5 +1,7 @@
 package filesystem
 
+import "io/fs"
+
 // This file holds definitions of various file-related errors.
 // These are loosely based off the standard POSIX/C error codes available at
 // http://www.virtsync.com/c-error-codes-include-errno but with names changed for readability.
@@ -25,6 +27,7 @@ const (
 	AlreadyOpen                        // An attempt was made to open a file that is already open. This error does not exist in POSIX because a file can only be opened once here.
 	WriteTooLarge                      // An attempt was made to write too much data in a single call to Write().
 	WrongMode                          // An attempt was made to write to a read-only file or read from a write-only file.
+	TooManyLinks                       // Too many symbolic links were followed while looking up a path (ELOOP).
 )
 
 var errorCodesToNames = map[ErrorCode]string{
@@ -41,6 +44,16 @@ var errorCodesToNames = map[ErrorCode]string{
 	AlreadyExists:     "AlreadyExists",
 	AlreadyOpen:       "AlreadyOpen",
 	WriteTooLarge:     "WriteTooLarge",
+	WrongMode:         "WrongMode",
+	TooManyLinks:      "TooManyLinks",
+}
+
+// The io/fs errors that mean the same thing as an ErrorCode, so that errors.Is(err, fs.ErrNotExist) works.
+var errorCodesToFsErrors = map[ErrorCode]error{
+	NotFound:      fs.ErrNotExist,
+	InactiveFD:    fs.ErrClosed,
+	AlreadyExists: fs.ErrExist,
+	WrongMode:     fs.ErrPermission,
 }
 
 // Needed for ErrorCode to conform to the builtin interface "error",
@@ -54,3 +67,8 @@ func (e ErrorCode) Error() string {
 func (e ErrorCode) String() string {
 	return e.Error()
 }
+
+// Used by errors.Is to match an ErrorCode to the io/fs error that means the same thing, like fs.ErrNotExist.
+func (e ErrorCode) Is(target error) bool {
+	return errorCodesToFsErrors[e] == target
+}
@@ -0,0 +1,239 @@
+package filesystem
+
+import (
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// An open file, used like an os.File rather than through a file descriptor, so that it works with io.Copy, bufio,
+// gzip and so on.
+//
+// Errors from the FileSystem are returned as an *fs.PathError holding the ErrorCode, so both
+// errors.Is(err, NotFound) and errors.Is(err, fs.ErrNotExist) work. Read and ReadAt return io.EOF at the end of the
+// file, and Write and WriteAt return io.ErrShortWrite if the FileSystem writes less than it was given. Once the
+// File is closed, everything returns fs.ErrClosed.
+type File struct {
+	fileSystem FileSystem
+	path       string
+	fd         int
+	flags      OpenFlags
+
+	mu     sync.Mutex // held while using fd, so that it isn't closed out from under a call
+	closed bool
+}
+
+var _ io.ReadWriteSeeker = (*File)(nil)
+var _ io.ReaderAt = (*File)(nil)
+var _ io.WriterAt = (*File)(nil)
+var _ io.Closer = (*File)(nil)
+var _ io.StringWriter = (*File)(nil)
+
+// How much to read or write in one call to the FileSystem.
+const fileChunkSize = 64 * 1024
+
+var whencesToSeekModes = map[int]SeekMode{
+	io.SeekStart:   FromBeginning,
+	io.SeekCurrent: FromCurrent,
+	io.SeekEnd:     FromEnd,
+}
+
+// Open the file at path on fileSystem; see FileSystem.Open for mode and flags.
+// Unlike a file descriptor from FileSystem.Open, the File starts at offset 0 even if the file was opened before,
+// unless flags include Append.
+func OpenFile(fileSystem FileSystem, path string, mode OpenMode, flags OpenFlags) (*File, error) {
+	fd, err := fileSystem.Open(path, mode, flags)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: err}
+	}
+	if !FlagIsSet(flags, Append) {
+		if _, err := fileSystem.Seek(fd, 0, FromBeginning); err != nil {
+			fileSystem.Close(fd)
+			return nil, &fs.PathError{Op: "open", Path: path, Err: err}
+		}
+	}
+	return &File{fileSystem: fileSystem, path: path, fd: fd, flags: flags}, nil
+}
+
+// The path the File was opened with.
+func (file *File) Name() string {
+	return file.path
+}
+
+// The file descriptor, for calling the FileSystem directly.
+func (file *File) Fd() int {
+	return file.fd
+}
+
+func (file *File) pathError(op string, err error) error {
+	return &fs.PathError{Op: op, Path: file.path, Err: err}
+}
+
+// Describe the file as it is now.
+func (file *File) Stat() (FileInfo, error) {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.closed {
+		return FileInfo{}, file.pathError("stat", fs.ErrClosed)
+	}
+	info, err := file.fileSystem.Stat(file.path)
+	if err != nil {
+		return FileInfo{}, file.pathError("stat", err)
+	}
+	return info, nil
+}
+
+// Read up to len(p) bytes at the offset. It may read less even before the end of the file, like any io.Reader.
+func (file *File) Read(p []byte) (int, error) {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.closed {
+		return 0, file.pathError("read", fs.ErrClosed)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return file.readOnce(p)
+}
+
+// Read up to len(p) bytes, and at most fileChunkSize, at the offset, and return io.EOF if there are none left.
+// ONLY CALL WITH THE LOCK.
+func (file *File) readOnce(p []byte) (int, error) {
+	numBytes := len(p)
+	if numBytes > fileChunkSize {
+		numBytes = fileChunkSize
+	}
+	bytesRead, data, err := file.fileSystem.Read(file.fd, numBytes)
+	if err != nil {
+		return 0, file.pathError("read", err)
+	}
+	if bytesRead == 0 {
+		return 0, io.EOF
+	}
+	return copy(p, data[:bytesRead]), nil
+}
+
+// Write all of p at the offset, or return why it couldn't.
+func (file *File) Write(p []byte) (int, error) {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.closed {
+		return 0, file.pathError("write", fs.ErrClosed)
+	}
+	return file.writeAll(p)
+}
+
+// ONLY CALL WITH THE LOCK.
+func (file *File) writeAll(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		chunk := p[n:]
+		if len(chunk) > fileChunkSize {
+			chunk = chunk[:fileChunkSize]
+		}
+		bytesWritten, err := file.fileSystem.Write(file.fd, len(chunk), chunk)
+		if err != nil {
+			return n, file.pathError("write", err)
+		}
+		n += bytesWritten
+		if bytesWritten < len(chunk) {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+func (file *File) WriteString(s string) (int, error) {
+	return file.Write([]byte(s))
+}
+
+func (file *File) Seek(offset int64, whence int) (int64, error) {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.closed {
+		return 0, file.pathError("seek", fs.ErrClosed)
+	}
+	base, found := whencesToSeekModes[whence]
+	if !found {
+		return 0, file.pathError("seek", IllegalArgument)
+	}
+	newPosition, err := file.fileSystem.Seek(file.fd, int(offset), base)
+	if err != nil {
+		return 0, file.pathError("seek", err)
+	}
+	return int64(newPosition), nil
+}
+
+// Read len(p) bytes starting at offset without changing the offset, or return why it couldn't, which is io.EOF if
+// the file ends first.
+func (file *File) ReadAt(p []byte, offset int64) (int, error) {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.closed {
+		return 0, file.pathError("read", fs.ErrClosed)
+	}
+	if offset < 0 {
+		return 0, file.pathError("read", IllegalArgument)
+	}
+	n := 0
+	for n < len(p) {
+		numBytes := len(p) - n
+		if numBytes > fileChunkSize {
+			numBytes = fileChunkSize
+		}
+		bytesRead, data, err := file.fileSystem.Pread(file.fd, int(offset)+n, numBytes)
+		if err != nil {
+			return n, file.pathError("read", err)
+		}
+		if bytesRead == 0 {
+			return n, io.EOF
+		}
+		n += copy(p[n:], data[:bytesRead])
+	}
+	return n, nil
+}
+
+// Write all of p starting at offset without changing the offset. Files opened with Append can't do this, since
+// every write goes to the end, so they return IllegalArgument.
+func (file *File) WriteAt(p []byte, offset int64) (int, error) {
+	if FlagIsSet(file.flags, Append) {
+		return 0, file.pathError("write", IllegalArgument)
+	}
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.closed {
+		return 0, file.pathError("write", fs.ErrClosed)
+	}
+	if offset < 0 {
+		return 0, file.pathError("write", IllegalArgument)
+	}
+	n := 0
+	for n < len(p) {
+		chunk := p[n:]
+		if len(chunk) > fileChunkSize {
+			chunk = chunk[:fileChunkSize]
+		}
+		bytesWritten, err := file.fileSystem.Pwrite(file.fd, int(offset)+n, chunk)
+		if err != nil {
+			return n, file.pathError("write", err)
+		}
+		n += bytesWritten
+		if bytesWritten < len(chunk) {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+func (file *File) Close() error {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.closed {
+		return file.pathError("close", fs.ErrClosed)
+	}
+	file.closed = true
+	if _, err := file.fileSystem.Close(file.fd); err != nil {
+		return file.pathError("close", err)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package filesystem_test
+
+import (
+	"ad"
+	"errors"
+	"filesystem"
+	"io"
+	"io/fs"
+	"memoryFS"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	if _, isSet := os.LookupEnv("DFS_DEFAULT_DEBUG_LEVEL"); !isSet {
+		ad.SetDebugLevel(ad.WARN)
+	}
+	os.Exit(m.Run())
+}
+
+func openFile(t *testing.T, fileSystem filesystem.FileSystem, path string, mode filesystem.OpenMode,
+	flags filesystem.OpenFlags) *filesystem.File {
+	file, err := filesystem.OpenFile(fileSystem, path, mode, flags)
+	if err != nil {
+		t.Fatalf("OpenFile(%v) failed: %v", path, err)
+	}
+	return file
+}
+
+func TestFileReadWriteSeek(t *testing.T) {
+	mfs := memoryFS.CreateEmptyMemoryFS()
+	file := openFile(t, &mfs, "/file", filesystem.ReadWrite, filesystem.Create)
+	if n, err := file.WriteString("0123456789"); n != 10 || err != nil {
+		t.Fatalf("WriteString() returned %d, %v", n, err)
+	}
+	buffer := make([]byte, 4)
+	if n, err := file.Read(buffer); n != 0 || err != io.EOF {
+		t.Fatalf("Read() at the end returned %d, %v, expected io.EOF", n, err)
+	}
+	if position, err := file.Seek(2, io.SeekStart); position != 2 || err != nil {
+		t.Fatalf("Seek() returned %d, %v", position, err)
+	}
+	if _, err := io.ReadFull(file, buffer); err != nil || string(buffer) != "2345" {
+		t.Fatalf("Read() returned %q, %v", buffer, err)
+	}
+
+	// ReadAt and WriteAt don't move the offset.
+	if n, err := file.WriteAt([]byte("ab"), 8); n != 2 || err != nil {
+		t.Fatalf("WriteAt() returned %d, %v", n, err)
+	}
+	if n, err := file.ReadAt(buffer, 7); n != 3 || err != io.EOF || string(buffer[:n]) != "7ab" {
+		t.Fatalf("ReadAt() past the end returned %d, %v, %q", n, err, buffer[:n])
+	}
+	if _, err := io.ReadFull(file, buffer); err != nil || string(buffer) != "67ab" {
+		t.Fatalf("Read() after ReadAt() and WriteAt() returned %q, %v", buffer, err)
+	}
+	if _, err := file.ReadAt(buffer, -1); !errors.Is(err, filesystem.IllegalArgument) {
+		t.Fatalf("ReadAt() with a negative offset returned %v", err)
+	}
+	if _, err := file.Seek(-1, io.SeekStart); !errors.Is(err, filesystem.IllegalArgument) {
+		t.Fatalf("Seek() before the beginning returned %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if _, err := file.Write(buffer); !errors.Is(err, fs.ErrClosed) {
+		t.Fatalf("Write() after Close() returned %v", err)
+	}
+	if err := file.Close(); !errors.Is(err, fs.ErrClosed) {
+		t.Fatalf("a second Close() returned %v", err)
+	}
+
+	// a File starts at the beginning, unless it appends.
+	file = openFile(t, &mfs, "/file", filesystem.ReadWrite, filesystem.Append)
+	if _, err := file.WriteAt(buffer, 0); !errors.Is(err, filesystem.IllegalArgument) {
+		t.Fatalf("WriteAt() in Append mode returned %v", err)
+	}
+	if position, err := file.Seek(0, io.SeekCurrent); position != 10 || err != nil {
+		t.Fatalf("a File opened with Append is at %d, %v", position, err)
+	}
+	file.Close()
+	file = openFile(t, &mfs, "/file", filesystem.ReadOnly, 0)
+	if contents, err := io.ReadAll(file); err != nil || string(contents) != "01234567ab" {
+		t.Fatalf("reading the file returned %q, %v", contents, err)
+	}
+	if info, err := file.Stat(); err != nil || info.Size != 10 || !info.IsOpen {
+		t.Fatalf("Stat() returned %+v, %v", info, err)
+	}
+	file.Close()
+}
+
+func TestFileErrors(t *testing.T) {
+	mfs := memoryFS.CreateEmptyMemoryFS()
+	if _, err := filesystem.OpenFile(&mfs, "/missing", filesystem.ReadOnly, 0); !errors.Is(err,
+		filesystem.NotFound) || !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("opening a missing file returned %v", err)
+	}
+	var pathErr *fs.PathError
+	if _, err := filesystem.OpenFile(&mfs, "/", filesystem.ReadOnly, 0); !errors.As(err, &pathErr) ||
+		pathErr.Path != "/" {
+		t.Fatalf("opening the root returned %v", err)
+	}
+	file := openFile(t, &mfs, "/file", filesystem.ReadOnly, filesystem.Create)
+	defer file.Close()
+	if _, err := file.Write([]byte("x")); !errors.Is(err, filesystem.WrongMode) ||
+		!errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("writing a ReadOnly file returned %v", err)
+	}
+	if _, err := filesystem.OpenFile(&mfs, "/file", filesystem.ReadOnly, 0); !errors.Is(err,
+		filesystem.AlreadyOpen) {
+		t.Fatalf("opening an open file returned %v", err)
+	}
+}
+
+// A FileSystem that only ever writes the first byte it is given.
+type oneByteWriter struct {
+	*memoryFS.MemoryFS
+}
+
+func (writer oneByteWriter) Write(fileDescriptor int, numBytes int, data []byte) (int, error) {
+	return writer.MemoryFS.Write(fileDescriptor, 1, data[:1])
+}
+
+func TestShortWrite(t *testing.T) {
+	mfs := memoryFS.CreateEmptyMemoryFS()
+	file := openFile(t, oneByteWriter{&mfs}, "/file", filesystem.WriteOnly, filesystem.Create)
+	defer file.Close()
+	if n, err := file.WriteString("abc"); n != 1 || err != io.ErrShortWrite {
+		t.Fatalf("a short write returned %d, %v", n, err)
+	}
+}
+
+func TestHelpers(t *testing.T) {
+	mfs := memoryFS.CreateEmptyMemoryFS()
+	if err := filesystem.MkdirAll(&mfs, "/a/b/c/"); err != nil {
+		t.Fatalf("MkdirAll() failed: %v", err)
+	}
+	if err := filesystem.MkdirAll(&mfs, "/a/b"); err != nil {
+		t.Fatalf("MkdirAll() of an existing directory failed: %v", err)
+	}
+	if err := filesystem.WriteFile(&mfs, "/a/b/file", []byte("first version")); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if err := filesystem.WriteFile(&mfs, "/a/b/file", []byte("second")); err != nil {
+		t.Fatalf("WriteFile() of an existing file failed: %v", err)
+	}
+	if contents, err := filesystem.ReadFile(&mfs, "/a/b/file"); err != nil || string(contents) != "second" {
+		t.Fatalf("ReadFile() returned %q, %v", contents, err)
+	}
+	if err := filesystem.MkdirAll(&mfs, "/a/b/file/d"); !errors.Is(err, filesystem.AlreadyExists) {
+		t.Fatalf("MkdirAll() under a file returned %v", err)
+	}
+	if _, err := filesystem.ReadFile(&mfs, "/a/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("ReadFile() of a missing file returned %v", err)
+	}
+
+	if err := filesystem.RemoveAll(&mfs, "/"); !errors.Is(err, filesystem.IllegalArgument) {
+		t.Fatalf("RemoveAll(/) returned %v", err)
+	}
+	// a link is removed without going into what it points to.
+	if _, err := mfs.Symlink("/a/b", "/a/link"); err != nil {
+		t.Fatalf("Symlink() failed: %v", err)
+	}
+	if err := filesystem.RemoveAll(&mfs, "/a/link"); err != nil {
+		t.Fatalf("RemoveAll() of a link failed: %v", err)
+	}
+	if _, err := mfs.Stat("/a/b/file"); err != nil {
+		t.Fatalf("RemoveAll() of a link removed what it points to: %v", err)
+	}
+	if err := filesystem.RemoveAll(&mfs, "/a"); err != nil {
+		t.Fatalf("RemoveAll() failed: %v", err)
+	}
+	if entries, err := mfs.ReadDir("/"); err != nil || len(entries) != 0 {
+		t.Fatalf("after RemoveAll(), the root has %+v, %v", entries, err)
+	}
+	if err := filesystem.RemoveAll(&mfs, "/a"); err != nil {
+		t.Fatalf("RemoveAll() of a missing path returned %v", err)
+	}
+}
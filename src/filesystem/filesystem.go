@@ -1,14 +1,25 @@
 package filesystem
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
+// Every path a FileSystem takes is checked and cleaned as CleanPath describes, so a path that holds a NUL byte or is
+// too long is IllegalArgument, one that doesn't begin with "/" is NotFound, and "/a//b/", "/a/./b" and "/a/c/../b" all
+// name "/a/b".
+// Symbolic links (see Symlink) are followed wherever they appear in a path, except as the last name in a path given
+// to Mkdir, Delete, Rename, Symlink, Readlink and Lstat, which work on the link itself. Following more than MaxSymlinkDepth
+// of them while looking up one path returns TooManyLinks, which is how a loop of links ends.
 type FileSystem interface {
 
 	// Creates a directory.
 	//
 	// Path is a relative path name beginning from the top-level synchronized directory and
 	// ending in the directory to be created.
-	// Possible errors are NotFound, TryAgain, IOError, NoMoreSpace, and AlreadyExists.
+	// If creating it would go over the filesystem's MaxInodes or its parent directory's MaxDirEntries (see Limits),
+	// or the quota of a directory above it (see SetQuota), returns NoMoreSpace.
+	// Possible errors are NotFound, TryAgain, IOError, NoMoreSpace, IllegalArgument, and AlreadyExists.
 	// Success is false iff err is non-nil.
 	Mkdir(path string) (success bool, err error)
 
@@ -20,13 +31,16 @@ type FileSystem interface {
 	// If the file exists and is not already opened, the file is opened and the Create flag is ignored.
 	// If the file does not exist and the Create flag is included, creates it and then opens it.
 	// If the file does not exist and the Create flag is not included, returns NotFound error.
+	// If the file does not exist and creating it would go over the filesystem's MaxInodes, its parent directory's
+	// MaxDirEntries (see Limits), or the quota of a directory above it (see SetQuota), returns NoMoreSpace.
 	// Even if the Create flag is specified, it is still possible to receive a NotFound error if the
 	// parent directory does not exist or if the path is not well-formed (e.g, it does not begin with "/")
 	// If the Truncate flag is set, truncates the file size to 0 (if opening succeeds).
 	// If the file is already open, if the Block flag is included, blocks until it is closed; if the
 	// Block flag is not included, returns AlreadyOpen.
 	// A newly created file has its offset set to 0.
-	// Possible errors are IsDirectory, TooManyFDsOpen, NotFound, AlreadyOpen, and TryAgain.
+	// Possible errors are IsDirectory, TooManyFDsOpen, NotFound, AlreadyOpen, NoMoreSpace, IllegalArgument, and
+	// TryAgain.
 	// fileDescriptor == -1 if and only iff err is non-nil.
 	Open(path string, mode OpenMode, flags OpenFlags) (fileDescriptor int, err error)
 
@@ -64,6 +78,9 @@ type FileSystem interface {
 	//
 	// The number of bytes written may be less than numBytes if, for example,
 	// there is insufficient space on the underlying physical medium.
+	// If writing all of them would make the file bigger than MaxFileSize or the filesystem bigger than MaxBytes
+	// (see Limits), as many as fit are written. If none fit, returns FileTooLarge or NoMoreSpace respectively.
+	// A directory's quota (see SetQuota) limits writing to the files under it like MaxBytes does.
 	// For a seekable file, writing takes place at the file offset, and
 	// the file offset is incremented by the number of bytes actually
 	// written.  If the file was opened in Append mode, the file offset is
@@ -75,21 +92,188 @@ type FileSystem interface {
 	// If err is non-nil, bytesWritten is -1.
 	Write(fileDescriptor int, numBytes int, data []byte) (bytesWritten int, err error)
 
+	// Like Read, but reads starting at offset rather than at the file offset, which is neither used nor changed.
+	//
+	// This lets several readers share a file descriptor without fighting over its offset.
+	// If offset or numBytes is negative, returns IllegalArgument.
+	// Possible errors are IOError, WrongMode, InactiveFD, IllegalArgument, and TryAgain.
+	// If err is non-nil, bytesRead is -1 and data is unspecified.
+	Pread(fileDescriptor int, offset int, numBytes int) (bytesRead int, data []byte, err error)
+
+	// Like Write, but writes all of data starting at offset rather than at the file offset, which is neither used
+	// nor changed.
+	//
+	// The data is written at offset even if the file was opened in Append mode. Limits apply as they do to Write.
+	// If offset is past the end of the file, the gap reads as zeros, as it does after seeking past the end and
+	// writing. If data is empty, this is a no-op. If offset is negative, returns IllegalArgument, and if it is the
+	// largest an int can hold, so that nothing fits after it, returns FileTooLarge.
+	// Possible errors are IOError, WrongMode, InactiveFD, TryAgain, FileTooLarge, IllegalArgument, or NoMoreSpace.
+	// If err is non-nil, bytesWritten is -1.
+	Pwrite(fileDescriptor int, offset int, data []byte) (bytesWritten int, err error)
+
 	// Deletes a name from the filesystem.
 	//
 	// If the name is a file, the file is deleted and the space it was using is made available for reuse.
 	// If the name is a directory, deletes it if it is empty or otherwise returns DirectoryNotEmpty.
-	// Calling Delete("/") results in an IllegalArgument error.
+	// Calling Delete("/"), or on any path that cleans to "/", results in an IllegalArgument error.
 	// Possible errors are NotFound, IllegalArgument, DirectoryNotEmpty, TryAgain, or IOError.
 	// Success is false if and only if err is non-nil.
 	Delete(path string) (success bool, err error)
 
+	// Give the file, directory or symbolic link at from the name to, which may be in another directory.
+	//
+	// The rename happens all at once, without copying anything, and a file that is open stays open under its new
+	// name, with the same file descriptor. If to already exists, it is replaced, as if it were deleted first, as
+	// long as both are directories or neither is; otherwise returns IsDirectory. A directory can only replace an
+	// empty one, and returns DirectoryNotEmpty otherwise. Renaming something to its own name does nothing.
+	// What is renamed stops counting against the quotas (see SetQuota) of the directories above from and starts
+	// counting against those above to. If that would take a directory that is above to but not above from over its
+	// quota, or take the new parent over MaxDirEntries (see Limits), returns NoMoreSpace.
+	// Renaming the root, renaming something over the root, or moving a directory under itself returns
+	// IllegalArgument.
+	// Possible errors are NotFound, IllegalArgument, IsDirectory, DirectoryNotEmpty, NoMoreSpace, TooManyLinks, and
+	// TryAgain. Success is false if and only if err is non-nil.
+	Rename(from string, to string) (success bool, err error)
+
+	// Get information about the file or directory at path.
+	//
+	// The file does not need to be open, and its offset is not changed. Stat("/") describes the root directory.
+	// Possible errors are NotFound, IllegalArgument, TooManyLinks, and TryAgain. If err is non-nil, info is
+	// unspecified.
+	Stat(path string) (info FileInfo, err error)
+
+	// Like Stat, but if path names a symbolic link, describes the link rather than what it points to.
+	//
+	// Possible errors are NotFound, IllegalArgument, TooManyLinks, and TryAgain. If err is non-nil, info is
+	// unspecified.
+	Lstat(path string) (info FileInfo, err error)
+
+	// Make a symbolic link at linkPath that points to target.
+	//
+	// target is kept as it is given and isn't looked up until the link is followed, so it need not exist. If it
+	// doesn't begin with "/", it is relative to the directory holding the link. A link counts as an inode in Limits
+	// and quotas (see SetQuota), so making one can return NoMoreSpace like making a file can.
+	// If something already exists at linkPath, even another link, returns AlreadyExists.
+	// If target is empty, holds a NUL byte, or is longer than MaxPathLength, returns IllegalArgument.
+	// Specification adapted from http://man7.org/linux/man-pages/man2/symlink.2.html.
+	// Possible errors are NotFound, AlreadyExists, IllegalArgument, NoMoreSpace, TooManyLinks, and TryAgain.
+	// Success is false if and only if err is non-nil.
+	Symlink(target string, linkPath string) (success bool, err error)
+
+	// Return the target of the symbolic link at path, exactly as it was given to Symlink.
+	//
+	// If path names a file or directory rather than a link, returns IllegalArgument.
+	// Possible errors are NotFound, IllegalArgument, TooManyLinks, and TryAgain. If err is non-nil, target is "".
+	Readlink(path string) (target string, err error)
+
+	// List the files, directories and symbolic links directly inside the directory at path, sorted by name.
+	//
+	// Links are described as Lstat describes them, not followed. If path names a file rather than a directory,
+	// returns NotFound, like a path with a file in the middle of it.
+	// Possible errors are NotFound, IllegalArgument, TooManyLinks, and TryAgain. If err is non-nil, entries is
+	// unspecified.
+	ReadDir(path string) (entries []FileInfo, err error)
+
+	// Make the file at path exactly size bytes long.
+	//
+	// If the file is longer than size, the bytes past size are discarded. If it is shorter, it is extended with a
+	// hole that reads as zeros. The file does not need to be open, and no file offset is changed, even one that is
+	// now past the end of the file.
+	// If size is negative, returns IllegalArgument. If the file would grow past MaxFileSize or make the filesystem
+	// bigger than MaxBytes (see Limits), returns FileTooLarge or NoMoreSpace respectively, and the size doesn't change.
+	// Going over a directory's quota (see SetQuota) returns NoMoreSpace too.
+	// Possible errors are NotFound, IsDirectory, IllegalArgument, FileTooLarge, NoMoreSpace, and TryAgain.
+	// Success is false if and only if err is non-nil.
+	Truncate(path string, size int) (success bool, err error)
+
+	// Like Truncate, but on the file referred to by the file descriptor, which must be open for writing.
+	//
+	// If the file is open for reading only, returns WrongMode.
+	// Possible errors are InactiveFD, WrongMode, IllegalArgument, FileTooLarge, NoMoreSpace, and TryAgain.
+	// Success is false if and only if err is non-nil.
+	Ftruncate(fileDescriptor int, size int) (success bool, err error)
+
+	// Manipulate the space of the byte range [offset, offset+length) of the file referred to by the file descriptor,
+	// which must be open for writing.
+	//
+	// If mode is Allocate and the file ends before offset+length, it is extended to offset+length bytes with zeros.
+	// If mode is PunchHole, the bytes in the range that are in the file become a hole, which reads as zeros and
+	// takes no space; the size of the file doesn't change.
+	// Neither mode changes the file offset.
+	// If offset is negative, length is not positive, or mode is unknown, returns IllegalArgument.
+	// If the file is open for reading only, returns WrongMode.
+	// Specification adapted from http://man7.org/linux/man-pages/man2/fallocate.2.html.
+	// If Allocate would go over MaxFileSize, MaxBytes or a quota, returns FileTooLarge or NoMoreSpace like Truncate
+	// does, and if offset+length is more than an int can hold, returns FileTooLarge.
+	// Possible errors are InactiveFD, WrongMode, IllegalArgument, TryAgain, FileTooLarge, and NoMoreSpace.
+	// Success is false if and only if err is non-nil.
+	Fallocate(fileDescriptor int, mode FallocateMode, offset int, length int) (success bool, err error)
+
+	// Report how much the filesystem holds and the Limits on how much it may hold.
+	//
+	// Possible errors are TryAgain. If err is non-nil, info is unspecified.
+	Statfs() (info FsInfo, err error)
+
+	// Limit what the directory at path may hold: the files and directories anywhere under it, not counting the
+	// directory itself, may add up to at most maxBytes bytes and maxInodes files and directories.
+	//
+	// A limit of 0 means there is none, so SetQuota(path, 0, 0) removes the quota. Once a directory has a quota,
+	// anything that would take it over, such as creating a file, making a directory, or growing a file, returns
+	// NoMoreSpace the way going over Limits does, including writing only as many bytes as fit. Setting a quota below
+	// what the directory already holds doesn't delete anything, but nothing under it can grow until it is back under
+	// the quota. A file that is deleted while it is open stops counting against quotas right away.
+	// Rename moves usage from the quotas above the old name to the quotas above the new one.
+	// If path names a file rather than a directory, returns NotFound, like ReadDir.
+	// If maxBytes or maxInodes is negative, returns IllegalArgument.
+	// Possible errors are NotFound, IllegalArgument, and TryAgain. Success is false if and only if err is non-nil.
+	SetQuota(path string, maxBytes int, maxInodes int) (success bool, err error)
+
+	// Report the quota on the directory at path and how much is under it. A directory without a quota reports
+	// limits of 0 and how much is under it all the same.
+	//
+	// If path names a file rather than a directory, returns NotFound, like ReadDir.
+	// Possible errors are NotFound, IllegalArgument, and TryAgain. If err is non-nil, quota is unspecified.
+	GetQuota(path string) (quota Quota, err error)
+
 	// Creates a copy of the file descriptor, using the lowest-numbered unused file descriptor.
 	//
 	// This function is not yet supported, so the spec is incomplete.
 	//func (ck *FSClerk) Duplicate(fileDescriptor int) (newFileDescriptor int, err error) { panic("Not supported.") }
 }
 
+// Describes a file, directory or symbolic link, as returned by Stat, Lstat and ReadDir.
+type FileInfo struct {
+	Name      string // the last component of the path; "/" for the root directory
+	IsDir     bool
+	Size      int  // the length of a file in bytes, or of a link's target; 0 for a directory
+	IsOpen    bool // whether some file descriptor refers to this file; false for a directory or a link
+	IsSymlink bool // only ever true from Lstat and ReadDir, since Stat describes what a link points to
+}
+
+// Limits on how much a filesystem may hold. A limit of 0 means there is none, so the zero value has no limits.
+type Limits struct {
+	MaxBytes      int // the most the sizes of all the files may add up to, counting holes
+	MaxInodes     int // the most files and directories there may be, counting the root
+	MaxFileSize   int // the largest any one file may be
+	MaxDirEntries int // the most files and directories any one directory may hold
+}
+
+// Describes how much a filesystem holds, as returned by Statfs. A file that is deleted while it is open still counts
+// until it is closed.
+type FsInfo struct {
+	Bytes  int // the sizes of all the files, added up
+	Inodes int // the number of files and directories, counting the root
+	Limits Limits
+}
+
+// A directory's quota and how much is under it, as returned by GetQuota. A limit of 0 means there is none.
+type Quota struct {
+	MaxBytes  int // the most the sizes of the files under the directory may add up to
+	MaxInodes int // the most files and directories there may be under the directory
+	Bytes     int // the sizes of the files under the directory, added up
+	Inodes    int // the number of files and directories under the directory, not counting itself
+}
+
 // The maximum number of file descriptors that can be active.
 const MaxActiveFDs = 128
 
@@ -153,3 +337,21 @@ const (
 	FromCurrent                   // Seek relative to the current position.
 	FromEnd                       // Seek after the end of the file.
 )
+
+type FallocateMode int
+
+const (
+	Allocate  FallocateMode = iota // Make sure the file is at least offset+length bytes long.
+	PunchHole                      // Turn the range into a hole without changing the size of the file.
+)
+
+func (m FallocateMode) String() string {
+	switch m {
+	case Allocate:
+		return "Allocate"
+	case PunchHole:
+		return "PunchHole"
+	default:
+		return fmt.Sprintf("FallocateMode(%d)", int(m))
+	}
+}
@@ -3,7 +3,9 @@ package filesystem
 import (
 	"ad"
 	"fmt"
+	"math"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 )
@@ -44,6 +46,7 @@ var FunctionalityTests = []func(t *testing.T, fs FileSystem){
 	TestSeekErrorBadFD,
 	TestSeekErrorBadOffsetOperation,
 	TestSeekOffEOF,
+	TestWriteAtHugeOffset,
 	TestWriteClosedFile,
 	TestWriteReadBasic,
 	TestWriteReadBasic4,
@@ -78,6 +81,31 @@ var FunctionalityTests = []func(t *testing.T, fs FileSystem){
 	TestMkdirAlreadyExists,
 	TestRndWriteReadVerfiyHoleExpansion,
 	TestDeleteCannotDeleteRootDir,
+	TestStat,
+	TestReadDir,
+	TestTruncateExtendsWithZeros,
+	TestTruncateBelowOffset,
+	TestFallocatePunchHole,
+	TestPreadPwrite,
+	TestPreadPwriteErrors,
+	TestPreadPwriteHugeArguments,
+	TestStatfs,
+	TestQuota,
+	TestQuotaErrors,
+	TestQuotaRename,
+	TestPathNormalization,
+	TestPathValidation,
+	TestPathsThatNameTheRoot,
+	TestSymlinkAbsolute,
+	TestSymlinkRelative,
+	TestSymlinkDangling,
+	TestSymlinkLoop,
+	TestSymlinkItself,
+	TestSymlinkErrors,
+	TestRename,
+	TestRenameReplace,
+	TestRenameOpenFile,
+	TestRenameErrors,
 }
 
 var testNames = []string{
@@ -217,6 +245,16 @@ func HelpMkdir(t *testing.T, fs FileSystem, path string) {
 	ad.AssertExplainT(t, success, "mkdir fail on %s", path)
 }
 
+func HelpSymlink(t *testing.T, fs FileSystem, target string, linkPath string) {
+	success, err := fs.Symlink(target, linkPath)
+	ad.AssertExplainT(t, success && err == nil, "err %v making a link at %s to %s", err, linkPath, target)
+}
+
+func HelpRename(t *testing.T, fs FileSystem, from string, to string) {
+	success, err := fs.Rename(from, to)
+	ad.AssertExplainT(t, success && err == nil, "err %v renaming %s to %s", err, from, to)
+}
+
 // ===== END MKDIR HELPERS =====
 
 // ===== BEGIN READ WRITE SEEK HELPERS =====
@@ -268,7 +306,7 @@ func HelpReadWrite(t *testing.T, fs FileSystem, path string, contents string) in
 	nBytes, data := HelpRead(t, fs, fd, len(contents))
 	for bite := 0; bite < len(contents); bite++ { //'byte' is reserved
 		ad.AssertExplainT(t, data[bite] == contents[bite],
-			"read data %s vs %s", data[bite], contents[bite])
+			"read data %q vs %q at byte %d", data[bite], contents[bite], bite)
 	}
 	return nBytes
 }
@@ -400,7 +438,8 @@ func TestOpenAppend(t *testing.T, fs FileSystem) {
 	pos := HelpSeek(t, fs, fd, 0, FromCurrent)
 	ad.AssertExplainT(t, pos == contentLengthBytes,
 		"Opened a file for append and expected "+
-			"the offset to be at the end of the file (position %d), but it was actually at position %d.")
+			"the offset to be at the end of the file (position %d), but it was actually at position %d.",
+		contentLengthBytes, pos)
 
 	// Now make sure that position was actually at the end of the file.
 	newPos := HelpSeek(t, fs, fd, 0, FromEnd)
@@ -668,6 +707,24 @@ func TestSeekOffEOF(t *testing.T, fs FileSystem) {
 	HelpDelete(t, fs, "/seek-eof.txt")
 }
 
+// Nothing fits after the largest offset an int can hold, and seeking further is like seeking before the start.
+func TestWriteAtHugeOffset(t *testing.T, fs FileSystem) {
+	fd := HelpOpen(t, fs, "/huge-offset.txt", ReadWrite, Create)
+	HelpSeek(t, fs, fd, math.MaxInt, FromBeginning)
+	n, err := fs.Write(fd, 1, []byte("x"))
+	ad.AssertEqualsT(t, FileTooLarge, err)
+	ad.AssertEqualsT(t, -1, n)
+	_, err = fs.Seek(fd, 1, FromCurrent)
+	ad.AssertEqualsT(t, IllegalArgument, err)
+	ad.AssertEqualsT(t, math.MaxInt, HelpSeek(t, fs, fd, 0, FromCurrent))
+
+	info, err := fs.Stat("/huge-offset.txt")
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, 0, info.Size)
+	HelpClose(t, fs, fd)
+	HelpDelete(t, fs, "/huge-offset.txt")
+}
+
 // ===== BEGIN ITERATIVE WRITE CHUNK TESTS EXPANDING FILES =====
 
 func TestWriteClosedFile(t *testing.T, fs FileSystem) {
@@ -935,3 +992,947 @@ func TestDeleteCannotDeleteRootDir(t *testing.T, fs FileSystem) {
 	ad.AssertExplainT(t, err == IllegalArgument, "Attempted to delete the root directory of a filesystem, expected "+
 		"IllegalArgument error, got %s", err)
 }
+
+// ===== BEGIN STAT AND READDIR TESTS =====
+
+func TestStat(t *testing.T, fs FileSystem) {
+	HelpMkdir(t, fs, "/dir")
+	fd := HelpOpen(t, fs, "/dir/file", ReadWrite, Create)
+	HelpWriteString(t, fs, fd, "12345")
+
+	info, err := fs.Stat("/dir/file")
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, FileInfo{Name: "file", Size: 5, IsOpen: true}, info)
+	HelpClose(t, fs, fd)
+	info, err = fs.Stat("/dir/file")
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, FileInfo{Name: "file", Size: 5, IsOpen: false}, info)
+
+	info, err = fs.Stat("/dir")
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, FileInfo{Name: "dir", IsDir: true}, info)
+	info, err = fs.Stat("/")
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, FileInfo{Name: "/", IsDir: true}, info)
+
+	_, err = fs.Stat("/dir/missing")
+	ad.AssertEqualsT(t, NotFound, err)
+	_, err = fs.Stat("/dir/file/below-a-file")
+	ad.AssertEqualsT(t, NotFound, err)
+}
+
+func TestReadDir(t *testing.T, fs FileSystem) {
+	entries, err := fs.ReadDir("/")
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, 0, len(entries))
+
+	HelpMkdir(t, fs, "/b-dir")
+	for path, contents := range map[string]string{"/c-file": "abc", "/a-file": "", "/b-dir/nested": "x"} {
+		fd := HelpOpen(t, fs, path, WriteOnly, Create)
+		HelpWriteString(t, fs, fd, contents)
+		HelpClose(t, fs, fd)
+	}
+
+	entries, err = fs.ReadDir("/")
+	ad.AssertNoErrorT(t, err)
+	expected := []FileInfo{{Name: "a-file"}, {Name: "b-dir", IsDir: true}, {Name: "c-file", Size: 3}}
+	ad.AssertEqualsT(t, len(expected), len(entries))
+	for i := range expected {
+		ad.AssertEqualsT(t, expected[i], entries[i])
+	}
+	entries, err = fs.ReadDir("/b-dir")
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, 1, len(entries))
+	ad.AssertEqualsT(t, FileInfo{Name: "nested", Size: 1}, entries[0])
+
+	_, err = fs.ReadDir("/c-file")
+	ad.AssertEqualsT(t, NotFound, err)
+	_, err = fs.ReadDir("/missing")
+	ad.AssertEqualsT(t, NotFound, err)
+}
+
+// ===== BEGIN TRUNCATE AND FALLOCATE TESTS =====
+
+func TestTruncateExtendsWithZeros(t *testing.T, fs FileSystem) {
+	HelpMkdir(t, fs, "/dir")
+	fd := HelpOpen(t, fs, "/dir/file", WriteOnly, Create)
+	HelpWriteString(t, fs, fd, "abc")
+	HelpClose(t, fs, fd)
+
+	// the file doesn't need to be open
+	success, err := fs.Truncate("/dir/file", 8)
+	ad.AssertNoErrorT(t, err)
+	ad.AssertT(t, success)
+	info, err := fs.Stat("/dir/file")
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, 8, info.Size)
+
+	fd = HelpOpen(t, fs, "/dir/file", ReadWrite, 0)
+	HelpSeek(t, fs, fd, 0, FromBeginning)
+	_, data := HelpRead(t, fs, fd, 8)
+	HelpVerifyBytes(t, []byte("abc\x00\x00\x00\x00\x00"), data, "Truncate didn't extend the file with zeros")
+
+	// Ftruncate extends past the offset, which stays where it was
+	success, err = fs.Ftruncate(fd, 10)
+	ad.AssertNoErrorT(t, err)
+	ad.AssertT(t, success)
+	_, data = HelpRead(t, fs, fd, 2)
+	HelpVerifyBytes(t, HelpMakeZeros(t, 2), data, "Ftruncate didn't extend the file with zeros")
+	HelpClose(t, fs, fd)
+
+	_, err = fs.Truncate("/dir/missing", 1)
+	ad.AssertEqualsT(t, NotFound, err)
+	_, err = fs.Truncate("/dir", 1)
+	ad.AssertEqualsT(t, IsDirectory, err)
+	_, err = fs.Truncate("/", 1)
+	ad.AssertEqualsT(t, IsDirectory, err)
+	success, err = fs.Truncate("/dir/file", -1)
+	ad.AssertEqualsT(t, IllegalArgument, err)
+	ad.AssertT(t, !success)
+}
+
+func TestTruncateBelowOffset(t *testing.T, fs FileSystem) {
+	fd := HelpOpen(t, fs, "/file", ReadWrite, Create)
+	HelpWriteString(t, fs, fd, "0123456789")
+
+	success, err := fs.Ftruncate(fd, 4)
+	ad.AssertNoErrorT(t, err)
+	ad.AssertT(t, success)
+	// the offset is now past the end of the file, so there's nothing to read and the next write leaves a hole
+	ad.AssertEqualsT(t, 10, HelpSeek(t, fs, fd, 0, FromCurrent))
+	bytesRead, _, err := fs.Read(fd, 1)
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, 0, bytesRead)
+	HelpWriteString(t, fs, fd, "x")
+	HelpSeek(t, fs, fd, 0, FromBeginning)
+	_, data := HelpRead(t, fs, fd, 11)
+	HelpVerifyBytes(t, []byte("0123\x00\x00\x00\x00\x00\x00x"), data, "writing after Ftruncate")
+
+	_, err = fs.Ftruncate(fd, -1)
+	ad.AssertEqualsT(t, IllegalArgument, err)
+	HelpClose(t, fs, fd)
+	_, err = fs.Ftruncate(fd, 0)
+	ad.AssertEqualsT(t, InactiveFD, err)
+
+	fd = HelpOpen(t, fs, "/file", ReadOnly, 0)
+	_, err = fs.Ftruncate(fd, 0)
+	ad.AssertEqualsT(t, WrongMode, err)
+	HelpClose(t, fs, fd)
+}
+
+func TestFallocatePunchHole(t *testing.T, fs FileSystem) {
+	const size = 100000 // big enough to span several pieces of a file, however a FileSystem stores it
+	contents := HelpMakeRndBytes(t, size)
+	fd := HelpOpen(t, fs, "/file", ReadWrite, Create)
+	HelpWriteBytes(t, fs, fd, contents)
+	HelpSeek(t, fs, fd, 123, FromBeginning)
+
+	// punch a hole in the middle, and ones that run past the end, however far
+	success, err := fs.Fallocate(fd, PunchHole, 1000, 70000)
+	ad.AssertNoErrorT(t, err)
+	ad.AssertT(t, success)
+	copy(contents[1000:71000], HelpMakeZeros(t, 70000))
+	_, err = fs.Fallocate(fd, PunchHole, size-10, 100)
+	ad.AssertNoErrorT(t, err)
+	copy(contents[size-10:], HelpMakeZeros(t, 10))
+	_, err = fs.Fallocate(fd, PunchHole, size-20, math.MaxInt)
+	ad.AssertNoErrorT(t, err)
+	copy(contents[size-20:], HelpMakeZeros(t, 20))
+
+	// neither the size nor the offset changed
+	info, err := fs.Stat("/file")
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, size, info.Size)
+	ad.AssertEqualsT(t, 123, HelpSeek(t, fs, fd, 0, FromCurrent))
+	HelpSeek(t, fs, fd, 0, FromBeginning)
+	_, data := HelpRead(t, fs, fd, size)
+	HelpVerifyBytes(t, contents, data, "reading a file with holes punched in it")
+
+	// Allocate only ever makes the file longer
+	_, err = fs.Fallocate(fd, Allocate, 0, 10)
+	ad.AssertNoErrorT(t, err)
+	_, err = fs.Fallocate(fd, Allocate, size-5, 20)
+	ad.AssertNoErrorT(t, err)
+	info, err = fs.Stat("/file")
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, size+15, info.Size)
+	_, data = HelpRead(t, fs, fd, 15)
+	HelpVerifyBytes(t, HelpMakeZeros(t, 15), data, "reading what Allocate added")
+
+	_, err = fs.Fallocate(fd, Allocate, 1, math.MaxInt)
+	ad.AssertEqualsT(t, FileTooLarge, err)
+
+	_, err = fs.Fallocate(fd, PunchHole, 0, 0)
+	ad.AssertEqualsT(t, IllegalArgument, err)
+	_, err = fs.Fallocate(fd, PunchHole, -1, 10)
+	ad.AssertEqualsT(t, IllegalArgument, err)
+	_, err = fs.Fallocate(fd, FallocateMode(7), 0, 10)
+	ad.AssertEqualsT(t, IllegalArgument, err)
+	HelpClose(t, fs, fd)
+	_, err = fs.Fallocate(fd, PunchHole, 0, 10)
+	ad.AssertEqualsT(t, InactiveFD, err)
+
+	fd = HelpOpen(t, fs, "/file", ReadOnly, 0)
+	_, err = fs.Fallocate(fd, PunchHole, 0, 10)
+	ad.AssertEqualsT(t, WrongMode, err)
+	HelpClose(t, fs, fd)
+}
+
+// ===== BEGIN PREAD AND PWRITE TESTS =====
+
+func TestPreadPwrite(t *testing.T, fs FileSystem) {
+	fd := HelpOpen(t, fs, "/file", ReadWrite, Create)
+	HelpWriteString(t, fs, fd, "0123456789")
+	HelpSeek(t, fs, fd, 3, FromBeginning)
+
+	// neither uses nor moves the offset
+	bytesRead, data, err := fs.Pread(fd, 6, 100)
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, 4, bytesRead)
+	HelpVerifyBytes(t, []byte("6789"), data[:bytesRead], "Pread")
+	bytesWritten, err := fs.Pwrite(fd, 1, []byte("ab"))
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, 2, bytesWritten)
+	_, data = HelpRead(t, fs, fd, 3)
+	HelpVerifyBytes(t, []byte("345"), data, "Read after Pread and Pwrite")
+
+	// at or past the end, Pread reads nothing, and Pwrite leaves a hole
+	bytesRead, _, err = fs.Pread(fd, 10, 1)
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, 0, bytesRead)
+	bytesRead, _, err = fs.Pread(fd, 1000, 1)
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, 0, bytesRead)
+	_, err = fs.Pwrite(fd, 12, []byte("x"))
+	ad.AssertNoErrorT(t, err)
+	bytesRead, data, err = fs.Pread(fd, 0, 100)
+	ad.AssertNoErrorT(t, err)
+	HelpVerifyBytes(t, []byte("0ab3456789\x00\x00x"), data[:bytesRead], "Pread of the whole file")
+	ad.AssertEqualsT(t, 6, HelpSeek(t, fs, fd, 0, FromCurrent))
+
+	// an empty Pwrite doesn't make the file longer
+	bytesWritten, err = fs.Pwrite(fd, 100, []byte{})
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, 0, bytesWritten)
+	info, err := fs.Stat("/file")
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, 13, info.Size)
+	HelpClose(t, fs, fd)
+
+	// Pwrite writes at offset even in Append mode
+	fd = HelpOpen(t, fs, "/file", WriteOnly, Append)
+	_, err = fs.Pwrite(fd, 0, []byte("A"))
+	ad.AssertNoErrorT(t, err)
+	HelpWriteString(t, fs, fd, "!")
+	HelpClose(t, fs, fd)
+	fd = HelpOpen(t, fs, "/file", ReadOnly, 0)
+	bytesRead, data, err = fs.Pread(fd, 0, 100)
+	ad.AssertNoErrorT(t, err)
+	HelpVerifyBytes(t, []byte("Aab3456789\x00\x00x!"), data[:bytesRead], "Pread after Pwrite in Append mode")
+	HelpClose(t, fs, fd)
+}
+
+func TestPreadPwriteErrors(t *testing.T, fs FileSystem) {
+	fd := HelpOpen(t, fs, "/file", ReadWrite, Create)
+	bytesRead, _, err := fs.Pread(fd, -1, 1)
+	ad.AssertEqualsT(t, IllegalArgument, err)
+	ad.AssertEqualsT(t, -1, bytesRead)
+	_, _, err = fs.Pread(fd, 0, -1)
+	ad.AssertEqualsT(t, IllegalArgument, err)
+	bytesWritten, err := fs.Pwrite(fd, -1, []byte("x"))
+	ad.AssertEqualsT(t, IllegalArgument, err)
+	ad.AssertEqualsT(t, -1, bytesWritten)
+	HelpClose(t, fs, fd)
+
+	_, _, err = fs.Pread(fd, 0, 1)
+	ad.AssertEqualsT(t, InactiveFD, err)
+	_, err = fs.Pwrite(fd, 0, []byte("x"))
+	ad.AssertEqualsT(t, InactiveFD, err)
+
+	fd = HelpOpen(t, fs, "/file", ReadOnly, 0)
+	_, err = fs.Pwrite(fd, 0, []byte("x"))
+	ad.AssertEqualsT(t, WrongMode, err)
+	HelpClose(t, fs, fd)
+	fd = HelpOpen(t, fs, "/file", WriteOnly, 0)
+	_, _, err = fs.Pread(fd, 0, 1)
+	ad.AssertEqualsT(t, WrongMode, err)
+	HelpClose(t, fs, fd)
+}
+
+// Offsets and lengths near the largest int don't overflow.
+func TestPreadPwriteHugeArguments(t *testing.T, fs FileSystem) {
+	fd := HelpOpen(t, fs, "/file", ReadWrite, Create)
+	HelpWriteString(t, fs, fd, "0123456789")
+
+	bytesRead, data, err := fs.Pread(fd, 7, math.MaxInt)
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, 3, bytesRead)
+	HelpVerifyBytes(t, []byte("789"), data[:bytesRead], "Pread of up to MaxInt bytes")
+	bytesRead, _, err = fs.Pread(fd, math.MaxInt, math.MaxInt)
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, 0, bytesRead)
+
+	bytesWritten, err := fs.Pwrite(fd, math.MaxInt, []byte("x"))
+	ad.AssertEqualsT(t, FileTooLarge, err)
+	ad.AssertEqualsT(t, -1, bytesWritten)
+	info, err := fs.Stat("/file")
+	ad.AssertNoErrorT(t, err)
+	ad.AssertEqualsT(t, 10, info.Size)
+	HelpClose(t, fs, fd)
+}
+
+func TestStatfs(t *testing.T, fs FileSystem) {
+	before, err := fs.Statfs()
+	ad.AssertEqualsT(t, nil, err)
+	ad.AssertExplainT(t, before.Inodes >= 1, "Statfs() counts %d inodes, but there is always the root", before.Inodes)
+
+	HelpMkdir(t, fs, "/dir")
+	fd := HelpOpen(t, fs, "/dir/file", ReadWrite, Create)
+	HelpWriteString(t, fs, fd, "hello")
+	info, err := fs.Statfs()
+	ad.AssertEqualsT(t, nil, err)
+	ad.AssertEqualsT(t, before.Inodes+2, info.Inodes)
+	ad.AssertEqualsT(t, before.Bytes+5, info.Bytes)
+	ad.AssertEqualsT(t, before.Limits, info.Limits)
+
+	// a file deleted while it is open takes up space until it is closed.
+	HelpDelete(t, fs, "/dir/file")
+	info, _ = fs.Statfs()
+	ad.AssertEqualsT(t, before.Inodes+2, info.Inodes)
+	ad.AssertEqualsT(t, before.Bytes+5, info.Bytes)
+	HelpClose(t, fs, fd)
+	HelpDelete(t, fs, "/dir")
+	info, _ = fs.Statfs()
+	ad.AssertEqualsT(t, before.Inodes, info.Inodes)
+	ad.AssertEqualsT(t, before.Bytes, info.Bytes)
+}
+
+func TestQuota(t *testing.T, fs FileSystem) {
+	HelpMkdir(t, fs, "/teams")
+	HelpMkdir(t, fs, "/teams/foo")
+	success, err := fs.SetQuota("/teams", 0, 4)
+	ad.AssertEqualsT(t, nil, err)
+	ad.AssertEqualsT(t, true, success)
+	_, err = fs.SetQuota("/teams/foo", 10, 0)
+	ad.AssertEqualsT(t, nil, err)
+
+	// a write that would go over the quota writes what fits.
+	fd := HelpOpen(t, fs, "/teams/foo/file", ReadWrite, Create)
+	HelpWriteString(t, fs, fd, "0123456")
+	bytesWritten, err := fs.Write(fd, 5, []byte("789ab"))
+	ad.AssertEqualsT(t, nil, err)
+	ad.AssertEqualsT(t, 3, bytesWritten)
+	bytesWritten, err = fs.Write(fd, 1, []byte("c"))
+	ad.AssertEqualsT(t, NoMoreSpace, err)
+	ad.AssertEqualsT(t, -1, bytesWritten)
+	_, err = fs.Ftruncate(fd, 11)
+	ad.AssertEqualsT(t, NoMoreSpace, err)
+	quota, err := fs.GetQuota("/teams/foo")
+	ad.AssertEqualsT(t, nil, err)
+	ad.AssertEqualsT(t, Quota{MaxBytes: 10, Bytes: 10, Inodes: 1}, quota)
+
+	// usage counts everything below, and the quota of any directory above applies.
+	HelpMkdir(t, fs, "/teams/foo/sub")
+	HelpMkdir(t, fs, "/teams/bar")
+	_, err = fs.Mkdir("/teams/foo/sub/deeper")
+	ad.AssertEqualsT(t, NoMoreSpace, err)
+	fd2, err := fs.Open("/teams/bar/file", ReadWrite, Create)
+	ad.AssertEqualsT(t, NoMoreSpace, err)
+	ad.AssertEqualsT(t, -1, fd2)
+	quota, _ = fs.GetQuota("/teams")
+	ad.AssertEqualsT(t, Quota{MaxInodes: 4, Bytes: 10, Inodes: 4}, quota)
+
+	// somewhere else isn't limited by it, and deleting frees up room.
+	HelpMkdir(t, fs, "/elsewhere")
+	HelpDelete(t, fs, "/teams/bar")
+	HelpMkdir(t, fs, "/teams/foo/sub/deeper")
+	HelpClose(t, fs, fd)
+	HelpDelete(t, fs, "/teams/foo/file")
+	quota, _ = fs.GetQuota("/teams/foo")
+	ad.AssertEqualsT(t, Quota{MaxBytes: 10, Bytes: 0, Inodes: 2}, quota)
+
+	// moving a file by copying and deleting it moves its usage from one quota to the other.
+	HelpMkdir(t, fs, "/other")
+	_, err = fs.SetQuota("/other", 100, 0)
+	ad.AssertEqualsT(t, nil, err)
+	fd = HelpOpen(t, fs, "/other/file", WriteOnly, Create)
+	HelpWriteString(t, fs, fd, "moving")
+	HelpClose(t, fs, fd)
+	fd = HelpOpen(t, fs, "/teams/foo/sub/file", WriteOnly, Create)
+	HelpWriteString(t, fs, fd, "moving")
+	HelpClose(t, fs, fd)
+	HelpDelete(t, fs, "/other/file")
+	quota, _ = fs.GetQuota("/other")
+	ad.AssertEqualsT(t, Quota{MaxBytes: 100}, quota)
+	quota, _ = fs.GetQuota("/teams/foo")
+	ad.AssertEqualsT(t, Quota{MaxBytes: 10, Bytes: 6, Inodes: 3}, quota)
+
+	// removing a quota
+	_, err = fs.SetQuota("/teams", 0, 0)
+	ad.AssertEqualsT(t, nil, err)
+	HelpMkdir(t, fs, "/teams/bar")
+	quota, _ = fs.GetQuota("/teams")
+	ad.AssertEqualsT(t, Quota{Bytes: 6, Inodes: 5}, quota)
+}
+
+func TestQuotaErrors(t *testing.T, fs FileSystem) {
+	_, err := fs.SetQuota("/missing", 1, 1)
+	ad.AssertEqualsT(t, NotFound, err)
+	_, err = fs.GetQuota("/missing")
+	ad.AssertEqualsT(t, NotFound, err)
+	fd := HelpOpen(t, fs, "/file", ReadWrite, Create)
+	HelpClose(t, fs, fd)
+	success, err := fs.SetQuota("/file", 1, 1)
+	ad.AssertEqualsT(t, NotFound, err)
+	ad.AssertEqualsT(t, false, success)
+	_, err = fs.GetQuota("/file")
+	ad.AssertEqualsT(t, NotFound, err)
+	_, err = fs.SetQuota("/", -1, 0)
+	ad.AssertEqualsT(t, IllegalArgument, err)
+	_, err = fs.SetQuota("/", 0, -1)
+	ad.AssertEqualsT(t, IllegalArgument, err)
+	quota, err := fs.GetQuota("/")
+	ad.AssertEqualsT(t, nil, err)
+	ad.AssertEqualsT(t, 0, quota.MaxBytes)
+}
+
+// Rename moves usage from quota to quota, and only the quotas that a move adds to can stop it.
+func TestQuotaRename(t *testing.T, fs FileSystem) {
+	HelpMkdir(t, fs, "/q1")
+	HelpMkdir(t, fs, "/q1/a")
+	HelpMkdir(t, fs, "/q1/b")
+	HelpMkdir(t, fs, "/q2")
+	_, err := fs.SetQuota("/q1", 10, 0)
+	ad.AssertEqualsT(t, nil, err)
+	_, err = fs.SetQuota("/q2", 5, 0)
+	ad.AssertEqualsT(t, nil, err)
+
+	// within one quota, a file moves even when there isn't room for a second copy of it.
+	helpWriteThrough(t, fs, "/q1/a/file", "12345678")
+	HelpRename(t, fs, "/q1/a/file", "/q1/b/file")
+	quota, _ := fs.GetQuota("/q1")
+	ad.AssertEqualsT(t, Quota{MaxBytes: 10, Bytes: 8, Inodes: 3}, quota)
+
+	// across quotas, it has to fit in the new one, and then it only counts there.
+	success, err := fs.Rename("/q1/b/file", "/q2/file")
+	ad.AssertEqualsT(t, NoMoreSpace, err)
+	ad.AssertEqualsT(t, false, success)
+	quota, _ = fs.GetQuota("/q2")
+	ad.AssertEqualsT(t, Quota{MaxBytes: 5}, quota)
+	_, err = fs.Truncate("/q1/b/file", 4)
+	ad.AssertEqualsT(t, nil, err)
+	HelpRename(t, fs, "/q1/b/file", "/q2/file")
+	quota, _ = fs.GetQuota("/q1")
+	ad.AssertEqualsT(t, Quota{MaxBytes: 10, Bytes: 0, Inodes: 2}, quota)
+	quota, _ = fs.GetQuota("/q2")
+	ad.AssertEqualsT(t, Quota{MaxBytes: 5, Bytes: 4, Inodes: 1}, quota)
+
+	// a directory brings everything under it along.
+	HelpMkdir(t, fs, "/q1/a/sub")
+	helpWriteThrough(t, fs, "/q1/a/sub/f", "abc")
+	_, err = fs.SetQuota("/q2", 100, 3)
+	ad.AssertEqualsT(t, nil, err)
+	_, err = fs.Rename("/q1/a", "/q2/a")
+	ad.AssertEqualsT(t, NoMoreSpace, err)
+	_, err = fs.SetQuota("/q2", 100, 0)
+	ad.AssertEqualsT(t, nil, err)
+	HelpRename(t, fs, "/q1/a", "/q2/a")
+	quota, _ = fs.GetQuota("/q1")
+	ad.AssertEqualsT(t, Quota{MaxBytes: 10, Bytes: 0, Inodes: 1}, quota)
+	quota, _ = fs.GetQuota("/q2")
+	ad.AssertEqualsT(t, Quota{MaxBytes: 100, Bytes: 7, Inodes: 4}, quota)
+
+	// what a rename replaces makes room for it.
+	_, err = fs.SetQuota("/q2", 10, 0)
+	ad.AssertEqualsT(t, nil, err)
+	helpWriteThrough(t, fs, "/q1/big", "123456")
+	HelpRename(t, fs, "/q1/big", "/q2/file")
+	ad.AssertEqualsT(t, "123456", helpReadThrough(t, fs, "/q2/file"))
+	quota, _ = fs.GetQuota("/q1")
+	ad.AssertEqualsT(t, Quota{MaxBytes: 10, Bytes: 0, Inodes: 1}, quota)
+	quota, _ = fs.GetQuota("/q2")
+	ad.AssertEqualsT(t, Quota{MaxBytes: 10, Bytes: 9, Inodes: 4}, quota)
+}
+
+func TestPathNormalization(t *testing.T, fs FileSystem) {
+	HelpMkdir(t, fs, "/a")
+	HelpMkdir(t, fs, "/a/b")
+	fd := HelpOpen(t, fs, "/a/file", WriteOnly, Create)
+	HelpClose(t, fs, fd)
+
+	for _, test := range []struct {
+		path string
+		name string // what Stat says it is called
+	}{
+		{"/a//b", "b"},
+		{"//a/b", "b"},
+		{"/a/b/", "b"},
+		{"/a/b//", "b"},
+		{"/a/./b", "b"},
+		{"/./a/b/.", "b"},
+		{"/a/b/../b", "b"},
+		{"/a/b/..", "a"},
+		{"/..", "/"},
+		{"/../a", "a"},
+		{"/a/../../../a/b", "b"},
+		{"/a/file/", "file"},
+		{"/a/file/..", "a"}, // cleaned as text, before looking anything up
+	} {
+		info, err := fs.Stat(test.path)
+		ad.AssertExplainT(t, err == nil && info.Name == test.name, "Stat(%q) returned (%+v, %v), expected %v",
+			test.path, info, err, test.name)
+	}
+
+	// making things through such paths makes them at the cleaned path, never with an empty or "." name.
+	HelpMkdir(t, fs, "/a/c/")
+	HelpMkdir(t, fs, "/a//d")
+	HelpMkdir(t, fs, "/a/b/../e")
+	fd = HelpOpen(t, fs, "/a/./new", ReadWrite, Create)
+	HelpClose(t, fs, fd)
+	entries, err := fs.ReadDir("/a/b/..")
+	ad.AssertEqualsT(t, nil, err)
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name
+	}
+	ad.AssertExplainT(t, strings.Join(names, ",") == "b,c,d,e,file,new", "ReadDir(/a) returned %v", names)
+
+	_, err = fs.Mkdir("/a/c/.")
+	ad.AssertEqualsT(t, AlreadyExists, err)
+	_, err = fs.Open("/a/b/", ReadOnly, 0)
+	ad.AssertEqualsT(t, IsDirectory, err)
+	HelpDelete(t, fs, "/a/c/")
+	HelpDelete(t, fs, "/a/e/../new")
+	_, err = fs.Stat("/a/new")
+	ad.AssertEqualsT(t, NotFound, err)
+}
+
+func TestPathValidation(t *testing.T, fs FileSystem) {
+	HelpMkdir(t, fs, "/a")
+	longestName := strings.Repeat("n", MaxNameLength)
+	HelpMkdir(t, fs, "/a/"+longestName)
+	fd := HelpOpen(t, fs, "/a/file", WriteOnly, Create)
+	HelpClose(t, fs, fd)
+
+	for _, test := range []struct {
+		path string
+		err  error
+	}{
+		{"", NotFound},
+		{"a", NotFound},
+		{"./a", NotFound},
+		{"../a", NotFound},
+		{"/missing", NotFound},
+		{"/a/missing/b", NotFound},
+		{"/a/file/b", NotFound},
+		{"/a/\x00", IllegalArgument},
+		{"/a\x00/file", IllegalArgument},
+		{"/a/" + longestName + "n", IllegalArgument},
+		{"/a/" + longestName + "n/..", IllegalArgument},
+		{"/a" + strings.Repeat("/.", MaxPathLength/2), IllegalArgument},
+	} {
+		fd, err := fs.Open(test.path, ReadOnly, 0)
+		ad.AssertExplainT(t, err == test.err && fd == -1, "Open(%q) returned (%v, %v), expected %v",
+			test.path, fd, err, test.err)
+		_, err = fs.Stat(test.path)
+		ad.AssertExplainT(t, err == test.err, "Stat(%q) returned %v, expected %v", test.path, err, test.err)
+		_, err = fs.Delete(test.path)
+		ad.AssertExplainT(t, err == test.err, "Delete(%q) returned %v, expected %v", test.path, err, test.err)
+		_, err = fs.Truncate(test.path, 0)
+		ad.AssertExplainT(t, err == test.err, "Truncate(%q) returned %v, expected %v", test.path, err, test.err)
+		if test.err == IllegalArgument {
+			_, err = fs.Mkdir(test.path)
+			ad.AssertExplainT(t, err == test.err, "Mkdir(%q) returned %v, expected %v", test.path, err, test.err)
+			_, err = fs.ReadDir(test.path)
+			ad.AssertExplainT(t, err == test.err, "ReadDir(%q) returned %v, expected %v", test.path, err, test.err)
+			fd, err = fs.Open(test.path, ReadWrite, Create)
+			ad.AssertExplainT(t, err == test.err, "Open(%q, Create) returned (%v, %v)", test.path, fd, err)
+		}
+	}
+
+	// a path right at the limit is fine.
+	longestPath := "/a" + strings.Repeat("/.", (MaxPathLength-2)/2)
+	info, err := fs.Stat(longestPath)
+	ad.AssertExplainT(t, err == nil && info.Name == "a", "Stat() of a %d-byte path returned (%+v, %v)",
+		len(longestPath), info, err)
+}
+
+func TestPathsThatNameTheRoot(t *testing.T, fs FileSystem) {
+	for _, path := range []string{"/", "//", "/.", "/..", "/./", "/a/.."} {
+		info, err := fs.Stat(path)
+		ad.AssertExplainT(t, err == nil && info.Name == "/" && info.IsDir, "Stat(%q) returned (%+v, %v)", path,
+			info, err)
+		_, err = fs.Mkdir(path)
+		ad.AssertExplainT(t, err == AlreadyExists, "Mkdir(%q) returned %v, expected AlreadyExists", path, err)
+		_, err = fs.Delete(path)
+		ad.AssertExplainT(t, err == IllegalArgument, "Delete(%q) returned %v, expected IllegalArgument", path, err)
+		_, err = fs.Open(path, ReadOnly, Create)
+		ad.AssertExplainT(t, err == IsDirectory, "Open(%q) returned %v, expected IsDirectory", path, err)
+	}
+	entries, err := fs.ReadDir("/")
+	ad.AssertEqualsT(t, nil, err)
+	ad.AssertEqualsT(t, 0, len(entries))
+}
+
+// Open path, creating it if it is missing, write contents to it, and close it again.
+func helpWriteThrough(t *testing.T, fs FileSystem, path string, contents string) {
+	fd := HelpOpen(t, fs, path, WriteOnly, Create)
+	HelpWriteString(t, fs, fd, contents)
+	HelpClose(t, fs, fd)
+}
+
+// Open path, read up to 100 bytes from the beginning of it, and close it again.
+func helpReadThrough(t *testing.T, fs FileSystem, path string) string {
+	fd := HelpOpen(t, fs, path, ReadOnly, 0)
+	HelpSeek(t, fs, fd, 0, FromBeginning)
+	_, data, err := fs.Read(fd, 100)
+	ad.AssertExplainT(t, err == nil, "err %v reading through %s", err, path)
+	HelpClose(t, fs, fd)
+	return string(data)
+}
+
+func TestSymlinkAbsolute(t *testing.T, fs FileSystem) {
+	HelpMkdir(t, fs, "/dir")
+	helpWriteThrough(t, fs, "/dir/file", "hello")
+	HelpSymlink(t, fs, "/dir/file", "/link")
+	HelpSymlink(t, fs, "/dir", "/dirlink")
+
+	ad.AssertEqualsT(t, "hello", helpReadThrough(t, fs, "/link"))
+	ad.AssertEqualsT(t, "hello", helpReadThrough(t, fs, "/dirlink/file"))
+	target, err := fs.Readlink("/link")
+	ad.AssertExplainT(t, err == nil && target == "/dir/file", "Readlink() returned (%q, %v)", target, err)
+
+	// Stat describes what the link points to, and Lstat describes the link.
+	info, err := fs.Stat("/link")
+	ad.AssertExplainT(t, err == nil && info.Size == 5 && !info.IsDir && !info.IsSymlink, "Stat() returned (%+v, %v)",
+		info, err)
+	info, err = fs.Stat("/dirlink")
+	ad.AssertExplainT(t, err == nil && info.IsDir && !info.IsSymlink, "Stat() returned (%+v, %v)", info, err)
+	info, err = fs.Lstat("/link")
+	ad.AssertExplainT(t, err == nil && info == FileInfo{Name: "link", Size: len("/dir/file"), IsSymlink: true},
+		"Lstat() returned (%+v, %v)", info, err)
+	info, err = fs.Lstat("/dirlink/file")
+	ad.AssertExplainT(t, err == nil && info.Name == "file" && !info.IsSymlink, "Lstat() returned (%+v, %v)", info, err)
+
+	// ReadDir follows a link to a directory, but lists the links in it as links.
+	entries, err := fs.ReadDir("/dirlink")
+	ad.AssertExplainT(t, err == nil && len(entries) == 1 && entries[0].Name == "file", "ReadDir() returned (%+v, %v)",
+		entries, err)
+	entries, err = fs.ReadDir("/")
+	ad.AssertEqualsT(t, nil, err)
+	ad.AssertEqualsT(t, 3, len(entries))
+	ad.AssertExplainT(t, entries[1].Name == "dirlink" && entries[1].IsSymlink && !entries[1].IsDir,
+		"ReadDir() listed %+v", entries[1])
+
+	// things made through a link end up where it points.
+	HelpMkdir(t, fs, "/dirlink/sub")
+	HelpOpenClose(t, fs, "/dirlink/sub/new", WriteOnly, Create)
+	_, err = fs.Stat("/dir/sub/new")
+	ad.AssertEqualsT(t, nil, err)
+
+	// deleting a link leaves what it points to alone, and deleting through one deletes what is there.
+	HelpDelete(t, fs, "/link")
+	ad.AssertEqualsT(t, "hello", helpReadThrough(t, fs, "/dir/file"))
+	HelpDelete(t, fs, "/dirlink/sub/new")
+	_, err = fs.Stat("/dir/sub/new")
+	ad.AssertEqualsT(t, NotFound, err)
+}
+
+func TestSymlinkRelative(t *testing.T, fs FileSystem) {
+	HelpMkdir(t, fs, "/a")
+	HelpMkdir(t, fs, "/a/b")
+	helpWriteThrough(t, fs, "/a/file", "relative")
+	HelpSymlink(t, fs, "../file", "/a/b/up")
+	HelpSymlink(t, fs, "b", "/a/down")
+	HelpSymlink(t, fs, "./b/up", "/a/chain")
+
+	// a relative target is looked up from the directory holding the link, wherever the path came from.
+	ad.AssertEqualsT(t, "relative", helpReadThrough(t, fs, "/a/b/up"))
+	ad.AssertEqualsT(t, "relative", helpReadThrough(t, fs, "/a/down/up"))
+	ad.AssertEqualsT(t, "relative", helpReadThrough(t, fs, "/a/chain"))
+	ad.AssertEqualsT(t, "relative", helpReadThrough(t, fs, "/a/down/../file"))
+	for path, expected := range map[string]string{"/a/b/up": "../file", "/a/down": "b", "/a/chain": "./b/up"} {
+		target, err := fs.Readlink(path)
+		ad.AssertExplainT(t, err == nil && target == expected, "Readlink(%v) returned (%q, %v), expected %q", path,
+			target, err, expected)
+	}
+
+	// ".." above the root stays at the root, like it does in a path.
+	HelpSymlink(t, fs, "../../../a/file", "/a/b/high")
+	ad.AssertEqualsT(t, "relative", helpReadThrough(t, fs, "/a/b/high"))
+	HelpSymlink(t, fs, "..", "/a/b/parent")
+	info, err := fs.Stat("/a/b/parent")
+	ad.AssertExplainT(t, err == nil && info.IsDir && info.Name == "a", "Stat() returned (%+v, %v)", info, err)
+}
+
+func TestSymlinkDangling(t *testing.T, fs FileSystem) {
+	HelpSymlink(t, fs, "/missing", "/link")
+	HelpSymlink(t, fs, "/nodir/missing", "/deeplink")
+
+	info, err := fs.Lstat("/link")
+	ad.AssertExplainT(t, err == nil && info.IsSymlink, "Lstat() of a dangling link returned (%+v, %v)", info, err)
+	target, err := fs.Readlink("/link")
+	ad.AssertExplainT(t, err == nil && target == "/missing", "Readlink() returned (%q, %v)", target, err)
+	_, err = fs.Stat("/link")
+	ad.AssertEqualsT(t, NotFound, err)
+	fd, err := fs.Open("/link", ReadOnly, 0)
+	ad.AssertExplainT(t, fd == -1 && err == NotFound, "Open() of a dangling link returned (%v, %v)", fd, err)
+	_, err = fs.ReadDir("/link")
+	ad.AssertEqualsT(t, NotFound, err)
+
+	// creating through a dangling link creates what it points to, as long as the directory for it exists.
+	fd, err = fs.Open("/deeplink", WriteOnly, Create)
+	ad.AssertExplainT(t, fd == -1 && err == NotFound, "Open(Create) through a link to a missing directory returned "+
+		"(%v, %v)", fd, err)
+	fd = HelpOpen(t, fs, "/link", WriteOnly, Create)
+	HelpWriteString(t, fs, fd, "made")
+	HelpClose(t, fs, fd)
+	ad.AssertEqualsT(t, "made", helpReadThrough(t, fs, "/missing"))
+	info, err = fs.Lstat("/link")
+	ad.AssertExplainT(t, err == nil && info.IsSymlink, "Open(Create) replaced the link with %+v, %v", info, err)
+
+	// but Mkdir doesn't follow the link, since it is already there.
+	_, err = fs.Mkdir("/deeplink")
+	ad.AssertEqualsT(t, AlreadyExists, err)
+	HelpMkdir(t, fs, "/nodir")
+	_, err = fs.Stat("/deeplink")
+	ad.AssertEqualsT(t, NotFound, err)
+	HelpOpenClose(t, fs, "/deeplink", ReadOnly, Create)
+	_, err = fs.Stat("/nodir/missing")
+	ad.AssertEqualsT(t, nil, err)
+}
+
+func TestSymlinkLoop(t *testing.T, fs FileSystem) {
+	HelpSymlink(t, fs, "/b", "/a")
+	HelpSymlink(t, fs, "a", "/b")
+	HelpMkdir(t, fs, "/dir")
+
+	for _, path := range []string{"/a", "/b", "/a/file"} {
+		_, err := fs.Stat(path)
+		ad.AssertExplainT(t, err == TooManyLinks, "Stat(%v) returned %v, expected TooManyLinks", path, err)
+		fd, err := fs.Open(path, ReadWrite, Create)
+		ad.AssertExplainT(t, fd == -1 && err == TooManyLinks, "Open(%v) returned (%v, %v), expected TooManyLinks",
+			path, fd, err)
+		_, err = fs.ReadDir(path)
+		ad.AssertExplainT(t, err == TooManyLinks, "ReadDir(%v) returned %v, expected TooManyLinks", path, err)
+	}
+	_, err := fs.Mkdir("/a/sub")
+	ad.AssertEqualsT(t, TooManyLinks, err)
+	_, err = fs.Symlink("/dir", "/b/link")
+	ad.AssertEqualsT(t, TooManyLinks, err)
+
+	// the links themselves can still be looked at and deleted.
+	info, err := fs.Lstat("/a")
+	ad.AssertExplainT(t, err == nil && info.IsSymlink, "Lstat() of a link in a loop returned (%+v, %v)", info, err)
+	target, err := fs.Readlink("/b")
+	ad.AssertExplainT(t, err == nil && target == "a", "Readlink() returned (%q, %v)", target, err)
+	HelpDelete(t, fs, "/a")
+	_, err = fs.Stat("/b")
+	ad.AssertEqualsT(t, NotFound, err)
+
+	// a chain of exactly MaxSymlinkDepth links is fine, but one more is too many.
+	helpWriteThrough(t, fs, "/dir/file", "end")
+	HelpSymlink(t, fs, "/dir/file", "/dir/link0")
+	for i := 1; i <= MaxSymlinkDepth; i++ {
+		HelpSymlink(t, fs, fmt.Sprintf("link%d", i-1), fmt.Sprintf("/dir/link%d", i))
+	}
+	ad.AssertEqualsT(t, "end", helpReadThrough(t, fs, fmt.Sprintf("/dir/link%d", MaxSymlinkDepth-1)))
+	_, err = fs.Stat(fmt.Sprintf("/dir/link%d", MaxSymlinkDepth))
+	ad.AssertEqualsT(t, TooManyLinks, err)
+}
+
+func TestSymlinkItself(t *testing.T, fs FileSystem) {
+	HelpSymlink(t, fs, "self", "/self")
+	HelpSymlink(t, fs, "/", "/root")
+
+	_, err := fs.Stat("/self")
+	ad.AssertEqualsT(t, TooManyLinks, err)
+	info, err := fs.Stat("/root")
+	ad.AssertExplainT(t, err == nil && info.IsDir && info.Name == "/", "Stat() of a link to / returned (%+v, %v)",
+		info, err)
+	info, err = fs.Stat("/root/root/root/self/..")
+	ad.AssertExplainT(t, err == nil && info.Name == "/", "Stat() through links to / returned (%+v, %v)", info, err)
+	HelpDelete(t, fs, "/root")
+	HelpDelete(t, fs, "/self")
+	entries, err := fs.ReadDir("/")
+	ad.AssertExplainT(t, err == nil && len(entries) == 0, "ReadDir() returned (%+v, %v)", entries, err)
+}
+
+func TestSymlinkErrors(t *testing.T, fs FileSystem) {
+	HelpMkdir(t, fs, "/dir")
+	HelpOpenClose(t, fs, "/file", WriteOnly, Create)
+	HelpSymlink(t, fs, "/file", "/link")
+
+	for _, test := range []struct {
+		target   string
+		linkPath string
+		err      error
+	}{
+		{"/dir", "/file", AlreadyExists},
+		{"/dir", "/dir", AlreadyExists},
+		{"/dir", "/link", AlreadyExists},
+		{"/dir", "/", AlreadyExists},
+		{"/dir", "/missing/link", NotFound},
+		{"/dir", "/file/link", NotFound},
+		{"/dir", "relative", NotFound},
+		{"", "/empty", IllegalArgument},
+		{"/a\x00b", "/nul", IllegalArgument},
+		{"/" + strings.Repeat("a", MaxPathLength), "/long", IllegalArgument},
+	} {
+		success, err := fs.Symlink(test.target, test.linkPath)
+		ad.AssertExplainT(t, !success && err == test.err, "Symlink(%q, %v) returned (%t, %v), expected %v",
+			test.target, test.linkPath, success, err, test.err)
+	}
+
+	for _, test := range []struct {
+		path string
+		err  error
+	}{
+		{"/file", IllegalArgument},
+		{"/dir", IllegalArgument},
+		{"/", IllegalArgument},
+		{"/missing", NotFound},
+		{"/link/missing", NotFound},
+	} {
+		target, err := fs.Readlink(test.path)
+		ad.AssertExplainT(t, target == "" && err == test.err, "Readlink(%v) returned (%q, %v), expected %v",
+			test.path, target, err, test.err)
+	}
+
+	// a link may point to a target that isn't a valid path; it just can't be followed.
+	HelpSymlink(t, fs, strings.Repeat("n", MaxNameLength+1), "/dir/badname")
+	_, err := fs.Stat("/dir/badname")
+	ad.AssertEqualsT(t, IllegalArgument, err)
+	target, err := fs.Readlink("/dir/badname")
+	ad.AssertExplainT(t, err == nil && len(target) == MaxNameLength+1, "Readlink() returned (%q, %v)", target, err)
+}
+
+// ===== BEGIN RENAME TESTS =====
+
+func TestRename(t *testing.T, fs FileSystem) {
+	HelpMkdir(t, fs, "/a")
+	HelpMkdir(t, fs, "/b")
+	helpWriteThrough(t, fs, "/a/file", "hello")
+
+	HelpRename(t, fs, "/a/file", "/b/moved")
+	ad.AssertEqualsT(t, "hello", helpReadThrough(t, fs, "/b/moved"))
+	_, err := fs.Stat("/a/file")
+	ad.AssertEqualsT(t, NotFound, err)
+
+	// a directory takes everything under it along.
+	HelpMkdir(t, fs, "/a/sub")
+	helpWriteThrough(t, fs, "/a/sub/deep", "deep")
+	HelpRename(t, fs, "/a", "/b/a")
+	ad.AssertEqualsT(t, "deep", helpReadThrough(t, fs, "/b/a/sub/deep"))
+	entries, err := fs.ReadDir("/")
+	ad.AssertExplainT(t, err == nil && len(entries) == 1 && entries[0].Name == "b", "ReadDir() returned (%+v, %v)",
+		entries, err)
+
+	// renaming something to its own name does nothing.
+	HelpRename(t, fs, "/b/moved", "/b/moved")
+	HelpRename(t, fs, "/b/a", "/b/./a")
+	ad.AssertEqualsT(t, "hello", helpReadThrough(t, fs, "/b/moved"))
+
+	// a link is renamed itself, and paths through links lead to where they point.
+	HelpSymlink(t, fs, "moved", "/b/link")
+	HelpRename(t, fs, "/b/link", "/link")
+	target, err := fs.Readlink("/link")
+	ad.AssertExplainT(t, err == nil && target == "moved", "Readlink() returned (%q, %v)", target, err)
+	_, err = fs.Lstat("/b/link")
+	ad.AssertEqualsT(t, NotFound, err)
+	HelpSymlink(t, fs, "/b", "/blink")
+	HelpRename(t, fs, "/blink/moved", "/blink/a/moved")
+	ad.AssertEqualsT(t, "hello", helpReadThrough(t, fs, "/b/a/moved"))
+}
+
+func TestRenameReplace(t *testing.T, fs FileSystem) {
+	helpWriteThrough(t, fs, "/x", "x")
+	helpWriteThrough(t, fs, "/y", "y")
+	HelpRename(t, fs, "/x", "/y")
+	ad.AssertEqualsT(t, "x", helpReadThrough(t, fs, "/y"))
+	_, err := fs.Stat("/x")
+	ad.AssertEqualsT(t, NotFound, err)
+
+	// a link is replaced, not what it points to.
+	HelpSymlink(t, fs, "/y", "/link")
+	helpWriteThrough(t, fs, "/z", "z")
+	HelpRename(t, fs, "/z", "/link")
+	info, err := fs.Lstat("/link")
+	ad.AssertExplainT(t, err == nil && !info.IsSymlink, "Lstat() returned (%+v, %v)", info, err)
+	ad.AssertEqualsT(t, "x", helpReadThrough(t, fs, "/y"))
+
+	// a directory can only replace an empty directory, and only a directory can.
+	HelpMkdir(t, fs, "/full")
+	helpWriteThrough(t, fs, "/full/f", "f")
+	HelpMkdir(t, fs, "/empty")
+	HelpMkdir(t, fs, "/other")
+	HelpRename(t, fs, "/full", "/empty")
+	ad.AssertEqualsT(t, "f", helpReadThrough(t, fs, "/empty/f"))
+	for _, test := range []struct {
+		from string
+		to   string
+		err  error
+	}{
+		{"/other", "/empty", DirectoryNotEmpty},
+		{"/y", "/other", IsDirectory},
+		{"/other", "/y", IsDirectory},
+		{"/other", "/link", IsDirectory},
+	} {
+		success, err := fs.Rename(test.from, test.to)
+		ad.AssertExplainT(t, !success && err == test.err, "Rename(%v, %v) returned (%t, %v), expected %v",
+			test.from, test.to, success, err, test.err)
+	}
+}
+
+// A file stays open through a rename, under the same file descriptor.
+func TestRenameOpenFile(t *testing.T, fs FileSystem) {
+	HelpMkdir(t, fs, "/dir")
+	fd := HelpOpen(t, fs, "/open", ReadWrite, Create)
+	HelpWriteString(t, fs, fd, "before")
+	HelpRename(t, fs, "/open", "/dir/renamed")
+	info, err := fs.Stat("/dir/renamed")
+	ad.AssertExplainT(t, err == nil && info.IsOpen, "Stat() of a renamed open file returned (%+v, %v)", info, err)
+	HelpWriteString(t, fs, fd, "!")
+	HelpClose(t, fs, fd)
+	ad.AssertEqualsT(t, "before!", helpReadThrough(t, fs, "/dir/renamed"))
+
+	// renaming over an open file works like deleting it.
+	fd = HelpOpen(t, fs, "/dir/renamed", ReadWrite, 0)
+	helpWriteThrough(t, fs, "/new", "new")
+	HelpRename(t, fs, "/new", "/dir/renamed")
+	ad.AssertEqualsT(t, "new", helpReadThrough(t, fs, "/dir/renamed"))
+	HelpClose(t, fs, fd)
+}
+
+func TestRenameErrors(t *testing.T, fs FileSystem) {
+	HelpMkdir(t, fs, "/dir")
+	HelpMkdir(t, fs, "/dir/inner")
+	helpWriteThrough(t, fs, "/file", "file")
+
+	for _, test := range []struct {
+		from string
+		to   string
+		err  error
+	}{
+		{"/missing", "/x", NotFound},
+		{"/file", "/missing/x", NotFound},
+		{"/file", "/file/x", NotFound},
+		{"/file", "relative", NotFound},
+		{"/file", "/a\x00b", IllegalArgument},
+		{"/", "/x", IllegalArgument},
+		{"/dir", "/", IllegalArgument},
+		{"/dir", "/dir/x", IllegalArgument},
+		{"/dir", "/dir/inner/x", IllegalArgument},
+		{"/dir", "/dir/inner", IllegalArgument},
+	} {
+		success, err := fs.Rename(test.from, test.to)
+		ad.AssertExplainT(t, !success && err == test.err, "Rename(%v, %v) returned (%t, %v), expected %v",
+			test.from, test.to, success, err, test.err)
+	}
+
+	// nothing moved.
+	ad.AssertEqualsT(t, "file", helpReadThrough(t, fs, "/file"))
+	entries, err := fs.ReadDir("/dir")
+	ad.AssertExplainT(t, err == nil && len(entries) == 1 && entries[0].Name == "inner", "ReadDir() returned (%+v, %v)",
+		entries, err)
+}
@@ -0,0 +1,104 @@
+package filesystem
+
+import (
+	"io"
+	"io/fs"
+	"path"
+)
+
+// Helpers like the os package's, for any FileSystem. Their errors are *fs.PathErrors, like File's.
+
+// Read the whole file at filePath.
+func ReadFile(fileSystem FileSystem, filePath string) ([]byte, error) {
+	file, err := OpenFile(fileSystem, filePath, ReadOnly, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	contents := []byte{}
+	buffer := make([]byte, fileChunkSize)
+	for {
+		n, err := file.Read(buffer)
+		contents = append(contents, buffer[:n]...)
+		if err == io.EOF {
+			return contents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Replace the contents of the file at filePath with data, creating the file if it doesn't exist.
+func WriteFile(fileSystem FileSystem, filePath string, data []byte) error {
+	file, err := OpenFile(fileSystem, filePath, WriteOnly, Create|Truncate)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Make the directory at dirPath and any of its parents that don't exist yet. It isn't an error if dirPath is already
+// a directory, but it is if it is a file, which returns AlreadyExists.
+func MkdirAll(fileSystem FileSystem, dirPath string) error {
+	dirPath = path.Clean(dirPath)
+	info, err := fileSystem.Stat(dirPath)
+	if err == nil {
+		if info.IsDir {
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: dirPath, Err: AlreadyExists}
+	}
+	if err != NotFound {
+		return &fs.PathError{Op: "mkdir", Path: dirPath, Err: err}
+	}
+
+	if parent := path.Dir(dirPath); parent != dirPath {
+		if err := MkdirAll(fileSystem, parent); err != nil {
+			return err
+		}
+	}
+	// someone else may have made it since the Stat
+	if _, err := fileSystem.Mkdir(dirPath); err != nil && err != AlreadyExists {
+		return &fs.PathError{Op: "mkdir", Path: dirPath, Err: err}
+	}
+	return nil
+}
+
+// Delete filePath and, if it is a directory, everything in it. It isn't an error if filePath doesn't exist.
+// RemoveAll("/") returns IllegalArgument without deleting anything, like Delete("/").
+// A symbolic link is deleted itself, and what it points to is left alone.
+func RemoveAll(fileSystem FileSystem, filePath string) error {
+	filePath = path.Clean(filePath)
+	if filePath == "/" {
+		return &fs.PathError{Op: "remove", Path: filePath, Err: IllegalArgument}
+	}
+	info, err := fileSystem.Lstat(filePath)
+	if err == NotFound {
+		return nil
+	}
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: filePath, Err: err}
+	}
+
+	if info.IsDir {
+		entries, err := fileSystem.ReadDir(filePath)
+		if err != nil && err != NotFound {
+			return &fs.PathError{Op: "remove", Path: filePath, Err: err}
+		}
+		for _, entry := range entries {
+			if err := RemoveAll(fileSystem, path.Join(filePath, entry.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := fileSystem.Delete(filePath); err != nil && err != NotFound {
+		return &fs.PathError{Op: "remove", Path: filePath, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package filesystem
+
+import (
+	"path"
+	"strings"
+)
+
+// Every FileSystem takes paths the same way: a path must begin with "/", and is cleaned before it is looked up, so
+// "//" is the same as "/", "." is skipped, ".." goes up a directory (and stays at "/" if it is already there), and a
+// trailing "/" is ignored. This is done on the text of the path, so "/file/.." is "/" even though a file has no
+// children, and "/link/.." is "/" wherever the symbolic link at "/link" points.
+//
+// A path that is empty or doesn't begin with "/" isn't well-formed, which is NotFound. A path that holds a NUL byte,
+// is longer than MaxPathLength, or has a name in it longer than MaxNameLength is IllegalArgument.
+
+const (
+	MaxNameLength   = 255  // the longest a file or directory name may be, in bytes
+	MaxPathLength   = 4096 // the longest a whole path may be, in bytes, before it is cleaned
+	MaxSymlinkDepth = 40   // the most symbolic links that may be followed while looking up one path
+)
+
+// Check filePath and clean it as described above, returning the path every FileSystem looks up.
+// The result is "/" for the root and otherwise has no trailing "/".
+func CleanPath(filePath string) (string, error) {
+	if filePath == "" || filePath[0] != '/' {
+		return "", NotFound
+	}
+	if len(filePath) > MaxPathLength || strings.IndexByte(filePath, 0) >= 0 {
+		return "", IllegalArgument
+	}
+	for _, name := range strings.Split(filePath, "/") {
+		if len(name) > MaxNameLength {
+			return "", IllegalArgument
+		}
+	}
+	return path.Clean(filePath), nil
+}
+
+// The names along filePath, which must be a path returned by CleanPath, e.g. ["a", "b"] for "/a/b" and none for "/".
+func SplitPath(filePath string) []string {
+	if filePath == "/" {
+		return []string{}
+	}
+	return strings.Split(filePath[1:], "/")
+}
@@ -13,15 +13,19 @@ import (
 	"time"
 )
 
+// How many times StaleRead tries each server before returning TryAgain.
+const staleReadRounds = 3
+
 type Clerk struct {
 	lock          sync.Mutex
-	servers       []*labrpc.ClientEnd
+	servers       []labrpc.Endpoint
 	id            int64 // a unique serial number for this Clerk
 	lastLeader    int   // which server was the leader most recently. -1 initially.
 	numOperations int   // how many operations this clerk has submitted (including the current one, if one is in progress)
 }
 
-func MakeFsClerk(servers []*labrpc.ClientEnd) *Clerk {
+func MakeFsClerk(servers []labrpc.Endpoint) *Clerk {
+	registerLabgobTypes()
 	ck := new(Clerk)
 	ck.lock.Lock()
 	ck.servers = servers
@@ -116,6 +120,30 @@ func (ck *Clerk) Write(fileDescriptor int, numBytes int, data []byte) (bytesWrit
 	return castWriteReply(returnVal)
 }
 
+// See the spec for FileSystem::Pread. This goes through Raft like every other operation; StalePread doesn't.
+func (ck *Clerk) Pread(fileDescriptor int, offset int, numBytes int) (bytesRead int, data []byte, err error) {
+	ab := AbstractOperation{OpType: PreadOp}
+	ab.FileDescriptor = fileDescriptor
+	ab.Offset = offset
+	ab.NumBytes = numBytes
+
+	returnVal := ck.Operation(ab)
+
+	return castReadReply(returnVal)
+}
+
+// See the spec for FileSystem::Pwrite.
+func (ck *Clerk) Pwrite(fileDescriptor int, offset int, data []byte) (bytesWritten int, err error) {
+	ab := AbstractOperation{OpType: PwriteOp}
+	ab.FileDescriptor = fileDescriptor
+	ab.Offset = offset
+	ab.Data = data
+
+	returnVal := ck.Operation(ab)
+
+	return castWriteReply(returnVal)
+}
+
 // See the spec for FileSystem::Delete.
 func (ck *Clerk) Delete(path string) (success bool, err error) {
 	ab := AbstractOperation{OpType: DeleteOp}
@@ -126,6 +154,191 @@ func (ck *Clerk) Delete(path string) (success bool, err error) {
 	return castDeleteReply(returnVal)
 }
 
+// See the spec for FileSystem::Rename.
+func (ck *Clerk) Rename(from string, to string) (success bool, err error) {
+	ab := AbstractOperation{OpType: RenameOp}
+	ab.Path = from
+	ab.NewPath = to
+
+	returnVal := ck.Operation(ab)
+
+	return castSuccessReply(returnVal)
+}
+
+// See the spec for FileSystem::Stat.
+func (ck *Clerk) Stat(path string) (info filesystem.FileInfo, err error) {
+	ab := AbstractOperation{OpType: StatOp}
+	ab.Path = path
+
+	returnVal := ck.Operation(ab)
+
+	return castStatReply(returnVal)
+}
+
+// See the spec for FileSystem::Lstat.
+func (ck *Clerk) Lstat(path string) (info filesystem.FileInfo, err error) {
+	ab := AbstractOperation{OpType: LstatOp}
+	ab.Path = path
+
+	returnVal := ck.Operation(ab)
+
+	return castStatReply(returnVal)
+}
+
+// See the spec for FileSystem::Symlink.
+func (ck *Clerk) Symlink(target string, linkPath string) (success bool, err error) {
+	ab := AbstractOperation{OpType: SymlinkOp}
+	ab.Target = target
+	ab.Path = linkPath
+
+	returnVal := ck.Operation(ab)
+
+	return castSuccessReply(returnVal)
+}
+
+// See the spec for FileSystem::Readlink.
+func (ck *Clerk) Readlink(path string) (target string, err error) {
+	ab := AbstractOperation{OpType: ReadlinkOp}
+	ab.Path = path
+
+	returnVal := ck.Operation(ab)
+
+	return castReadlinkReply(returnVal)
+}
+
+// See the spec for FileSystem::ReadDir.
+func (ck *Clerk) ReadDir(path string) (entries []filesystem.FileInfo, err error) {
+	ab := AbstractOperation{OpType: ReadDirOp}
+	ab.Path = path
+
+	returnVal := ck.Operation(ab)
+
+	return castReadDirReply(returnVal)
+}
+
+// See the spec for FileSystem::Truncate.
+func (ck *Clerk) Truncate(path string, size int) (success bool, err error) {
+	ab := AbstractOperation{OpType: TruncateOp}
+	ab.Path = path
+	ab.Size = size
+
+	returnVal := ck.Operation(ab)
+
+	return castSuccessReply(returnVal)
+}
+
+// See the spec for FileSystem::Ftruncate.
+func (ck *Clerk) Ftruncate(fileDescriptor int, size int) (success bool, err error) {
+	ab := AbstractOperation{OpType: FtruncateOp}
+	ab.FileDescriptor = fileDescriptor
+	ab.Size = size
+
+	returnVal := ck.Operation(ab)
+
+	return castSuccessReply(returnVal)
+}
+
+// See the spec for FileSystem::Fallocate.
+func (ck *Clerk) Fallocate(fileDescriptor int, mode filesystem.FallocateMode, offset int, length int) (success bool,
+	err error) {
+	ab := AbstractOperation{OpType: FallocateOp}
+	ab.FileDescriptor = fileDescriptor
+	ab.FallocateMode = mode
+	ab.Offset = offset
+	ab.NumBytes = length
+
+	returnVal := ck.Operation(ab)
+
+	return castSuccessReply(returnVal)
+}
+
+// See the spec for FileSystem::Statfs.
+func (ck *Clerk) Statfs() (info filesystem.FsInfo, err error) {
+	ab := AbstractOperation{OpType: StatfsOp}
+
+	returnVal := ck.Operation(ab)
+
+	return castStatfsReply(returnVal)
+}
+
+// See the spec for FileSystem::SetQuota.
+func (ck *Clerk) SetQuota(path string, maxBytes int, maxInodes int) (success bool, err error) {
+	ab := AbstractOperation{OpType: SetQuotaOp}
+	ab.Path = path
+	ab.MaxBytes = maxBytes
+	ab.MaxInodes = maxInodes
+
+	returnVal := ck.Operation(ab)
+
+	return castSuccessReply(returnVal)
+}
+
+// See the spec for FileSystem::GetQuota.
+func (ck *Clerk) GetQuota(path string) (quota filesystem.Quota, err error) {
+	ab := AbstractOperation{OpType: GetQuotaOp}
+	ab.Path = path
+
+	returnVal := ck.Operation(ab)
+
+	return castGetQuotaReply(returnVal)
+}
+
+// Open a file as a *filesystem.File, which can be used with io.Copy, bufio and so on instead of a file descriptor.
+// See filesystem.OpenFile.
+func (ck *Clerk) OpenFile(path string, mode filesystem.OpenMode, flags filesystem.OpenFlags) (*filesystem.File, error) {
+	return filesystem.OpenFile(ck, path, mode, flags)
+}
+
+// Read up to numBytes bytes starting at offset from the file at path, without going through Raft.
+//
+// The file does not need to be open. The read is served by whichever server answers first, so it may not reflect
+// the most recent writes; clerks made with only the learners' ports use the learners as read replicas. If no server
+// answers after each has been tried a few times, returns TryAgain.
+// Possible errors are NotFound, IsDirectory, IllegalArgument, and TryAgain. If err is non-nil, bytesRead is -1.
+func (ck *Clerk) StaleRead(path string, offset int, numBytes int) (bytesRead int, data []byte, err error) {
+	return ck.staleRead(StaleReadArgs{Path: path, Offset: offset, NumBytes: numBytes})
+}
+
+// Pread without going through Raft, like StaleRead.
+//
+// The file descriptor must have been opened through Raft. A server that hasn't yet applied the Open, or has
+// applied a later Close, returns InactiveFD.
+// Possible errors are InactiveFD, WrongMode, IllegalArgument, and TryAgain. If err is non-nil, bytesRead is -1.
+func (ck *Clerk) StalePread(fileDescriptor int, offset int, numBytes int) (bytesRead int, data []byte, err error) {
+	return ck.staleRead(StaleReadArgs{UseFD: true, FileDescriptor: fileDescriptor, Offset: offset, NumBytes: numBytes})
+}
+
+// Doesn't take ck.lock, since it doesn't change the clerk, so that the clerk's other operations don't have to wait
+// for servers that don't answer.
+func (ck *Clerk) staleRead(args StaleReadArgs) (bytesRead int, data []byte, err error) {
+	for attempt := 0; attempt < staleReadRounds*len(ck.servers); attempt++ {
+		serverToTry := attempt % len(ck.servers)
+		reply := StaleReadReply{}
+		ok := ck.servers[serverToTry].Call("FileServer.StaleRead", &args, &reply)
+		if ok && reply.Status == OK {
+			ad.Debug(ad.RPC, "%v: stale read of %+v served by server %d at index %d", clerkShortName(ck.id), args,
+				serverToTry, reply.AppliedIndex)
+			assertReplyTypesValid(ReadOp, reply.ReturnValue)
+			return castReadReply(reply.ReturnValue)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	ad.Debug(ad.RPC, "%v: no server served the stale read of %+v", clerkShortName(ck.id), args)
+	return -1, nil, filesystem.TryAgain
+}
+
+// Ask one server for its Stats(). Returns ok=false if the server could not be reached.
+// Any server can answer, whether or not it is the leader.
+func (ck *Clerk) Status(server int) (stats Stats, ok bool) {
+	ck.lock.Lock()
+	defer ck.lock.Unlock()
+
+	args := StatusArgs{ck.id}
+	reply := StatusReply{}
+	ok = ck.servers[server].Call("FileServer.Status", &args, &reply)
+	return reply.Stats, ok
+}
+
 // Perform some operation.
 //
 // abstractOperation is the operation to be performed, defined in ops.go.
@@ -0,0 +1,99 @@
+package fsraft
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"labrpc"
+	"os"
+	"strconv"
+	"strings"
+	"tcprpc"
+)
+
+// The members of a cluster of FileServers running as separate processes, and where to reach them.
+//
+// In a cluster config file, each line is a peer id followed by the TCP address the peer listens on. Ids are the
+// peers' indices, so they must be 0, 1, 2, ... in some order. A peer may be marked as a learner by adding the word
+// "learner". Blank lines and everything after a '#' are ignored. For example:
+//
+//	# id  address          role
+//	0     10.0.0.1:7000
+//	1     10.0.0.2:7000
+//	2     10.0.0.3:7000    learner
+type ClusterConfig struct {
+	Addresses []string // Addresses[id] is where peer id listens
+	Learners  []int    // ids of the non-voting peers, in increasing order
+}
+
+// Read a cluster config file.
+func ReadClusterConfig(path string) (ClusterConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ClusterConfig{}, err
+	}
+	defer file.Close()
+	cluster, err := ParseClusterConfig(file)
+	if err != nil {
+		return ClusterConfig{}, fmt.Errorf("%v: %v", path, err)
+	}
+	return cluster, nil
+}
+
+// Parse a cluster config in the format described at ClusterConfig.
+func ParseClusterConfig(reader io.Reader) (ClusterConfig, error) {
+	addressesById := make(map[int]string)
+	isLearner := make(map[int]bool)
+	scanner := bufio.NewScanner(reader)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 3 || len(fields) < 2 || (len(fields) == 3 && fields[2] != "learner") {
+			return ClusterConfig{}, fmt.Errorf("line %d: expected \"id address\" or \"id address learner\", got %q",
+				lineNum, scanner.Text())
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil || id < 0 {
+			return ClusterConfig{}, fmt.Errorf("line %d: %q is not a valid peer id", lineNum, fields[0])
+		}
+		if _, exists := addressesById[id]; exists {
+			return ClusterConfig{}, fmt.Errorf("line %d: peer %d is listed twice", lineNum, id)
+		}
+		addressesById[id] = fields[1]
+		isLearner[id] = len(fields) == 3
+	}
+	if err := scanner.Err(); err != nil {
+		return ClusterConfig{}, err
+	}
+	if len(addressesById) == 0 {
+		return ClusterConfig{}, fmt.Errorf("no peers are listed")
+	}
+
+	cluster := ClusterConfig{Addresses: make([]string, len(addressesById)), Learners: make([]int, 0)}
+	for id := 0; id < len(addressesById); id++ {
+		address, exists := addressesById[id]
+		if !exists {
+			return ClusterConfig{}, fmt.Errorf("peer ids must be 0 through %d, but %d is missing", len(addressesById)-1, id)
+		}
+		cluster.Addresses[id] = address
+		if isLearner[id] {
+			cluster.Learners = append(cluster.Learners, id)
+		}
+	}
+	return cluster, nil
+}
+
+// Make a TCP endpoint for every peer, indexed by id, for a FileServer's peers or a Clerk's servers.
+func (cluster ClusterConfig) MakeEnds() []labrpc.Endpoint {
+	ends := make([]labrpc.Endpoint, len(cluster.Addresses))
+	for id, address := range cluster.Addresses {
+		ends[id] = tcprpc.MakeEnd(address)
+	}
+	return ends
+}
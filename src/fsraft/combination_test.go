@@ -1,7 +1,7 @@
 // Code generated by generate_unit_tests.go. DO NOT EDIT.
 // This file contains a unit test for every combination of functionality test
 // (found in filesystem_tests.go) and difficulty (found in difficulties.go).
-// Generated at Fri May 11 9:05:36 PM.
+// Generated at Mon Oct 19 11:57:01 AM.
 
 package fsraft
 
@@ -704,3 +704,315 @@ func TestClerk_OneClerkThreeServersNoErrors_TestWriteReadBasic4(t *testing.T) {
 	runFunctionalityTestWithDifficulty(t, filesystem.TestWriteReadBasic4, OneClerkThreeServersNoErrors)
 }
 
+func TestClerk_OneClerkFiveServersUnreliableNet_TestFallocatePunchHole(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestFallocatePunchHole, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestPathNormalization(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPathNormalization, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestPathValidation(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPathValidation, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestPathsThatNameTheRoot(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPathsThatNameTheRoot, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestPreadPwrite(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPreadPwrite, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestPreadPwriteErrors(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPreadPwriteErrors, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestPreadPwriteHugeArguments(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPreadPwriteHugeArguments, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestQuota(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestQuota, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestQuotaErrors(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestQuotaErrors, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestQuotaRename(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestQuotaRename, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestReadDir(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestReadDir, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestRename(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestRename, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestRenameErrors(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestRenameErrors, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestRenameOpenFile(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestRenameOpenFile, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestRenameReplace(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestRenameReplace, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestStat(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestStat, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestStatfs(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestStatfs, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestSymlinkAbsolute(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkAbsolute, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestSymlinkDangling(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkDangling, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestSymlinkErrors(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkErrors, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestSymlinkItself(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkItself, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestSymlinkLoop(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkLoop, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestSymlinkRelative(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkRelative, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestTruncateBelowOffset(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestTruncateBelowOffset, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestTruncateExtendsWithZeros(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestTruncateExtendsWithZeros, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkFiveServersUnreliableNet_TestWriteAtHugeOffset(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestWriteAtHugeOffset, OneClerkFiveServersUnreliableNet)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestFallocatePunchHole(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestFallocatePunchHole, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestPathNormalization(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPathNormalization, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestPathValidation(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPathValidation, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestPathsThatNameTheRoot(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPathsThatNameTheRoot, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestPreadPwrite(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPreadPwrite, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestPreadPwriteErrors(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPreadPwriteErrors, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestPreadPwriteHugeArguments(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPreadPwriteHugeArguments, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestQuota(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestQuota, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestQuotaErrors(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestQuotaErrors, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestQuotaRename(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestQuotaRename, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestReadDir(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestReadDir, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestRename(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestRename, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestRenameErrors(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestRenameErrors, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestRenameOpenFile(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestRenameOpenFile, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestRenameReplace(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestRenameReplace, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestStat(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestStat, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestStatfs(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestStatfs, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestSymlinkAbsolute(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkAbsolute, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestSymlinkDangling(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkDangling, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestSymlinkErrors(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkErrors, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestSymlinkItself(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkItself, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestSymlinkLoop(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkLoop, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestSymlinkRelative(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkRelative, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestTruncateBelowOffset(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestTruncateBelowOffset, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestTruncateExtendsWithZeros(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestTruncateExtendsWithZeros, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersNoErrors_TestWriteAtHugeOffset(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestWriteAtHugeOffset, OneClerkThreeServersNoErrors)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestFallocatePunchHole(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestFallocatePunchHole, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestPathNormalization(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPathNormalization, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestPathValidation(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPathValidation, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestPathsThatNameTheRoot(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPathsThatNameTheRoot, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestPreadPwrite(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPreadPwrite, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestPreadPwriteErrors(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPreadPwriteErrors, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestPreadPwriteHugeArguments(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestPreadPwriteHugeArguments, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestQuota(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestQuota, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestQuotaErrors(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestQuotaErrors, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestQuotaRename(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestQuotaRename, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestReadDir(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestReadDir, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestRename(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestRename, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestRenameErrors(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestRenameErrors, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestRenameOpenFile(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestRenameOpenFile, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestRenameReplace(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestRenameReplace, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestStat(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestStat, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestStatfs(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestStatfs, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestSymlinkAbsolute(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkAbsolute, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestSymlinkDangling(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkDangling, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestSymlinkErrors(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkErrors, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestSymlinkItself(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkItself, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestSymlinkLoop(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkLoop, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestSymlinkRelative(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestSymlinkRelative, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestTruncateBelowOffset(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestTruncateBelowOffset, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestTruncateExtendsWithZeros(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestTruncateExtendsWithZeros, OneClerkThreeServersSnapshots)
+}
+
+func TestClerk_OneClerkThreeServersSnapshots_TestWriteAtHugeOffset(t *testing.T) {
+	runFunctionalityTestWithDifficulty(t, filesystem.TestWriteAtHugeOffset, OneClerkThreeServersSnapshots)
+}
+
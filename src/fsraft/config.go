@@ -32,8 +32,8 @@ func makeSeed() int64 {
 }
 
 // Randomize server handles
-func random_handles(kvh []*labrpc.ClientEnd) []*labrpc.ClientEnd {
-	sa := make([]*labrpc.ClientEnd, len(kvh))
+func random_handles(kvh []labrpc.Endpoint) []labrpc.Endpoint {
+	sa := make([]labrpc.Endpoint, len(kvh))
 	copy(sa, kvh)
 	for i := range sa {
 		j := rand.Intn(i + 1)
@@ -48,12 +48,13 @@ type config struct {
 	net          *labrpc.Network
 	n            int
 	fileServers  []*FileServer
+	rpcServers   []*labrpc.Server // the RPC server each FileServer and its Raft are registered on
 	saved        []*raft.Persister
 	endnames     [][]string // names of each server's sending ClientEnds
 	clerks       map[*Clerk][]string
 	nextClientId int
-	maxraftstate int
-	start        time.Time // time at which make_config() was called
+	serverConfig FileServerConfig // passed to every StartFileServer()
+	start        time.Time        // time at which make_config() was called
 	// begin()/end() statistics
 	t0    time.Time // time at which test_test.go called cfg.begin()
 	rpcs0 int       // rpcTotal() at start of test
@@ -197,7 +198,7 @@ func (cfg *config) makeClient(to []int) *Clerk {
 	defer cfg.mu.Unlock()
 
 	// a fresh set of ClientEnds.
-	ends := make([]*labrpc.ClientEnd, cfg.n)
+	ends := make([]labrpc.Endpoint, cfg.n)
 	endnames := make([]string, cfg.n)
 	for j := 0; j < cfg.n; j++ {
 		endnames[j] = randstring(20)
@@ -307,7 +308,7 @@ func (cfg *config) StartServer(i int) {
 	}
 
 	// a fresh set of ClientEnds.
-	ends := make([]*labrpc.ClientEnd, cfg.n)
+	ends := make([]labrpc.Endpoint, cfg.n)
 	for j := 0; j < cfg.n; j++ {
 		ends[j] = cfg.net.MakeEnd(cfg.endnames[i][j])
 		cfg.net.Connect(cfg.endnames[i][j], j)
@@ -325,7 +326,7 @@ func (cfg *config) StartServer(i int) {
 	}
 	cfg.mu.Unlock()
 
-	cfg.fileServers[i] = StartFileServer(ends, i, cfg.saved[i], cfg.maxraftstate)
+	cfg.fileServers[i] = StartFileServer(ends, i, cfg.saved[i], cfg.serverConfig)
 
 	kvsvc := labrpc.MakeService(cfg.fileServers[i])
 	rfsvc := labrpc.MakeService(cfg.fileServers[i].Raft())
@@ -333,6 +334,7 @@ func (cfg *config) StartServer(i int) {
 	srv.AddService(kvsvc)
 	srv.AddService(rfsvc)
 	cfg.net.AddServer(i, srv)
+	cfg.rpcServers[i] = srv
 }
 
 func (cfg *config) Leader() (bool, int) {
@@ -371,6 +373,19 @@ func (cfg *config) make_partition() ([]int, []int) {
 var ncpuOnce sync.Once
 
 func make_config(t *testing.T, n int, unreliable bool, maxraftstate int) *config {
+	return make_config_with_learners(t, n, nil, unreliable, maxraftstate)
+}
+
+// like make_config(), but the servers listed in learners are non-voting learners.
+func make_config_with_learners(t *testing.T, n int, learners []int, unreliable bool, maxraftstate int) *config {
+	serverConfig := DefaultFileServerConfig()
+	serverConfig.Raft.Learners = learners
+	serverConfig.MaxRaftState = maxraftstate
+	return make_config_with_server_config(t, n, unreliable, serverConfig)
+}
+
+// like make_config(), but every server is started with serverConfig.
+func make_config_with_server_config(t *testing.T, n int, unreliable bool, serverConfig FileServerConfig) *config {
 	ncpuOnce.Do(func() {
 		if runtime.NumCPU() < 2 {
 			fmt.Printf("warning: only one CPU, which may conceal locking bugs\n")
@@ -383,11 +398,12 @@ func make_config(t *testing.T, n int, unreliable bool, maxraftstate int) *config
 	cfg.net = labrpc.MakeNetwork()
 	cfg.n = n
 	cfg.fileServers = make([]*FileServer, cfg.n)
+	cfg.rpcServers = make([]*labrpc.Server, cfg.n)
 	cfg.saved = make([]*raft.Persister, cfg.n)
 	cfg.endnames = make([][]string, cfg.n)
 	cfg.clerks = make(map[*Clerk][]string)
 	cfg.nextClientId = cfg.n + 1000 // client ids start 1000 above the highest serverid
-	cfg.maxraftstate = maxraftstate
+	cfg.serverConfig = serverConfig
 	cfg.start = time.Now()
 
 	// create a full set of KV servers.
@@ -24,16 +24,19 @@ var Difficulties = []func(t *testing.T) fs.FileSystem{
 
 func OneClerkThreeServersNoErrors(t *testing.T) fs.FileSystem {
 	cfg := make_config(t, 3, false, -1)
+	t.Cleanup(cfg.cleanup)
 	return cfg.makeClient(cfg.All())
 }
 
 func OneClerkFiveServersUnreliableNet(t *testing.T) fs.FileSystem {
 	cfg := make_config(t, 5, true, -1)
+	t.Cleanup(cfg.cleanup)
 	return cfg.makeClient(cfg.All())
 }
 
 func OneClerkThreeServersSnapshots(t *testing.T) fs.FileSystem {
 	cfg := make_config(t, 3, true, 1000) // arbitrarily
+	t.Cleanup(cfg.cleanup)
 	return cfg.makeClient(cfg.All())
 }
 
@@ -0,0 +1,69 @@
+package fsraft
+
+import (
+	"ad"
+	"sort"
+)
+
+// Operations on a FileServer for the people running it, rather than for clerks. See package adminapi, which serves
+// them over HTTP.
+
+// A clerk this FileServer has executed commands for. Clerks never say goodbye, so a session lasts as long as the
+// server's state does.
+type Session struct {
+	ClerkId           int64
+	CommandsExecuted  int // how many of the clerk's commands have been executed
+	PendingOperations int // how many of its RPCs are waiting on this server for their command to be committed
+}
+
+// List the clerks this server has executed commands for, in increasing order of ClerkId.
+func (fs *FileServer) Sessions() []Session {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	pending := make(map[int64]int)
+	for _, opInProgress := range fs.operationsInProgress {
+		pending[opInProgress.operationArgs.ClerkId]++
+	}
+	sessions := make([]Session, 0, len(fs.clerkCommandsExecuted))
+	for clerkId, commandsExecuted := range fs.clerkCommandsExecuted {
+		sessions = append(sessions, Session{clerkId, commandsExecuted, pending[clerkId]})
+		delete(pending, clerkId)
+	}
+	// clerks whose first command hasn't been executed yet
+	for clerkId, numPending := range pending {
+		sessions = append(sessions, Session{clerkId, 0, numPending})
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ClerkId < sessions[j].ClerkId })
+	return sessions
+}
+
+// Snapshot everything executed so far, whatever MaxRaftState is, and return the last index in the snapshot.
+// Does nothing if Raft's snapshot already goes that far, so it is safe to retry.
+func (fs *FileServer) ForceSnapshot() int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if fs.lastCommandIndexExecuted > fs.rf.Status().SnapshotIndex {
+		fs.writeSnapshot(fs.lastCommandIndexExecuted)
+	} else {
+		ad.DebugObj(fs, ad.TRACE, "Not snapshotting because the snapshot already goes up to index %d",
+			fs.lastCommandIndexExecuted)
+	}
+	return fs.lastCommandIndexExecuted
+}
+
+// Make peer the leader. See raft.Raft.TransferLeadership.
+func (fs *FileServer) TransferLeadership(peer int) error {
+	return fs.rf.TransferLeadership(peer)
+}
+
+// Make the learner peer a voter. See raft.Raft.Promote.
+func (fs *FileServer) Promote(peer int) error {
+	return fs.rf.Promote(peer)
+}
+
+// Make the voter peer a learner. See raft.Raft.Demote.
+func (fs *FileServer) Demote(peer int) error {
+	return fs.rf.Demote(peer)
+}
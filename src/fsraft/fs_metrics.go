@@ -0,0 +1,145 @@
+package fsraft
+
+import (
+	"fmt"
+	"io"
+	"labrpc"
+	"net/http"
+	"sort"
+)
+
+// Serves a FileServer's Stats() over HTTP in the Prometheus text format, for monitoring systems to scrape.
+// Register it on whatever mux and port the process uses, for example http.Handle("/metrics", exporter).
+type MetricsExporter struct {
+	fs        *FileServer
+	rpcServer *labrpc.Server // the RPC server the FileServer and its Raft are registered on, or nil
+}
+
+// Make an exporter for fs. If rpcServer is not nil, the counts and sizes of the RPCs it has handled are reported too.
+func MakeMetricsExporter(fs *FileServer, rpcServer *labrpc.Server) *MetricsExporter {
+	return &MetricsExporter{fs, rpcServer}
+}
+
+func (exporter *MetricsExporter) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	exporter.WriteMetrics(writer)
+}
+
+// Write every metric to writer in the Prometheus text format.
+func (exporter *MetricsExporter) WriteMetrics(writer io.Writer) {
+	stats := exporter.fs.Stats()
+	m := metricsWriter{writer}
+
+	isLeader := 0
+	if stats.ThinksIsLeader {
+		isLeader = 1
+	}
+	m.gauge("dfs_raft_term", "The current Raft term.", stats.Raft.Term)
+	m.gauge("dfs_raft_is_leader", "1 if this server is the Raft leader, 0 otherwise.", isLeader)
+	m.counter("dfs_raft_leader_changes_total", "How many leaders this server has followed or been.", stats.Raft.LeaderChanges)
+	m.gauge("dfs_raft_commit_index", "The highest log index known to be committed.", stats.Raft.CommitIndex)
+	m.gauge("dfs_raft_last_applied_index", "The highest log index applied to the filesystem by Raft.", stats.Raft.LastApplied)
+	m.gauge("dfs_raft_snapshot_index", "The last log index compressed into a snapshot.", stats.Raft.SnapshotIndex)
+	m.gauge("dfs_raft_last_log_index", "The last index in the log.", stats.Raft.LastLogIndex)
+	m.gauge("dfs_raft_log_bytes", "The size of the uncompressed part of the log.", stats.Raft.LogSizeBytes)
+
+	// only the leader knows how far behind its followers are
+	if stats.Raft.MatchIndex != nil {
+		m.header("dfs_raft_follower_commit_lag", "gauge", "How many committed entries a follower has yet to receive.")
+		for peer, matchIndex := range stats.Raft.MatchIndex {
+			if peer == stats.Me {
+				continue
+			}
+			lag := stats.Raft.CommitIndex - matchIndex
+			if lag < 0 {
+				lag = 0
+			}
+			m.sample("dfs_raft_follower_commit_lag", fmt.Sprintf(`{peer="%d"}`, peer), lag)
+		}
+	}
+
+	if exporter.rpcServer != nil {
+		methodStats := exporter.rpcServer.GetMethodStats()
+		methods := make([]string, 0, len(methodStats))
+		for method := range methodStats {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		m.header("dfs_rpc_received_total", "counter", "RPCs handled, by method.")
+		for _, method := range methods {
+			m.sample("dfs_rpc_received_total", fmt.Sprintf(`{method="%v"}`, method), methodStats[method].Count)
+		}
+		m.header("dfs_rpc_bytes_total", "counter", "Encoded size of the arguments received and replies sent, by method.")
+		for _, method := range methods {
+			m.sample("dfs_rpc_bytes_total", fmt.Sprintf(`{method="%v",direction="in"}`, method), methodStats[method].BytesIn)
+			m.sample("dfs_rpc_bytes_total", fmt.Sprintf(`{method="%v",direction="out"}`, method), methodStats[method].BytesOut)
+		}
+	}
+
+	m.gauge("dfs_fs_inodes", "Files and directories in the filesystem, including the root.", stats.Inodes)
+	m.gauge("dfs_fs_bytes", "Bytes stored in the filesystem's files.", stats.FileBytes)
+	m.gauge("dfs_fs_open_files", "Open file descriptors.", stats.OpenFiles)
+	m.gauge("dfs_applied_index", "The last log index executed on the filesystem.", stats.AppliedIndex)
+	m.gauge("dfs_pending_operations", "RPCs waiting for their command to be committed.", stats.PendingOperations)
+	m.counter("dfs_duplicate_hits_total", "Commands answered from the cache of replies because they were already executed.",
+		stats.DuplicateHits)
+	m.counter("dfs_snapshots_written_total", "Snapshots written.", stats.SnapshotsWritten)
+	m.counter("dfs_snapshots_installed_total", "Snapshots received from the leader.", stats.SnapshotsInstalled)
+	m.gauge("dfs_snapshot_bytes", "The size of the last snapshot written or installed.", stats.LastSnapshotBytes)
+	m.header("dfs_snapshot_seconds_total", "counter", "Time spent writing snapshots.")
+	m.sample("dfs_snapshot_seconds_total", "", stats.TotalSnapshotTime.Seconds())
+
+	opTypes := make([]int, 0, len(stats.Operations))
+	for opType := range stats.Operations {
+		opTypes = append(opTypes, int(opType))
+	}
+	sort.Ints(opTypes)
+	m.header("dfs_operations_executed_total", "counter", "Operations applied to the filesystem, not counting duplicates.")
+	for _, opType := range opTypes {
+		m.sample("dfs_operations_executed_total", fmt.Sprintf(`{op="%v"}`, OpType(opType)),
+			stats.Operations[OpType(opType)].Executed)
+	}
+	m.header("dfs_operation_latency_seconds", "histogram", "Time from receiving an operation to replying OK, on the leader.")
+	for _, opType := range opTypes {
+		m.histogram("dfs_operation_latency_seconds", fmt.Sprintf(`op="%v"`, OpType(opType)),
+			stats.Operations[OpType(opType)].Latency)
+	}
+}
+
+// Private helper methods ==============================================================================================
+
+type metricsWriter struct {
+	writer io.Writer
+}
+
+func (m metricsWriter) header(name string, metricType string, help string) {
+	fmt.Fprintf(m.writer, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, metricType)
+}
+
+// labels is either empty or a complete label set such as `{peer="1"}`.
+func (m metricsWriter) sample(name string, labels string, value interface{}) {
+	fmt.Fprintf(m.writer, "%v%v %v\n", name, labels, value)
+}
+
+func (m metricsWriter) gauge(name string, help string, value int) {
+	m.header(name, "gauge", help)
+	m.sample(name, "", value)
+}
+
+func (m metricsWriter) counter(name string, help string, value int) {
+	m.header(name, "counter", help)
+	m.sample(name, "", value)
+}
+
+// Write the samples of one histogram. labels has no braces, e.g. `op="Read"`, so that "le" can be added to it.
+func (m metricsWriter) histogram(name string, labels string, histogram LatencyHistogram) {
+	cumulative := 0
+	for bucket, bound := range histogram.Bounds {
+		cumulative += histogram.Counts[bucket]
+		m.sample(name+"_bucket", fmt.Sprintf(`{%v,le="%v"}`, labels, bound.Seconds()), cumulative)
+	}
+	cumulative += histogram.Counts[len(histogram.Bounds)]
+	m.sample(name+"_bucket", fmt.Sprintf(`{%v,le="+Inf"}`, labels), cumulative)
+	m.sample(name+"_sum", "{"+labels+"}", histogram.Total.Seconds())
+	m.sample(name+"_count", "{"+labels+"}", cumulative)
+}
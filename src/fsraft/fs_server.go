@@ -22,7 +22,7 @@ type FileServer struct {
 	me                 int                // index into the list of servers
 	applyCh            chan raft.ApplyMsg // for messages from the raft server
 	rf                 *raft.Raft         // The underlying Raft
-	maxraftstate       int                // snapshot if log grows this big, -1 for no snapshots
+	config             FileServerConfig   // tuning settings, including maxraftstate
 	killCh             chan bool          // send on this channel when you die
 	thinksRaftIsLeader bool               // if it thinks its raft peer is a leader
 	thinksRaftTermIs   int                // what it thinks the term of the underlying Raft peer is
@@ -32,6 +32,45 @@ type FileServer struct {
 	clerkCommandsExecuted    map[int64]int                   //clerkCommandsExecuted[clerk serial number] = last command index of a command from that clerk
 	lastCommandIndexExecuted int                             // total number of commands executed. Equal to the sum of values in clerkCommandsExecuted.
 	cachedReplies            map[int64]map[int][]interface{} // Map<Clerk ID, Map<Clerk index, result>>
+	stats                    Stats                           // counters reported by Stats(). Me, Raft, etc. are filled in there.
+}
+
+// Settings for a FileServer. Start from DefaultFileServerConfig() and change what you need.
+type FileServerConfig struct {
+	Raft                   raft.Config       // settings for the underlying Raft peer, including which peers are learners
+	MaxRaftState           int               // snapshot when Raft's saved state grows this big, -1 for no snapshots
+	LeadershipPollInterval time.Duration     // how often to check whether the Raft peer is still the leader
+	Limits                 filesystem.Limits // how much the filesystem may hold; every server needs the same ones
+}
+
+func DefaultFileServerConfig() FileServerConfig {
+	return FileServerConfig{
+		Raft:                   raft.DefaultConfig(),
+		MaxRaftState:           -1,
+		LeadershipPollInterval: 300 * time.Millisecond,
+	}
+}
+
+// Returns an error describing the first problem with the config, or nil if there are none.
+func (config FileServerConfig) Validate() error {
+	if err := config.Raft.Validate(); err != nil {
+		return err
+	}
+	switch {
+	case config.MaxRaftState != -1 && config.MaxRaftState <= 0:
+		return fmt.Errorf("MaxRaftState must be positive or -1, not %d", config.MaxRaftState)
+	case config.Limits.MaxBytes < 0 || config.Limits.MaxInodes < 0 || config.Limits.MaxFileSize < 0 ||
+		config.Limits.MaxDirEntries < 0:
+		return fmt.Errorf("Limits must not be negative, not %+v", config.Limits)
+	case config.LeadershipPollInterval <= 0:
+		return fmt.Errorf("LeadershipPollInterval must be positive, not %v", config.LeadershipPollInterval)
+	case config.LeadershipPollInterval > config.Raft.MaxElectionTimeout:
+		// operations started on a leader that has been deposed only fail once this notices, so polling less often than
+		// a new leader can be elected would leave clerks waiting on a dead leader for longer than necessary.
+		return fmt.Errorf("LeadershipPollInterval (%v) must not be greater than Raft.MaxElectionTimeout (%v)",
+			config.LeadershipPollInterval, config.Raft.MaxElectionTimeout)
+	}
+	return nil
 }
 
 // Clerk-facing API ====================================================================================================
@@ -39,41 +78,38 @@ type FileServer struct {
 // Start a FileServer.
 // servers[] contains the ports of the set of servers that will cooperate via Raft to form the fault-tolerant file service.
 // me is the index of the current server in servers[].
-func StartFileServer(servers []*labrpc.ClientEnd, me int, persister *raft.Persister, maxRaftState int) *FileServer {
+// Panics if config is invalid (see FileServerConfig.Validate).
+func StartFileServer(servers []labrpc.Endpoint, me int, persister raft.Storage, config FileServerConfig) *FileServer {
+	if err := config.Validate(); err != nil {
+		panic(fmt.Sprintf("Invalid FileServerConfig: %v", err))
+	}
 	// the filesystem server should store snapshots with persister.SaveSnapshot(),
 	// and Raft should save its state (including log) with persister.SaveRaftState().
-	// the filesystem server should snapshot when Raft's saved state exceeds maxraftstate bytes,
-	// in order to allow Raft to garbage-collect its log. if maxraftstate is -1, you don't need to snapshot.
+	// the filesystem server should snapshot when Raft's saved state exceeds config.MaxRaftState bytes,
+	// in order to allow Raft to garbage-collect its log. if it is -1, you don't need to snapshot.
 	// StartFileServer() must return quickly, so it should start goroutines
 	// for any long-running work.
 
-	// call labgob.Register on structures you want
-	// Go's RPC library to marshall/unmarshall.
-	labgob.Register(OpenOp)
-	labgob.Register(filesystem.NotFound)
-	labgob.Register(filesystem.ReadOnly)
-	labgob.Register(filesystem.Append)
-	labgob.Register(filesystem.FromBeginning)
-	labgob.Register(AbstractOperation{})
-	labgob.Register(OperationArgs{})
-	labgob.Register(OperationReply{})
+	registerLabgobTypes()
 
 	fs := new(FileServer)
 	fs.lock.Lock()
 	fs.me = me
 	fs.applyCh = make(chan raft.ApplyMsg)
-	fs.rf = raft.Make(servers, me, persister, fs.applyCh)
-	fs.maxraftstate = maxRaftState
+	fs.config = config
+	fs.rf = raft.MakeWithConfig(servers, me, persister, fs.applyCh, config.Raft)
 	fs.killCh = make(chan bool, 2) // 2 because there's 2 long-running threads per server
 
 	fs.thinksRaftIsLeader = false
 	fs.thinksRaftTermIs = 0
 
 	fs.memoryFS = memoryFS.CreateEmptyMemoryFS()
+	fs.memoryFS.SetLimits(config.Limits)
 	fs.operationsInProgress = make(map[OpArgsHash]OperationInProgress)
 	fs.clerkCommandsExecuted = make(map[int64]int)
 	fs.lastCommandIndexExecuted = 0
 	fs.cachedReplies = make(map[int64]map[int][]interface{})
+	fs.stats = makeStats()
 
 	go fs.applyChMonitorThread()
 	go fs.stateUpdaterThread()
@@ -99,6 +135,7 @@ func (fs *FileServer) Kill() {
 
 // Do an Operation.
 func (fs *FileServer) Operation(args *OperationArgs, reply *OperationReply) {
+	receivedAt := time.Now()
 	fs.lock.Lock()
 	ad.Assert(args != nil)
 	ad.Assert(reply != nil)
@@ -124,6 +161,9 @@ func (fs *FileServer) Operation(args *OperationArgs, reply *OperationReply) {
 
 	if result.Status == OK {
 		ad.AssertExplain(len(result.ReturnValue) > 0, "Got a Reply that is OK but with an empty returnValue!")
+		opStats := fs.stats.Operations[args.AbstractOperation.OpType]
+		opStats.Latency.record(time.Since(receivedAt))
+		fs.stats.Operations[args.AbstractOperation.OpType] = opStats
 	}
 
 	// Copy fields because the OperationReply we get out of the channel is a // different object than
@@ -132,6 +172,55 @@ func (fs *FileServer) Operation(args *OperationArgs, reply *OperationReply) {
 	reply.ReturnValue = result.ReturnValue
 }
 
+// Read a file, or an open file descriptor like Pread does, from this server's local copy of the filesystem, without
+// going through Raft. Neither changes any state, so any server can answer, including learners, which is what makes
+// them useful as read replicas.
+// The data may be stale: it reflects the commands this server has applied so far (see reply.AppliedIndex),
+// which may be behind the leader.
+func (fs *FileServer) StaleRead(args *StaleReadArgs, reply *StaleReadReply) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	ad.Assert(args != nil)
+	ad.Assert(reply != nil)
+
+	var bytesRead int
+	var data []byte
+	var err error
+	if args.UseFD {
+		bytesRead, data, err = fs.memoryFS.Pread(args.FileDescriptor, args.Offset, args.NumBytes)
+	} else {
+		bytesRead, data, err = fs.memoryFS.ReadPath(args.Path, args.Offset, args.NumBytes)
+	}
+	reply.ReturnValue = []interface{}{bytesRead, data, err}
+	reply.AppliedIndex = fs.lastCommandIndexExecuted
+	reply.Status = OK
+	ad.DebugObj(fs, ad.RPC, "Served stale read of %+v at index %d", *args, reply.AppliedIndex)
+}
+
+// Return what this server has been doing, including the state of its Raft peer.
+func (fs *FileServer) Stats() Stats {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	stats := fs.stats.copy()
+	stats.Me = fs.me
+	stats.Raft = fs.rf.Status()
+	stats.ThinksIsLeader = fs.thinksRaftIsLeader
+	stats.AppliedIndex = fs.lastCommandIndexExecuted
+	stats.PendingOperations = len(fs.operationsInProgress)
+	stats.Inodes, stats.FileBytes = fs.memoryFS.Usage()
+	stats.OpenFiles = fs.memoryFS.NumOpenFiles()
+	return stats
+}
+
+// Report Stats() over RPC, for monitoring tools such as `dfsctl status`.
+func (fs *FileServer) Status(args *StatusArgs, reply *StatusReply) {
+	ad.Assert(args != nil)
+	ad.Assert(reply != nil)
+	reply.Stats = fs.Stats()
+	ad.DebugObj(fs, ad.TRACE, "Reported status to %v", clerkShortName(args.ClerkId))
+}
+
 // Long-running threads ================================================================================================
 
 func (fs *FileServer) applyChMonitorThread() {
@@ -191,11 +280,20 @@ func (fs *FileServer) applyChMonitorThread() {
 				} else {
 					ad.DebugObj(fs, ad.TRACE, "No RPC in progress for %v.", clerkShortName(opArgs.ClerkId))
 				}
-				if (fs.maxraftstate != -1) && (fs.rf.StateSizeBytes() > fs.maxraftstate) {
-					ad.DebugObj(fs, ad.TRACE, "Raft's state is %d bytes, but max is %d bytes.", fs.rf.StateSizeBytes(), fs.maxraftstate)
+				if (fs.config.MaxRaftState != -1) && (fs.rf.StateSizeBytes() > fs.config.MaxRaftState) {
+					ad.DebugObj(fs, ad.TRACE, "Raft's state is %d bytes, but max is %d bytes.", fs.rf.StateSizeBytes(), fs.config.MaxRaftState)
 					ad.AssertEquals(applyMsg.CommandIndex, fs.lastCommandIndexExecuted)
 					fs.writeSnapshot(fs.lastCommandIndexExecuted)
 				}
+			} else if applyMsg.Purpose == raft.MEMBERSHIP {
+				// there is nothing to execute, but the index still counts as executed.
+				if applyMsg.CommandIndex == fs.lastCommandIndexExecuted+1 {
+					ad.DebugObj(fs, ad.RPC, "Membership changed at index %d: %+v", applyMsg.CommandIndex, applyMsg.Command)
+					fs.lastCommandIndexExecuted = applyMsg.CommandIndex
+				} else {
+					ad.DebugObj(fs, ad.WARN, "Skipping out-of-order membership change %+v!", applyMsg)
+				}
+				fs.updateTermAndLeadership()
 			} else {
 				index := applyMsg.CommandIndex
 				if index > fs.lastCommandIndexExecuted {
@@ -203,6 +301,7 @@ func (fs *FileServer) applyChMonitorThread() {
 					fs.lastCommandIndexExecuted = index
 					fs.updateTermAndLeadership()
 					fs.readSnapshot(applyMsg.Command.([]byte))
+					fs.stats.SnapshotsInstalled++
 					fs.writeSnapshot(index) // so it can be backed up
 				} else {
 					ad.DebugObj(fs, ad.TRACE, "Ignoring snapshot out of the applyCh because it covers indices through %d and I have"+
@@ -221,7 +320,7 @@ func (fs *FileServer) stateUpdaterThread() {
 		select {
 		case <-fs.killCh:
 			return
-		case <-time.After(300 * time.Millisecond):
+		case <-time.After(fs.config.LeadershipPollInterval):
 			fs.lock.Lock()
 			fs.updateTermAndLeadership()
 			fs.lock.Unlock()
@@ -258,6 +357,9 @@ func (fs *FileServer) execute(ab AbstractOperation, clerkId int64, clerkIndex in
 		// Don't skip commands from a clerk and execute commands in order
 		ad.AssertEquals(fs.clerkCommandsExecuted[clerkId]+1, clerkIndex)
 		fs.clerkCommandsExecuted[clerkId] += 1
+		opStats := fs.stats.Operations[ab.OpType]
+		opStats.Executed++
+		fs.stats.Operations[ab.OpType] = opStats
 
 		ad.DebugObj(fs, ad.RPC, "Executing %v for %v %v.", ab.String(), clerkShortName(clerkId), clerkIndex)
 		returnValue := fs.performAbstractOperation(ab)
@@ -277,6 +379,7 @@ func (fs *FileServer) execute(ab AbstractOperation, clerkId int64, clerkIndex in
 			"and clerkIndex=%d, cache is %+v", clerkId, clerkIndex, fs.cachedReplies)
 		ad.DebugObj(fs, ad.TRACE, "Skipping duplicate command %+v for %v %d because %v. Returning %v from the cache.",
 			ab, clerkShortName(clerkId), clerkIndex, duplicateReason, returnValue)
+		fs.stats.DuplicateHits++
 		return returnValue
 	}
 }
@@ -286,12 +389,10 @@ func (fs *FileServer) performAbstractOperation(ab AbstractOperation) []interface
 	// Should be a switch on OpType
 	switch ab.OpType {
 	case MkdirOp:
-		// Make sure relevant args are set
-		ad.Assert(ab.Path != "")
+		// memoryFS checks the path, so a malformed one from a clerk is an error rather than a crash on every server
 		success, err := fs.memoryFS.Mkdir(ab.Path)
 		return []interface{}{success, err}
 	case OpenOp:
-		ad.Assert(ab.Path != "")
 		fileDescriptor, err := fs.memoryFS.Open(ab.Path, ab.OpenMode, ab.OpenFlags)
 		return []interface{}{fileDescriptor, err}
 	case CloseOp:
@@ -303,12 +404,54 @@ func (fs *FileServer) performAbstractOperation(ab AbstractOperation) []interface
 	case ReadOp:
 		bytesRead, data, err := fs.memoryFS.Read(ab.FileDescriptor, ab.NumBytes)
 		return []interface{}{bytesRead, data, err}
+	case PreadOp:
+		bytesRead, data, err := fs.memoryFS.Pread(ab.FileDescriptor, ab.Offset, ab.NumBytes)
+		return []interface{}{bytesRead, data, err}
 	case WriteOp:
 		bytesWritten, err := fs.memoryFS.Write(ab.FileDescriptor, ab.NumBytes, ab.Data)
 		return []interface{}{bytesWritten, err}
+	case PwriteOp:
+		bytesWritten, err := fs.memoryFS.Pwrite(ab.FileDescriptor, ab.Offset, ab.Data)
+		return []interface{}{bytesWritten, err}
 	case DeleteOp:
 		success, err := fs.memoryFS.Delete(ab.Path)
 		return []interface{}{success, err}
+	case StatOp:
+		info, err := fs.memoryFS.Stat(ab.Path)
+		return []interface{}{info, err}
+	case ReadDirOp:
+		entries, err := fs.memoryFS.ReadDir(ab.Path)
+		return []interface{}{entries, err}
+	case TruncateOp:
+		success, err := fs.memoryFS.Truncate(ab.Path, ab.Size)
+		return []interface{}{success, err}
+	case FtruncateOp:
+		success, err := fs.memoryFS.Ftruncate(ab.FileDescriptor, ab.Size)
+		return []interface{}{success, err}
+	case FallocateOp:
+		success, err := fs.memoryFS.Fallocate(ab.FileDescriptor, ab.FallocateMode, ab.Offset, ab.NumBytes)
+		return []interface{}{success, err}
+	case StatfsOp:
+		info, err := fs.memoryFS.Statfs()
+		return []interface{}{info, err}
+	case SetQuotaOp:
+		success, err := fs.memoryFS.SetQuota(ab.Path, ab.MaxBytes, ab.MaxInodes)
+		return []interface{}{success, err}
+	case GetQuotaOp:
+		quota, err := fs.memoryFS.GetQuota(ab.Path)
+		return []interface{}{quota, err}
+	case LstatOp:
+		info, err := fs.memoryFS.Lstat(ab.Path)
+		return []interface{}{info, err}
+	case SymlinkOp:
+		success, err := fs.memoryFS.Symlink(ab.Target, ab.Path)
+		return []interface{}{success, err}
+	case ReadlinkOp:
+		target, err := fs.memoryFS.Readlink(ab.Path)
+		return []interface{}{target, err}
+	case RenameOp:
+		success, err := fs.memoryFS.Rename(ab.Path, ab.NewPath)
+		return []interface{}{success, err}
 	}
 	panic("Needs a return at the end of the function, but we can never get here")
 }
@@ -363,18 +506,31 @@ func (fs *FileServer) loseLeadership() {
 // Not threadsafe: only call with the lock!
 func (fs *FileServer) writeSnapshot(lastIncludedIndex int) {
 	ad.DebugObj(fs, ad.RPC, "Writing snapshot containing up to index=%d", lastIncludedIndex)
-	fs.rf.Snapshot(fs.getSnapshotData(), lastIncludedIndex)
-
+	start := time.Now()
+	data := fs.getSnapshotData()
+	fs.rf.Snapshot(data, lastIncludedIndex)
+
+	fs.stats.SnapshotsWritten++
+	fs.stats.LastSnapshotBytes = len(data)
+	fs.stats.LastSnapshotTime = time.Since(start)
+	fs.stats.TotalSnapshotTime += fs.stats.LastSnapshotTime
 }
 
 // Returns the data to be persisted in a snapshot.
 func (fs *FileServer) getSnapshotData() []byte {
 	byteBuffer := new(bytes.Buffer)
 	encoder := labgob.NewEncoder(byteBuffer)
-	encoder.Encode(fs.memoryFS)
+	encoder.Encode(fs.memoryFS.Snapshot())
 	encoder.Encode(fs.clerkCommandsExecuted)
 	encoder.Encode(fs.lastCommandIndexExecuted)
 
+	// clerks only do one request at a time, so the only reply a clerk can ask for again is its latest one.
+	lastReplies := make(map[int64][]interface{})
+	for clerkId, commandsExecuted := range fs.clerkCommandsExecuted {
+		lastReplies[clerkId] = fs.cachedReplies[clerkId][commandsExecuted]
+	}
+	encoder.Encode(lastReplies)
+
 	return byteBuffer.Bytes()
 }
 
@@ -387,11 +543,12 @@ func (fs *FileServer) readSnapshot(data []byte) {
 	byteBuffer := bytes.NewBuffer(data)
 	decoder := labgob.NewDecoder(byteBuffer)
 
-	var mfs memoryFS.MemoryFS
+	var mfs memoryFS.Snapshot
 	if decoder.Decode(&mfs) != nil {
 		panic("Error decoding memoryFS!")
 	} else {
-		fs.memoryFS = mfs
+		fs.memoryFS = memoryFS.RestoreMemoryFS(mfs)
+		fs.memoryFS.SetLimits(fs.config.Limits)
 	}
 
 	var clerkCommandsExecuted map[int64]int
@@ -408,6 +565,16 @@ func (fs *FileServer) readSnapshot(data []byte) {
 		fs.lastCommandIndexExecuted = lastCommandIndexExecuted
 	}
 
+	var lastReplies map[int64][]interface{}
+	if decoder.Decode(&lastReplies) != nil {
+		panic("Error decoding lastReplies!")
+	} else {
+		fs.cachedReplies = make(map[int64]map[int][]interface{})
+		for clerkId, reply := range lastReplies {
+			fs.cachedReplies[clerkId] = map[int][]interface{}{fs.clerkCommandsExecuted[clerkId]: reply}
+		}
+	}
+
 	ad.DebugObj(fs, ad.RPC, "State read from stable storage. memoryFS=%+v, clerkCommandsExecuted=%+v, "+
 		"lastCommandIndexExecuted=%v", fs.memoryFS, fs.clerkCommandsExecuted, fs.lastCommandIndexExecuted)
 }
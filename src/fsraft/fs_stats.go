@@ -0,0 +1,178 @@
+package fsraft
+
+import (
+	"fmt"
+	"raft"
+	"sort"
+	"time"
+)
+
+// Counters kept by a FileServer so that operators can see what it is doing. They are not persisted, so they start
+// over from zero when the server restarts.
+
+// Upper bounds of the buckets in a LatencyHistogram. Latencies above the last bound go in one more bucket.
+var latencyBucketBounds = []time.Duration{
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+// Counts how long operations took.
+// Counts[i] is the number of latencies in (Bounds[i-1], Bounds[i]], and Counts[len(Bounds)] is the number above the
+// last bound.
+type LatencyHistogram struct {
+	Bounds []time.Duration
+	Counts []int
+	Total  time.Duration // the sum of every latency recorded
+}
+
+func makeLatencyHistogram() LatencyHistogram {
+	return LatencyHistogram{latencyBucketBounds, make([]int, len(latencyBucketBounds)+1), 0}
+}
+
+func (histogram *LatencyHistogram) record(latency time.Duration) {
+	bucket := sort.Search(len(histogram.Bounds), func(i int) bool { return latency <= histogram.Bounds[i] })
+	histogram.Counts[bucket]++
+	histogram.Total += latency
+}
+
+// The number of latencies recorded.
+func (histogram LatencyHistogram) Count() int {
+	count := 0
+	for _, bucketCount := range histogram.Counts {
+		count += bucketCount
+	}
+	return count
+}
+
+// The mean latency, or 0 if none have been recorded.
+func (histogram LatencyHistogram) Mean() time.Duration {
+	count := histogram.Count()
+	if count == 0 {
+		return 0
+	}
+	return histogram.Total / time.Duration(count)
+}
+
+// Return the upper bound of the bucket containing the p-th percentile (0 < p <= 100), or 0 if nothing has been
+// recorded. Returns -1 if it is above the last bound.
+func (histogram LatencyHistogram) Percentile(p float64) time.Duration {
+	count := histogram.Count()
+	if count == 0 {
+		return 0
+	}
+	needed := int(float64(count)*p/100 + 0.5)
+	if needed < 1 {
+		needed = 1
+	}
+	seen := 0
+	for bucket, bucketCount := range histogram.Counts {
+		seen += bucketCount
+		if seen >= needed {
+			if bucket == len(histogram.Bounds) {
+				return -1
+			}
+			return histogram.Bounds[bucket]
+		}
+	}
+	panic("Needs a return at the end of the function, but we can never get here")
+}
+
+func (histogram LatencyHistogram) formatPercentile(p float64) string {
+	bound := histogram.Percentile(p)
+	if bound == -1 {
+		return fmt.Sprintf(">%v", histogram.Bounds[len(histogram.Bounds)-1])
+	}
+	return fmt.Sprintf("<=%v", bound)
+}
+
+func (histogram LatencyHistogram) copy() LatencyHistogram {
+	histogram.Counts = append([]int{}, histogram.Counts...)
+	return histogram
+}
+
+// What a FileServer has done with one type of operation.
+type OpStats struct {
+	Executed int              // applied to the filesystem, not counting duplicates. Counted on every server.
+	Latency  LatencyHistogram // from receiving the RPC to replying OK. Only counted on the leader that replies.
+}
+
+// Everything a FileServer reports about itself. See FileServer.Stats().
+type Stats struct {
+	Me                 int
+	Raft               raft.Status
+	ThinksIsLeader     bool
+	AppliedIndex       int // the last log index executed on the filesystem
+	Operations         map[OpType]OpStats
+	DuplicateHits      int // commands that were already executed, answered from the cache of replies
+	PendingOperations  int // RPCs waiting for their command to be committed
+	Inodes             int // files and directories in the filesystem, including the root
+	FileBytes          int // bytes stored in the filesystem's files
+	OpenFiles          int // open file descriptors
+	SnapshotsWritten   int
+	SnapshotsInstalled int           // snapshots received from the leader
+	LastSnapshotBytes  int           // the size of the last snapshot written or installed
+	LastSnapshotTime   time.Duration // how long writing the last snapshot took
+	TotalSnapshotTime  time.Duration // how long writing snapshots took altogether
+}
+
+func makeStats() Stats {
+	stats := Stats{Operations: make(map[OpType]OpStats)}
+	for opType := range opTypesToStrings {
+		stats.Operations[opType] = OpStats{Latency: makeLatencyHistogram()}
+	}
+	return stats
+}
+
+// Return a copy that shares no memory with stats.
+func (stats Stats) copy() Stats {
+	operations := make(map[OpType]OpStats)
+	for opType, opStats := range stats.Operations {
+		opStats.Latency = opStats.Latency.copy()
+		operations[opType] = opStats
+	}
+	stats.Operations = operations
+	return stats
+}
+
+// Format Stats as human-readable lines, e.g. for `dfsctl status`.
+func (stats Stats) String() string {
+	str := stats.Raft.String()
+	str += fmt.Sprintf("server %d: thinks leader=%v, applied=%d, pending=%d, duplicate hits=%d\n",
+		stats.Me, stats.ThinksIsLeader, stats.AppliedIndex, stats.PendingOperations, stats.DuplicateHits)
+	str += fmt.Sprintf("filesystem: %d inodes, %d bytes, %d open files\n", stats.Inodes, stats.FileBytes, stats.OpenFiles)
+	str += fmt.Sprintf("snapshots: %d written, %d installed, last %d bytes, last took %v, total %v\n",
+		stats.SnapshotsWritten, stats.SnapshotsInstalled, stats.LastSnapshotBytes, stats.LastSnapshotTime,
+		stats.TotalSnapshotTime)
+	opTypes := make([]int, 0, len(stats.Operations))
+	for opType := range stats.Operations {
+		opTypes = append(opTypes, int(opType))
+	}
+	sort.Ints(opTypes)
+	for _, opType := range opTypes {
+		opStats := stats.Operations[OpType(opType)]
+		str += fmt.Sprintf("%-9v executed=%d replied=%d mean=%v p50%v p99%v\n", OpType(opType), opStats.Executed,
+			opStats.Latency.Count(), opStats.Latency.Mean(), opStats.Latency.formatPercentile(50),
+			opStats.Latency.formatPercentile(99))
+	}
+	return str
+}
+
+// StatusArgs and StatusReply ==========================================================================================
+
+type StatusArgs struct {
+	ClerkId int64 // who is asking, for debugging
+}
+
+type StatusReply struct {
+	Stats Stats
+}
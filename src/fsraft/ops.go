@@ -5,6 +5,7 @@ import (
 	"crypto/sha1"
 	"filesystem"
 	"fmt"
+	"labgob"
 	"reflect"
 )
 
@@ -22,16 +23,44 @@ const (
 	ReadOp
 	WriteOp
 	DeleteOp
+	StatOp
+	ReadDirOp
+	TruncateOp
+	FtruncateOp
+	FallocateOp
+	PreadOp
+	PwriteOp
+	StatfsOp
+	SetQuotaOp
+	GetQuotaOp
+	LstatOp
+	SymlinkOp
+	ReadlinkOp
+	RenameOp
 )
 
 var opTypesToStrings = map[OpType]string{
-	MkdirOp:  "Mkdir",
-	OpenOp:   "Open",
-	CloseOp:  "Close",
-	SeekOp:   "Seek",
-	ReadOp:   "Read",
-	WriteOp:  "Write",
-	DeleteOp: "Delete",
+	MkdirOp:     "Mkdir",
+	OpenOp:      "Open",
+	CloseOp:     "Close",
+	SeekOp:      "Seek",
+	ReadOp:      "Read",
+	WriteOp:     "Write",
+	DeleteOp:    "Delete",
+	StatOp:      "Stat",
+	ReadDirOp:   "ReadDir",
+	TruncateOp:  "Truncate",
+	FtruncateOp: "Ftruncate",
+	FallocateOp: "Fallocate",
+	PreadOp:     "Pread",
+	PwriteOp:    "Pwrite",
+	StatfsOp:    "Statfs",
+	SetQuotaOp:  "SetQuota",
+	GetQuotaOp:  "GetQuota",
+	LstatOp:     "Lstat",
+	SymlinkOp:   "Symlink",
+	ReadlinkOp:  "Readlink",
+	RenameOp:    "Rename",
 }
 
 func (o OpType) String() string {
@@ -52,6 +81,12 @@ type AbstractOperation struct {
 	Base           filesystem.SeekMode
 	NumBytes       int
 	Data           []byte
+	Size           int
+	FallocateMode  filesystem.FallocateMode
+	MaxBytes       int
+	MaxInodes      int
+	Target         string
+	NewPath        string
 }
 
 func (ab *AbstractOperation) String() string {
@@ -71,6 +106,33 @@ func (ab *AbstractOperation) String() string {
 		args = fmt.Sprintf("%v, %v, %+v", ab.FileDescriptor, ab.NumBytes, ab.Data)
 	case DeleteOp:
 		args = ab.Path
+	case StatOp:
+		args = ab.Path
+	case ReadDirOp:
+		args = ab.Path
+	case TruncateOp:
+		args = fmt.Sprintf("%v, %v", ab.Path, ab.Size)
+	case FtruncateOp:
+		args = fmt.Sprintf("%v, %v", ab.FileDescriptor, ab.Size)
+	case FallocateOp:
+		args = fmt.Sprintf("%v, %v, %v, %v", ab.FileDescriptor, ab.FallocateMode, ab.Offset, ab.NumBytes)
+	case PreadOp:
+		args = fmt.Sprintf("%v, %v, %v", ab.FileDescriptor, ab.Offset, ab.NumBytes)
+	case PwriteOp:
+		args = fmt.Sprintf("%v, %v, %+v", ab.FileDescriptor, ab.Offset, ab.Data)
+	case StatfsOp:
+	case SetQuotaOp:
+		args = fmt.Sprintf("%v, %v, %v", ab.Path, ab.MaxBytes, ab.MaxInodes)
+	case GetQuotaOp:
+		args = ab.Path
+	case LstatOp:
+		args = ab.Path
+	case SymlinkOp:
+		args = fmt.Sprintf("%v, %v", ab.Target, ab.Path)
+	case ReadlinkOp:
+		args = ab.Path
+	case RenameOp:
+		args = fmt.Sprintf("%v, %v", ab.Path, ab.NewPath)
 	}
 	return fmt.Sprintf("%v(%v)", ab.OpType.String(), args)
 }
@@ -96,12 +158,12 @@ func assertReplyTypesValid(opType OpType, reply interface{}) {
 		ad.AssertEquals(2, len(arr))
 		_ = arr[0].(int) // newPosition
 		ad.AssertIsErrorOrNil(arr[1])
-	case ReadOp:
+	case ReadOp, PreadOp:
 		ad.AssertEquals(3, len(arr))
 		_ = arr[0].(int)    // bytesRead
 		_ = arr[1].([]byte) // data
 		ad.AssertIsErrorOrNil(arr[2])
-	case WriteOp:
+	case WriteOp, PwriteOp:
 		ad.AssertEquals(2, len(arr))
 		_ = arr[0].(int) // bytesWritten
 		ad.AssertIsErrorOrNil(arr[1])
@@ -109,6 +171,30 @@ func assertReplyTypesValid(opType OpType, reply interface{}) {
 		ad.AssertEquals(2, len(arr))
 		_ = arr[0].(bool) // success
 		ad.AssertIsErrorOrNil(arr[1])
+	case StatOp, LstatOp:
+		ad.AssertEquals(2, len(arr))
+		_ = arr[0].(filesystem.FileInfo) // info
+		ad.AssertIsErrorOrNil(arr[1])
+	case ReadDirOp:
+		ad.AssertEquals(2, len(arr))
+		_ = arr[0].([]filesystem.FileInfo) // entries
+		ad.AssertIsErrorOrNil(arr[1])
+	case TruncateOp, FtruncateOp, FallocateOp, SetQuotaOp, SymlinkOp, RenameOp:
+		ad.AssertEquals(2, len(arr))
+		_ = arr[0].(bool) // success
+		ad.AssertIsErrorOrNil(arr[1])
+	case StatfsOp:
+		ad.AssertEquals(2, len(arr))
+		_ = arr[0].(filesystem.FsInfo) // info
+		ad.AssertIsErrorOrNil(arr[1])
+	case GetQuotaOp:
+		ad.AssertEquals(2, len(arr))
+		_ = arr[0].(filesystem.Quota) // quota
+		ad.AssertIsErrorOrNil(arr[1])
+	case ReadlinkOp:
+		ad.AssertEquals(2, len(arr))
+		_ = arr[0].(string) // target
+		ad.AssertIsErrorOrNil(arr[1])
 	}
 }
 
@@ -148,7 +234,7 @@ func castSeekReply(reply interface{}) (newPosition int, err error) {
 	return newPosition, err
 }
 
-// Cast a reply structure to the appropriate return type for Read, panicking if the reply is malformed.
+// Cast a reply structure to the appropriate return type for Read or Pread, panicking if the reply is malformed.
 func castReadReply(reply interface{}) (bytesRead int, data []byte, err error) {
 	arr := reply.([]interface{})
 	ad.AssertEquals(3, len(arr))
@@ -158,7 +244,7 @@ func castReadReply(reply interface{}) (bytesRead int, data []byte, err error) {
 	return bytesRead, data, err
 }
 
-// Cast a reply structure to the appropriate return type for Write, panicking if the reply is malformed.
+// Cast a reply structure to the appropriate return type for Write or Pwrite, panicking if the reply is malformed.
 func castWriteReply(reply interface{}) (bytesWritten int, err error) {
 	arr := reply.([]interface{})
 	ad.AssertEquals(2, len(arr))
@@ -176,6 +262,61 @@ func castDeleteReply(reply interface{}) (success bool, err error) {
 	return success, err
 }
 
+// Cast a reply structure to the appropriate return type for Stat, panicking if the reply is malformed.
+func castStatReply(reply interface{}) (info filesystem.FileInfo, err error) {
+	arr := reply.([]interface{})
+	ad.AssertEquals(2, len(arr))
+	info = arr[0].(filesystem.FileInfo)
+	err = ad.AssertIsErrorOrNil(arr[1])
+	return info, err
+}
+
+// Cast a reply structure to the appropriate return type for ReadDir, panicking if the reply is malformed.
+func castReadDirReply(reply interface{}) (entries []filesystem.FileInfo, err error) {
+	arr := reply.([]interface{})
+	ad.AssertEquals(2, len(arr))
+	entries = arr[0].([]filesystem.FileInfo)
+	err = ad.AssertIsErrorOrNil(arr[1])
+	return entries, err
+}
+
+// Cast a reply structure to the appropriate return type for Truncate, Ftruncate, Fallocate, SetQuota, Symlink or
+// Rename, panicking if the reply is malformed.
+func castSuccessReply(reply interface{}) (success bool, err error) {
+	arr := reply.([]interface{})
+	ad.AssertEquals(2, len(arr))
+	success = arr[0].(bool)
+	err = ad.AssertIsErrorOrNil(arr[1])
+	return success, err
+}
+
+// Cast a reply structure to the appropriate return type for Statfs, panicking if the reply is malformed.
+func castStatfsReply(reply interface{}) (info filesystem.FsInfo, err error) {
+	arr := reply.([]interface{})
+	ad.AssertEquals(2, len(arr))
+	info = arr[0].(filesystem.FsInfo)
+	err = ad.AssertIsErrorOrNil(arr[1])
+	return info, err
+}
+
+// Cast a reply structure to the appropriate return type for GetQuota, panicking if the reply is malformed.
+func castGetQuotaReply(reply interface{}) (quota filesystem.Quota, err error) {
+	arr := reply.([]interface{})
+	ad.AssertEquals(2, len(arr))
+	quota = arr[0].(filesystem.Quota)
+	err = ad.AssertIsErrorOrNil(arr[1])
+	return quota, err
+}
+
+// Cast a reply structure to the appropriate return type for Readlink, panicking if the reply is malformed.
+func castReadlinkReply(reply interface{}) (target string, err error) {
+	arr := reply.([]interface{})
+	ad.AssertEquals(2, len(arr))
+	target = arr[0].(string)
+	err = ad.AssertIsErrorOrNil(arr[1])
+	return target, err
+}
+
 // OperationArgs =======================================================================================================
 
 type OperationArgs struct {
@@ -232,6 +373,23 @@ type OperationReply struct {
 	Status      ReplyStatus
 }
 
+// StaleReadArgs and StaleReadReply ====================================================================================
+
+// A read served from one server's local copy of the filesystem, without going through Raft.
+type StaleReadArgs struct {
+	Path           string
+	UseFD          bool // read from FileDescriptor, like Pread, rather than from Path
+	FileDescriptor int
+	Offset         int // where to start reading; no file offset is used, and a file read by Path needn't be open
+	NumBytes       int
+}
+
+type StaleReadReply struct {
+	ReturnValue  []interface{} // laid out like the ReturnValue of a ReadOp
+	AppliedIndex int           // the reply reflects every command up to and including this log index
+	Status       ReplyStatus
+}
+
 // OperationInProgress =================================================================================================
 
 type OperationInProgress struct {
@@ -239,3 +397,25 @@ type OperationInProgress struct {
 	expectedIndex int                 // the index this should appear at in the log
 	resultChannel chan OperationReply // The channel on which the reply will be sent
 }
+
+// Registration ========================================================================================================
+
+// Call labgob.Register on the types that Go's RPC library has to marshall/unmarshall inside interface{}s, such as the
+// ReturnValues of operations. Both servers and clerks need this, since a clerk may run in a process of its own.
+func registerLabgobTypes() {
+	labgob.Register(OpenOp)
+	labgob.Register(filesystem.NotFound)
+	labgob.Register(filesystem.ReadOnly)
+	labgob.Register(filesystem.Append)
+	labgob.Register(filesystem.FromBeginning)
+	labgob.Register(filesystem.Allocate)
+	labgob.Register(filesystem.FileInfo{})
+	labgob.Register([]filesystem.FileInfo{})
+	labgob.Register(filesystem.FsInfo{})
+	labgob.Register(filesystem.Quota{})
+	labgob.Register(AbstractOperation{})
+	labgob.Register(OperationArgs{})
+	labgob.Register(OperationReply{})
+	labgob.Register(StatusArgs{})
+	labgob.Register(StatusReply{})
+}
@@ -1,10 +1,19 @@
 package fsraft
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	fs "filesystem"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"math"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"raft"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -150,6 +159,325 @@ func TestOneClerkFiveServersPartition(t *testing.T) {
 	return
 }
 
+func TestLearnerServesStaleReads(t *testing.T) {
+	const nservers = 3
+	const learner = 2
+	cfg := make_config_with_learners(t, nservers, []int{learner}, false, -1)
+	defer cfg.cleanup()
+	clerk := cfg.makeClerk(cfg.All())
+	learnerClerk := cfg.makeClerk([]int{learner})
+	dataFile := "/replicated.txt"
+
+	cfg.begin("Test: learner serves stale reads")
+
+	if !cfg.fileServers[learner].Raft().IsLearner() {
+		t.Fatalf("server %d should be a learner", learner)
+	}
+
+	Put(t, clerk, dataFile, "before")
+	waitForStaleRead(t, learnerClerk, dataFile, "before")
+
+	// once cut off, the learner keeps serving what it has, while the voters make progress without it.
+	cfg.partition([]int{0, 1}, []int{learner})
+	Put(t, clerk, dataFile, "after!")
+	_, data, err := learnerClerk.StaleRead(dataFile, 0, 100)
+	if err != nil || string(data) != "before" {
+		t.Fatalf("partitioned learner returned (%q, %v), expected the stale value %q", data, err, "before")
+	}
+
+	cfg.ConnectAll()
+	waitForStaleRead(t, learnerClerk, dataFile, "after!")
+
+	// numBytes is only an upper bound, however big it is.
+	if _, data, err := learnerClerk.StaleRead(dataFile, 1, math.MaxInt); err != nil || string(data) != "fter!" {
+		t.Fatalf("stale read of up to MaxInt bytes returned (%q, %v), expected %q", data, err, "fter!")
+	}
+	if _, _, err := learnerClerk.StaleRead("/doesNotExist", 0, 1); err != fs.NotFound {
+		t.Fatalf("stale read of a missing file returned %v, expected NotFound", err)
+	}
+
+	cfg.end()
+}
+
+// A stale read gives up with TryAgain when no server answers.
+func TestStaleReadUnreachable(t *testing.T) {
+	const nservers = 3
+	cfg := make_config(t, nservers, false, -1)
+	defer cfg.cleanup()
+	clerk := cfg.makeClerk(cfg.All())
+	dataFile := "/unreachable.txt"
+
+	cfg.begin("Test: stale read with no server to answer")
+
+	Put(t, clerk, dataFile, "contents")
+	cfg.DisconnectClient(clerk, cfg.All())
+	if _, _, err := clerk.StaleRead(dataFile, 0, 100); err != fs.TryAgain {
+		t.Fatalf("stale read with every server unreachable returned %v, expected TryAgain", err)
+	}
+	cfg.ConnectClient(clerk, cfg.All())
+	waitForStaleRead(t, clerk, dataFile, "contents")
+
+	cfg.end()
+}
+
+// Retry a stale read until it returns expected, failing the test if that doesn't happen soon.
+func waitForStaleRead(t *testing.T, clerk *Clerk, fileName string, expected string) {
+	for start := time.Now(); time.Since(start) < 5*electionTimeout; time.Sleep(50 * time.Millisecond) {
+		_, data, err := clerk.StaleRead(fileName, 0, len(expected)+1)
+		if err == nil && string(data) == expected {
+			return
+		}
+	}
+	t.Fatalf("stale read of %v never returned %q", fileName, expected)
+}
+
+func TestStalePread(t *testing.T) {
+	const nservers = 3
+	cfg := make_config(t, nservers, false, -1)
+	defer cfg.cleanup()
+	clerk := cfg.makeClerk(cfg.All())
+	readerClerk := cfg.makeClerk(cfg.All())
+	dataFile := "/pread.txt"
+
+	cfg.begin("Test: stale Pread of a file descriptor")
+
+	fd := fs.HelpOpen(t, clerk, dataFile, fs.ReadWrite, fs.Create)
+	fs.HelpWriteString(t, clerk, fd, "0123456789")
+	// another clerk can read the file descriptor without going through Raft, once its server has caught up
+	for start := time.Now(); ; time.Sleep(50 * time.Millisecond) {
+		_, data, err := readerClerk.StalePread(fd, 4, 3)
+		if err == nil && string(data) == "456" {
+			break
+		}
+		if time.Since(start) > 5*electionTimeout {
+			t.Fatalf("stale Pread never returned %q, last returned (%q, %v)", "456", data, err)
+		}
+	}
+	if position := fs.HelpSeek(t, clerk, fd, 0, fs.FromCurrent); position != 10 {
+		t.Fatalf("after a stale Pread, the offset is %d, expected 10", position)
+	}
+	if _, _, err := readerClerk.StalePread(fd, -1, 1); err != fs.IllegalArgument {
+		t.Fatalf("stale Pread at a negative offset returned %v, expected IllegalArgument", err)
+	}
+	fs.HelpClose(t, clerk, fd)
+	if _, _, err := readerClerk.StalePread(fd+1, 0, 1); err != fs.InactiveFD {
+		t.Fatalf("stale Pread of an fd that was never opened returned %v, expected InactiveFD", err)
+	}
+
+	cfg.end()
+}
+
+func TestLimits(t *testing.T) {
+	const nservers = 3
+	serverConfig := DefaultFileServerConfig()
+	serverConfig.MaxRaftState = 1000
+	serverConfig.Limits = fs.Limits{MaxBytes: 100, MaxInodes: 3}
+	cfg := make_config_with_server_config(t, nservers, false, serverConfig)
+	defer cfg.cleanup()
+	clerk := cfg.makeClerk(cfg.All())
+
+	cfg.begin("Test: the servers enforce Limits, even after snapshots")
+
+	fd := fs.HelpOpen(t, clerk, "/file", fs.ReadWrite, fs.Create)
+	// enough small writes to make the servers snapshot, which mustn't lose the Limits
+	for i := 0; i < 30; i++ {
+		fs.HelpWriteString(t, clerk, fd, "abc")
+	}
+	if n, err := clerk.Write(fd, 20, make([]byte, 20)); n != 10 || err != nil {
+		t.Fatalf("a partial Write() returned %d, %v, expected 10 bytes", n, err)
+	}
+	if n, err := clerk.Write(fd, 1, []byte("x")); n != -1 || err != fs.NoMoreSpace {
+		t.Fatalf("Write() on a full filesystem returned %d, %v", n, err)
+	}
+	fs.HelpMkdir(t, clerk, "/dir")
+	if _, err := clerk.Mkdir("/another"); err != fs.NoMoreSpace {
+		t.Fatalf("Mkdir() with no inodes left returned %v", err)
+	}
+	info, err := clerk.Statfs()
+	if err != nil || info.Bytes != 100 || info.Inodes != 3 || info.Limits != serverConfig.Limits {
+		t.Fatalf("Statfs() returned %+v, %v", info, err)
+	}
+	fs.HelpClose(t, clerk, fd)
+
+	cfg.end()
+}
+
+func TestStatusReportsOperations(t *testing.T) {
+	const nservers = 3
+	cfg := make_config(t, nservers, false, -1)
+	defer cfg.cleanup()
+	clerk := cfg.makeClerk(cfg.All())
+	dataFile := "/status.txt"
+
+	cfg.begin("Test: servers report their status over RPC")
+
+	Put(t, clerk, dataFile, "hello")
+	_, leader := cfg.Leader()
+
+	// the clerk's handles are shuffled, so go by who answers
+	allStats := make(map[int]Stats)
+	for server := 0; server < nservers; server++ {
+		stats, ok := clerk.Status(server)
+		if !ok {
+			t.Fatalf("couldn't get the status of a server")
+		}
+		allStats[stats.Me] = stats
+	}
+	if len(allStats) != nservers {
+		t.Fatalf("expected every server to report, but got %+v", allStats)
+	}
+
+	leaderStats := allStats[leader]
+	if !leaderStats.ThinksIsLeader || leaderStats.Raft.Role != raft.Leader || leaderStats.Raft.Leader != leader {
+		t.Fatalf("leader %d reported %+v", leader, leaderStats)
+	}
+	for _, opType := range []OpType{OpenOp, WriteOp, CloseOp} {
+		opStats := leaderStats.Operations[opType]
+		if opStats.Executed != 1 || opStats.Latency.Count() != 1 || opStats.Latency.Mean() <= 0 {
+			t.Fatalf("leader reported %+v for %v, expected one execution and one latency", opStats, opType)
+		}
+	}
+	if leaderStats.Operations[ReadOp].Executed != 0 || leaderStats.PendingOperations != 0 {
+		t.Fatalf("leader reported %+v, expected no reads and nothing pending", leaderStats)
+	}
+	if leaderStats.AppliedIndex != 3 || leaderStats.Raft.CommitIndex != 3 {
+		t.Fatalf("leader reported applied=%d, commit=%d, expected 3", leaderStats.AppliedIndex, leaderStats.Raft.CommitIndex)
+	}
+
+	// followers execute the same commands, but don't reply to the clerk
+	for server, stats := range allStats {
+		if server != leader && stats.Operations[WriteOp].Latency.Count() != 0 {
+			t.Fatalf("follower %d reported %+v, expected no latencies", server, stats.Operations[WriteOp])
+		}
+	}
+
+	cfg.end()
+}
+
+func TestClerkOpenFile(t *testing.T) {
+	const nservers = 3
+	cfg := make_config(t, nservers, false, -1)
+	defer cfg.cleanup()
+	clerk := cfg.makeClerk(cfg.All())
+
+	cfg.begin("Test: files from Clerk.OpenFile work with the io package")
+
+	contents := bytes.Repeat([]byte("all work and no play "), 5000)
+	file, err := clerk.OpenFile("/file.gz", fs.WriteOnly, fs.Create)
+	if err != nil {
+		t.Fatalf("OpenFile() failed: %v", err)
+	}
+	buffered := bufio.NewWriter(file)
+	compressor := gzip.NewWriter(buffered)
+	if _, err := compressor.Write(contents); err != nil {
+		t.Fatalf("compressing into the file failed: %v", err)
+	}
+	if err := compressor.Close(); err != nil {
+		t.Fatalf("compressing into the file failed: %v", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		t.Fatalf("flushing into the file failed: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	// reopening starts at the beginning again.
+	file, err = clerk.OpenFile("/file.gz", fs.ReadOnly, 0)
+	if err != nil {
+		t.Fatalf("OpenFile() failed: %v", err)
+	}
+	decompressor, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		t.Fatalf("reading the gzip header failed: %v", err)
+	}
+	if decompressed, err := io.ReadAll(decompressor); err != nil || !bytes.Equal(decompressed, contents) {
+		t.Fatalf("decompressing the file returned %d bytes and %v, expected %d bytes", len(decompressed), err,
+			len(contents))
+	}
+	file.Close()
+
+	cfg.end()
+}
+
+func TestMetricsExporter(t *testing.T) {
+	const nservers = 3
+	cfg := make_config(t, nservers, false, -1)
+	defer cfg.cleanup()
+	clerk := cfg.makeClerk(cfg.All())
+
+	cfg.begin("Test: servers export Prometheus metrics")
+
+	Put(t, clerk, "/metrics.txt", "hello")
+	_, leader := cfg.Leader()
+	follower := (leader + 1) % nservers
+	time.Sleep(electionTimeout / 2) // so the followers hear about the commit
+
+	leaderMetrics := scrapeMetrics(t, MakeMetricsExporter(cfg.fileServers[leader], cfg.rpcServers[leader]))
+	followerMetrics := scrapeMetrics(t, MakeMetricsExporter(cfg.fileServers[follower], cfg.rpcServers[follower]))
+
+	expectMetric := func(metrics map[string]string, name string, expected string) {
+		if metrics[name] != expected {
+			t.Fatalf("expected %v to be %q, but it was %q", name, expected, metrics[name])
+		}
+	}
+	expectMetric(leaderMetrics, "dfs_raft_is_leader", "1")
+	expectMetric(followerMetrics, "dfs_raft_is_leader", "0")
+	for _, metrics := range []map[string]string{leaderMetrics, followerMetrics} {
+		expectMetric(metrics, "dfs_raft_commit_index", "3")
+		expectMetric(metrics, "dfs_fs_inodes", "2")
+		expectMetric(metrics, "dfs_fs_bytes", "5")
+		expectMetric(metrics, "dfs_fs_open_files", "0")
+		expectMetric(metrics, `dfs_operations_executed_total{op="Write"}`, "1")
+		expectMetric(metrics, `dfs_operations_executed_total{op="Read"}`, "0")
+	}
+	expectMetric(leaderMetrics, fmt.Sprintf(`dfs_raft_follower_commit_lag{peer="%d"}`, follower), "0")
+	expectMetric(leaderMetrics, `dfs_operation_latency_seconds_count{op="Write"}`, "1")
+	expectMetric(leaderMetrics, `dfs_operation_latency_seconds_bucket{op="Write",le="+Inf"}`, "1")
+	expectMetric(followerMetrics, `dfs_operation_latency_seconds_count{op="Write"}`, "0")
+
+	for _, name := range []string{
+		`dfs_rpc_received_total{method="Raft.AppendEntries"}`,
+		`dfs_rpc_bytes_total{method="Raft.AppendEntries",direction="in"}`,
+		`dfs_rpc_bytes_total{method="Raft.AppendEntries",direction="out"}`,
+	} {
+		if value, err := strconv.Atoi(followerMetrics[name]); err != nil || value <= 0 {
+			t.Fatalf("expected %v to be positive on a follower, but it was %q", name, followerMetrics[name])
+		}
+	}
+	if _, exists := leaderMetrics[`dfs_rpc_received_total{method="FileServer.Operation"}`]; !exists {
+		t.Fatalf("the leader didn't report the clerk's RPCs")
+	}
+
+	cfg.end()
+}
+
+// Fetch the metrics from exporter over HTTP and return a map from each sample's name and labels to its value.
+func scrapeMetrics(t *testing.T, exporter *MetricsExporter) map[string]string {
+	server := httptest.NewServer(exporter)
+	defer server.Close()
+
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("couldn't scrape metrics: %v", err)
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("couldn't read metrics: %v", err)
+	}
+
+	metrics := make(map[string]string)
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		separator := strings.LastIndex(line, " ")
+		metrics[line[:separator]] = line[separator+1:]
+	}
+	return metrics
+}
+
 // Generic test apparatus =======================================================================================================
 
 // Generic test apparatus ==============================================================================================
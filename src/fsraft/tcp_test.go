@@ -0,0 +1,54 @@
+package fsraft
+
+import (
+	"fmt"
+	"labrpc"
+	"sync"
+	"tcprpc"
+	"testing"
+)
+
+func TestTCPThreeServers(t *testing.T) {
+	const nservers = 3
+	const nclerks = 3
+	const nappends = 5
+	cluster := StartTestCluster(t, nservers, DefaultFileServerConfig())
+	dataFile := "/tcp.txt"
+
+	fmt.Printf("Test: three servers over TCP ...\n")
+
+	clerk := cluster.MakeClerk()
+	Put(t, clerk, dataFile, "")
+
+	var wg sync.WaitGroup
+	for clerkNum := 0; clerkNum < nclerks; clerkNum++ {
+		wg.Add(1)
+		go func(clerkNum int) {
+			defer wg.Done()
+			appender := cluster.MakeClerk()
+			for writeNum := 0; writeNum < nappends; writeNum++ {
+				Append(t, appender, dataFile, makeValue(clerkNum, writeNum))
+			}
+		}(clerkNum)
+	}
+	wg.Wait()
+	for clerkNum := 0; clerkNum < nclerks; clerkNum++ {
+		checkClerkAppends(t, clerkNum, Get(t, clerk, dataFile), nappends)
+	}
+
+	// the other two carry on without the leader
+	leader := cluster.Leader()
+	cluster.Crash(leader)
+	Put(t, clerk, dataFile, "after the crash")
+	if contents := Get(t, clerk, dataFile); contents != "after the crash" {
+		t.Fatalf("expected %q, got %q", "after the crash", contents)
+	}
+
+	// the old leader comes back at the same address and catches up
+	cluster.Restart(leader)
+	Put(t, clerk, dataFile, "after the restart")
+	restartedClerk := MakeFsClerk([]labrpc.Endpoint{tcprpc.MakeEnd(cluster.Addresses[leader])})
+	waitForStaleRead(t, restartedClerk, dataFile, "after the restart")
+
+	fmt.Printf("  ... Passed\n")
+}
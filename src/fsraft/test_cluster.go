@@ -0,0 +1,113 @@
+package fsraft
+
+import (
+	"labrpc"
+	"raft"
+	"tcprpc"
+	"testing"
+	"time"
+)
+
+// A cluster of FileServers for tests, here and in the packages built on top of fsraft, that talk to each other and
+// to clerks over TCP on 127.0.0.1. It stops when the test that started it is done.
+type TestCluster struct {
+	t           *testing.T
+	config      FileServerConfig
+	Addresses   []string          // Addresses[i] is where server i listens
+	FileServers []*FileServer     // nil while a server is crashed
+	Persisters  []*raft.Persister // what each server has persisted
+	rpcServers  []*labrpc.Server
+	listeners   []*tcprpc.Listener
+}
+
+// Start n FileServers, each with config.
+func StartTestCluster(t *testing.T, n int, config FileServerConfig) *TestCluster {
+	cluster := &TestCluster{t, config, make([]string, n), make([]*FileServer, n), make([]*raft.Persister, n),
+		make([]*labrpc.Server, n), make([]*tcprpc.Listener, n)}
+	// listen first, since every server needs everyone's address
+	for i := 0; i < n; i++ {
+		cluster.listen(i, "127.0.0.1:0")
+		cluster.Addresses[i] = cluster.listeners[i].Addr()
+	}
+	for i := 0; i < n; i++ {
+		cluster.Persisters[i] = raft.MakePersister()
+		cluster.start(i)
+	}
+	t.Cleanup(cluster.stop)
+	return cluster
+}
+
+func (cluster *TestCluster) listen(i int, address string) {
+	cluster.rpcServers[i] = labrpc.MakeServer()
+	listener, err := tcprpc.Listen(address, cluster.rpcServers[i])
+	if err != nil {
+		cluster.t.Fatalf("couldn't listen on %v: %v", address, err)
+	}
+	cluster.listeners[i] = listener
+}
+
+// Start server i from its persister, serving RPCs on its listener.
+func (cluster *TestCluster) start(i int) {
+	cluster.FileServers[i] = StartFileServer(cluster.MakeEnds(), i, cluster.Persisters[i], cluster.config)
+	cluster.rpcServers[i].AddService(labrpc.MakeService(cluster.FileServers[i]))
+	cluster.rpcServers[i].AddService(labrpc.MakeService(cluster.FileServers[i].Raft()))
+}
+
+// The ClusterConfig a dfs-server in this cluster would read.
+func (cluster *TestCluster) ClusterConfig() ClusterConfig {
+	learners := make([]int, len(cluster.config.Raft.Learners))
+	copy(learners, cluster.config.Raft.Learners)
+	return ClusterConfig{Addresses: cluster.Addresses, Learners: learners}
+}
+
+// An end to every server.
+func (cluster *TestCluster) MakeEnds() []labrpc.Endpoint {
+	ends := make([]labrpc.Endpoint, len(cluster.Addresses))
+	for i, address := range cluster.Addresses {
+		ends[i] = tcprpc.MakeEnd(address)
+	}
+	return ends
+}
+
+func (cluster *TestCluster) MakeClerk() *Clerk {
+	return MakeFsClerk(cluster.MakeEnds())
+}
+
+// Crash server i: it stops answering, and keeps only what it persisted.
+func (cluster *TestCluster) Crash(i int) {
+	cluster.listeners[i].Close()
+	cluster.FileServers[i].Kill()
+	cluster.FileServers[i] = nil
+	cluster.Persisters[i] = cluster.Persisters[i].Copy()
+}
+
+// Restart a crashed server at the same address.
+func (cluster *TestCluster) Restart(i int) {
+	cluster.listen(i, cluster.Addresses[i])
+	cluster.start(i)
+}
+
+// Wait for a server to become leader, and return it.
+func (cluster *TestCluster) Leader() int {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(50 * time.Millisecond) {
+		for i, fileServer := range cluster.FileServers {
+			if fileServer == nil {
+				continue
+			}
+			if _, isLeader := fileServer.Raft().GetState(); isLeader {
+				return i
+			}
+		}
+	}
+	cluster.t.Fatalf("no leader was elected")
+	return -1
+}
+
+func (cluster *TestCluster) stop() {
+	for i, fileServer := range cluster.FileServers {
+		if fileServer != nil {
+			cluster.listeners[i].Close()
+			fileServer.Kill()
+		}
+	}
+}
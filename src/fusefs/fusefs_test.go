@@ -0,0 +1,740 @@
+package fusefs
+
+import (
+	"ad"
+	"bytes"
+	"filesystem"
+	"fmt"
+	"fsraft"
+	"io"
+	"memoryFS"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	if _, isSet := os.LookupEnv("DFS_DEFAULT_DEBUG_LEVEL"); !isSet {
+		ad.SetDebugLevel(ad.WARN)
+	}
+	os.Exit(m.Run())
+}
+
+// Stands in for the kernel: a Device whose requests come from the test, and whose replies and notifications go back
+// to it. Its methods fail the test if a reply is missing or malformed, and return the errno if there is one.
+type testKernel struct {
+	t             *testing.T
+	requests      chan []byte
+	replies       chan []byte
+	notifications chan []byte
+	unique        uint64
+	server        *Server
+}
+
+const testTimeout = 10 * time.Second
+
+// Serve fs to a new kernel, and send INIT.
+func mount(t *testing.T, fs filesystem.FileSystem) *testKernel {
+	k := &testKernel{t: t, requests: make(chan []byte), replies: make(chan []byte, 1),
+		notifications: make(chan []byte, 100)}
+	k.server = Serve(k, fs, Config{EntryTimeout: time.Second, AttrTimeout: time.Second, UID: 1000, GID: 100})
+	e := &encoder{}
+	e.u32(kernelVersion)
+	e.u32(minorVersion)
+	e.u32(128 * 1024) // max_readahead
+	e.u32(initAtomicOTrunc | initBigWrites | 1<<0)
+	d, err := k.request(opInit, 0, e)
+	if err != 0 {
+		t.Fatalf("INIT failed: %v", err)
+	}
+	if major, minor := d.u32(), d.u32(); major != kernelVersion || minor != minorVersion {
+		t.Fatalf("INIT returned version %d.%d", major, minor)
+	}
+	return k
+}
+
+func (k *testKernel) Read(request []byte) (int, error) {
+	r, ok := <-k.requests
+	if !ok {
+		return 0, io.EOF
+	}
+	return copy(request, r), nil
+}
+
+func (k *testKernel) Write(reply []byte) (int, error) {
+	reply = append([]byte{}, reply...)
+	d := &decoder{buf: reply}
+	if length := d.u32(); int(length) != len(reply) {
+		k.t.Errorf("reply of %d bytes says it has %d", len(reply), length)
+	}
+	d.u32()
+	if d.u64() == 0 {
+		k.notifications <- reply
+	} else {
+		k.replies <- reply
+	}
+	return len(reply), nil
+}
+
+// Unmount, and wait for the server to stop.
+func (k *testKernel) unmount() {
+	close(k.requests)
+	if err := k.server.Wait(); err != nil {
+		k.t.Fatalf("the server stopped with %v", err)
+	}
+}
+
+// Send a request about the node id, and return the reply's fields or its errno.
+func (k *testKernel) request(op opcode, id uint64, e *encoder) (*decoder, errno) {
+	k.unique++
+	request := &encoder{}
+	request.u32(uint32(inHeaderSize + len(e.buf)))
+	request.u32(uint32(op))
+	request.u64(k.unique)
+	request.u64(id)
+	request.u32(1000) // uid
+	request.u32(100)  // gid
+	request.u32(1234) // pid
+	request.u32(0)    // padding
+	request.buf = append(request.buf, e.buf...)
+	k.requests <- request.buf
+	if op == opForget || op == opBatchForget || op == opInterrupt {
+		return nil, 0
+	}
+
+	select {
+	case reply := <-k.replies:
+		d := &decoder{buf: reply}
+		d.u32() // len
+		err := errno(-int32(d.u32()))
+		if unique := d.u64(); unique != k.unique {
+			k.t.Fatalf("reply to request %d has unique %d", k.unique, unique)
+		}
+		if err != 0 && len(d.buf) != 0 {
+			k.t.Fatalf("error reply to opcode %d has %d bytes of fields", op, len(d.buf))
+		}
+		return d, err
+	case <-time.After(testTimeout):
+		k.t.Fatalf("no reply to opcode %d", op)
+		return nil, 0
+	}
+}
+
+// Like request, but failing the test on an error.
+func (k *testKernel) must(op opcode, id uint64, e *encoder) *decoder {
+	d, err := k.request(op, id, e)
+	if err != 0 {
+		k.t.Fatalf("opcode %d on node %d failed: %v", op, id, err)
+	}
+	return d
+}
+
+// Fail the test unless the request fails with expected.
+func (k *testKernel) expectErrno(expected errno, op opcode, id uint64, e *encoder) {
+	if _, err := k.request(op, id, e); err != expected {
+		k.t.Fatalf("opcode %d on node %d returned errno %d, expected %d", op, id, err, expected)
+	}
+}
+
+func names(names ...string) *encoder {
+	e := &encoder{}
+	for _, name := range names {
+		e.string(name)
+	}
+	return e
+}
+
+// The node id and attributes in a struct fuse_entry_out.
+func decodeEntry(d *decoder) (uint64, attr) {
+	id := d.u64()
+	d.skip(8 + 8 + 8 + 4 + 4) // generation and timeouts
+	return id, decodeAttr(d)
+}
+
+func decodeAttr(d *decoder) attr {
+	a := attr{ino: d.u64(), size: d.u64()}
+	d.skip(8 + 3*8 + 3*4) // blocks and times
+	a.mode, a.nlink, a.uid, a.gid = d.u32(), d.u32(), d.u32(), d.u32()
+	d.skip(3 * 4) // rdev, blksize and flags
+	return a
+}
+
+func (k *testKernel) lookup(parent uint64, name string) (uint64, attr) {
+	return decodeEntry(k.must(opLookup, parent, names(name)))
+}
+
+func (k *testKernel) getattr(id uint64) attr {
+	e := &encoder{}
+	e.u32(0)
+	e.u32(0)
+	e.u64(0)
+	d := k.must(opGetattr, id, e)
+	d.skip(8 + 4 + 4)
+	return decodeAttr(d)
+}
+
+// Create and open a file, and return its node id and handle.
+func (k *testKernel) create(parent uint64, name string, flags uint32) (uint64, uint64) {
+	e := &encoder{}
+	e.u32(flags)
+	e.u32(0644)
+	e.u32(022)
+	e.u32(0)
+	e.string(name)
+	d := k.must(opCreate, parent, e)
+	id, _ := decodeEntry(d)
+	return id, d.u64()
+}
+
+func openRequest(flags uint32) *encoder {
+	e := &encoder{}
+	e.u32(flags)
+	e.u32(0)
+	return e
+}
+
+func (k *testKernel) open(id uint64, flags uint32) uint64 {
+	return k.must(opOpen, id, openRequest(flags)).u64()
+}
+
+func ioRequest(fh uint64, offset int, size int) *encoder {
+	e := &encoder{}
+	e.u64(fh)
+	e.u64(uint64(offset))
+	e.u32(uint32(size))
+	e.u32(0) // read_flags or write_flags
+	e.u64(0) // lock_owner
+	e.u32(0) // flags
+	e.u32(0) // padding
+	return e
+}
+
+func (k *testKernel) read(fh uint64, offset int, size int) string {
+	return string(k.must(opRead, 0, ioRequest(fh, offset, size)).buf)
+}
+
+func (k *testKernel) write(fh uint64, offset int, data string) {
+	e := ioRequest(fh, offset, len(data))
+	e.buf = append(e.buf, data...)
+	if written := k.must(opWrite, 0, e).u32(); int(written) != len(data) {
+		k.t.Fatalf("WRITE wrote %d bytes, expected %d", written, len(data))
+	}
+}
+
+func (k *testKernel) release(fh uint64) {
+	e := &encoder{}
+	e.u64(fh)
+	e.u32(0)
+	e.u32(0)
+	e.u64(0)
+	k.must(opRelease, 0, e)
+}
+
+func (k *testKernel) setSize(id uint64, size int) attr {
+	e := &encoder{}
+	e.u32(setattrSize)
+	e.u32(0)
+	e.u64(0)
+	e.u64(uint64(size))
+	e.buf = append(e.buf, make([]byte, 8+3*8+3*4+5*4)...)
+	d := k.must(opSetattr, id, e)
+	d.skip(8 + 4 + 4)
+	return decodeAttr(d)
+}
+
+func (k *testKernel) mkdir(parent uint64, name string) uint64 {
+	e := &encoder{}
+	e.u32(0755)
+	e.u32(022)
+	e.string(name)
+	id, _ := decodeEntry(k.must(opMkdir, parent, e))
+	return id
+}
+
+// List a directory, asking for size bytes at a time, and return its entries' names.
+func (k *testKernel) readdir(id uint64, size int) []string {
+	fh := k.must(opOpendir, id, openRequest(0)).u64()
+	entries := make([]string, 0)
+	for offset := 0; ; {
+		d := k.must(opReaddir, id, ioRequest(fh, offset, size))
+		if len(d.buf) == 0 {
+			break
+		}
+		for len(d.buf) > 0 {
+			d.u64() // ino
+			offset = int(d.u64())
+			nameLength := int(d.u32())
+			d.u32() // type
+			entries = append(entries, string(d.bytes(nameLength)))
+			d.skip(direntSize(entries[len(entries)-1]) - 24 - nameLength)
+		}
+		if d.finish() != nil {
+			k.t.Fatalf("malformed READDIR reply")
+		}
+	}
+	e := &encoder{}
+	e.u64(fh)
+	e.u32(0)
+	e.u32(0)
+	e.u64(0)
+	k.must(opReleasedir, id, e)
+	return entries
+}
+
+func renameRequest(newParent uint64, name string, newName string, flags uint32) *encoder {
+	e := &encoder{}
+	e.u64(newParent)
+	e.u32(flags)
+	e.u32(0)
+	e.string(name)
+	e.string(newName)
+	return e
+}
+
+// The next notification, which has to come within a little while.
+func (k *testKernel) notification() (notifyCode, *decoder) {
+	select {
+	case notification := <-k.notifications:
+		d := &decoder{buf: notification}
+		d.u32()
+		code := notifyCode(d.u32())
+		d.u64()
+		return code, d
+	case <-time.After(testTimeout):
+		k.t.Fatalf("no notification")
+		return 0, nil
+	}
+}
+
+func (k *testKernel) expectNoNotifications() {
+	select {
+	case <-k.notifications:
+		k.t.Fatalf("unexpected notification")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func newMemoryFS() filesystem.FileSystem {
+	mfs := memoryFS.CreateEmptyMemoryFS()
+	return &mfs
+}
+
+func TestInit(t *testing.T) {
+	k := &testKernel{t: t, requests: make(chan []byte), replies: make(chan []byte, 1),
+		notifications: make(chan []byte, 100)}
+	k.server = Serve(k, newMemoryFS(), DefaultConfig())
+	defer k.unmount()
+
+	k.expectErrno(eio, opGetattr, rootID, &encoder{buf: make([]byte, 16)})
+	init := func(major uint32, minor uint32) (*decoder, errno) {
+		e := &encoder{}
+		e.u32(major)
+		e.u32(minor)
+		e.u32(0)
+		e.u32(0xffffffff)
+		return k.request(opInit, 0, e)
+	}
+	if _, err := init(8, 0); err != einval {
+		t.Fatalf("INIT for version 8.0 returned %d, expected EINVAL", err)
+	}
+	if _, err := init(7, 8); err != einval {
+		t.Fatalf("INIT for version 7.8 returned %d, expected EINVAL", err)
+	}
+	// an older kernel gets a shorter reply, with only the flags both sides know about
+	d, err := init(7, 22)
+	if err != 0 || len(d.buf) != 24 {
+		t.Fatalf("INIT for version 7.22 returned (%d bytes, %d)", len(d.buf), err)
+	}
+	if major, minor, _, flags := d.u32(), d.u32(), d.u32(), d.u32(); major != 7 || minor != 22 ||
+		flags != initAtomicOTrunc|initBigWrites {
+		t.Fatalf("INIT for version 7.22 returned version %d.%d and flags %#x", major, minor, flags)
+	}
+	if a := k.getattr(rootID); a.ino != rootID || a.mode != modeDir|dirPerm {
+		t.Fatalf("GETATTR of the root returned %+v", a)
+	}
+	k.expectErrno(enosys, opcode(5), rootID, &encoder{}) // READLINK
+}
+
+func TestFiles(t *testing.T) {
+	fs := newMemoryFS()
+	k := mount(t, fs)
+	defer k.unmount()
+
+	id, fh := k.create(rootID, "file", openReadWrite)
+	k.write(fh, 0, "hello")
+	k.write(fh, 10, "world")
+	if data := k.read(fh, 0, 100); data != "hello\x00\x00\x00\x00\x00world" {
+		t.Fatalf("READ returned %q", data)
+	}
+	if data := k.read(fh, 3, 4); data != "lo\x00\x00" {
+		t.Fatalf("READ in the middle returned %q", data)
+	}
+	if a := k.getattr(id); a.ino != id || a.size != 15 || a.mode != modeFile|filePerm || a.uid != 1000 ||
+		a.gid != 100 {
+		t.Fatalf("GETATTR returned %+v", a)
+	}
+	if lookedUp, a := k.lookup(rootID, "file"); lookedUp != id || a.size != 15 {
+		t.Fatalf("LOOKUP returned (%d, %+v), expected node %d", lookedUp, a, id)
+	}
+
+	// a second open shares the file, and appending goes to the end whatever the offset
+	appender := k.open(id, openWriteOnly|openAppend)
+	k.write(appender, 0, "!")
+	if data := k.read(fh, 0, 100); data != "hello\x00\x00\x00\x00\x00world!" {
+		t.Fatalf("READ after appending returned %q", data)
+	}
+	k.release(appender)
+	k.release(fh)
+	fd, err := fs.Open("/file", filesystem.ReadOnly, 0)
+	if err != nil {
+		t.Fatalf("couldn't open the file after every handle was released: %v", err)
+	}
+	fs.Close(fd)
+
+	fh = k.open(id, openWriteOnly|openTruncate)
+	if a := k.getattr(id); a.size != 0 {
+		t.Fatalf("GETATTR after opening with O_TRUNC returned size %d", a.size)
+	}
+	k.release(fh)
+
+	// another client holding the file open keeps it from being opened through the mount
+	fd, _ = fs.Open("/file", filesystem.ReadOnly, 0)
+	k.expectErrno(ebusy, opOpen, id, openRequest(openReadOnly))
+	fs.Close(fd)
+
+	e := &encoder{}
+	e.u32(openReadWrite | openExclusive)
+	e.buf = append(e.buf, make([]byte, 12)...)
+	e.string("file")
+	k.expectErrno(eexist, opCreate, rootID, e)
+	k.expectErrno(enoent, opLookup, rootID, names("missing"))
+	k.expectErrno(einval, opLookup, rootID, names(".."))
+	k.expectErrno(enametoolong, opLookup, rootID, names(string(bytes.Repeat([]byte{'x'}, 256))))
+	k.expectErrno(ebadf, opRead, 0, ioRequest(1000, 0, 10))
+	k.expectErrno(eisdir, opOpen, rootID, openRequest(openReadOnly))
+
+	k.must(opUnlink, rootID, names("file"))
+	k.expectErrno(enoent, opLookup, rootID, names("file"))
+	k.expectErrno(enoent, opUnlink, rootID, names("file"))
+}
+
+func TestTruncate(t *testing.T) {
+	k := mount(t, newMemoryFS())
+	defer k.unmount()
+
+	id, fh := k.create(rootID, "file", openReadWrite)
+	k.write(fh, 0, "0123456789")
+	if a := k.setSize(id, 4); a.size != 4 {
+		t.Fatalf("SETATTR to 4 bytes returned size %d", a.size)
+	}
+	if data := k.read(fh, 0, 100); data != "0123" {
+		t.Fatalf("READ after cutting the file short returned %q", data)
+	}
+	k.setSize(id, 6)
+	if data := k.read(fh, 0, 100); data != "0123\x00\x00" {
+		t.Fatalf("READ after filling the file out returned %q", data)
+	}
+	k.release(fh)
+
+	// with the file closed
+	if a := k.setSize(id, 2); a.size != 2 {
+		t.Fatalf("SETATTR of a closed file returned size %d", a.size)
+	}
+	k.setSize(id, 0)
+	fh = k.open(id, openReadOnly)
+	if data := k.read(fh, 0, 100); data != "" {
+		t.Fatalf("READ after emptying the file returned %q", data)
+	}
+	k.release(fh)
+
+	dir := k.mkdir(rootID, "dir")
+	e := &encoder{buf: make([]byte, 88)}
+	e.buf[0] = setattrSize
+	k.expectErrno(eisdir, opSetattr, dir, e)
+	k.expectNoNotifications()
+}
+
+func TestDirectories(t *testing.T) {
+	k := mount(t, newMemoryFS())
+	defer k.unmount()
+
+	dir := k.mkdir(rootID, "dir")
+	if a := k.getattr(dir); a.mode != modeDir|dirPerm || a.nlink != 2 {
+		t.Fatalf("GETATTR of a directory returned %+v", a)
+	}
+	expected := []string{".", ".."}
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("file-%02d", i)
+		_, fh := k.create(dir, name, openWriteOnly)
+		k.release(fh)
+		expected = append(expected, name)
+	}
+	k.mkdir(dir, "sub")
+	expected = append(expected, "sub")
+	// small enough to take several READDIRs
+	if entries := k.readdir(dir, 100); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("READDIR returned %v, expected %v", entries, expected)
+	}
+	if entries := k.readdir(rootID, 4096); !reflect.DeepEqual(entries, []string{".", "..", "dir"}) {
+		t.Fatalf("READDIR of the root returned %v", entries)
+	}
+
+	k.expectErrno(eexist, opMkdir, rootID, &encoder{buf: append(make([]byte, 8), "dir\x00"...)})
+	k.expectErrno(enotempty, opRmdir, rootID, names("dir"))
+	k.expectErrno(eisdir, opUnlink, dir, names("sub"))
+	k.expectErrno(enotdir, opRmdir, dir, names("file-00"))
+	k.must(opRmdir, dir, names("sub"))
+	fileID, _ := k.lookup(dir, "file-00")
+	k.expectErrno(enotdir, opOpendir, fileID, openRequest(0))
+}
+
+func TestRename(t *testing.T) {
+	fs := newMemoryFS()
+	k := mount(t, fs)
+	defer k.unmount()
+
+	dir := k.mkdir(rootID, "dir")
+	id, fh := k.create(dir, "a", openReadWrite)
+	k.write(fh, 0, "contents of a")
+
+	// a file that is open stays open under its new name
+	k.must(opRename2, dir, renameRequest(rootID, "a", "b", 0))
+	if renamed, _ := k.lookup(rootID, "b"); renamed != id {
+		t.Fatalf("LOOKUP after RENAME returned node %d, expected %d", renamed, id)
+	}
+	k.expectErrno(enoent, opLookup, dir, names("a"))
+	k.write(fh, 13, "!")
+	if data := k.read(fh, 0, 100); data != "contents of a!" {
+		t.Fatalf("READ after RENAME returned %q", data)
+	}
+	k.release(fh)
+
+	// replacing a file
+	_, fh = k.create(rootID, "c", openWriteOnly)
+	k.write(fh, 0, "contents of c")
+	k.release(fh)
+	k.expectErrno(eexist, opRename2, rootID, renameRequest(rootID, "b", "c", renameNoReplace))
+	// RENAME, unlike RENAME2, has no flags
+	e := &encoder{}
+	e.u64(rootID)
+	e.string("b")
+	e.string("c")
+	k.must(opRename, rootID, e)
+	fh = k.open(id, openReadOnly)
+	if data := k.read(fh, 0, 100); data != "contents of a!" {
+		t.Fatalf("READ after replacing a file returned %q", data)
+	}
+	k.release(fh)
+
+	// a directory and what's in it
+	_, fh = k.create(dir, "inner", openWriteOnly)
+	k.write(fh, 0, "inner")
+	k.release(fh)
+	k.must(opRename2, rootID, renameRequest(rootID, "dir", "moved", 0))
+	if renamed, _ := k.lookup(rootID, "moved"); renamed != dir {
+		t.Fatalf("LOOKUP of a renamed directory returned node %d, expected %d", renamed, dir)
+	}
+	if entries := k.readdir(dir, 4096); !reflect.DeepEqual(entries, []string{".", "..", "inner"}) {
+		t.Fatalf("READDIR of a renamed directory returned %v", entries)
+	}
+	if _, err := fs.Stat("/dir"); err != filesystem.NotFound {
+		t.Fatalf("the old directory is still there: %v", err)
+	}
+
+	k.expectErrno(einval, opRename2, rootID, renameRequest(dir, "moved", "inside", 0))
+	k.expectErrno(enotdir, opRename2, rootID, renameRequest(rootID, "moved", "c", 0))
+	k.expectErrno(eisdir, opRename2, rootID, renameRequest(rootID, "c", "moved", 0))
+	k.expectErrno(einval, opRename2, rootID, renameRequest(rootID, "c", "moved", renameExchange))
+	k.expectErrno(enoent, opRename2, rootID, renameRequest(rootID, "missing", "x", 0))
+}
+
+// Renaming a file from one quota root to another takes its bytes out of the first quota and puts them in the second.
+func TestRenameAcrossQuotas(t *testing.T) {
+	fs := newMemoryFS()
+	k := mount(t, fs)
+	defer k.unmount()
+
+	first := k.mkdir(rootID, "first")
+	second := k.mkdir(rootID, "second")
+	fs.SetQuota("/first", 100, 0)
+	fs.SetQuota("/second", 10, 0)
+	_, fh := k.create(first, "big", openWriteOnly)
+	k.write(fh, 0, "more than ten bytes")
+	k.release(fh)
+	_, fh = k.create(first, "small", openWriteOnly)
+	k.write(fh, 0, "five!")
+	k.release(fh)
+
+	k.expectErrno(enospc, opRename2, first, renameRequest(second, "big", "big", 0))
+	k.must(opRename2, first, renameRequest(second, "small", "small", 0))
+	if quota, err := fs.GetQuota("/first"); err != nil || quota.Bytes != 19 || quota.Inodes != 1 {
+		t.Fatalf("after renaming out of /first, GetQuota() returned %+v, %v", quota, err)
+	}
+	if quota, err := fs.GetQuota("/second"); err != nil || quota.Bytes != 5 || quota.Inodes != 1 {
+		t.Fatalf("after renaming into /second, GetQuota() returned %+v, %v", quota, err)
+	}
+
+	// moving within a quota root doesn't need room for a second copy
+	sub := k.mkdir(first, "sub")
+	fs.SetQuota("/first", 21, 0)
+	k.must(opRename2, first, renameRequest(sub, "big", "big", 0))
+	if quota, err := fs.GetQuota("/first"); err != nil || quota.Bytes != 19 || quota.Inodes != 2 {
+		t.Fatalf("after renaming within /first, GetQuota() returned %+v, %v", quota, err)
+	}
+}
+
+// Symbolic links are renamed as links, whatever they point to, and so are links inside a renamed directory.
+func TestRenameSymlinks(t *testing.T) {
+	fs := newMemoryFS()
+	k := mount(t, fs)
+	defer k.unmount()
+
+	dir := k.mkdir(rootID, "dir")
+	_, fh := k.create(dir, "file", openWriteOnly)
+	k.write(fh, 0, "contents")
+	k.release(fh)
+	fs.Symlink("/dir", "/dirlink")
+	fs.Symlink("missing", "/dangling")
+	fs.Symlink("file", "/dir/relative")
+
+	k.must(opRename2, rootID, renameRequest(rootID, "dirlink", "moved", 0))
+	if target, err := fs.Readlink("/moved"); err != nil || target != "/dir" {
+		t.Fatalf("after renaming a link to a directory, Readlink() returned (%q, %v)", target, err)
+	}
+	if entries, err := fs.ReadDir("/dir"); err != nil || len(entries) != 2 {
+		t.Fatalf("renaming a link to a directory changed the directory: ReadDir() returned (%+v, %v)", entries, err)
+	}
+	k.must(opRename2, rootID, renameRequest(rootID, "dangling", "stillDangling", 0))
+	if target, err := fs.Readlink("/stillDangling"); err != nil || target != "missing" {
+		t.Fatalf("after renaming a dangling link, Readlink() returned (%q, %v)", target, err)
+	}
+	k.must(opRename2, rootID, renameRequest(rootID, "dir", "dir2", 0))
+	if target, err := fs.Readlink("/dir2/relative"); err != nil || target != "file" {
+		t.Fatalf("after renaming a directory, Readlink() of the link in it returned (%q, %v)", target, err)
+	}
+	for _, oldPath := range []string{"/dirlink", "/dangling", "/dir"} {
+		if _, err := fs.Lstat(oldPath); err != filesystem.NotFound {
+			t.Fatalf("%v is still there after renaming it: %v", oldPath, err)
+		}
+	}
+
+	// replacing a dangling link replaces the link, rather than creating what it points to
+	fs.Symlink("/missing", "/dangling")
+	_, fh = k.create(rootID, "replacement", openWriteOnly)
+	k.release(fh)
+	k.must(opRename2, rootID, renameRequest(rootID, "replacement", "dangling", 0))
+	if info, err := fs.Lstat("/dangling"); err != nil || info.IsSymlink {
+		t.Fatalf("after replacing a dangling link, Lstat() returned (%+v, %v)", info, err)
+	}
+	if _, err := fs.Lstat("/missing"); err != filesystem.NotFound {
+		t.Fatalf("replacing a dangling link created what it pointed to: %v", err)
+	}
+}
+
+// Changes that another client makes are noticed, and the kernel is told to drop what it has cached.
+func TestInvalidation(t *testing.T) {
+	fs := newMemoryFS()
+	k := mount(t, fs)
+	defer k.unmount()
+	other := mount(t, fs)
+	defer other.unmount()
+
+	id, fh := k.create(rootID, "file", openWriteOnly)
+	k.write(fh, 0, "hello")
+	k.release(fh)
+	k.getattr(id)
+	k.expectNoNotifications()
+
+	otherID, _ := other.lookup(rootID, "file")
+	otherFh := other.open(otherID, openWriteOnly|openAppend)
+	other.write(otherFh, 0, " world")
+	other.release(otherFh)
+	other.expectNoNotifications()
+
+	if a := k.getattr(id); a.size != 11 {
+		t.Fatalf("GETATTR after another client wrote returned size %d", a.size)
+	}
+	if code, d := k.notification(); code != notifyInvalInode || d.u64() != id {
+		t.Fatalf("got notification %d, expected INVAL_INODE of node %d", code, id)
+	}
+	k.getattr(id)
+	k.expectNoNotifications()
+
+	other.must(opUnlink, rootID, names("file"))
+	k.expectErrno(enoent, opGetattr, id, &encoder{buf: make([]byte, 16)})
+	code, d := k.notification()
+	if parent, length, _, name := d.u64(), d.u32(), d.u32(), d.string(); code != notifyInvalEntry ||
+		parent != rootID || length != 4 || name != "file" {
+		t.Fatalf("got notification %d for (%d, %q), expected INVAL_ENTRY for (%d, %q)", code, parent, name, rootID,
+			"file")
+	}
+}
+
+func TestForget(t *testing.T) {
+	k := mount(t, newMemoryFS())
+	defer k.unmount()
+
+	dir := k.mkdir(rootID, "dir")
+	if again, _ := k.lookup(rootID, "dir"); again != dir {
+		t.Fatalf("LOOKUP returned node %d, expected %d", again, dir)
+	}
+	e := &encoder{}
+	e.u64(1)
+	k.request(opForget, dir, e)
+	k.getattr(dir)
+	e = &encoder{}
+	e.u32(1) // count
+	e.u32(0)
+	e.u64(dir)
+	e.u64(1)
+	k.request(opBatchForget, 0, e)
+	k.expectErrno(enoent, opGetattr, dir, &encoder{buf: make([]byte, 16)})
+	if again, _ := k.lookup(rootID, "dir"); again == dir {
+		t.Fatalf("LOOKUP after FORGET returned the forgotten node %d", dir)
+	}
+	// the root is never forgotten
+	e = &encoder{}
+	e.u64(100)
+	k.request(opForget, rootID, e)
+	k.getattr(rootID)
+}
+
+// Files the kernel leaves open are closed when the filesystem is unmounted.
+func TestUnmount(t *testing.T) {
+	fs := newMemoryFS()
+	k := mount(t, fs)
+	_, fh := k.create(rootID, "file", openWriteOnly)
+	k.write(fh, 0, "data")
+	k.must(opDestroy, 0, &encoder{})
+	if err := k.server.Wait(); err != nil {
+		t.Fatalf("the server stopped with %v", err)
+	}
+	fd, err := fs.Open("/file", filesystem.ReadOnly, 0)
+	if err != nil {
+		t.Fatalf("couldn't open a file left open at unmount: %v", err)
+	}
+	fs.Close(fd)
+}
+
+// Three FileServers, mounted through a clerk.
+func TestClerk(t *testing.T) {
+	cluster := fsraft.StartTestCluster(t, 3, fsraft.DefaultFileServerConfig())
+	k := mount(t, cluster.MakeClerk())
+	defer k.unmount()
+	dir := k.mkdir(rootID, "dir")
+	id, fh := k.create(dir, "file", openReadWrite)
+	data := string(bytes.Repeat([]byte("0123456789"), maxWrite/10))
+	k.write(fh, 0, data)
+	if read := k.read(fh, 0, maxWrite); read != data {
+		t.Fatalf("READ through the clerk returned %d bytes, expected %d", len(read), len(data))
+	}
+	k.release(fh)
+	if a := k.getattr(id); a.size != uint64(len(data)) {
+		t.Fatalf("GETATTR through the clerk returned size %d, expected %d", a.size, len(data))
+	}
+	k.must(opRename2, dir, renameRequest(rootID, "file", "renamed", 0))
+	if entries := k.readdir(rootID, 4096); !reflect.DeepEqual(entries, []string{".", "..", "dir", "renamed"}) {
+		t.Fatalf("READDIR through the clerk returned %v", entries)
+	}
+}
@@ -0,0 +1,70 @@
+//go:build linux
+
+package fusefs
+
+import (
+	"filesystem"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Mount fs at mountpoint and serve it until it is unmounted, e.g. with Unmount or umount(8). This makes the mount
+// system call itself rather than going through fusermount, so it needs to run as root (or with CAP_SYS_ADMIN).
+// The kernel checks permissions against the modes the server reports, and lets every user in.
+func Mount(mountpoint string, fs filesystem.FileSystem, config Config) (*Server, error) {
+	file, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	options := fmt.Sprintf("fd=%d,rootmode=%o,user_id=%d,group_id=%d,default_permissions,allow_other", file.Fd(),
+		modeDir, config.UID, config.GID)
+	if err := syscall.Mount("dfs", mountpoint, "fuse.dfs", syscall.MS_NOSUID|syscall.MS_NODEV, options); err != nil {
+		file.Close()
+		return nil, &os.PathError{Op: "mount", Path: mountpoint, Err: err}
+	}
+	s := Serve(&deviceFile{file}, fs, config)
+	go func() {
+		<-s.done
+		file.Close()
+	}()
+	return s, nil
+}
+
+func Unmount(mountpoint string) error {
+	if err := syscall.Unmount(mountpoint, 0); err != nil {
+		return &os.PathError{Op: "unmount", Path: mountpoint, Err: err}
+	}
+	return nil
+}
+
+// /dev/fuse, as a Device.
+type deviceFile struct {
+	file *os.File
+}
+
+func (d *deviceFile) Read(request []byte) (int, error) {
+	for {
+		n, err := syscall.Read(int(d.file.Fd()), request)
+		switch err {
+		case nil:
+			return n, nil
+		case syscall.ENOENT, syscall.EINTR, syscall.EAGAIN:
+			// the request was interrupted before it was read, or there was nothing to read yet
+			continue
+		case syscall.ENODEV:
+			return 0, io.EOF // unmounted
+		default:
+			return 0, &os.PathError{Op: "read", Path: d.file.Name(), Err: err}
+		}
+	}
+}
+
+func (d *deviceFile) Write(reply []byte) (int, error) {
+	n, err := syscall.Write(int(d.file.Fd()), reply)
+	if err != nil {
+		return 0, &os.PathError{Op: "write", Path: d.file.Name(), Err: err}
+	}
+	return n, nil
+}
@@ -0,0 +1,394 @@
+package fusefs
+
+// The parts of the FUSE kernel protocol that the server speaks, as described in the Linux kernel's
+// include/uapi/linux/fuse.h.
+//
+// Each read from /dev/fuse returns one whole request: a fuse_in_header (the request's total length, its opcode, a
+// unique id, the node it is about, and the caller's uid, gid and pid) and then the opcode's fields. Each write is one
+// whole reply: a fuse_out_header (the reply's total length, 0 or a negated errno, and the request's unique id) and
+// then the reply's fields. A notification is written the same way, with a unique id of 0 and the notification's code
+// in place of the errno. Structs are laid out the way the C compiler lays them out, in the kernel's byte order, which
+// is little-endian on every architecture this is likely to run on.
+//
+// These are the requests the server understands. Any other request gets ENOSYS, which tells the kernel not to send
+// that kind of request again.
+//
+//	INIT  DESTROY  LOOKUP  FORGET  BATCH_FORGET  GETATTR  SETATTR  OPEN  CREATE  READ  WRITE  FLUSH  FSYNC
+//	RELEASE  OPENDIR  READDIR  RELEASEDIR  FSYNCDIR  MKDIR  UNLINK  RMDIR  RENAME  RENAME2  STATFS  ACCESS
+//	INTERRUPT
+
+import (
+	"encoding/binary"
+	"errors"
+	"filesystem"
+	"fmt"
+)
+
+type opcode uint32
+
+const (
+	opLookup      opcode = 1
+	opForget      opcode = 2
+	opGetattr     opcode = 3
+	opSetattr     opcode = 4
+	opMkdir       opcode = 9
+	opUnlink      opcode = 10
+	opRmdir       opcode = 11
+	opRename      opcode = 12
+	opOpen        opcode = 14
+	opRead        opcode = 15
+	opWrite       opcode = 16
+	opStatfs      opcode = 17
+	opRelease     opcode = 18
+	opFsync       opcode = 20
+	opFlush       opcode = 25
+	opInit        opcode = 26
+	opOpendir     opcode = 27
+	opReaddir     opcode = 28
+	opReleasedir  opcode = 29
+	opFsyncdir    opcode = 30
+	opAccess      opcode = 34
+	opCreate      opcode = 35
+	opInterrupt   opcode = 36
+	opDestroy     opcode = 38
+	opBatchForget opcode = 42
+	opRename2     opcode = 45
+)
+
+// Notifications the server sends without being asked, to drop what the kernel has cached.
+type notifyCode int32
+
+const (
+	notifyInvalInode notifyCode = 2
+	notifyInvalEntry notifyCode = 3
+)
+
+const (
+	// The protocol version the server speaks. Kernels that speak an older minor version than minMinorVersion don't
+	// lay out the structs the way the server expects.
+	kernelVersion   = 7
+	minorVersion    = 31
+	minMinorVersion = 12
+
+	// The node id of the root directory, which the kernel knows without looking it up.
+	rootID = 1
+
+	// The most data a single READ or WRITE carries.
+	maxWrite = 128 * 1024
+
+	// Reads of /dev/fuse need room for the largest WRITE: its headers and then maxWrite bytes of data.
+	maxRequestSize = maxWrite + 4096
+
+	inHeaderSize  = 40
+	outHeaderSize = 16
+)
+
+// Flags in INIT.
+const (
+	initAtomicOTrunc = 1 << 3 // O_TRUNC comes in OPEN, rather than in a SETATTR after it
+	initBigWrites    = 1 << 5 // WRITEs can be bigger than a page
+)
+
+// Bits in SETATTR's valid.
+const (
+	setattrSize = 1 << 3
+)
+
+// Flags in RENAME2.
+const (
+	renameNoReplace = 1 << 0
+	renameExchange  = 1 << 1
+)
+
+// Linux's open(2) flags, as they arrive in OPEN and CREATE.
+const (
+	openAccessModeMask = 3
+	openReadOnly       = 0
+	openWriteOnly      = 1
+	openReadWrite      = 2
+	openExclusive      = 0200
+	openTruncate       = 01000
+	openAppend         = 02000
+)
+
+// Linux file types and permissions, for attributes.
+const (
+	modeDir  = 0040000
+	modeFile = 0100000
+	dirPerm  = 0755
+	filePerm = 0644
+)
+
+// Directory entry types, for READDIR.
+const (
+	direntDir  = 4
+	direntFile = 8
+)
+
+const (
+	blockSize     = 4096
+	maxNameLength = filesystem.MaxNameLength
+)
+
+// Linux errnos. These are the numbers the kernel expects whatever the server was built for, so they aren't taken
+// from syscall.
+type errno int32
+
+const (
+	enoent       errno = 2
+	eio          errno = 5
+	ebadf        errno = 9
+	eagain       errno = 11
+	ebusy        errno = 16
+	eexist       errno = 17
+	enotdir      errno = 20
+	eisdir       errno = 21
+	einval       errno = 22
+	emfile       errno = 24
+	efbig        errno = 27
+	enospc       errno = 28
+	enametoolong errno = 36
+	enosys       errno = 38
+	enotempty    errno = 39
+	eloop        errno = 40
+	emsgsize     errno = 90
+)
+
+var errorCodesToErrnos = map[filesystem.ErrorCode]errno{
+	filesystem.NotFound:          enoent,
+	filesystem.IsDirectory:       eisdir,
+	filesystem.TooManyFDsOpen:    emfile,
+	filesystem.InactiveFD:        ebadf,
+	filesystem.IllegalArgument:   einval,
+	filesystem.TryAgain:          eagain,
+	filesystem.IOError:           eio,
+	filesystem.FileTooLarge:      efbig,
+	filesystem.NoMoreSpace:       enospc,
+	filesystem.DirectoryNotEmpty: enotempty,
+	filesystem.AlreadyExists:     eexist,
+	filesystem.AlreadyOpen:       ebusy,
+	filesystem.WriteTooLarge:     emsgsize,
+	filesystem.WrongMode:         ebadf,
+	filesystem.TooManyLinks:      eloop,
+}
+
+func (e errno) Error() string {
+	return fmt.Sprintf("errno %d", int32(e))
+}
+
+// The errno to report for err. Errors that aren't a filesystem.ErrorCode or an errno are reported as EIO.
+func errnoForError(err error) errno {
+	switch err := err.(type) {
+	case errno:
+		return err
+	case filesystem.ErrorCode:
+		if e, found := errorCodesToErrnos[err]; found {
+			return e
+		}
+	}
+	return eio
+}
+
+type inHeader struct {
+	length uint32
+	opcode opcode
+	unique uint64
+	nodeID uint64
+	uid    uint32
+	gid    uint32
+	pid    uint32
+}
+
+// Returned while decoding a request that is too short or holds impossible values.
+var errMalformed = errors.New("malformed request")
+
+// Split a request into its header and the rest of it.
+func parseRequest(request []byte) (inHeader, []byte, error) {
+	if len(request) < inHeaderSize {
+		return inHeader{}, nil, errMalformed
+	}
+	d := &decoder{buf: request[:inHeaderSize]}
+	header := inHeader{length: d.u32(), opcode: opcode(d.u32()), unique: d.u64(), nodeID: d.u64(), uid: d.u32(),
+		gid: d.u32(), pid: d.u32()}
+	if int(header.length) != len(request) {
+		return inHeader{}, nil, errMalformed
+	}
+	return header, request[inHeaderSize:], nil
+}
+
+// A reply to the request with the given unique id: an error if err isn't 0, or else body.
+func makeReply(unique uint64, err errno, body []byte) []byte {
+	if err != 0 {
+		body = nil
+	}
+	e := &encoder{buf: make([]byte, 0, outHeaderSize+len(body))}
+	e.u32(uint32(outHeaderSize + len(body)))
+	e.u32(uint32(-err))
+	e.u64(unique)
+	e.buf = append(e.buf, body...)
+	return e.buf
+}
+
+// A notification with the given code and fields.
+func makeNotification(code notifyCode, body []byte) []byte {
+	e := &encoder{buf: make([]byte, 0, outHeaderSize+len(body))}
+	e.u32(uint32(outHeaderSize + len(body)))
+	e.u32(uint32(code))
+	e.u64(0)
+	e.buf = append(e.buf, body...)
+	return e.buf
+}
+
+// A file's attributes, as in struct fuse_attr. There are no times, owners or permissions to keep, so the times are
+// always 0 and every file belongs to whoever mounted the filesystem.
+type attr struct {
+	ino   uint64
+	size  uint64
+	mode  uint32
+	nlink uint32
+	uid   uint32
+	gid   uint32
+}
+
+func attrFor(id uint64, info filesystem.FileInfo, uid uint32, gid uint32) attr {
+	a := attr{ino: id, size: uint64(info.Size), mode: modeFile | filePerm, nlink: 1, uid: uid, gid: gid}
+	if info.IsDir {
+		a.mode, a.nlink = modeDir|dirPerm, 2
+	}
+	return a
+}
+
+// Builds the fields of a reply.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) u32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) u64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+// A NUL-terminated string.
+func (e *encoder) string(v string) {
+	e.buf = append(e.buf, v...)
+	e.buf = append(e.buf, 0)
+}
+
+// Pad with zeroes to a multiple of 8 bytes.
+func (e *encoder) align() {
+	for len(e.buf)%8 != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) attr(a attr) {
+	e.u64(a.ino)
+	e.u64(a.size)
+	e.u64((a.size + 511) / 512) // blocks, which are always 512 bytes here
+	for i := 0; i < 3; i++ {
+		e.u64(0) // atime, mtime and ctime
+	}
+	for i := 0; i < 3; i++ {
+		e.u32(0) // atimensec, mtimensec and ctimensec
+	}
+	e.u32(a.mode)
+	e.u32(a.nlink)
+	e.u32(a.uid)
+	e.u32(a.gid)
+	e.u32(0) // rdev
+	e.u32(blockSize)
+	e.u32(0) // flags
+}
+
+// A struct fuse_entry_out, which answers a request that names a new or newly found node.
+func (e *encoder) entry(a attr, entryTimeout timeout, attrTimeout timeout) {
+	e.u64(a.ino) // the node id, which is also the inode number
+	e.u64(0)     // generation, which is always 0 since node ids are never reused
+	e.u64(entryTimeout.sec)
+	e.u64(attrTimeout.sec)
+	e.u32(entryTimeout.nsec)
+	e.u32(attrTimeout.nsec)
+	e.attr(a)
+}
+
+// How long the kernel may cache something, in the two parts the protocol wants.
+type timeout struct {
+	sec  uint64
+	nsec uint32
+}
+
+// Takes apart the fields of a request. Once something goes wrong, err is set and every method returns zero values,
+// so callers can decode every field and check err once at the end.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.buf) {
+		d.err = errMalformed
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) u32() uint32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (d *decoder) u64() uint64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+// Skip n bytes of fields that aren't used.
+func (d *decoder) skip(n int) {
+	d.take(n)
+}
+
+// The next n bytes, copied so the caller can keep them.
+func (d *decoder) bytes(n int) []byte {
+	b := d.take(n)
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// A NUL-terminated string.
+func (d *decoder) string() string {
+	if d.err != nil {
+		return ""
+	}
+	for i, b := range d.buf {
+		if b == 0 {
+			s := string(d.buf[:i])
+			d.buf = d.buf[i+1:]
+			return s
+		}
+	}
+	d.err = errMalformed
+	return ""
+}
+
+// Return the first thing that went wrong. Unlike 9P, newer kernels may add fields to the end of a request that
+// older servers don't know about, so there can be bytes left over.
+func (d *decoder) finish() error {
+	return d.err
+}
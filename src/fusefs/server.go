@@ -0,0 +1,1051 @@
+package fusefs
+
+import (
+	"ad"
+	"filesystem"
+	"hash/fnv"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Serves a filesystem, usually an fsraft.Clerk, to the kernel's FUSE driver, so that it can be mounted and used like
+// any other filesystem (see Mount). The server doesn't talk to /dev/fuse directly but to a Device, so that tests can
+// stand in for the kernel.
+//
+// The kernel refers to files by node ids, which the server hands out as it looks names up and keeps until the
+// kernel forgets them. Every open of a file shares one of the filesystem's file descriptors, opened for reading and
+// writing, so that a file can be opened any number of times through the mount even though the filesystem only lets
+// it be opened once; another client of the cluster that has the file open still makes opens fail with EBUSY.
+//
+// Other clients can change the filesystem at any time, so the kernel is only told to cache names and attributes for
+// a short while (see Config), isn't allowed to keep a file's pages from one open to the next, and is told to drop what
+// it has cached for a file when the server notices that someone else changed it.
+type Server struct {
+	device     Device
+	fs         filesystem.FileSystem
+	config     Config
+	nodes      map[uint64]*node
+	pathsToIDs map[string]uint64
+	nextID     uint64
+	handles    map[uint64]*handle
+	nextHandle uint64
+	minor      uint32 // the protocol minor version agreed on in INIT, or 0 before it
+	done       chan struct{}
+	err        error // why serving stopped, once done is closed
+
+	// Notifications waiting to be written. They're written by their own goroutine because the kernel may hold locks
+	// while it waits for a reply, and a notification that needs one of those locks would deadlock if it were written
+	// before the reply.
+	lock          sync.Mutex
+	notifications [][]byte
+	pending       chan struct{} // has something in it when there may be notifications to write
+}
+
+// Where requests come from and replies go, e.g. /dev/fuse. Each Read returns exactly one whole request, and io.EOF
+// once the filesystem is unmounted; each Write is given exactly one whole reply or notification.
+type Device interface {
+	Read(request []byte) (int, error)
+	Write(reply []byte) (int, error)
+}
+
+type Config struct {
+	EntryTimeout time.Duration // how long the kernel may remember what a name refers to
+	AttrTimeout  time.Duration // how long the kernel may remember a file's size and type
+	UID          uint32        // the owner of every file
+	GID          uint32
+}
+
+func DefaultConfig() Config {
+	return Config{EntryTimeout: time.Second, AttrTimeout: time.Second, UID: uint32(os.Getuid()),
+		GID: uint32(os.Getgid())}
+}
+
+// A file or directory that the kernel knows by its node id.
+type node struct {
+	id      uint64
+	path    string
+	isDir   bool
+	lookups uint64 // how many times the kernel has been told about the node, less the number it has forgotten
+	size    int    // the size the kernel was last told about, to notice changes made by other clients
+	opens   int    // how many handles the node's file has open
+	fd      int    // the filesystem's file descriptor that the handles share, while opens > 0
+}
+
+// An open file or directory, which the kernel knows by its handle id.
+type handle struct {
+	node      *node
+	appending bool
+	entries   []dirent // an open directory's entries, as of the READDIR at offset 0
+}
+
+type dirent struct {
+	ino   uint64
+	name  string
+	isDir bool
+}
+
+// Serve fs to the kernel through device until it is unmounted.
+func Serve(device Device, fs filesystem.FileSystem, config Config) *Server {
+	s := &Server{
+		device:     device,
+		fs:         fs,
+		config:     config,
+		nodes:      make(map[uint64]*node),
+		pathsToIDs: make(map[string]uint64),
+		nextID:     rootID + 1,
+		handles:    make(map[uint64]*handle),
+		nextHandle: 1,
+		done:       make(chan struct{}),
+		pending:    make(chan struct{}, 1),
+	}
+	s.nodes[rootID] = &node{id: rootID, path: "/", isDir: true, lookups: 1}
+	s.pathsToIDs["/"] = rootID
+	go s.serve()
+	go s.writeNotifications()
+	return s
+}
+
+// Wait until the server stops, and return why it stopped, or nil if it was unmounted.
+func (s *Server) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Answer requests one at a time until the filesystem is unmounted, then close the files the kernel left open.
+//
+// Answering requests in order means an INTERRUPT always arrives after the request it names has been answered, so it
+// can be ignored.
+func (s *Server) serve() {
+	ad.Debug(ad.RPC, "Server started")
+	buf := make([]byte, maxRequestSize)
+	for {
+		n, err := s.device.Read(buf)
+		if err != nil {
+			if err != io.EOF {
+				s.err = err
+				ad.Debug(ad.WARN, "Server couldn't read a request, stopping: %v", err)
+			}
+			break
+		}
+		header, body, err := parseRequest(buf[:n])
+		if err != nil {
+			ad.Debug(ad.WARN, "Server got a malformed request of %d bytes", n)
+			continue
+		}
+		reply, stop := s.handle(header, body)
+		if reply != nil {
+			if _, err := s.device.Write(reply); err != nil {
+				// the request was interrupted, or the filesystem was unmounted, which the next read will say
+				ad.Debug(ad.RPC, "Server couldn't write the reply to request %d: %v", header.unique, err)
+			}
+		}
+		if stop {
+			break
+		}
+	}
+	s.closeAll()
+	ad.Debug(ad.RPC, "Server stopped")
+	close(s.done)
+}
+
+// Carry out one request and return the reply to write, if the request gets one, and whether to stop serving.
+func (s *Server) handle(header inHeader, body []byte) (reply []byte, stop bool) {
+	d := &decoder{buf: body}
+	e := &encoder{}
+	var err error
+	if header.opcode != opInit && s.minor == 0 {
+		err = eio
+	} else {
+		switch header.opcode {
+		case opInit:
+			err = s.init(d, e)
+		case opDestroy:
+			stop = true
+		case opForget:
+			s.forget(header.nodeID, d.u64())
+			return nil, false
+		case opBatchForget:
+			s.batchForget(d)
+			return nil, false
+		case opInterrupt:
+			return nil, false
+		case opLookup:
+			err = s.lookup(header.nodeID, d, e)
+		case opGetattr:
+			err = s.getattr(header.nodeID, d, e)
+		case opSetattr:
+			err = s.setattr(header.nodeID, d, e)
+		case opOpen:
+			err = s.open(header.nodeID, d, e)
+		case opCreate:
+			err = s.create(header.nodeID, d, e)
+		case opRead:
+			err = s.read(d, e)
+		case opWrite:
+			err = s.write(d, e)
+		case opFlush, opFsync, opFsyncdir:
+			// writes are applied as soon as they're answered, so there's nothing to flush
+			_, err = s.lookupHandle(d.u64())
+		case opRelease, opReleasedir:
+			err = s.release(d)
+		case opOpendir:
+			err = s.opendir(header.nodeID, e)
+		case opReaddir:
+			err = s.readdir(d, e)
+		case opMkdir:
+			err = s.mkdir(header.nodeID, d, e)
+		case opUnlink:
+			err = s.remove(header.nodeID, d, false)
+		case opRmdir:
+			err = s.remove(header.nodeID, d, true)
+		case opRename:
+			err = s.rename(header.nodeID, d, false)
+		case opRename2:
+			err = s.rename(header.nodeID, d, true)
+		case opStatfs:
+			err = s.statfs(e)
+		case opAccess:
+			// there are no permissions to check
+		default:
+			ad.Debug(ad.TRACE, "Server got a request with unsupported opcode %d", header.opcode)
+			err = enosys
+		}
+	}
+
+	if err == errMalformed {
+		ad.Debug(ad.WARN, "Server got a malformed request with opcode %d", header.opcode)
+		err = einval
+	}
+	if err != nil {
+		return makeReply(header.unique, errnoForError(err), nil), stop
+	}
+	return makeReply(header.unique, 0, e.buf), stop
+}
+
+func (s *Server) init(d *decoder, e *encoder) error {
+	major := d.u32()
+	minor := d.u32()
+	maxReadahead := d.u32()
+	flags := d.u32()
+	if d.finish() != nil {
+		return d.err
+	}
+	if major != kernelVersion || minor < minMinorVersion {
+		ad.Debug(ad.WARN, "Server can't speak version %d.%d of the protocol", major, minor)
+		return einval
+	}
+	s.minor = minorVersion
+	if minor < s.minor {
+		s.minor = minor
+	}
+	e.u32(kernelVersion)
+	e.u32(s.minor)
+	e.u32(maxReadahead)
+	e.u32(flags & (initAtomicOTrunc | initBigWrites))
+	e.u32(0) // max_background and congestion_threshold, to use the kernel's defaults
+	e.u32(maxWrite)
+	if s.minor >= 23 {
+		e.u32(1) // time_gran, in nanoseconds
+		for i := 0; i < 9; i++ {
+			e.u32(0) // max_pages, map_alignment and unused fields
+		}
+	}
+	return nil
+}
+
+func (s *Server) lookup(parentID uint64, d *decoder, e *encoder) error {
+	name := d.string()
+	if d.finish() != nil {
+		return d.err
+	}
+	parent, err := s.lookupNode(parentID)
+	if err != nil {
+		return err
+	}
+	childPath, err := childPath(parent.path, name)
+	if err != nil {
+		return err
+	}
+	info, err := s.fs.Stat(childPath)
+	if err != nil {
+		return err
+	}
+	s.replyEntry(e, s.nodeFor(childPath, info), info)
+	return nil
+}
+
+// The node for the file or directory at filePath, made if the kernel doesn't know of one yet, counting one more
+// lookup of it.
+func (s *Server) nodeFor(filePath string, info filesystem.FileInfo) *node {
+	if id, found := s.pathsToIDs[filePath]; found {
+		n := s.nodes[id]
+		if n.isDir == info.IsDir {
+			n.lookups++
+			return n
+		}
+		// something else has taken its place, which the kernel should know by another id
+		delete(s.pathsToIDs, filePath)
+	}
+	n := &node{id: s.nextID, path: filePath, isDir: info.IsDir, lookups: 1, size: info.Size}
+	s.nextID++
+	s.nodes[n.id] = n
+	s.pathsToIDs[filePath] = n.id
+	return n
+}
+
+func (s *Server) forget(id uint64, lookups uint64) {
+	n, found := s.nodes[id]
+	if !found || id == rootID {
+		return
+	}
+	if lookups > n.lookups {
+		lookups = n.lookups
+	}
+	n.lookups -= lookups
+	s.dropIfUnused(n)
+}
+
+func (s *Server) batchForget(d *decoder) {
+	count := d.u32()
+	d.u32() // dummy
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		id := d.u64()
+		lookups := d.u64()
+		if d.err == nil {
+			s.forget(id, lookups)
+		}
+	}
+}
+
+// Forget n once the kernel has forgotten it and closed it.
+func (s *Server) dropIfUnused(n *node) {
+	if n.lookups > 0 || n.opens > 0 || n.id == rootID {
+		return
+	}
+	delete(s.nodes, n.id)
+	if s.pathsToIDs[n.path] == n.id {
+		delete(s.pathsToIDs, n.path)
+	}
+}
+
+func (s *Server) getattr(id uint64, d *decoder, e *encoder) error {
+	d.u32() // getattr_flags
+	d.u32() // dummy
+	d.u64() // fh
+	if d.finish() != nil {
+		return d.err
+	}
+	n, err := s.lookupNode(id)
+	if err != nil {
+		return err
+	}
+	info, err := s.stat(n)
+	if err != nil {
+		return err
+	}
+	s.replyAttr(e, n, info)
+	return nil
+}
+
+// Stat n's file, and tell the kernel to drop what it has cached if someone else changed it.
+func (s *Server) stat(n *node) (filesystem.FileInfo, error) {
+	info, err := s.fs.Stat(n.path)
+	if err == filesystem.NotFound && s.pathsToIDs[n.path] == n.id {
+		// someone else deleted it, so the kernel's name for it is out of date
+		s.invalidateEntry(n.path)
+	}
+	if err != nil {
+		return info, err
+	}
+	if info.IsDir != n.isDir {
+		if s.pathsToIDs[n.path] == n.id {
+			s.invalidateEntry(n.path)
+		}
+		return info, filesystem.NotFound
+	}
+	if !info.IsDir && info.Size != n.size {
+		s.invalidateInode(n)
+		n.size = info.Size
+	}
+	return info, nil
+}
+
+// Only the size can be changed. Times, permissions and owners aren't kept, so changes to them are accepted and
+// dropped.
+func (s *Server) setattr(id uint64, d *decoder, e *encoder) error {
+	valid := d.u32()
+	d.u32() // padding
+	d.u64() // fh
+	size := d.u64()
+	d.skip(8 + 3*8 + 3*4 + 5*4) // lock_owner, the times, mode, uid, gid and unused fields
+	if d.finish() != nil {
+		return d.err
+	}
+	n, err := s.lookupNode(id)
+	if err != nil {
+		return err
+	}
+	if valid&setattrSize != 0 {
+		if n.isDir {
+			return eisdir
+		}
+		if err := s.truncate(n, int(size)); err != nil {
+			return err
+		}
+	}
+	info, err := s.stat(n)
+	if err != nil {
+		return err
+	}
+	s.replyAttr(e, n, info)
+	return nil
+}
+
+// Change the size of n's file, cutting it short or filling it out with zeroes.
+func (s *Server) truncate(n *node, size int) error {
+	if size < 0 {
+		return einval
+	}
+	if _, err := s.fs.Truncate(n.path, size); err != nil {
+		return err
+	}
+	n.size = size
+	return nil
+}
+
+// Close the file descriptor n's handles share and open the file again with flags.
+func (s *Server) reopen(n *node, flags filesystem.OpenFlags) error {
+	if _, err := s.fs.Close(n.fd); err != nil {
+		return err
+	}
+	fd, err := s.fs.Open(n.path, filesystem.ReadWrite, flags)
+	if err != nil {
+		// the handles are left without a file, so everything they do fails until they're released
+		ad.Debug(ad.WARN, "Server couldn't open %v again: %v", n.path, err)
+		n.fd = -1
+		return err
+	}
+	n.fd = fd
+	return nil
+}
+
+func (s *Server) open(id uint64, d *decoder, e *encoder) error {
+	flags := d.u32()
+	d.u32() // open_flags
+	if d.finish() != nil {
+		return d.err
+	}
+	n, err := s.lookupNode(id)
+	if err != nil {
+		return err
+	}
+	if n.isDir {
+		return eisdir
+	}
+	fh, err := s.openHandle(n, flags, 0)
+	if err != nil {
+		return err
+	}
+	s.replyOpen(e, fh)
+	return nil
+}
+
+// Create a file and open it, or just open it if it turns out to exist already and O_EXCL isn't set.
+func (s *Server) create(parentID uint64, d *decoder, e *encoder) error {
+	flags := d.u32()
+	d.u32() // mode, since there are no permissions
+	d.u32() // umask
+	d.u32() // open_flags
+	name := d.string()
+	if d.finish() != nil {
+		return d.err
+	}
+	parent, err := s.lookupNode(parentID)
+	if err != nil {
+		return err
+	}
+	filePath, err := childPath(parent.path, name)
+	if err != nil {
+		return err
+	}
+	info, err := s.fs.Stat(filePath)
+	if err == nil {
+		// someone made it since the kernel looked, so open what they made
+		if flags&openExclusive != 0 {
+			return eexist
+		}
+		if info.IsDir {
+			return eisdir
+		}
+		n := s.nodeFor(filePath, info)
+		fh, err := s.openHandle(n, flags, 0)
+		if err != nil {
+			n.lookups--
+			s.dropIfUnused(n)
+			return err
+		}
+		info.Size = n.size
+		s.replyEntry(e, n, info)
+		s.replyOpen(e, fh)
+		return nil
+	} else if err != filesystem.NotFound {
+		return err
+	}
+
+	fd, err := s.fs.Open(filePath, filesystem.ReadWrite, filesystem.Create|openFlagsFor(flags))
+	if err != nil {
+		return err
+	}
+	if info, err = s.fs.Stat(filePath); err != nil {
+		s.fs.Close(fd)
+		return err
+	}
+	// a node the kernel still knows by this name was for a file that someone else deleted
+	delete(s.pathsToIDs, filePath)
+	n := s.nodeFor(filePath, info)
+	n.fd = fd
+	fh := s.addHandle(n, flags)
+	s.replyEntry(e, n, info)
+	s.replyOpen(e, fh)
+	return nil
+}
+
+// Open n's file for a new handle, or share its file descriptor if it is already open.
+func (s *Server) openHandle(n *node, flags uint32, extra filesystem.OpenFlags) (uint64, error) {
+	openFlags := openFlagsFor(flags) | extra
+	if n.opens == 0 {
+		fd, err := s.fs.Open(n.path, filesystem.ReadWrite, openFlags)
+		if err != nil {
+			return 0, err
+		}
+		n.fd = fd
+	} else if openFlags&filesystem.Truncate != 0 {
+		if err := s.reopen(n, filesystem.Truncate); err != nil {
+			return 0, err
+		}
+	}
+	if openFlags&filesystem.Truncate != 0 {
+		n.size = 0
+	}
+	return s.addHandle(n, flags), nil
+}
+
+func (s *Server) addHandle(n *node, flags uint32) uint64 {
+	n.opens++
+	fh := s.nextHandle
+	s.nextHandle++
+	s.handles[fh] = &handle{node: n, appending: flags&openAppend != 0}
+	return fh
+}
+
+// The filesystem's flags for Linux open flags. Only O_TRUNC means anything, and only when the file is opened for
+// writing; everything else is up to the kernel or has no meaning here.
+func openFlagsFor(flags uint32) filesystem.OpenFlags {
+	if flags&openTruncate != 0 && flags&openAccessModeMask != openReadOnly {
+		return filesystem.Truncate
+	}
+	return 0
+}
+
+func (s *Server) read(d *decoder, e *encoder) error {
+	fh := d.u64()
+	offset := d.u64()
+	size := int(d.u32())
+	if d.finish() != nil {
+		return d.err
+	}
+	h, err := s.lookupHandle(fh)
+	if err != nil {
+		return err
+	}
+	if h.node.isDir {
+		return eisdir
+	}
+	if size > maxWrite {
+		size = maxWrite
+	}
+	data, err := s.readAt(h.node.fd, int(offset), size)
+	if err != nil {
+		return err
+	}
+	e.buf = append(e.buf, data...)
+	return nil
+}
+
+// Read up to size bytes at offset, stopping short only at the end of the file, since the kernel takes a short read
+// to mean that the file ends there.
+func (s *Server) readAt(fd int, offset int, size int) ([]byte, error) {
+	data := make([]byte, 0, size)
+	for len(data) < size {
+		bytesRead, chunk, err := s.fs.Pread(fd, offset+len(data), size-len(data))
+		if err != nil {
+			return nil, err
+		}
+		if bytesRead == 0 {
+			break
+		}
+		data = append(data, chunk[:bytesRead]...)
+	}
+	return data, nil
+}
+
+func (s *Server) write(d *decoder, e *encoder) error {
+	fh := d.u64()
+	offset := d.u64()
+	size := int(d.u32())
+	d.u32() // write_flags
+	d.u64() // lock_owner
+	d.u32() // flags
+	d.u32() // padding
+	data := d.bytes(size)
+	if d.finish() != nil {
+		return d.err
+	}
+	h, err := s.lookupHandle(fh)
+	if err != nil {
+		return err
+	}
+	if h.node.isDir {
+		return eisdir
+	}
+	end, err := s.writeAt(h.node.fd, int(offset), data, h.appending)
+	if err != nil {
+		return err
+	}
+	// the kernel knows about its own writes, so they aren't changes to tell it about
+	if end > h.node.size {
+		h.node.size = end
+	}
+	e.u32(uint32(len(data)))
+	e.u32(0) // padding
+	return nil
+}
+
+// Write all of data at offset, or at the end of the file if appending, and return where the write ended.
+func (s *Server) writeAt(fd int, offset int, data []byte, appending bool) (int, error) {
+	if appending {
+		var err error
+		if offset, err = s.fs.Seek(fd, 0, filesystem.FromEnd); err != nil {
+			return 0, err
+		}
+	}
+	for written := 0; written < len(data); {
+		var bytesWritten int
+		var err error
+		if appending {
+			bytesWritten, err = s.fs.Write(fd, len(data)-written, data[written:])
+		} else {
+			bytesWritten, err = s.fs.Pwrite(fd, offset+written, data[written:])
+		}
+		if err != nil {
+			return 0, err
+		}
+		if bytesWritten == 0 {
+			return 0, eio
+		}
+		written += bytesWritten
+	}
+	return offset + len(data), nil
+}
+
+// Forget a handle, closing its file if it was the last handle open on it. The handle is gone even if closing fails.
+func (s *Server) release(d *decoder) error {
+	fh := d.u64()
+	if d.finish() != nil {
+		return d.err
+	}
+	h, err := s.lookupHandle(fh)
+	if err != nil {
+		return err
+	}
+	delete(s.handles, fh)
+	n := h.node
+	n.opens--
+	defer s.dropIfUnused(n)
+	if n.opens > 0 || n.isDir || n.fd < 0 {
+		return nil
+	}
+	_, err = s.fs.Close(n.fd)
+	return err
+}
+
+func (s *Server) opendir(id uint64, e *encoder) error {
+	n, err := s.lookupNode(id)
+	if err != nil {
+		return err
+	}
+	if !n.isDir {
+		return enotdir
+	}
+	n.opens++
+	fh := s.nextHandle
+	s.nextHandle++
+	s.handles[fh] = &handle{node: n}
+	s.replyOpen(e, fh)
+	return nil
+}
+
+// List an open directory, starting with "." and "..". offset is 0 to start, and otherwise the offset of the last
+// entry returned so far.
+func (s *Server) readdir(d *decoder, e *encoder) error {
+	fh := d.u64()
+	offset := d.u64()
+	size := int(d.u32())
+	if d.finish() != nil {
+		return d.err
+	}
+	h, err := s.lookupHandle(fh)
+	if err != nil {
+		return err
+	}
+	if !h.node.isDir {
+		return enotdir
+	}
+	if offset == 0 || h.entries == nil {
+		infos, err := s.fs.ReadDir(h.node.path)
+		if err != nil {
+			return err
+		}
+		h.entries = make([]dirent, 0, len(infos)+2)
+		h.entries = append(h.entries, dirent{s.inoFor(h.node.path), ".", true},
+			dirent{s.inoFor(path.Dir(h.node.path)), "..", true})
+		for _, info := range infos {
+			entryPath, _ := childPath(h.node.path, info.Name)
+			h.entries = append(h.entries, dirent{s.inoFor(entryPath), info.Name, info.IsDir})
+		}
+	}
+
+	for i := int(offset); i >= 0 && i < len(h.entries); i++ {
+		entry := h.entries[i]
+		if len(e.buf)+direntSize(entry.name) > size {
+			break
+		}
+		e.u64(entry.ino)
+		e.u64(uint64(i + 1))
+		e.u32(uint32(len(entry.name)))
+		if entry.isDir {
+			e.u32(direntDir)
+		} else {
+			e.u32(direntFile)
+		}
+		e.buf = append(e.buf, entry.name...)
+		e.align()
+	}
+	return nil
+}
+
+// The size of a struct fuse_dirent holding name, padded to 8 bytes.
+func direntSize(name string) int {
+	return (8 + 8 + 4 + 4 + len(name) + 7) / 8 * 8
+}
+
+// The inode number to list for the entry at filePath: its node id if the kernel knows it, or else a hash of its
+// path, which the kernel doesn't use for anything and programs only use to tell entries apart.
+func (s *Server) inoFor(filePath string) uint64 {
+	if id, found := s.pathsToIDs[filePath]; found {
+		return id
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(filePath))
+	return hash.Sum64() | 1<<63
+}
+
+func (s *Server) mkdir(parentID uint64, d *decoder, e *encoder) error {
+	d.u32() // mode
+	d.u32() // umask
+	name := d.string()
+	if d.finish() != nil {
+		return d.err
+	}
+	parent, err := s.lookupNode(parentID)
+	if err != nil {
+		return err
+	}
+	dirPath, err := childPath(parent.path, name)
+	if err != nil {
+		return err
+	}
+	if _, err := s.fs.Mkdir(dirPath); err != nil {
+		return err
+	}
+	info, err := s.fs.Stat(dirPath)
+	if err != nil {
+		return err
+	}
+	s.replyEntry(e, s.nodeFor(dirPath, info), info)
+	return nil
+}
+
+// UNLINK or RMDIR. A file that is deleted while it is open stays open, as far as the filesystem allows.
+func (s *Server) remove(parentID uint64, d *decoder, isDir bool) error {
+	name := d.string()
+	if d.finish() != nil {
+		return d.err
+	}
+	parent, err := s.lookupNode(parentID)
+	if err != nil {
+		return err
+	}
+	filePath, err := childPath(parent.path, name)
+	if err != nil {
+		return err
+	}
+	info, err := s.fs.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir && !isDir {
+		return eisdir
+	}
+	if !info.IsDir && isDir {
+		return enotdir
+	}
+	if _, err := s.fs.Delete(filePath); err != nil {
+		return err
+	}
+	delete(s.pathsToIDs, filePath)
+	return nil
+}
+
+// RENAME or RENAME2, which the filesystem does all at once with Rename. Files under what is renamed that are open
+// through the mount stay open, and symbolic links are moved as links, never followed.
+func (s *Server) rename(parentID uint64, d *decoder, hasFlags bool) error {
+	newParentID := d.u64()
+	var flags uint32
+	if hasFlags {
+		flags = d.u32()
+		d.u32() // padding
+	}
+	name := d.string()
+	newName := d.string()
+	if d.finish() != nil {
+		return d.err
+	}
+	if flags&renameExchange != 0 {
+		return einval
+	}
+	parent, err := s.lookupNode(parentID)
+	if err != nil {
+		return err
+	}
+	newParent, err := s.lookupNode(newParentID)
+	if err != nil {
+		return err
+	}
+	from, err := childPath(parent.path, name)
+	if err != nil {
+		return err
+	}
+	to, err := childPath(newParent.path, newName)
+	if err != nil {
+		return err
+	}
+	info, err := s.fs.Lstat(from)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return nil
+	}
+	if strings.HasPrefix(to, from+"/") {
+		return einval
+	}
+
+	existing, err := s.fs.Lstat(to)
+	if err == nil {
+		if flags&renameNoReplace != 0 {
+			return eexist
+		}
+		if info.IsDir && !existing.IsDir {
+			return enotdir
+		}
+		if !info.IsDir && existing.IsDir {
+			return eisdir
+		}
+	} else if err != filesystem.NotFound {
+		return err
+	}
+
+	if _, err := s.fs.Rename(from, to); err != nil {
+		return err
+	}
+	delete(s.pathsToIDs, to)
+	for _, n := range s.nodesUnder(from) {
+		delete(s.pathsToIDs, n.path)
+		n.path = to + strings.TrimPrefix(n.path, from)
+		s.pathsToIDs[n.path] = n.id
+	}
+	return nil
+}
+
+// The nodes for filePath and everything under it.
+func (s *Server) nodesUnder(filePath string) []*node {
+	nodes := make([]*node, 0)
+	for p, id := range s.pathsToIDs {
+		if p == filePath || strings.HasPrefix(p, filePath+"/") {
+			nodes = append(nodes, s.nodes[id])
+		}
+	}
+	return nodes
+}
+
+// Report the filesystem's Limits as its capacity. Anything it has no limit on is reported as 0, free or not.
+func (s *Server) statfs(e *encoder) error {
+	info, err := s.fs.Statfs()
+	if err != nil {
+		return err
+	}
+	blocks, freeBlocks := capacity(info.Limits.MaxBytes/blockSize, (info.Bytes+blockSize-1)/blockSize)
+	files, freeFiles := capacity(info.Limits.MaxInodes, info.Inodes)
+	e.u64(blocks)
+	e.u64(freeBlocks)
+	e.u64(freeBlocks) // bavail
+	e.u64(files)
+	e.u64(freeFiles)
+	e.u32(blockSize)
+	e.u32(maxNameLength)
+	e.u32(blockSize) // frsize
+	for i := 0; i < 7; i++ {
+		e.u32(0) // padding and spare fields
+	}
+	return nil
+}
+
+// How much of limit there is, and how much of it is free after used, as reported by statfs.
+func capacity(limit int, used int) (total uint64, free uint64) {
+	if limit <= used {
+		return uint64(limit), 0
+	}
+	return uint64(limit), uint64(limit - used)
+}
+
+func (s *Server) replyEntry(e *encoder, n *node, info filesystem.FileInfo) {
+	e.entry(attrFor(n.id, info, s.config.UID, s.config.GID), timeoutFor(s.config.EntryTimeout),
+		timeoutFor(s.config.AttrTimeout))
+}
+
+// A struct fuse_attr_out.
+func (s *Server) replyAttr(e *encoder, n *node, info filesystem.FileInfo) {
+	attrTimeout := timeoutFor(s.config.AttrTimeout)
+	e.u64(attrTimeout.sec)
+	e.u32(attrTimeout.nsec)
+	e.u32(0) // dummy
+	e.attr(attrFor(n.id, info, s.config.UID, s.config.GID))
+}
+
+// A struct fuse_open_out. Its flags are 0, so the kernel drops a file's cached pages whenever it is opened.
+func (s *Server) replyOpen(e *encoder, fh uint64) {
+	e.u64(fh)
+	e.u32(0) // open_flags
+	e.u32(0) // padding
+}
+
+func timeoutFor(d time.Duration) timeout {
+	return timeout{sec: uint64(d / time.Second), nsec: uint32(d % time.Second)}
+}
+
+func (s *Server) lookupNode(id uint64) (*node, error) {
+	n, found := s.nodes[id]
+	if !found {
+		return nil, enoent
+	}
+	return n, nil
+}
+
+func (s *Server) lookupHandle(fh uint64) (*handle, error) {
+	h, found := s.handles[fh]
+	if !found {
+		return nil, ebadf
+	}
+	if !h.node.isDir && h.node.fd < 0 {
+		return nil, eio
+	}
+	return h, nil
+}
+
+// Close every file the kernel left open, so that other clients can open them.
+func (s *Server) closeAll() {
+	for fh, h := range s.handles {
+		delete(s.handles, fh)
+		n := h.node
+		n.opens--
+		if n.opens == 0 && !n.isDir && n.fd >= 0 {
+			if _, err := s.fs.Close(n.fd); err != nil {
+				ad.Debug(ad.WARN, "Server couldn't close %v: %v", n.path, err)
+			}
+		}
+	}
+}
+
+// Tell the kernel to drop the pages and attributes it has cached for n.
+func (s *Server) invalidateInode(n *node) {
+	e := &encoder{}
+	e.u64(n.id)
+	e.u64(0) // off
+	e.u64(0) // len, which with off 0 means all of the pages
+	s.notify(makeNotification(notifyInvalInode, e.buf))
+}
+
+// Tell the kernel to forget what the name filePath refers to, if it knows the directory filePath is in.
+func (s *Server) invalidateEntry(filePath string) {
+	parentID, found := s.pathsToIDs[path.Dir(filePath)]
+	if !found || filePath == "/" {
+		return
+	}
+	name := path.Base(filePath)
+	e := &encoder{}
+	e.u64(parentID)
+	e.u32(uint32(len(name)))
+	e.u32(0) // flags
+	e.string(name)
+	s.notify(makeNotification(notifyInvalEntry, e.buf))
+}
+
+func (s *Server) notify(notification []byte) {
+	s.lock.Lock()
+	s.notifications = append(s.notifications, notification)
+	s.lock.Unlock()
+	select {
+	case s.pending <- struct{}{}:
+	default:
+	}
+}
+
+// Write notifications as they come, until the server stops.
+func (s *Server) writeNotifications() {
+	for {
+		select {
+		case <-s.pending:
+		case <-s.done:
+			return
+		}
+		s.lock.Lock()
+		notifications := s.notifications
+		s.notifications = nil
+		s.lock.Unlock()
+		for _, notification := range notifications {
+			// the kernel says ENOENT if it doesn't have what the notification is about cached, which is fine
+			if _, err := s.device.Write(notification); err != nil {
+				ad.Debug(ad.TRACE, "Server couldn't write a notification: %v", err)
+			}
+		}
+	}
+}
+
+// The path of the entry called name in the directory at dir.
+func childPath(dir string, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return "", einval
+	}
+	if len(name) > maxNameLength {
+		return "", enametoolong
+	}
+	if dir == "/" {
+		return "/" + name, nil
+	}
+	return dir + "/" + name, nil
+}
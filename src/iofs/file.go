@@ -0,0 +1,199 @@
+package iofs
+
+import (
+	"filesystem"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+)
+
+// A file from FS.Open, read through its file descriptor.
+type file struct {
+	fsys     *FS
+	name     string
+	filePath string
+	fd       int
+
+	mu     sync.Mutex // held while using fd, so that it isn't closed out from under a call
+	closed bool
+}
+
+var _ io.ReadSeekCloser = (*file)(nil)
+var _ io.ReaderAt = (*file)(nil)
+
+var whencesToSeekModes = map[int]filesystem.SeekMode{
+	io.SeekStart:   filesystem.FromBeginning,
+	io.SeekCurrent: filesystem.FromCurrent,
+	io.SeekEnd:     filesystem.FromEnd,
+}
+
+// Describe the file as it is now, rather than when it was opened.
+func (f *file) Stat() (fs.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, pathError("stat", f.name, fs.ErrClosed)
+	}
+	info, err := f.fsys.fileSystem.Stat(f.filePath)
+	if err != nil {
+		return nil, pathError("stat", f.name, err)
+	}
+	return fileInfo{path.Base(f.name), info}, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, pathError("read", f.name, fs.ErrClosed)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return f.readOnce(p)
+}
+
+// Read up to len(p) bytes, and at most chunkSize, at the offset, and return io.EOF if there are none left.
+// ONLY CALL WITH THE LOCK.
+func (f *file) readOnce(p []byte) (int, error) {
+	numBytes := len(p)
+	if numBytes > chunkSize {
+		numBytes = chunkSize
+	}
+	bytesRead, data, err := f.fsys.fileSystem.Read(f.fd, numBytes)
+	if err != nil {
+		return 0, pathError("read", f.name, err)
+	}
+	if bytesRead == 0 {
+		return 0, io.EOF
+	}
+	return copy(p, data[:bytesRead]), nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, pathError("seek", f.name, fs.ErrClosed)
+	}
+	base, found := whencesToSeekModes[whence]
+	if !found {
+		return 0, pathError("seek", f.name, fs.ErrInvalid)
+	}
+	newPosition, err := f.fsys.fileSystem.Seek(f.fd, int(offset), base)
+	if err != nil {
+		return 0, pathError("seek", f.name, err)
+	}
+	return int64(newPosition), nil
+}
+
+// Read len(p) bytes starting at offset, or return why it couldn't, without changing the offset that Read uses.
+func (f *file) ReadAt(p []byte, offset int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, pathError("read", f.name, fs.ErrClosed)
+	}
+	if offset < 0 {
+		return 0, pathError("read", f.name, fs.ErrInvalid)
+	}
+	n := 0
+	for n < len(p) {
+		numBytes := len(p) - n
+		if numBytes > chunkSize {
+			numBytes = chunkSize
+		}
+		bytesRead, data, err := f.fsys.fileSystem.Pread(f.fd, int(offset)+n, numBytes)
+		if err != nil {
+			return n, pathError("read", f.name, err)
+		}
+		if bytesRead == 0 {
+			return n, io.EOF
+		}
+		n += copy(p[n:], data[:bytesRead])
+	}
+	return n, nil
+}
+
+func (f *file) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return pathError("close", f.name, fs.ErrClosed)
+	}
+	f.closed = true
+	if _, err := f.fsys.fileSystem.Close(f.fd); err != nil {
+		return pathError("close", f.name, err)
+	}
+	return nil
+}
+
+// A directory from FS.Open. It has no file descriptor; ReadDir lists the directory the first time it is called and
+// then goes through that list.
+type dir struct {
+	fsys     *FS
+	name     string
+	filePath string
+	info     filesystem.FileInfo
+
+	mu      sync.Mutex
+	entries []fs.DirEntry // nil until the first ReadDir
+	offset  int           // how many of entries ReadDir has returned
+	closed  bool
+}
+
+var _ fs.ReadDirFile = (*dir)(nil)
+
+// Describe the directory as it was when it was opened.
+func (d *dir) Stat() (fs.FileInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, pathError("stat", d.name, fs.ErrClosed)
+	}
+	return fileInfo{path.Base(d.name), d.info}, nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, pathError("read", d.name, filesystem.IsDirectory)
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, pathError("readdir", d.name, fs.ErrClosed)
+	}
+	if d.entries == nil {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+	}
+
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
+
+func (d *dir) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return pathError("close", d.name, fs.ErrClosed)
+	}
+	d.closed = true
+	return nil
+}
@@ -0,0 +1,158 @@
+// Package iofs lets the standard library's io/fs helpers, like fs.WalkDir, template.ParseFS and http.FS, work
+// directly on a filesystem.FileSystem, usually an fsraft.Clerk:
+//
+//	http.Handle("/", http.FileServer(http.FS(iofs.MakeFS(clerk))))
+//
+// Names are io/fs names, so they are unrooted and "." is the root directory; "dir/file" is "/dir/file" on the
+// filesystem. Everything is read-only: files are opened ReadOnly, and since a file can only be opened once, opening
+// a file that is already open fails with an error that matches filesystem.AlreadyOpen.
+package iofs
+
+import (
+	"ad"
+	"filesystem"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+)
+
+// An io/fs view of a filesystem.FileSystem. It is safe to use from many goroutines if the FileSystem is.
+type FS struct {
+	fileSystem filesystem.FileSystem
+}
+
+var _ fs.ReadDirFS = (*FS)(nil)
+var _ fs.StatFS = (*FS)(nil)
+var _ fs.ReadFileFS = (*FS)(nil)
+
+// Make an io/fs view of fileSystem.
+func MakeFS(fileSystem filesystem.FileSystem) *FS {
+	return &FS{fileSystem}
+}
+
+// How much to read from the filesystem in one call.
+const chunkSize = 64 * 1024
+
+// Open the file or directory called name.
+// A file is opened ReadOnly and keeps its file descriptor until it is closed; besides fs.File, it implements
+// io.Seeker and io.ReaderAt. A directory implements fs.ReadDirFile, and lists the directory when it is first read.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	filePath, err := filesystemPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	ad.Debug(ad.TRACE, "Opening %v", filePath)
+	// Open("/") is NotFound rather than IsDirectory
+	if filePath != "/" {
+		fd, err := fsys.fileSystem.Open(filePath, filesystem.ReadOnly, 0)
+		if err == nil {
+			// a file keeps its offset from when it was last open
+			if _, err := fsys.fileSystem.Seek(fd, 0, filesystem.FromBeginning); err != nil {
+				fsys.fileSystem.Close(fd)
+				return nil, pathError("open", name, err)
+			}
+			return &file{fsys: fsys, name: name, filePath: filePath, fd: fd}, nil
+		}
+		if err != filesystem.IsDirectory {
+			return nil, pathError("open", name, err)
+		}
+	}
+	info, err := fsys.fileSystem.Stat(filePath)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return &dir{fsys: fsys, name: name, filePath: filePath, info: info}, nil
+}
+
+// Describe the file or directory called name, without opening it.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	filePath, err := filesystemPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fsys.fileSystem.Stat(filePath)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return fileInfo{path.Base(name), info}, nil
+}
+
+// List the directory called name, sorted by name.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	filePath, err := filesystemPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := fsys.fileSystem.ReadDir(filePath)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	entries := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = fs.FileInfoToDirEntry(fileInfo{info.Name, info})
+	}
+	return entries, nil
+}
+
+// Read the whole file called name.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// The filesystem path for an io/fs name, or an fs.ErrInvalid *fs.PathError if it isn't a valid one.
+func filesystemPath(op string, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return "/", nil
+	}
+	return "/" + name, nil
+}
+
+// Wrap an error from the filesystem. filesystem.ErrorCode matches the io/fs errors that mean the same thing, like
+// fs.ErrNotExist, so errors.Is works on the result.
+func pathError(op string, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// A filesystem.FileInfo as an fs.FileInfo. There are no modification times, so ModTime is always the zero time.
+type fileInfo struct {
+	name string
+	info filesystem.FileInfo
+}
+
+func (info fileInfo) Name() string {
+	return info.name
+}
+
+func (info fileInfo) Size() int64 {
+	return int64(info.info.Size)
+}
+
+// There are no permissions either, so everything is readable and nothing is writable.
+func (info fileInfo) Mode() fs.FileMode {
+	if info.info.IsDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (info fileInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (info fileInfo) IsDir() bool {
+	return info.info.IsDir
+}
+
+// The filesystem.FileInfo.
+func (info fileInfo) Sys() interface{} {
+	return info.info
+}
@@ -0,0 +1,169 @@
+package iofs
+
+import (
+	"ad"
+	"bytes"
+	"errors"
+	"filesystem"
+	"fsraft"
+	"io"
+	"io/fs"
+	"memoryFS"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMain(m *testing.M) {
+	if _, isSet := os.LookupEnv("DFS_DEFAULT_DEBUG_LEVEL"); !isSet {
+		ad.SetDebugLevel(ad.WARN)
+	}
+	os.Exit(m.Run())
+}
+
+// The files writeTree makes, other than directories, and their contents.
+var treeFiles = map[string]string{
+	"README":               "read me\n",
+	"empty":                "",
+	"dir/a.txt":            "aaa",
+	"dir/sub/b.txt":        "bbbbbbbbbb",
+	"dir/sub/deeper/c.bin": string(bytes.Repeat([]byte{0, 1, 2, 3}, 100)),
+}
+
+func writeTree(t *testing.T, fileSystem filesystem.FileSystem) {
+	for _, dir := range []string{"/dir", "/dir/sub", "/dir/sub/deeper", "/dir/nothing"} {
+		filesystem.HelpMkdir(t, fileSystem, dir)
+	}
+	for name, contents := range treeFiles {
+		writeFile(t, fileSystem, "/"+name, contents)
+	}
+}
+
+func writeFile(t *testing.T, fileSystem filesystem.FileSystem, path string, contents string) {
+	fd := filesystem.HelpOpen(t, fileSystem, path, filesystem.WriteOnly, filesystem.Create)
+	filesystem.HelpWriteString(t, fileSystem, fd, contents)
+	filesystem.HelpClose(t, fileSystem, fd)
+}
+
+func expectedNames() []string {
+	names := []string{"dir/nothing"}
+	for name := range treeFiles {
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestMemoryFS(t *testing.T) {
+	mfs := memoryFS.CreateEmptyMemoryFS()
+	writeTree(t, &mfs)
+	if err := fstest.TestFS(MakeFS(&mfs), expectedNames()...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClerk(t *testing.T) {
+	clerk := fsraft.StartTestCluster(t, 3, fsraft.DefaultFileServerConfig()).MakeClerk()
+	writeTree(t, clerk)
+	if err := fstest.TestFS(MakeFS(clerk), expectedNames()...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	mfs := memoryFS.CreateEmptyMemoryFS()
+	writeTree(t, &mfs)
+	fsys := MakeFS(&mfs)
+
+	for _, name := range []string{"/README", "dir/", "dir/../README", ""} {
+		if _, err := fsys.Open(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Fatalf("Open(%q) returned %v, expected fs.ErrInvalid", name, err)
+		}
+	}
+	if _, err := fsys.Stat("missing"); !errors.Is(err, fs.ErrNotExist) || !errors.Is(err, filesystem.NotFound) {
+		t.Fatalf("Stat() of a missing file returned %v", err)
+	}
+	if _, err := fsys.ReadDir("README"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("ReadDir() of a file returned %v", err)
+	}
+
+	file, err := fsys.Open("dir/a.txt")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if _, err := fsys.Open("dir/a.txt"); !errors.Is(err, filesystem.AlreadyOpen) {
+		t.Fatalf("opening an open file returned %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if err := file.Close(); !errors.Is(err, fs.ErrClosed) {
+		t.Fatalf("closing a closed file returned %v", err)
+	}
+	if _, err := file.Read(make([]byte, 1)); !errors.Is(err, fs.ErrClosed) {
+		t.Fatalf("reading a closed file returned %v", err)
+	}
+	if _, err := fs.ReadFile(fsys, "dir"); !errors.Is(err, filesystem.IsDirectory) {
+		t.Fatalf("ReadFile() of a directory returned %v", err)
+	}
+}
+
+func TestReadAtAndSeek(t *testing.T) {
+	mfs := memoryFS.CreateEmptyMemoryFS()
+	writeFile(t, &mfs, "/file", "0123456789")
+	file, err := MakeFS(&mfs).Open("file")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer file.Close()
+	readSeeker := file.(io.ReadSeeker)
+	readerAt := file.(io.ReaderAt)
+
+	buffer := make([]byte, 3)
+	if _, err := io.ReadFull(readSeeker, buffer); err != nil || string(buffer) != "012" {
+		t.Fatalf("Read() returned %q, %v", buffer, err)
+	}
+	// ReadAt doesn't move the offset Read uses.
+	if n, err := readerAt.ReadAt(buffer, 8); n != 2 || err != io.EOF || string(buffer[:n]) != "89" {
+		t.Fatalf("ReadAt() past the end returned %d, %v, %q", n, err, buffer[:n])
+	}
+	if n, err := readerAt.ReadAt(buffer, 4); n != 3 || err != nil || string(buffer) != "456" {
+		t.Fatalf("ReadAt() returned %d, %v, %q", n, err, buffer)
+	}
+	if _, err := io.ReadFull(readSeeker, buffer); err != nil || string(buffer) != "345" {
+		t.Fatalf("Read() after ReadAt() returned %q, %v", buffer, err)
+	}
+
+	if position, err := readSeeker.Seek(-1, io.SeekEnd); position != 9 || err != nil {
+		t.Fatalf("Seek() returned %d, %v", position, err)
+	}
+	if rest, err := io.ReadAll(readSeeker); err != nil || string(rest) != "9" {
+		t.Fatalf("Read() after Seek() returned %q, %v", rest, err)
+	}
+	if _, err := readSeeker.Seek(-20, io.SeekCurrent); !errors.Is(err, filesystem.IllegalArgument) {
+		t.Fatalf("Seek() before the beginning returned %v", err)
+	}
+	if _, err := readerAt.ReadAt(buffer, -1); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("ReadAt() with a negative offset returned %v", err)
+	}
+}
+
+func TestReadsBiggerThanAChunk(t *testing.T) {
+	mfs := memoryFS.CreateEmptyMemoryFS()
+	contents := bytes.Repeat([]byte("0123456789"), chunkSize/4)
+	writeFile(t, &mfs, "/big", string(contents))
+	fsys := MakeFS(&mfs)
+
+	if read, err := fs.ReadFile(fsys, "big"); err != nil || !bytes.Equal(read, contents) {
+		t.Fatalf("ReadFile() returned %d bytes and %v, expected %d bytes", len(read), err, len(contents))
+	}
+	file, err := fsys.Open("big")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer file.Close()
+	buffer := make([]byte, chunkSize+10)
+	if n, err := file.(io.ReaderAt).ReadAt(buffer, 5); n != len(buffer) || err != nil ||
+		!bytes.Equal(buffer, contents[5:5+len(buffer)]) {
+		t.Fatalf("ReadAt() returned %d, %v", n, err)
+	}
+}
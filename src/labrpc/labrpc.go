@@ -75,6 +75,14 @@ type replyMsg struct {
 	reply []byte
 }
 
+// anything that can send RPCs to one server.
+// Raft, the file server and its clerks only depend on this,
+// so that a real network (see package tcprpc) can be used
+// in place of the simulated one.
+type Endpoint interface {
+	Call(svcMeth string, args interface{}, reply interface{}) bool
+}
+
 type ClientEnd struct {
 	endname interface{}   // this end-point's name
 	ch      chan reqMsg   // copy of Network.endCh
@@ -375,14 +383,24 @@ func (rn *Network) GetTotalCount() int {
 // and a k/v server can listen to the same rpc endpoint.
 //
 type Server struct {
-	mu       sync.Mutex
-	services map[string]*Service
-	count    int // incoming RPCs
+	mu          sync.Mutex
+	services    map[string]*Service
+	count       int                    // incoming RPCs
+	methodStats map[string]MethodStats // per "Service.Method", for statistics
+}
+
+// how many RPCs of one kind a server has handled, and
+// how big their encoded arguments and replies were.
+type MethodStats struct {
+	Count    int
+	BytesIn  int
+	BytesOut int
 }
 
 func MakeServer() *Server {
 	rs := &Server{}
 	rs.services = map[string]*Service{}
+	rs.methodStats = map[string]MethodStats{}
 	return rs
 }
 
@@ -407,7 +425,15 @@ func (rs *Server) dispatch(req reqMsg) replyMsg {
 	rs.mu.Unlock()
 
 	if ok {
-		return service.dispatch(methodName, req)
+		reply := service.dispatch(methodName, req)
+		rs.mu.Lock()
+		stats := rs.methodStats[req.svcMeth]
+		stats.Count += 1
+		stats.BytesIn += len(req.args)
+		stats.BytesOut += len(reply.reply)
+		rs.methodStats[req.svcMeth] = stats
+		rs.mu.Unlock()
+		return reply
 	} else {
 		choices := []string{}
 		for k, _ := range rs.services {
@@ -419,12 +445,50 @@ func (rs *Server) dispatch(req reqMsg) replyMsg {
 	}
 }
 
+// dispatch an RPC that arrived some other way than through
+// a Network, e.g. over TCP (see package tcprpc). args is the
+// labgob-encoded argument; its type is taken from the handler.
+// returns the labgob-encoded reply, or ok=false if there is
+// no such service or method (instead of exiting, since the
+// request came from outside the program).
+func (rs *Server) DispatchEncoded(svcMeth string, args []byte) (reply []byte, ok bool) {
+	dot := strings.LastIndex(svcMeth, ".")
+	if dot < 0 {
+		return nil, false
+	}
+	rs.mu.Lock()
+	service, ok := rs.services[svcMeth[:dot]]
+	rs.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	method, ok := service.methods[svcMeth[dot+1:]]
+	if !ok {
+		return nil, false
+	}
+	req := reqMsg{svcMeth: svcMeth, argsType: method.Type.In(1), args: args}
+	rep := rs.dispatch(req)
+	return rep.reply, rep.ok
+}
+
 func (rs *Server) GetCount() int {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
 	return rs.count
 }
 
+// get a copy of the server's per-method statistics,
+// keyed by "Service.Method" (e.g. "Raft.AppendEntries").
+func (rs *Server) GetMethodStats() map[string]MethodStats {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	stats := map[string]MethodStats{}
+	for svcMeth, methodStats := range rs.methodStats {
+		stats[svcMeth] = methodStats
+	}
+	return stats
+}
+
 // an object with methods that can be called via RPC.
 // a single server may have more than one Service.
 type Service struct {
@@ -0,0 +1,105 @@
+package memoryFS
+
+// How many bytes of a file each chunk holds. A chunk can be shorter, e.g. the last one in a small file.
+const chunkSize = 64 * 1024
+
+// The contents of a File, kept in chunks so that a write only touches the chunks it covers, and so that a hole made
+// by seeking past the end and writing takes no memory. Anything before size that hasn't been written, whether it is
+// in a missing chunk or past the end of a short one, reads as zeros.
+// The zero value is an empty file.
+type chunkedContents struct {
+	chunks map[int][]byte // chunk number -> up to chunkSize bytes starting at chunk number * chunkSize
+	size   int
+}
+
+// Copy numBytes bytes starting at offset out of the file.
+// Requires 0 <= offset and offset+numBytes <= size.
+func (contents *chunkedContents) readAt(offset int, numBytes int) []byte {
+	data := make([]byte, numBytes)
+	for done := 0; done < numBytes; {
+		chunkNum, withinChunk := (offset+done)/chunkSize, (offset+done)%chunkSize
+		n := chunkSize - withinChunk
+		if n > numBytes-done {
+			n = numBytes - done
+		}
+		// data is already zeros, so holes need no copying
+		if chunk := contents.chunks[chunkNum]; withinChunk < len(chunk) {
+			copy(data[done:done+n], chunk[withinChunk:])
+		}
+		done += n
+	}
+	return data
+}
+
+// Copy data into the file starting at offset, making the file longer if it ends before offset+len(data).
+// Requires 0 <= offset.
+func (contents *chunkedContents) writeAt(offset int, data []byte) {
+	if contents.chunks == nil {
+		contents.chunks = make(map[int][]byte)
+	}
+	if offset+len(data) > contents.size {
+		contents.size = offset + len(data)
+	}
+	for done := 0; done < len(data); {
+		chunkNum, withinChunk := (offset+done)/chunkSize, (offset+done)%chunkSize
+		n := chunkSize - withinChunk
+		if n > len(data)-done {
+			n = len(data) - done
+		}
+		chunk := contents.chunks[chunkNum]
+		if len(chunk) < withinChunk+n {
+			// append, so that appending to a file is amortized O(1) rather than copying the chunk every time
+			chunk = append(chunk, make([]byte, withinChunk+n-len(chunk))...)
+		}
+		copy(chunk[withinChunk:], data[done:done+n])
+		contents.chunks[chunkNum] = chunk
+		done += n
+	}
+}
+
+// Make the file size bytes long, dropping whatever is past the new end, or adding a hole if it gets longer.
+func (contents *chunkedContents) truncate(size int) {
+	for chunkNum, chunk := range contents.chunks {
+		start := chunkNum * chunkSize
+		if start >= size {
+			delete(contents.chunks, chunkNum)
+		} else if start+len(chunk) > size {
+			contents.chunks[chunkNum] = chunk[:size-start]
+		}
+	}
+	contents.size = size
+}
+
+// Turn numBytes bytes starting at offset into a hole, freeing the chunks the range covers completely.
+// The size doesn't change, so the part of the range past the end of the file is ignored.
+// Requires 0 <= offset and 0 <= numBytes.
+func (contents *chunkedContents) punchHole(offset int, numBytes int) {
+	end := contents.size
+	if numBytes < contents.size-offset { // rather than offset+numBytes < contents.size, which can overflow
+		end = offset + numBytes
+	}
+	for chunkNum, chunk := range contents.chunks {
+		start := chunkNum * chunkSize
+		from, to := offset-start, end-start
+		if from < 0 {
+			from = 0
+		}
+		if to > len(chunk) {
+			to = len(chunk)
+		}
+		if from >= to {
+			// the range misses what this chunk holds
+			continue
+		}
+		if from == 0 && to == len(chunk) {
+			delete(contents.chunks, chunkNum)
+		} else if to == len(chunk) {
+			// whatever is past the end of a short chunk already reads as zeros
+			contents.chunks[chunkNum] = chunk[:from]
+		} else {
+			for i := from; i < to; i++ {
+				chunk[i] = 0
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package memoryFS
+
+import (
+	"bytes"
+	"filesystem"
+	"math/rand"
+	"testing"
+)
+
+// Check contents against what a plain []byte says it should be.
+func checkContents(t *testing.T, contents *chunkedContents, expected []byte) {
+	if contents.size != len(expected) {
+		t.Fatalf("size is %d, expected %d", contents.size, len(expected))
+	}
+	if data := contents.readAt(0, contents.size); !bytes.Equal(data, expected) {
+		t.Fatalf("the contents differ from what was written")
+	}
+	for chunkNum, chunk := range contents.chunks {
+		if len(chunk) == 0 || len(chunk) > chunkSize || chunkNum*chunkSize+len(chunk) > contents.size {
+			t.Fatalf("chunk %d is %d bytes long in a %d-byte file", chunkNum, len(chunk), contents.size)
+		}
+	}
+}
+
+func TestChunkedContentsRandomWrites(t *testing.T) {
+	var contents chunkedContents
+	var expected []byte
+	for i := 0; i < 200; i++ {
+		offset := rand.Intn(4 * chunkSize)
+		data := make([]byte, rand.Intn(2*chunkSize))
+		rand.Read(data)
+		if i%10 == 9 {
+			// sometimes truncate rather than write
+			contents.truncate(offset)
+			if offset < len(expected) {
+				expected = expected[:offset]
+			} else {
+				expected = append(expected, make([]byte, offset-len(expected))...)
+			}
+			checkContents(t, &contents, expected)
+			continue
+		}
+		if i%10 == 4 {
+			// or punch a hole
+			contents.punchHole(offset, len(data))
+			for j := offset; j < offset+len(data) && j < len(expected); j++ {
+				expected[j] = 0
+			}
+			checkContents(t, &contents, expected)
+			continue
+		}
+
+		contents.writeAt(offset, data)
+		if end := offset + len(data); end > len(expected) {
+			expected = append(expected, make([]byte, end-len(expected))...)
+		}
+		copy(expected[offset:], data)
+		checkContents(t, &contents, expected)
+
+		if len(expected) > 0 {
+			readOffset := rand.Intn(len(expected))
+			numBytes := rand.Intn(len(expected) - readOffset + 1)
+			if data := contents.readAt(readOffset, numBytes); !bytes.Equal(data,
+				expected[readOffset:readOffset+numBytes]) {
+				t.Fatalf("readAt(%d, %d) differs from what was written", readOffset, numBytes)
+			}
+		}
+	}
+}
+
+func TestWriteFarPastTheEndMakesAHole(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+	fd := filesystem.HelpOpen(t, &mfs, "/sparse", filesystem.ReadWrite, filesystem.Create)
+	const gigabyte = 1 << 30
+	filesystem.HelpSeek(t, &mfs, fd, gigabyte, filesystem.FromBeginning)
+	filesystem.HelpWriteString(t, &mfs, fd, "end")
+
+	file := mfs.activeFDs[fd]
+	if len(file.contents.chunks) != 1 {
+		t.Fatalf("a write past a 1GB hole made %d chunks, expected 1", len(file.contents.chunks))
+	}
+	if info, err := mfs.Stat("/sparse"); err != nil || info.Size != gigabyte+3 {
+		t.Fatalf("Stat() returned %+v, %v", info, err)
+	}
+	filesystem.HelpSeek(t, &mfs, fd, gigabyte-2, filesystem.FromBeginning)
+	if _, data := filesystem.HelpRead(t, &mfs, fd, 5); string(data) != "\x00\x00end" {
+		t.Fatalf("reading across the end of the hole returned %q", data)
+	}
+	filesystem.HelpSeek(t, &mfs, fd, 12345, filesystem.FromBeginning)
+	if _, data := filesystem.HelpRead(t, &mfs, fd, 3*chunkSize); !bytes.Equal(data, make([]byte, 3*chunkSize)) {
+		t.Fatalf("reading the hole returned something other than zeros")
+	}
+
+	// punching a hole over the chunk drops it.
+	_, err := mfs.Fallocate(fd, filesystem.PunchHole, gigabyte-chunkSize, 2*chunkSize)
+	if err != nil || len(file.contents.chunks) != 0 || file.contents.size != gigabyte+3 {
+		t.Fatalf("after punching a hole, the file is %d bytes in %d chunks, err=%v", file.contents.size,
+			len(file.contents.chunks), err)
+	}
+	filesystem.HelpClose(t, &mfs, fd)
+
+	// truncating drops the chunks.
+	fd = filesystem.HelpOpen(t, &mfs, "/sparse", filesystem.ReadWrite, filesystem.Truncate)
+	if file.contents.size != 0 || len(file.contents.chunks) != 0 {
+		t.Fatalf("after truncating, the file is %d bytes in %d chunks", file.contents.size, len(file.contents.chunks))
+	}
+	filesystem.HelpClose(t, &mfs, fd)
+}
@@ -3,6 +3,7 @@ package memoryFS
 import (
 	"ad"
 	"fmt"
+	"math"
 )
 
 // A directory in a filesystem.
@@ -11,6 +12,14 @@ import (
 type Directory struct {
 	inode    Inode
 	children map[string]Node
+	usage    treeUsage // the files and directories anywhere under this one, not counting itself
+	quota    treeUsage // the most usage may be, where 0 means no limit
+}
+
+// How many bytes and how many files and directories a directory tree holds, or may hold.
+type treeUsage struct {
+	bytes  int
+	inodes int
 }
 
 // Directory has no public constructor.
@@ -33,6 +42,19 @@ func (dir *Directory) CreateFile(childName string) *File {
 	return dir.createChild(childName, false).(*File)
 }
 
+// Creates a Symlink within this Directory named childName that points to target and returns it.
+// Panics if there is already a Node named childName in this directory.
+func (dir *Directory) CreateSymlink(childName string, target string) *Symlink {
+	if dir.HasChildNamed(childName) {
+		panic(fmt.Sprintf("Already has child named %v", childName))
+	}
+	ad.Debug(ad.TRACE, "Creating symlink named %v to %v", childName, target)
+	link := &Symlink{inode: Inode{name: childName, parent: dir}, target: target}
+	dir.children[childName] = link
+	dir.addUsage(0, 1)
+	return link
+}
+
 // Creates a child, either a File if isDirectory is false or a Directory otherwise.
 // Panics if there is already a Node named childName in this directory.
 func (dir *Directory) createChild(childName string, isDirectory bool) Node {
@@ -61,6 +83,7 @@ func (dir *Directory) createChild(childName string, isDirectory bool) Node {
 
 	// Finish up
 	dir.children[childName] = node
+	dir.addUsage(0, 1)
 	return node
 
 }
@@ -96,9 +119,79 @@ func (dir *Directory) GetChildNamed(childName string) Node {
 // See FileSystem::Delete.
 func (dir *Directory) Delete() (success bool, err error) {
 	ad.AssertExplain(len(dir.children) == 0, "Cannot delete a non-empty directory!")
+	dir.Parent().addUsage(0, -1)
 	return dir.inode.Delete()
 }
 
 func (dir *Directory) Parent() *Directory {
 	return dir.inode.Parent()
 }
+
+// Moves the child named childName into newParent, naming it newName there, and moves the usage it counts for from the
+// Directories above it to the ones above its new name.
+// Panics if there is no child named childName, or if newParent already has a child named newName.
+func (dir *Directory) moveChild(childName string, newParent *Directory, newName string) {
+	child := dir.GetChildNamed(childName)
+	if newParent.HasChildNamed(newName) {
+		panic(fmt.Sprintf("Already has child named %v", newName))
+	}
+	ad.Debug(ad.TRACE, "Moving child named %v to %v", childName, newName)
+	usage := usageOf(child)
+	delete(dir.children, childName)
+	dir.addUsage(-usage.bytes, -usage.inodes)
+	inode := inodeOf(child)
+	inode.name = newName
+	inode.parent = newParent
+	newParent.children[newName] = child
+	newParent.addUsage(usage.bytes, usage.inodes)
+}
+
+// The usage that node counts for in the Directories above it: itself and, for a Directory, everything under it.
+func usageOf(node Node) treeUsage {
+	switch node := node.(type) {
+	case *Directory:
+		return treeUsage{bytes: node.usage.bytes, inodes: node.usage.inodes + 1}
+	case *File:
+		return treeUsage{bytes: node.contents.size, inodes: 1}
+	}
+	return treeUsage{inodes: 1}
+}
+
+// Whether dir is ancestor or lies somewhere under it.
+func (dir *Directory) isUnder(ancestor *Directory) bool {
+	for ; dir != nil; dir = dir.Parent() {
+		if dir == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// Count bytes and inodes more (or fewer, if they are negative) under this Directory and every Directory above it.
+func (dir *Directory) addUsage(bytes int, inodes int) {
+	for ; dir != nil; dir = dir.Parent() {
+		dir.usage.bytes += bytes
+		dir.usage.inodes += inodes
+	}
+}
+
+// How many more bytes and inodes may go under this Directory before it or a Directory above it goes over its quota.
+// Either is math.MaxInt if nothing limits it, or negative if a quota is already exceeded.
+func (dir *Directory) roomUnderQuotas() (bytes int, inodes int) {
+	return dir.roomUnderQuotasBelow(nil)
+}
+
+// Like roomUnderQuotas, but only counting the quotas of this Directory and those above it that are under stop, so
+// not stop's or those above it.
+func (dir *Directory) roomUnderQuotasBelow(stop *Directory) (bytes int, inodes int) {
+	bytes, inodes = math.MaxInt, math.MaxInt
+	for ; dir != nil && dir != stop; dir = dir.Parent() {
+		if dir.quota.bytes > 0 && dir.quota.bytes-dir.usage.bytes < bytes {
+			bytes = dir.quota.bytes - dir.usage.bytes
+		}
+		if dir.quota.inodes > 0 && dir.quota.inodes-dir.usage.inodes < inodes {
+			inodes = dir.quota.inodes - dir.usage.inodes
+		}
+	}
+	return bytes, inodes
+}
@@ -15,13 +15,37 @@ type File struct {
 	inode    Inode
 	isOpen   bool
 	openMode filesystem.OpenMode
-	contents []byte
+	contents chunkedContents
 	offset   int        // Invariant: offset >= 0
 	lock     sync.Mutex // Invariant: the lock is held whenever isOpen is true.
 }
 
 // Construct a file by calling createFile(fileName string) in the desired parent directory.
 
+// How big a File may get under the filesystem's Limits, as worked out by MemoryFS.sizeLimitFor.
+type sizeLimit struct {
+	maxSize int   // the largest the file may get; never less than its size
+	err     error // what to return for a change that would need the file to get bigger than maxSize
+}
+
+// How many of numBytes bytes written at offset fit under the limit, which is all of them or as many as fit before
+// maxSize. Returns err if none do. Writing zero bytes does nothing, so they always fit, wherever they go.
+func (limit sizeLimit) bytesThatFit(offset int, numBytes int) (int, error) {
+	if numBytes == 0 {
+		return 0, nil
+	}
+	if numBytes > limit.maxSize-offset { // rather than offset+numBytes > limit.maxSize, which can overflow
+		if offset >= limit.maxSize {
+			ad.Debug(ad.TRACE, "Returning %v because the file can't get past %d bytes.", limit.err, limit.maxSize)
+			return -1, limit.err
+		}
+		ad.Debug(ad.TRACE, "Only writing %d of %d bytes because the file can't get past %d bytes.",
+			limit.maxSize-offset, numBytes, limit.maxSize)
+		return limit.maxSize - offset, nil
+	}
+	return numBytes, nil
+}
+
 // See Node::Name.
 func (file *File) Name() string {
 	return file.inode.Name()
@@ -38,11 +62,11 @@ func (file *File) Open(mode filesystem.OpenMode, flags filesystem.OpenFlags) (er
 	ad.Debug(ad.TRACE, "Got lock on file %s", file.Name())
 	file.openMode = mode
 	if filesystem.FlagIsSet(flags, filesystem.Truncate) {
-		file.contents = make([]byte, 0)
+		file.contents.truncate(0)
 		file.offset = 0
 	}
 	if filesystem.FlagIsSet(flags, filesystem.Append) {
-		file.offset = len(file.contents)
+		file.offset = file.contents.size
 	}
 	return nil
 }
@@ -66,7 +90,7 @@ func (file *File) Seek(offset int, base filesystem.SeekMode) (newPosition int, e
 	case filesystem.FromCurrent:
 		file.offset += offset
 	case filesystem.FromEnd:
-		file.offset = len(file.contents) + offset
+		file.offset = file.contents.size + offset
 	}
 
 	if file.offset < 0 {
@@ -86,30 +110,32 @@ func (file *File) Read(numBytes int) (bytesRead int, data []byte, err error) {
 	if file.openMode == filesystem.WriteOnly {
 		return -1, nil, filesystem.WrongMode
 	}
-	if numBytes == 0 || file.offset >= len(file.contents) {
+	bytesRead, data = file.readAt(file.offset, numBytes)
+	file.offset += bytesRead
+	return bytesRead, data, nil
+}
+
+// Copy up to numBytes bytes starting at offset out of the file, without using or changing the file offset.
+// Requires offset >= 0 and numBytes >= 0.
+func (file *File) readAt(offset int, numBytes int) (bytesRead int, data []byte) {
+	if numBytes == 0 || offset >= file.contents.size {
 		// This is specified to be a no-op.
-		return 0, make([]byte, 0), nil
+		return 0, make([]byte, 0)
 	}
 
-	if file.offset+numBytes <= len(file.contents) {
-		// We can read numBytes without hitting the end of the file.
+	if numBytes <= file.contents.size-offset {
+		// We can read numBytes without hitting the end of the file. (Comparing offset+numBytes
+		// against the size instead would overflow for huge numBytes.)
 		bytesRead = numBytes
-		data = make([]byte, bytesRead)
-		copy(data, file.contents[file.offset:file.offset+numBytes])
-		file.offset += bytesRead
 	} else {
 		// We can only read up to the end of the file.
-		bytesRead = len(file.contents) - file.offset
-		data = make([]byte, bytesRead)
-		copy(data, file.contents[file.offset:])
-		file.offset = len(file.contents)
+		bytesRead = file.contents.size - offset
 	}
-
-	return bytesRead, data, nil
+	return bytesRead, file.contents.readAt(offset, bytesRead)
 }
 
 // See FileSystem::Write.
-func (file *File) Write(numBytes int, data []byte) (bytesWritten int, err error) {
+func (file *File) Write(numBytes int, data []byte, limit sizeLimit) (bytesWritten int, err error) {
 	ad.Debug(ad.TRACE, "Beginning Write(%d, len(data)=%d)", numBytes, len(data))
 	if numBytes < 0 {
 		ad.Debug(ad.TRACE, "Returning IllegalArgument because numBytes is negative.")
@@ -120,30 +146,102 @@ func (file *File) Write(numBytes int, data []byte) (bytesWritten int, err error)
 		return -1, filesystem.WrongMode
 	}
 
-	// Two stages: first, make sure the file is long enough by adding zero bytes if necessary.
 	bytesWritten = numBytes
 	if len(data) < bytesWritten {
 		bytesWritten = len(data)
 	}
-	if file.offset+bytesWritten > len(file.contents) {
-		padZeroes := make([]byte, file.offset+bytesWritten-len(file.contents))
-		ad.Debug(ad.TRACE, "File offset is at %d and would write %d, but file is only %d bytes long. "+
-			"Padding space with %d+%d-%d=%d zero bytes.",
-			file.offset, bytesWritten, len(file.contents),
-			file.offset, bytesWritten, len(file.contents), len(padZeroes))
-		file.contents = append(file.contents, padZeroes...)
+	bytesWritten, err = limit.bytesThatFit(file.offset, bytesWritten)
+	if err != nil || bytesWritten == 0 {
+		return bytesWritten, err
 	}
-
-	// Then, overwrite bytes without having to worry about length.
-	copy(file.contents[file.offset:], data[:bytesWritten])
+	if file.offset > file.contents.size {
+		ad.Debug(ad.TRACE, "File offset is at %d, but file is only %d bytes long, so there will be a %d-byte hole.",
+			file.offset, file.contents.size, file.offset-file.contents.size)
+	}
+	file.contents.writeAt(file.offset, data[:bytesWritten])
 	file.offset += bytesWritten
-	ad.Assert(file.offset <= len(file.contents))
+	ad.Assert(file.offset <= file.contents.size)
 	ad.Debug(ad.TRACE, "Done writing %d bytes, offset now at %d", bytesWritten, file.offset)
 	return bytesWritten, nil
 }
 
+// See FileSystem::Pread.
+func (file *File) Pread(offset int, numBytes int) (bytesRead int, data []byte, err error) {
+	if offset < 0 || numBytes < 0 {
+		return -1, nil, filesystem.IllegalArgument
+	}
+	if file.openMode == filesystem.WriteOnly {
+		return -1, nil, filesystem.WrongMode
+	}
+	bytesRead, data = file.readAt(offset, numBytes)
+	return bytesRead, data, nil
+}
+
+// See FileSystem::Pwrite.
+func (file *File) Pwrite(offset int, data []byte, limit sizeLimit) (bytesWritten int, err error) {
+	if offset < 0 {
+		return -1, filesystem.IllegalArgument
+	}
+	if file.openMode == filesystem.ReadOnly {
+		return -1, filesystem.WrongMode
+	}
+	bytesWritten, err = limit.bytesThatFit(offset, len(data))
+	if err != nil || bytesWritten == 0 {
+		return bytesWritten, err
+	}
+	file.contents.writeAt(offset, data[:bytesWritten])
+	return bytesWritten, nil
+}
+
+// See FileSystem::Truncate. Unlike the other methods, this does not need the file to be open.
+func (file *File) Truncate(size int, limit sizeLimit) (success bool, err error) {
+	if size < 0 {
+		return false, filesystem.IllegalArgument
+	}
+	if size > limit.maxSize {
+		return false, limit.err
+	}
+	file.contents.truncate(size)
+	return true, nil
+}
+
+// See FileSystem::Ftruncate.
+func (file *File) Ftruncate(size int, limit sizeLimit) (success bool, err error) {
+	if file.openMode == filesystem.ReadOnly {
+		return false, filesystem.WrongMode
+	}
+	return file.Truncate(size, limit)
+}
+
+// See FileSystem::Fallocate.
+func (file *File) Fallocate(mode filesystem.FallocateMode, offset int, length int, limit sizeLimit) (success bool,
+	err error) {
+	if offset < 0 || length <= 0 {
+		return false, filesystem.IllegalArgument
+	}
+	if file.openMode == filesystem.ReadOnly {
+		return false, filesystem.WrongMode
+	}
+	switch mode {
+	case filesystem.Allocate:
+		// Holes take no space here, so there is nothing to reserve beyond the size.
+		if length > limit.maxSize-offset { // rather than offset+length > limit.maxSize, which can overflow
+			return false, limit.err
+		}
+		if offset+length > file.contents.size {
+			file.contents.truncate(offset + length)
+		}
+	case filesystem.PunchHole:
+		file.contents.punchHole(offset, length)
+	default:
+		return false, filesystem.IllegalArgument
+	}
+	return true, nil
+}
+
 // See FileSystem::Delete.
 func (file *File) Delete() (success bool, err error) {
+	file.Parent().addUsage(-file.contents.size, -1)
 	return file.inode.Delete()
 }
 
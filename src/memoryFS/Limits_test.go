@@ -0,0 +1,193 @@
+package memoryFS
+
+import (
+	"filesystem"
+	"testing"
+)
+
+func checkUsage(t *testing.T, mfs *MemoryFS, expectedInodes int, expectedBytes int) {
+	info, err := mfs.Statfs()
+	if err != nil || info.Inodes != expectedInodes || info.Bytes != expectedBytes {
+		t.Fatalf("Statfs() returned %+v, %v, expected %d inodes and %d bytes", info, err, expectedInodes,
+			expectedBytes)
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+	mfs.SetLimits(filesystem.Limits{MaxFileSize: 10})
+	fd := filesystem.HelpOpen(t, &mfs, "/file", filesystem.ReadWrite, filesystem.Create)
+
+	// a write that would go past the limit writes what fits.
+	if n, err := mfs.Write(fd, 8, []byte("01234567")); n != 8 || err != nil {
+		t.Fatalf("Write() returned %d, %v", n, err)
+	}
+	if n, err := mfs.Write(fd, 5, []byte("89abc")); n != 2 || err != nil {
+		t.Fatalf("a partial Write() returned %d, %v, expected 2 bytes", n, err)
+	}
+	if n, err := mfs.Write(fd, 1, []byte("d")); n != -1 || err != filesystem.FileTooLarge {
+		t.Fatalf("Write() at the limit returned %d, %v", n, err)
+	}
+	if n, err := mfs.Pwrite(fd, 12, []byte("e")); n != -1 || err != filesystem.FileTooLarge {
+		t.Fatalf("Pwrite() past the limit returned %d, %v", n, err)
+	}
+	if n, err := mfs.Pwrite(fd, 0, []byte("abc")); n != 3 || err != nil {
+		t.Fatalf("Pwrite() within the file returned %d, %v", n, err)
+	}
+
+	// writing nothing is fine wherever it happens, and Write and Pwrite agree about that.
+	if n, err := mfs.Write(fd, 0, []byte{}); n != 0 || err != nil {
+		t.Fatalf("an empty Write() at the limit returned %d, %v", n, err)
+	}
+	if n, err := mfs.Pwrite(fd, 10, []byte{}); n != 0 || err != nil {
+		t.Fatalf("an empty Pwrite() at the limit returned %d, %v", n, err)
+	}
+	filesystem.HelpSeek(t, &mfs, fd, 12, filesystem.FromBeginning)
+	if n, err := mfs.Write(fd, 0, []byte{}); n != 0 || err != nil {
+		t.Fatalf("an empty Write() past the limit returned %d, %v", n, err)
+	}
+	if n, err := mfs.Pwrite(fd, 12, []byte{}); n != 0 || err != nil {
+		t.Fatalf("an empty Pwrite() past the limit returned %d, %v", n, err)
+	}
+	if _, err := mfs.Ftruncate(fd, 11); err != filesystem.FileTooLarge {
+		t.Fatalf("Ftruncate() past the limit returned %v", err)
+	}
+	if _, err := mfs.Fallocate(fd, filesystem.Allocate, 5, 6); err != filesystem.FileTooLarge {
+		t.Fatalf("Fallocate() past the limit returned %v", err)
+	}
+	if _, data, _ := mfs.Pread(fd, 0, 20); string(data) != "abc3456789" {
+		t.Fatalf("the file holds %q", data)
+	}
+	checkUsage(t, &mfs, 2, 10)
+
+	// shrinking is always fine.
+	if _, err := mfs.Truncate("/file", 4); err != nil {
+		t.Fatalf("Truncate() failed: %v", err)
+	}
+	checkUsage(t, &mfs, 2, 4)
+	filesystem.HelpClose(t, &mfs, fd)
+}
+
+func TestMaxBytes(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+	mfs.SetLimits(filesystem.Limits{MaxBytes: 10, MaxFileSize: 8})
+	first := filesystem.HelpOpen(t, &mfs, "/first", filesystem.ReadWrite, filesystem.Create)
+	second := filesystem.HelpOpen(t, &mfs, "/second", filesystem.ReadWrite, filesystem.Create)
+	filesystem.HelpWriteString(t, &mfs, first, "123456")
+
+	// whichever limit is closer decides the error.
+	if n, err := mfs.Write(second, 6, []byte("abcdef")); n != 4 || err != nil {
+		t.Fatalf("a partial Write() returned %d, %v, expected 4 bytes", n, err)
+	}
+	if n, err := mfs.Write(second, 1, []byte("g")); n != -1 || err != filesystem.NoMoreSpace {
+		t.Fatalf("Write() on a full filesystem returned %d, %v", n, err)
+	}
+	if _, err := mfs.Ftruncate(first, 9); err != filesystem.NoMoreSpace {
+		t.Fatalf("Ftruncate() past both limits returned %v", err)
+	}
+	checkUsage(t, &mfs, 3, 10)
+
+	// deleting an open file frees its bytes only once it is closed.
+	filesystem.HelpDelete(t, &mfs, "/first")
+	checkUsage(t, &mfs, 3, 10)
+	if n, err := mfs.Write(second, 1, []byte("g")); n != -1 || err != filesystem.NoMoreSpace {
+		t.Fatalf("Write() returned %d, %v while a deleted file is open", n, err)
+	}
+	filesystem.HelpClose(t, &mfs, first)
+	checkUsage(t, &mfs, 2, 4)
+	filesystem.HelpWriteString(t, &mfs, second, "ghij")
+	checkUsage(t, &mfs, 2, 8)
+	if _, err := mfs.Ftruncate(second, 9); err != filesystem.FileTooLarge {
+		t.Fatalf("Ftruncate() past MaxFileSize returned %v", err)
+	}
+
+	// so does opening with Truncate.
+	filesystem.HelpClose(t, &mfs, second)
+	second = filesystem.HelpOpen(t, &mfs, "/second", filesystem.ReadWrite, filesystem.Truncate)
+	checkUsage(t, &mfs, 2, 0)
+	filesystem.HelpClose(t, &mfs, second)
+}
+
+func TestMaxInodesAndDirEntries(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+	mfs.SetLimits(filesystem.Limits{MaxInodes: 4, MaxDirEntries: 2})
+	filesystem.HelpMkdir(t, &mfs, "/a")
+	filesystem.HelpMkdir(t, &mfs, "/b")
+	if _, err := mfs.Mkdir("/c"); err != filesystem.NoMoreSpace {
+		t.Fatalf("Mkdir() in a full directory returned %v", err)
+	}
+	if fd, err := mfs.Open("/c", filesystem.ReadWrite, filesystem.Create); fd != -1 || err != filesystem.NoMoreSpace {
+		t.Fatalf("Open(Create) in a full directory returned %d, %v", fd, err)
+	}
+	fd := filesystem.HelpOpen(t, &mfs, "/a/file", filesystem.ReadWrite, filesystem.Create)
+	filesystem.HelpClose(t, &mfs, fd)
+	if _, err := mfs.Mkdir("/b/dir"); err != filesystem.NoMoreSpace {
+		t.Fatalf("Mkdir() with no inodes left returned %v", err)
+	}
+	checkUsage(t, &mfs, 4, 0)
+
+	// opening what already exists doesn't need room.
+	fd = filesystem.HelpOpen(t, &mfs, "/a/file", filesystem.ReadWrite, filesystem.Create)
+	filesystem.HelpClose(t, &mfs, fd)
+
+	filesystem.HelpDelete(t, &mfs, "/a/file")
+	filesystem.HelpMkdir(t, &mfs, "/b/dir")
+	checkUsage(t, &mfs, 4, 0)
+}
+
+func TestLimitsAfterRestoring(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+	filesystem.HelpMkdir(t, &mfs, "/dir")
+	fd := filesystem.HelpOpen(t, &mfs, "/dir/file", filesystem.ReadWrite, filesystem.Create)
+	filesystem.HelpWriteString(t, &mfs, fd, "hello")
+
+	restored := roundTrip(t, &mfs)
+	checkUsage(t, &restored, 3, 5)
+	if info, _ := restored.Statfs(); info.Limits != (filesystem.Limits{}) {
+		t.Fatalf("a restored MemoryFS has Limits %+v", info.Limits)
+	}
+	restored.SetLimits(filesystem.Limits{MaxBytes: 7})
+	if n, err := restored.Write(fd, 5, []byte("world")); n != 2 || err != nil {
+		t.Fatalf("a partial Write() after restoring returned %d, %v", n, err)
+	}
+	checkUsage(t, &restored, 3, 7)
+}
+
+func TestQuotaOfDeletedOpenFile(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+	filesystem.HelpMkdir(t, &mfs, "/dir")
+	mfs.SetQuota("/dir", 10, 0)
+	fd := filesystem.HelpOpen(t, &mfs, "/dir/file", filesystem.ReadWrite, filesystem.Create)
+	filesystem.HelpWriteString(t, &mfs, fd, "hello")
+
+	// the file leaves the quota as soon as it is deleted, and growing it afterwards isn't charged to the directory.
+	filesystem.HelpDelete(t, &mfs, "/dir/file")
+	filesystem.HelpWriteString(t, &mfs, fd, " world, which is more than ten bytes")
+	if quota, err := mfs.GetQuota("/dir"); err != nil || quota.Bytes != 0 || quota.Inodes != 0 {
+		t.Fatalf("GetQuota() returned %+v, %v", quota, err)
+	}
+	checkUsage(t, &mfs, 3, 41)
+	filesystem.HelpClose(t, &mfs, fd)
+	checkUsage(t, &mfs, 2, 0)
+}
+
+func TestQuotasAfterRestoring(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+	filesystem.HelpMkdir(t, &mfs, "/dir")
+	filesystem.HelpMkdir(t, &mfs, "/dir/sub")
+	mfs.SetQuota("/", 0, 10)
+	mfs.SetQuota("/dir", 8, 0)
+	fd := filesystem.HelpOpen(t, &mfs, "/dir/sub/file", filesystem.ReadWrite, filesystem.Create)
+	filesystem.HelpWriteString(t, &mfs, fd, "hello")
+
+	restored := roundTrip(t, &mfs)
+	for _, path := range []string{"/", "/dir", "/dir/sub"} {
+		expected, _ := mfs.GetQuota(path)
+		if quota, err := restored.GetQuota(path); err != nil || quota != expected {
+			t.Fatalf("GetQuota(%v) returned %+v, %v after restoring, expected %+v", path, quota, err, expected)
+		}
+	}
+	if n, err := restored.Write(fd, 5, []byte("world")); n != 3 || err != nil {
+		t.Fatalf("a partial Write() after restoring returned %d, %v", n, err)
+	}
+}
@@ -1,10 +1,13 @@
 package memoryFS
 
-import "ad"
-
-// An abstraction of a File or a Directory.
-// Note that Node is implemented by *File and *Directory,
-// NOT by File or Directory.
+import (
+	"ad"
+	"fmt"
+)
+
+// An abstraction of a File, a Directory or a Symlink.
+// Note that Node is implemented by *File, *Directory and *Symlink,
+// NOT by File, Directory or Symlink.
 type Node interface {
 	// The name of this Node.
 	Name() string
@@ -19,7 +22,7 @@ type Node interface {
 }
 
 // An "abstract class" to hold shared implementations of the functions in Node.
-// Like File and Directory, *Inode implements Node but Inode (no pointer) does not.
+// Like File, Directory and Symlink, *Inode implements Node but Inode (no pointer) does not.
 type Inode struct {
 	name   string
 	parent *Directory
@@ -40,3 +43,16 @@ func (in *Inode) Delete() (success bool, err error) {
 func (in *Inode) Parent() *Directory {
 	return in.parent
 }
+
+// The Inode that holds node's name and parent.
+func inodeOf(node Node) *Inode {
+	switch node := node.(type) {
+	case *Directory:
+		return &node.inode
+	case *File:
+		return &node.inode
+	case *Symlink:
+		return &node.inode
+	}
+	panic(fmt.Sprintf("Unknown kind of Node %+v", node))
+}
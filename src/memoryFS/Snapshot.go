@@ -0,0 +1,149 @@
+package memoryFS
+
+import (
+	"ad"
+	"filesystem"
+	"sort"
+)
+
+// A copy of a MemoryFS that can be encoded with gob (or labgob), e.g. in a FileServer's snapshot.
+// MemoryFS itself can't be, because its fields are unexported and its files point back at their parents.
+type Snapshot struct {
+	Nodes               []SnapshotNode // every Node, parents before their children, with the root first
+	FDs                 []SnapshotFD   // the open file descriptors, in increasing order
+	SmallestAvailableFD int
+}
+
+// A file, directory or symlink in a Snapshot.
+type SnapshotNode struct {
+	Parent    int    // index into Nodes of the parent, or -1 for the root and for deleted files that are still open
+	Name      string // "" for the root
+	IsDir     bool
+	IsSymlink bool
+	Target    string         // a symlink's target; "" for files and directories
+	Size      int            // 0 for directories and symlinks
+	Chunks    map[int][]byte // a file's chunks, which leave out its holes; nil otherwise
+
+	QuotaBytes  int // a directory's quota, where 0 means no limit; 0 for files
+	QuotaInodes int
+}
+
+// An open file descriptor in a Snapshot.
+type SnapshotFD struct {
+	FD     int
+	Node   int // index into Nodes of the open file
+	Mode   filesystem.OpenMode
+	Offset int
+}
+
+// Copy the filesystem, including which files are open, into a Snapshot.
+// The Snapshot shares file contents with the filesystem, so encode it before changing the filesystem.
+func (mfs *MemoryFS) Snapshot() Snapshot {
+	snapshot := Snapshot{SmallestAvailableFD: mfs.smallestAvailableFD}
+	nodes := make([]Node, 0) // nodes[i] is the Node that snapshot.Nodes[i] describes
+	indices := make(map[Node]int)
+	add := func(node Node, parent int) int {
+		snapshotNode := SnapshotNode{Parent: parent, Name: node.Name()}
+		switch node := node.(type) {
+		case *Directory:
+			snapshotNode.IsDir = true
+			snapshotNode.QuotaBytes = node.quota.bytes
+			snapshotNode.QuotaInodes = node.quota.inodes
+		case *File:
+			snapshotNode.Size = node.contents.size
+			snapshotNode.Chunks = node.contents.chunks
+		case *Symlink:
+			snapshotNode.IsSymlink = true
+			snapshotNode.Target = node.target
+		}
+		indices[node] = len(nodes)
+		nodes = append(nodes, node)
+		snapshot.Nodes = append(snapshot.Nodes, snapshotNode)
+		return indices[node]
+	}
+
+	add(mfs.rootDir, -1)
+	for i := 0; i < len(nodes); i++ {
+		dir, isDirectory := nodes[i].(*Directory)
+		if !isDirectory {
+			continue
+		}
+		names := make([]string, 0, len(dir.children))
+		for name := range dir.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(dir.children[name], i)
+		}
+	}
+
+	fds := make([]int, 0, len(mfs.activeFDs))
+	for fd := range mfs.activeFDs {
+		fds = append(fds, fd)
+	}
+	sort.Ints(fds)
+	for _, fd := range fds {
+		file := mfs.activeFDs[fd]
+		index, found := indices[file]
+		if !found {
+			// deleted while it was open, so it's only reachable through its file descriptor.
+			index = add(file, -1)
+		}
+		snapshot.FDs = append(snapshot.FDs, SnapshotFD{FD: fd, Node: index, Mode: file.openMode, Offset: file.offset})
+	}
+	return snapshot
+}
+
+// Make a MemoryFS with the files, directories and open file descriptors in snapshot.
+// The Limits aren't part of a Snapshot, so the MemoryFS has none until SetLimits is called.
+func RestoreMemoryFS(snapshot Snapshot) MemoryFS {
+	mfs := CreateEmptyMemoryFS()
+	if len(snapshot.Nodes) == 0 {
+		return mfs
+	}
+	ad.Assert(snapshot.Nodes[0].IsDir && snapshot.Nodes[0].Parent == -1)
+
+	nodes := make([]Node, len(snapshot.Nodes))
+	nodes[0] = mfs.rootDir
+	for i, snapshotNode := range snapshot.Nodes[1:] {
+		i++ // because of the [1:]
+		if snapshotNode.Parent == -1 {
+			// a deleted file that is still open, which isn't in any directory.
+			nodes[i] = &File{inode: Inode{name: snapshotNode.Name}, contents: snapshotNode.contents()}
+			continue
+		}
+		parent := nodes[snapshotNode.Parent].(*Directory)
+		if snapshotNode.IsDir {
+			dir := parent.CreateDir(snapshotNode.Name)
+			dir.quota = treeUsage{bytes: snapshotNode.QuotaBytes, inodes: snapshotNode.QuotaInodes}
+			nodes[i] = dir
+		} else if snapshotNode.IsSymlink {
+			nodes[i] = parent.CreateSymlink(snapshotNode.Name, snapshotNode.Target)
+		} else {
+			file := parent.CreateFile(snapshotNode.Name)
+			file.contents = snapshotNode.contents()
+			parent.addUsage(file.contents.size, 0)
+			nodes[i] = file
+		}
+	}
+	mfs.rootDir.quota = treeUsage{bytes: snapshot.Nodes[0].QuotaBytes, inodes: snapshot.Nodes[0].QuotaInodes}
+
+	for _, snapshotFD := range snapshot.FDs {
+		file := nodes[snapshotFD.Node].(*File)
+		err := file.Open(snapshotFD.Mode, 0)
+		ad.Assert(err == nil)
+		file.offset = snapshotFD.Offset
+		mfs.activeFDs[snapshotFD.FD] = file
+	}
+	mfs.smallestAvailableFD = snapshot.SmallestAvailableFD
+	mfs.inodesUsed = len(snapshot.Nodes)
+	for _, snapshotNode := range snapshot.Nodes {
+		mfs.bytesUsed += snapshotNode.Size
+	}
+	return mfs
+}
+
+func (snapshotNode SnapshotNode) contents() chunkedContents {
+	return chunkedContents{chunks: snapshotNode.Chunks, size: snapshotNode.Size}
+}
@@ -0,0 +1,130 @@
+package memoryFS
+
+import (
+	"bytes"
+	"filesystem"
+	"labgob"
+	"testing"
+)
+
+// Encode a snapshot of mfs with labgob, the way a FileServer does, and restore it.
+func roundTrip(t *testing.T, mfs *MemoryFS) MemoryFS {
+	buffer := new(bytes.Buffer)
+	if err := labgob.NewEncoder(buffer).Encode(mfs.Snapshot()); err != nil {
+		t.Fatalf("couldn't encode the snapshot: %v", err)
+	}
+	var snapshot Snapshot
+	if err := labgob.NewDecoder(buffer).Decode(&snapshot); err != nil {
+		t.Fatalf("couldn't decode the snapshot: %v", err)
+	}
+	return RestoreMemoryFS(snapshot)
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+	filesystem.HelpMkdir(t, &mfs, "/dir")
+	filesystem.HelpMkdir(t, &mfs, "/dir/sub")
+	fd := filesystem.HelpOpen(t, &mfs, "/dir/sub/file", filesystem.ReadWrite, filesystem.Create)
+	filesystem.HelpWriteString(t, &mfs, fd, "hello world")
+	filesystem.HelpSeek(t, &mfs, fd, 6, filesystem.FromBeginning)
+	deletedFD := filesystem.HelpOpen(t, &mfs, "/deleted", filesystem.ReadWrite, filesystem.Create)
+	filesystem.HelpWriteString(t, &mfs, deletedFD, "gone")
+	filesystem.HelpDelete(t, &mfs, "/deleted")
+	closedFD := filesystem.HelpOpen(t, &mfs, "/empty", filesystem.ReadOnly, filesystem.Create)
+	filesystem.HelpClose(t, &mfs, closedFD)
+
+	restored := roundTrip(t, &mfs)
+	for _, path := range []string{"/dir", "/dir/sub", "/dir/sub/file", "/empty"} {
+		expected, _ := mfs.Stat(path)
+		if info, err := restored.Stat(path); err != nil || info != expected {
+			t.Fatalf("Stat(%v) returned %+v, %v after restoring, expected %+v", path, info, err, expected)
+		}
+	}
+	if _, err := restored.Stat("/deleted"); err != filesystem.NotFound {
+		t.Fatalf("Stat() of a deleted file returned %v after restoring", err)
+	}
+	inodes, fileBytes := mfs.Usage()
+	if restoredInodes, restoredBytes := restored.Usage(); restoredInodes != inodes || restoredBytes != fileBytes {
+		t.Fatalf("Usage() returned %d, %d after restoring, expected %d, %d", restoredInodes, restoredBytes, inodes,
+			fileBytes)
+	}
+
+	// the open fds keep their offsets, and the deleted file can still be read through its fd.
+	if _, data, err := restored.Read(fd, 5); err != nil || string(data) != "world" {
+		t.Fatalf("Read() returned %q, %v after restoring", data, err)
+	}
+	filesystem.HelpSeek(t, &restored, deletedFD, 0, filesystem.FromBeginning)
+	if _, data, err := restored.Read(deletedFD, 4); err != nil || string(data) != "gone" {
+		t.Fatalf("Read() of a deleted file returned %q, %v after restoring", data, err)
+	}
+	if restored.NumOpenFiles() != 2 {
+		t.Fatalf("%d files are open after restoring, expected 2", restored.NumOpenFiles())
+	}
+
+	// the next fd is the one the original would have handed out.
+	expectedFD := filesystem.HelpOpen(t, &mfs, "/dir/sub/file2", filesystem.ReadOnly, filesystem.Create)
+	if nextFD := filesystem.HelpOpen(t, &restored, "/dir/sub/file2", filesystem.ReadOnly,
+		filesystem.Create); nextFD != expectedFD {
+		t.Fatalf("Open() returned fd %d after restoring, expected %d", nextFD, expectedFD)
+	}
+	filesystem.HelpClose(t, &restored, fd)
+	filesystem.HelpClose(t, &restored, deletedFD)
+}
+
+func TestSnapshotSymlinks(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+	filesystem.HelpMkdir(t, &mfs, "/dir")
+	fd := filesystem.HelpOpen(t, &mfs, "/dir/file", filesystem.WriteOnly, filesystem.Create)
+	filesystem.HelpWriteString(t, &mfs, fd, "linked")
+	filesystem.HelpClose(t, &mfs, fd)
+	filesystem.HelpSymlink(t, &mfs, "file", "/dir/relative")
+	filesystem.HelpSymlink(t, &mfs, "/dir", "/absolute")
+	filesystem.HelpSymlink(t, &mfs, "/missing", "/dangling")
+
+	restored := roundTrip(t, &mfs)
+	for path, target := range map[string]string{"/dir/relative": "file", "/absolute": "/dir", "/dangling": "/missing"} {
+		if restoredTarget, err := restored.Readlink(path); err != nil || restoredTarget != target {
+			t.Fatalf("Readlink(%v) returned %q, %v after restoring, expected %q", path, restoredTarget, err, target)
+		}
+	}
+	fd = filesystem.HelpOpen(t, &restored, "/absolute/relative", filesystem.ReadOnly, 0)
+	filesystem.HelpSeek(t, &restored, fd, 0, filesystem.FromBeginning)
+	if _, data, err := restored.Read(fd, 10); err != nil || string(data) != "linked" {
+		t.Fatalf("Read() through links returned %q, %v after restoring", data, err)
+	}
+	filesystem.HelpClose(t, &restored, fd)
+	inodes, _ := mfs.Usage()
+	if restoredInodes, _ := restored.Usage(); restoredInodes != inodes {
+		t.Fatalf("%d inodes are used after restoring, expected %d", restoredInodes, inodes)
+	}
+}
+
+func TestSnapshotEmpty(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+	restored := roundTrip(t, &mfs)
+	if entries, err := restored.ReadDir("/"); err != nil || len(entries) != 0 {
+		t.Fatalf("ReadDir(/) returned %v, %v after restoring an empty filesystem", entries, err)
+	}
+	restored = RestoreMemoryFS(Snapshot{})
+	if inodes, _ := restored.Usage(); inodes != 1 {
+		t.Fatalf("restoring a zero Snapshot made %d inodes, expected 1", inodes)
+	}
+}
+
+func TestSnapshotRootQuota(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+	filesystem.HelpMkdir(t, &mfs, "/dir")
+	restored := roundTrip(t, &mfs)
+	if success, err := restored.SetQuota("/", 100, 100); !success || err != nil {
+		t.Fatalf("SetQuota(/) failed after restoring: %v", err)
+	}
+
+	// the restored nodes must update the restored root's usage, not a stale copy of it.
+	filesystem.HelpMkdir(t, &restored, "/dir/sub")
+	fd := filesystem.HelpOpen(t, &restored, "/dir/sub/file", filesystem.WriteOnly, filesystem.Create)
+	filesystem.HelpWriteString(t, &restored, fd, "hello")
+	filesystem.HelpClose(t, &restored, fd)
+	if quota, err := restored.GetQuota("/"); err != nil || quota.Bytes != 5 || quota.Inodes != 3 {
+		t.Fatalf("GetQuota(/) returned %+v, %v after restoring, expected 5 bytes and 3 inodes", quota, err)
+	}
+}
@@ -0,0 +1,31 @@
+package memoryFS
+
+// A symbolic link in a filesystem, which holds the path of another Node.
+// This class extends Node.
+// This data structure is NOT THREADSAFE.
+type Symlink struct {
+	inode  Inode
+	target string // exactly as it was given to Symlink, and relative to the parent if it doesn't begin with "/"
+}
+
+// Symlink has no public constructor; use Directory::CreateSymlink.
+
+// See Node::Name.
+func (link *Symlink) Name() string {
+	return link.inode.Name()
+}
+
+// The path that this Symlink points to.
+func (link *Symlink) Target() string {
+	return link.target
+}
+
+// See FileSystem::Delete.
+func (link *Symlink) Delete() (success bool, err error) {
+	link.Parent().addUsage(0, -1)
+	return link.inode.Delete()
+}
+
+func (link *Symlink) Parent() *Directory {
+	return link.inode.Parent()
+}
@@ -3,7 +3,10 @@ package memoryFS
 import (
 	"ad"
 	"filesystem"
+	"fmt"
+	"math"
 	"path"
+	"sort"
 	"strings"
 )
 
@@ -13,7 +16,11 @@ type MemoryFS struct {
 	activeFDs           map[int]*File // A map from active file descriptors to open files.
 	smallestAvailableFD int           // The smallest positive number that is not 0, 1, 2, or an active file descriptor.
 	// (0, 1, and 2 are banned because they are reserved for stdin, stdout, and stderr)
-	rootDir Directory
+	rootDir *Directory // a pointer, so that copying a MemoryFS doesn't strand its children's parent pointers
+
+	limits     filesystem.Limits
+	bytesUsed  int // the sizes of all the files, including deleted ones that are still open
+	inodesUsed int // the files and directories, including the root and deleted files that are still open
 }
 
 // Create an empty in-memory FileSystem rooted at "/".
@@ -21,7 +28,8 @@ func CreateEmptyMemoryFS() MemoryFS {
 	mfs := MemoryFS{
 		activeFDs:           make(map[int]*File), //opened FDs ...
 		smallestAvailableFD: 3,
-		rootDir:             Directory{},
+		rootDir:             &Directory{},
+		inodesUsed:          1,
 	}
 	mfs.rootDir.inode = Inode{
 		name:   "",
@@ -37,13 +45,21 @@ func CreateEmptyMemoryFS() MemoryFS {
 func (mfs *MemoryFS) Mkdir(filePath string) (success bool, err error) {
 	ad.Debug(ad.TRACE, "Starting Mkdir(%v)", filePath)
 	success = false // in case we return early, set it here
-	currentDir, _, newDirName, existence := mfs.followPath(filePath)
+	currentDir, _, newDirName, existence, err := mfs.followPath(filePath, false)
+	if err != nil {
+		ad.Debug(ad.RPC, "Done with Mkdir(%v), returning (%t, %v)", filePath, success, err)
+		return
+	}
 	switch existence {
 	case NodeExists:
 		err = filesystem.AlreadyExists
 	case ParentExistsButNodeDoesNot:
+		if err = mfs.checkRoomForNode(currentDir); err != nil {
+			break
+		}
 		// true for directory instead of file
 		currentDir.createChild(newDirName, true)
+		mfs.inodesUsed++
 		success = true
 	case ParentDoesNotExist:
 		err = filesystem.NotFound
@@ -60,7 +76,11 @@ func (mfs *MemoryFS) Open(filePath string, mode filesystem.OpenMode, flags files
 	// function are evaluated at defer time, not at call time.
 	fileDescriptor = -1 // in case we return early, set it here
 
-	currentDir, node, fileName, existence := mfs.followPath(filePath)
+	currentDir, node, fileName, existence, err := mfs.followPath(filePath, true)
+	if err != nil {
+		ad.Debug(ad.RPC, "Done with Open(%v, %v, %v), returning (%v, %v)", filePath, mode.String(), flags, fileDescriptor, err)
+		return
+	}
 
 	switch existence {
 	case NodeExists:
@@ -68,7 +88,12 @@ func (mfs *MemoryFS) Open(filePath string, mode filesystem.OpenMode, flags files
 
 	case ParentExistsButNodeDoesNot:
 		if filesystem.FlagIsSet(flags, filesystem.Create) {
+			if err = mfs.checkRoomForNode(currentDir); err != nil {
+				ad.Debug(ad.RPC, "Done with Open(%v, %v, %v), returning (%v, %v)", filePath, mode.String(), flags, fileDescriptor, err)
+				return
+			}
 			currentDir.CreateFile(fileName)
+			mfs.inodesUsed++
 			// Set node here because node was set to nil above because it didn't exist
 			node = currentDir.GetChildNamed(fileName)
 		} else {
@@ -91,7 +116,9 @@ func (mfs *MemoryFS) Open(filePath string, mode filesystem.OpenMode, flags files
 		return
 	}
 
+	sizeBefore := file.contents.size
 	errFromFile := file.Open(mode, flags)
+	mfs.countResize(file, sizeBefore) // for the Truncate flag
 	if errFromFile != nil {
 		fileDescriptor = -1
 		err = errFromFile
@@ -142,6 +169,9 @@ func (mfs *MemoryFS) Close(fileDescriptor int) (success bool, err error) {
 	if success {
 		ad.Assert(err == nil)
 		delete(mfs.activeFDs, fileDescriptor)
+		if isDeleted(file) {
+			mfs.forgetNode(file)
+		}
 	}
 	// Maintain the invariant that smallestAvailableFD is actually the smallest
 	if fileDescriptor < mfs.smallestAvailableFD {
@@ -177,20 +207,43 @@ func (mfs *MemoryFS) Write(fileDescriptor int, numBytes int, data []byte) (bytes
 	if !fdIsActive {
 		return -1, filesystem.InactiveFD
 	}
-	return file.Write(numBytes, data)
+	defer mfs.countResize(file, file.contents.size)
+	return file.Write(numBytes, data, mfs.sizeLimitFor(file))
+}
+
+// See the spec for FileSystem::Pread.
+func (mfs *MemoryFS) Pread(fileDescriptor int, offset int, numBytes int) (bytesRead int, data []byte, err error) {
+	file, fdIsActive := mfs.activeFDs[fileDescriptor]
+	if !fdIsActive {
+		return -1, make([]byte, 0), filesystem.InactiveFD
+	}
+	return file.Pread(offset, numBytes)
+}
+
+// See the spec for FileSystem::Pwrite.
+func (mfs *MemoryFS) Pwrite(fileDescriptor int, offset int, data []byte) (bytesWritten int, err error) {
+	file, fdIsActive := mfs.activeFDs[fileDescriptor]
+	if !fdIsActive {
+		return -1, filesystem.InactiveFD
+	}
+	defer mfs.countResize(file, file.contents.size)
+	return file.Pwrite(offset, data, mfs.sizeLimitFor(file))
 }
 
 // See the spec for FileSystem::Delete.
 func (mfs *MemoryFS) Delete(filePath string) (success bool, err error) {
 	ad.Debug(ad.TRACE, "Starting Delete(%v)", filePath)
-	if filePath == "/" {
-		ad.Debug(ad.RPC, "Returning IllegalArgument to Delete(\"/\")")
+	currentDir, node, nodeName, existence, err := mfs.followPath(filePath, false)
+	ad.Debug(ad.TRACE, "Got currentDir=%+v, node=%+v, nodeName=%v, existence=%v", currentDir, node, nodeName, existence)
+	if err != nil {
+		ad.Debug(ad.RPC, "Done with Delete(%v), returning (%t, %s)", filePath, success, err)
+		return false, err
+	}
+	if node == mfs.rootDir {
+		ad.Debug(ad.RPC, "Returning IllegalArgument to Delete(%v) of the root", filePath)
 		return false, filesystem.IllegalArgument
 	}
 
-	currentDir, node, nodeName, existence := mfs.followPath(filePath)
-	ad.Debug(ad.TRACE, "Got currentDir=%+v, node=%+v, nodeName=%v, existence=%v", currentDir, node, nodeName, existence)
-
 	switch existence {
 	case NodeExists:
 		// proceed as normal
@@ -212,53 +265,498 @@ func (mfs *MemoryFS) Delete(filePath string) (success bool, err error) {
 	}
 
 	node.Delete()
+	if file, isFile := node.(*File); !isFile || !file.isOpen {
+		// an open file still counts until it is closed
+		mfs.forgetNode(node)
+	}
 	ad.Debug(ad.RPC, "Done with Delete(%v), returning (%t, %s)", filePath, success, err)
 	return true, nil
 }
 
+// See the spec for FileSystem::Rename.
+func (mfs *MemoryFS) Rename(from string, to string) (success bool, err error) {
+	ad.Debug(ad.TRACE, "Starting Rename(%v, %v)", from, to)
+	err = mfs.rename(from, to)
+	ad.Debug(ad.RPC, "Done with Rename(%v, %v), returning %v", from, to, err)
+	return err == nil, err
+}
+
+func (mfs *MemoryFS) rename(from string, to string) error {
+	oldParent, node, oldName, existence, err := mfs.followPath(from, false)
+	if err != nil {
+		return err
+	}
+	if existence != NodeExists {
+		return filesystem.NotFound
+	}
+	newParent, replaced, newName, existence, err := mfs.followPath(to, false)
+	if err != nil {
+		return err
+	}
+	if existence == ParentDoesNotExist {
+		return filesystem.NotFound
+	}
+	if node == mfs.rootDir || replaced == mfs.rootDir {
+		ad.Debug(ad.TRACE, "Can't rename the root, or rename something over it")
+		return filesystem.IllegalArgument
+	}
+	if replaced == node {
+		return nil
+	}
+	if dir, isDirectory := node.(*Directory); isDirectory && newParent.isUnder(dir) {
+		ad.Debug(ad.TRACE, "Can't move %v into itself", from)
+		return filesystem.IllegalArgument
+	}
+
+	var freed treeUsage
+	if replaced != nil {
+		_, nodeIsDirectory := node.(*Directory)
+		replacedDir, replacedIsDirectory := replaced.(*Directory)
+		if nodeIsDirectory != replacedIsDirectory {
+			ad.Debug(ad.TRACE, "Can't replace %v with %v when only one is a directory", to, from)
+			return filesystem.IsDirectory
+		}
+		if replacedIsDirectory && len(replacedDir.children) > 0 {
+			return filesystem.DirectoryNotEmpty
+		}
+		freed = usageOf(replaced)
+	} else if newParent != oldParent && mfs.limits.MaxDirEntries > 0 &&
+		len(newParent.children) >= mfs.limits.MaxDirEntries {
+		ad.Debug(ad.TRACE, "Directory %v already has %d entries", newParent.Name(), len(newParent.children))
+		return filesystem.NoMoreSpace
+	}
+
+	// only the quotas of the directories that the node joins without having been under them already can be exceeded.
+	common := newParent
+	for !oldParent.isUnder(common) {
+		common = common.Parent()
+	}
+	moved := usageOf(node)
+	roomBytes, roomInodes := newParent.roomUnderQuotasBelow(common)
+	if bytes := moved.bytes - freed.bytes; bytes > 0 && bytes > roomBytes {
+		ad.Debug(ad.TRACE, "Moving %d bytes to %v would go over a quota", bytes, to)
+		return filesystem.NoMoreSpace
+	}
+	if inodes := moved.inodes - freed.inodes; inodes > 0 && inodes > roomInodes {
+		ad.Debug(ad.TRACE, "Moving %d inodes to %v would go over a quota", inodes, to)
+		return filesystem.NoMoreSpace
+	}
+
+	if replaced != nil {
+		replaced.Delete()
+		if file, isFile := replaced.(*File); !isFile || !file.isOpen {
+			// an open file still counts until it is closed, as it does when it is deleted
+			mfs.forgetNode(replaced)
+		}
+	}
+	oldParent.moveChild(oldName, newParent, newName)
+	return nil
+}
+
+// See the spec for FileSystem::Stat.
+func (mfs *MemoryFS) Stat(filePath string) (info filesystem.FileInfo, err error) {
+	node, err := mfs.findNode(filePath, true)
+	if err != nil {
+		ad.Debug(ad.RPC, "Done with Stat(%v), returning %v", filePath, err)
+		return filesystem.FileInfo{}, err
+	}
+	info = describeNode(node)
+	ad.Debug(ad.RPC, "Done with Stat(%v), returning %+v", filePath, info)
+	return info, nil
+}
+
+// See the spec for FileSystem::Lstat.
+func (mfs *MemoryFS) Lstat(filePath string) (info filesystem.FileInfo, err error) {
+	node, err := mfs.findNode(filePath, false)
+	if err != nil {
+		ad.Debug(ad.RPC, "Done with Lstat(%v), returning %v", filePath, err)
+		return filesystem.FileInfo{}, err
+	}
+	info = describeNode(node)
+	ad.Debug(ad.RPC, "Done with Lstat(%v), returning %+v", filePath, info)
+	return info, nil
+}
+
+// See the spec for FileSystem::Symlink.
+func (mfs *MemoryFS) Symlink(target string, linkPath string) (success bool, err error) {
+	ad.Debug(ad.TRACE, "Starting Symlink(%v, %v)", target, linkPath)
+	if target == "" || len(target) > filesystem.MaxPathLength || strings.IndexByte(target, 0) >= 0 {
+		ad.Debug(ad.RPC, "Done with Symlink(%q, %v), returning IllegalArgument", target, linkPath)
+		return false, filesystem.IllegalArgument
+	}
+	currentDir, _, linkName, existence, err := mfs.followPath(linkPath, false)
+	if err != nil {
+		ad.Debug(ad.RPC, "Done with Symlink(%v, %v), returning %v", target, linkPath, err)
+		return false, err
+	}
+	switch existence {
+	case NodeExists:
+		err = filesystem.AlreadyExists
+	case ParentExistsButNodeDoesNot:
+		if err = mfs.checkRoomForNode(currentDir); err != nil {
+			break
+		}
+		currentDir.CreateSymlink(linkName, target)
+		mfs.inodesUsed++
+		success = true
+	case ParentDoesNotExist:
+		err = filesystem.NotFound
+	}
+	ad.Debug(ad.RPC, "Done with Symlink(%v, %v), returning (%t, %v)", target, linkPath, success, err)
+	return success, err
+}
+
+// See the spec for FileSystem::Readlink.
+func (mfs *MemoryFS) Readlink(filePath string) (target string, err error) {
+	node, err := mfs.findNode(filePath, false)
+	if err != nil {
+		ad.Debug(ad.RPC, "Done with Readlink(%v), returning %v", filePath, err)
+		return "", err
+	}
+	link, isSymlink := node.(*Symlink)
+	if !isSymlink {
+		ad.Debug(ad.RPC, "Done with Readlink(%v), returning IllegalArgument because it isn't a symlink", filePath)
+		return "", filesystem.IllegalArgument
+	}
+	ad.Debug(ad.RPC, "Done with Readlink(%v), returning %v", filePath, link.target)
+	return link.target, nil
+}
+
+// See the spec for FileSystem::ReadDir.
+func (mfs *MemoryFS) ReadDir(filePath string) (entries []filesystem.FileInfo, err error) {
+	node, err := mfs.findNode(filePath, true)
+	if err != nil {
+		ad.Debug(ad.RPC, "Done with ReadDir(%v), returning %v", filePath, err)
+		return make([]filesystem.FileInfo, 0), err
+	}
+	dir, isDirectory := node.(*Directory)
+	if !isDirectory {
+		ad.Debug(ad.RPC, "Done with ReadDir(%v), returning NotFound because it is a file", filePath)
+		return make([]filesystem.FileInfo, 0), filesystem.NotFound
+	}
+
+	names := make([]string, 0, len(dir.children))
+	for name := range dir.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries = make([]filesystem.FileInfo, len(names))
+	for i, name := range names {
+		entries[i] = describeNode(dir.children[name])
+	}
+	ad.Debug(ad.RPC, "Done with ReadDir(%v), returning %d entries", filePath, len(entries))
+	return entries, nil
+}
+
+// See the spec for FileSystem::Truncate.
+func (mfs *MemoryFS) Truncate(filePath string, size int) (success bool, err error) {
+	node, err := mfs.findNode(filePath, true)
+	if err != nil {
+		ad.Debug(ad.RPC, "Done with Truncate(%v, %d), returning %v", filePath, size, err)
+		return false, err
+	}
+	file, isFile := node.(*File)
+	if !isFile {
+		ad.Debug(ad.RPC, "Done with Truncate(%v, %d), returning IsDirectory", filePath, size)
+		return false, filesystem.IsDirectory
+	}
+	defer mfs.countResize(file, file.contents.size)
+	success, err = file.Truncate(size, mfs.sizeLimitFor(file))
+	ad.Debug(ad.RPC, "Done with Truncate(%v, %d), returning (%t, %v)", filePath, size, success, err)
+	return success, err
+}
+
+// See the spec for FileSystem::Ftruncate.
+func (mfs *MemoryFS) Ftruncate(fileDescriptor int, size int) (success bool, err error) {
+	file, fdIsActive := mfs.activeFDs[fileDescriptor]
+	if !fdIsActive {
+		return false, filesystem.InactiveFD
+	}
+	defer mfs.countResize(file, file.contents.size)
+	success, err = file.Ftruncate(size, mfs.sizeLimitFor(file))
+	ad.Debug(ad.RPC, "Done with Ftruncate(%d, %d), returning (%t, %v)", fileDescriptor, size, success, err)
+	return success, err
+}
+
+// See the spec for FileSystem::Fallocate.
+func (mfs *MemoryFS) Fallocate(fileDescriptor int, mode filesystem.FallocateMode, offset int, length int) (success bool, err error) {
+	file, fdIsActive := mfs.activeFDs[fileDescriptor]
+	if !fdIsActive {
+		return false, filesystem.InactiveFD
+	}
+	defer mfs.countResize(file, file.contents.size)
+	success, err = file.Fallocate(mode, offset, length, mfs.sizeLimitFor(file))
+	ad.Debug(ad.RPC, "Done with Fallocate(%d, %v, %d, %d), returning (%t, %v)", fileDescriptor, mode, offset, length,
+		success, err)
+	return success, err
+}
+
+// See the spec for FileSystem::Statfs.
+func (mfs *MemoryFS) Statfs() (info filesystem.FsInfo, err error) {
+	info = filesystem.FsInfo{Bytes: mfs.bytesUsed, Inodes: mfs.inodesUsed, Limits: mfs.limits}
+	ad.Debug(ad.RPC, "Done with Statfs(), returning %+v", info)
+	return info, nil
+}
+
+// See the spec for FileSystem::SetQuota.
+func (mfs *MemoryFS) SetQuota(filePath string, maxBytes int, maxInodes int) (success bool, err error) {
+	if maxBytes < 0 || maxInodes < 0 {
+		ad.Debug(ad.RPC, "Done with SetQuota(%v, %d, %d), returning IllegalArgument", filePath, maxBytes, maxInodes)
+		return false, filesystem.IllegalArgument
+	}
+	dir, err := mfs.findDirectory(filePath)
+	if err != nil {
+		ad.Debug(ad.RPC, "Done with SetQuota(%v, %d, %d), returning %v", filePath, maxBytes, maxInodes, err)
+		return false, err
+	}
+	dir.quota = treeUsage{bytes: maxBytes, inodes: maxInodes}
+	ad.Debug(ad.RPC, "Done with SetQuota(%v, %d, %d), returning success", filePath, maxBytes, maxInodes)
+	return true, nil
+}
+
+// See the spec for FileSystem::GetQuota.
+func (mfs *MemoryFS) GetQuota(filePath string) (quota filesystem.Quota, err error) {
+	dir, err := mfs.findDirectory(filePath)
+	if err != nil {
+		ad.Debug(ad.RPC, "Done with GetQuota(%v), returning %v", filePath, err)
+		return filesystem.Quota{}, err
+	}
+	quota = filesystem.Quota{MaxBytes: dir.quota.bytes, MaxInodes: dir.quota.inodes, Bytes: dir.usage.bytes,
+		Inodes: dir.usage.inodes}
+	ad.Debug(ad.RPC, "Done with GetQuota(%v), returning %+v", filePath, quota)
+	return quota, nil
+}
+
+// Other operations ===========================================================
+
+// Read up to numBytes bytes starting at offset from the file at filePath.
+//
+// Unlike Read, this does not need a file descriptor: the file does not have to be open, it is not opened, and no
+// file offset is used or changed. This lets a server answer reads from its local copy of the filesystem without
+// changing any state.
+// Possible errors are NotFound, IsDirectory, and IllegalArgument (if offset or numBytes is negative).
+// If err is non-nil, bytesRead is -1.
+func (mfs *MemoryFS) ReadPath(filePath string, offset int, numBytes int) (bytesRead int, data []byte, err error) {
+	if offset < 0 || numBytes < 0 {
+		return -1, make([]byte, 0), filesystem.IllegalArgument
+	}
+	node, err := mfs.findNode(filePath, true)
+	if err != nil {
+		return -1, make([]byte, 0), err
+	}
+	file, isFile := node.(*File)
+	if !isFile {
+		return -1, make([]byte, 0), filesystem.IsDirectory
+	}
+	bytesRead, data = file.readAt(offset, numBytes)
+	ad.Debug(ad.RPC, "Done with ReadPath(%v, %d, %d), read %d bytes", filePath, offset, numBytes, bytesRead)
+	return bytesRead, data, nil
+}
+
+// Count the files and directories in the filesystem (including the root), and the bytes stored in its files.
+// Like Statfs, this counts files that were deleted while they were open until they are closed.
+func (mfs *MemoryFS) Usage() (numInodes int, numBytes int) {
+	return mfs.inodesUsed, mfs.bytesUsed
+}
+
+// Set the Limits on how much the filesystem may hold. Lowering a limit below what the filesystem already holds
+// doesn't delete anything, but nothing can grow until it is back under the limit.
+func (mfs *MemoryFS) SetLimits(limits filesystem.Limits) {
+	mfs.limits = limits
+}
+
+// The number of file descriptors that are open.
+func (mfs *MemoryFS) NumOpenFiles() int {
+	return len(mfs.activeFDs)
+}
+
 // Private helper methods =====================================================
 
-// Follow a path.
-// Assuming the path points to a valid Node, returns that Node, its parent, and NodeExists.
+// Returns NoMoreSpace if making a file, directory or symlink in dir would go over MaxInodes, MaxDirEntries or a
+// quota.
+func (mfs *MemoryFS) checkRoomForNode(dir *Directory) error {
+	if mfs.limits.MaxInodes > 0 && mfs.inodesUsed >= mfs.limits.MaxInodes {
+		ad.Debug(ad.TRACE, "Out of inodes: %d of %d are used", mfs.inodesUsed, mfs.limits.MaxInodes)
+		return filesystem.NoMoreSpace
+	}
+	if mfs.limits.MaxDirEntries > 0 && len(dir.children) >= mfs.limits.MaxDirEntries {
+		ad.Debug(ad.TRACE, "Directory %v already has %d entries", dir.Name(), len(dir.children))
+		return filesystem.NoMoreSpace
+	}
+	if _, inodes := dir.roomUnderQuotas(); inodes < 1 {
+		ad.Debug(ad.TRACE, "Directory %v is at the inode quota of a directory above it", dir.Name())
+		return filesystem.NoMoreSpace
+	}
+	return nil
+}
+
+// How big file may get under MaxFileSize, MaxBytes and the quotas above it. It may always stay as big as it is.
+func (mfs *MemoryFS) sizeLimitFor(file *File) sizeLimit {
+	limit := sizeLimit{math.MaxInt, filesystem.FileTooLarge}
+	if mfs.limits.MaxFileSize > 0 {
+		limit = sizeLimit{mfs.limits.MaxFileSize, filesystem.FileTooLarge}
+	}
+	if mfs.limits.MaxBytes > 0 {
+		if maxSize := file.contents.size + mfs.limits.MaxBytes - mfs.bytesUsed; maxSize < limit.maxSize {
+			limit = sizeLimit{maxSize, filesystem.NoMoreSpace}
+		}
+	}
+	if !isDeleted(file) {
+		if room, _ := file.Parent().roomUnderQuotas(); room != math.MaxInt && file.contents.size+room < limit.maxSize {
+			limit = sizeLimit{file.contents.size + room, filesystem.NoMoreSpace}
+		}
+	}
+	if limit.maxSize < file.contents.size {
+		limit.maxSize = file.contents.size
+	}
+	return limit
+}
+
+// Count the change in file's size since it was sizeBefore bytes long.
+func (mfs *MemoryFS) countResize(file *File, sizeBefore int) {
+	mfs.bytesUsed += file.contents.size - sizeBefore
+	if !isDeleted(file) {
+		file.Parent().addUsage(file.contents.size-sizeBefore, 0)
+	}
+}
+
+// Stop counting a Node that has been deleted, or a file that was deleted while it was open and has been closed.
+func (mfs *MemoryFS) forgetNode(node Node) {
+	mfs.inodesUsed--
+	if file, isFile := node.(*File); isFile {
+		mfs.bytesUsed -= file.contents.size
+	}
+}
+
+// Whether file has been deleted, even if it is still open.
+func isDeleted(file *File) bool {
+	parent := file.Parent()
+	return parent == nil || parent.children[file.Name()] != file
+}
+
+// Follow a path, after checking and cleaning it with filesystem.CleanPath.
+// Symlinks along the way are followed, and so is one at the end of the path if followLast is set; following more
+// than filesystem.MaxSymlinkDepth of them returns TooManyLinks.
+// If the path isn't well-formed, or a Symlink leads somewhere that isn't, returns the error from CleanPath,
+// parentDir=nil, node=nil, and existence=ParentDoesNotExist.
+// Assuming the path points to a valid Node, returns that Node, its parent, and NodeExists. For the root directory,
+// the parent is nil.
 // If the parent exists and is a Directory but it has no child with the specified name, then node=nil and existence=ParentExistsButNodeDoesNot
-// If the parent does not exist, parent is a File (not a Directory),  or the path is not well-formed,
-// returns parentDir=nil, node=nil, and existence=ParentDoesNotExist.
-// Regardless of existence, nodeName is the component of the path after the final "/".
-func (mfs *MemoryFS) followPath(filePath string) (parentDir *Directory, node Node, nodeName string, existence followPathResult) {
+// If the parent does not exist or parent is a File (not a Directory), returns parentDir=nil, node=nil, and
+// existence=ParentDoesNotExist.
+// Regardless of existence, nodeName is the last name in the path once the Symlinks are followed, or "" for the root.
+func (mfs *MemoryFS) followPath(filePath string, followLast bool) (parentDir *Directory, node Node, nodeName string,
+	existence followPathResult, err error) {
 	ad.Debug(ad.TRACE, "Following path %v", filePath)
-	dirPath, nodeName := path.Split(filePath)
-	if string(filePath[0]) != "/" {
-		return nil, nil, nodeName, ParentDoesNotExist
-	}
-	dirs := strings.Split(dirPath, "/")
-	dirs = dirs[1:] // Remove the empty string before the initial "/"
-
-	currentDir := &mfs.rootDir
-	// - 1 to get to the parent, we're not at the child yet
-	for _, dir := range dirs[:len(dirs)-1] {
-		ad.Debug(ad.TRACE, "currentDir=%+v", currentDir)
-		if currentDir.HasChildNamed(dir) {
-			child := currentDir.GetChildNamed(dir)
-			childDir, childIsDirectory := child.(*Directory)
-			// if the child is a file but the path expects it to be a directory because there are more path components
-			if !childIsDirectory {
-				ad.Debug(ad.TRACE, "Child named %v is not a directory", dir)
-				return nil, nil, nodeName, ParentDoesNotExist
+	for linksFollowed := 0; ; linksFollowed++ {
+		if linksFollowed > filesystem.MaxSymlinkDepth {
+			ad.Debug(ad.TRACE, "Followed too many symlinks, ending at %v", filePath)
+			return nil, nil, "", ParentDoesNotExist, filesystem.TooManyLinks
+		}
+		cleanPath, err := filesystem.CleanPath(filePath)
+		if err != nil {
+			ad.Debug(ad.TRACE, "Path %q is not valid: %v", filePath, err)
+			return nil, nil, "", ParentDoesNotExist, err
+		}
+		names := filesystem.SplitPath(cleanPath)
+		if len(names) == 0 {
+			return nil, mfs.rootDir, "", NodeExists, nil
+		}
+		nodeName = names[len(names)-1]
+
+		currentDir, nextPath := mfs.walk(names, followLast)
+		if nextPath != "" {
+			ad.Debug(ad.TRACE, "Following a symlink in %v to %v", filePath, nextPath)
+			filePath = nextPath
+			continue
+		}
+		if currentDir == nil {
+			return nil, nil, nodeName, ParentDoesNotExist, nil
+		}
+		if !currentDir.HasChildNamed(nodeName) {
+			ad.Debug(ad.TRACE, "Final child named %v does not exist, returning Parent exists but node does not", nodeName)
+			return currentDir, nil, nodeName, ParentExistsButNodeDoesNot, nil
+		}
+		ad.Debug(ad.TRACE, "Node %s exists", nodeName)
+		return currentDir, currentDir.GetChildNamed(nodeName), nodeName, NodeExists, nil
+	}
+}
+
+// Go down from the root through all but the last of names, and return the Directory that should hold the last.
+// If that runs into a Symlink, or the last name is a Symlink and followLast is set, returns the path that the walk
+// has to start over from instead: the link's target, followed by whatever names were left after the link.
+// If a name on the way is missing or a File, returns nil and "".
+func (mfs *MemoryFS) walk(names []string, followLast bool) (parentDir *Directory, nextPath string) {
+	currentDir := mfs.rootDir
+	dirPath := "/"
+	for i, name := range names {
+		child, exists := currentDir.children[name]
+		last := i == len(names)-1
+		if link, isSymlink := child.(*Symlink); isSymlink && (followLast || !last) {
+			target := link.target
+			if !strings.HasPrefix(target, "/") {
+				target = path.Join(dirPath, target)
 			}
-			currentDir = childDir
-		} else {
-			ad.Debug(ad.TRACE, "Child named %v does not exist", dir)
-			return nil, nil, nodeName, ParentDoesNotExist
+			return nil, path.Join(append([]string{target}, names[i+1:]...)...)
+		}
+		if last {
+			break
 		}
+		childDir, childIsDirectory := child.(*Directory)
+		// if the child is missing or is a file but the path expects it to be a directory because there are more path
+		// components
+		if !exists || !childIsDirectory {
+			ad.Debug(ad.TRACE, "Child named %v is not a directory", name)
+			return nil, ""
+		}
+		currentDir = childDir
+		dirPath = path.Join(dirPath, name)
+	}
+	return currentDir, ""
+}
+
+// Find the Node at filePath, which may be "/" for the root directory, following a Symlink at the end of the path if
+// followLast is set.
+// Returns NotFound if there is no such Node, or the error from followPath if the path isn't valid.
+func (mfs *MemoryFS) findNode(filePath string, followLast bool) (node Node, err error) {
+	_, node, _, existence, err := mfs.followPath(filePath, followLast)
+	if err != nil {
+		return nil, err
+	}
+	if existence != NodeExists {
+		return nil, filesystem.NotFound
 	}
+	return node, nil
+}
 
-	if !currentDir.HasChildNamed(nodeName) {
-		ad.Debug(ad.TRACE, "Final child named %v does not exist, returning Parent exists but node does not", nodeName)
-		return currentDir, nil, nodeName, ParentExistsButNodeDoesNot
+// Find the Directory at filePath. Returns NotFound if it is a file, like findNode does if there is nothing there.
+func (mfs *MemoryFS) findDirectory(filePath string) (dir *Directory, err error) {
+	node, err := mfs.findNode(filePath, true)
+	if err != nil {
+		return nil, err
+	}
+	dir, isDirectory := node.(*Directory)
+	if !isDirectory {
+		return nil, filesystem.NotFound
 	}
+	return dir, nil
+}
 
-	ad.Debug(ad.TRACE, "Node %s exists", nodeName)
-	return currentDir, currentDir.GetChildNamed(nodeName), nodeName, NodeExists
+// Describe a Node for Stat, Lstat and ReadDir.
+func describeNode(node Node) filesystem.FileInfo {
+	switch node := node.(type) {
+	case *Directory:
+		name := node.Name()
+		if node.Parent() == nil {
+			name = "/"
+		}
+		return filesystem.FileInfo{Name: name, IsDir: true}
+	case *File:
+		return filesystem.FileInfo{Name: node.Name(), Size: node.contents.size, IsOpen: node.isOpen}
+	case *Symlink:
+		return filesystem.FileInfo{Name: node.Name(), Size: len(node.target), IsSymlink: true}
+	}
+	panic(fmt.Sprintf("Unknown kind of Node %+v", node))
 }
 
 type followPathResult int
@@ -1,6 +1,6 @@
 // Code generated by generate_unit_tests.go. DO NOT EDIT.
 // This file contains a unit test for every functionality test (found in filesystem_tests.go).
-// Generated at Fri May 11 9:05:36 PM.
+// Generated at Mon Oct 19 11:57:01 AM.
 
 package memoryFS
 
@@ -297,3 +297,133 @@ func TestMemoryFS_TestMkdirNotFound(t *testing.T) {
         filesystem.TestMkdirNotFound(t, &mfs)
 }
 
+func TestMemoryFS_TestFallocatePunchHole(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestFallocatePunchHole(t, &mfs)
+}
+
+func TestMemoryFS_TestPathNormalization(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestPathNormalization(t, &mfs)
+}
+
+func TestMemoryFS_TestPathValidation(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestPathValidation(t, &mfs)
+}
+
+func TestMemoryFS_TestPathsThatNameTheRoot(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestPathsThatNameTheRoot(t, &mfs)
+}
+
+func TestMemoryFS_TestPreadPwrite(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestPreadPwrite(t, &mfs)
+}
+
+func TestMemoryFS_TestPreadPwriteErrors(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestPreadPwriteErrors(t, &mfs)
+}
+
+func TestMemoryFS_TestPreadPwriteHugeArguments(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestPreadPwriteHugeArguments(t, &mfs)
+}
+
+func TestMemoryFS_TestQuota(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestQuota(t, &mfs)
+}
+
+func TestMemoryFS_TestQuotaErrors(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestQuotaErrors(t, &mfs)
+}
+
+func TestMemoryFS_TestQuotaRename(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestQuotaRename(t, &mfs)
+}
+
+func TestMemoryFS_TestReadDir(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestReadDir(t, &mfs)
+}
+
+func TestMemoryFS_TestRename(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestRename(t, &mfs)
+}
+
+func TestMemoryFS_TestRenameErrors(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestRenameErrors(t, &mfs)
+}
+
+func TestMemoryFS_TestRenameOpenFile(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestRenameOpenFile(t, &mfs)
+}
+
+func TestMemoryFS_TestRenameReplace(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestRenameReplace(t, &mfs)
+}
+
+func TestMemoryFS_TestStat(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestStat(t, &mfs)
+}
+
+func TestMemoryFS_TestStatfs(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestStatfs(t, &mfs)
+}
+
+func TestMemoryFS_TestSymlinkAbsolute(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestSymlinkAbsolute(t, &mfs)
+}
+
+func TestMemoryFS_TestSymlinkDangling(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestSymlinkDangling(t, &mfs)
+}
+
+func TestMemoryFS_TestSymlinkErrors(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestSymlinkErrors(t, &mfs)
+}
+
+func TestMemoryFS_TestSymlinkItself(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestSymlinkItself(t, &mfs)
+}
+
+func TestMemoryFS_TestSymlinkLoop(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestSymlinkLoop(t, &mfs)
+}
+
+func TestMemoryFS_TestSymlinkRelative(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestSymlinkRelative(t, &mfs)
+}
+
+func TestMemoryFS_TestTruncateBelowOffset(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestTruncateBelowOffset(t, &mfs)
+}
+
+func TestMemoryFS_TestTruncateExtendsWithZeros(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestTruncateExtendsWithZeros(t, &mfs)
+}
+
+func TestMemoryFS_TestWriteAtHugeOffset(t *testing.T) {
+	mfs := CreateEmptyMemoryFS()
+        filesystem.TestWriteAtHugeOffset(t, &mfs)
+}
+
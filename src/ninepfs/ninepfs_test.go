@@ -0,0 +1,508 @@
+package ninepfs
+
+import (
+	"ad"
+	"bufio"
+	"filesystem"
+	"fsraft"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	if _, isSet := os.LookupEnv("DFS_DEFAULT_DEBUG_LEVEL"); !isSet {
+		ad.SetDebugLevel(ad.WARN)
+	}
+	os.Exit(m.Run())
+}
+
+// Three FileServers on localhost behind a 9P Server, so tests go 9P client -> server -> clerk -> Raft.
+type testCluster struct {
+	server *Server
+}
+
+func startTestCluster(t *testing.T) *testCluster {
+	raftCluster := fsraft.StartTestCluster(t, 3, fsraft.DefaultFileServerConfig())
+	server, err := Listen("127.0.0.1:0", func() filesystem.FileSystem {
+		return raftCluster.MakeClerk()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCluster{server}
+}
+
+func (cluster *testCluster) stop() {
+	cluster.server.Close()
+}
+
+// A minimal 9P2000.L client, enough to drive the server from the tests. Its methods fail the test if the connection
+// breaks or a reply is malformed, and return the errno if the server answers with Rlerror.
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+	tag    uint16
+}
+
+// Connect to the cluster's server, negotiate the version and attach fid 0 to the root.
+func (cluster *testCluster) connect(t *testing.T) *testClient {
+	conn, err := net.Dial("tcp", cluster.server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+	if msize, version := c.version(64*1024, protocolVersion); msize != 64*1024 || version != protocolVersion {
+		t.Fatalf("Tversion returned (%d, %q), expected (%d, %q)", msize, version, 64*1024, protocolVersion)
+	}
+	if _, err := c.attach(0); err != nil {
+		t.Fatalf("Tattach failed: %v", err)
+	}
+	return c
+}
+
+// Send a request and return the reply's fields, or the errno from an Rlerror.
+func (c *testClient) rpc(t messageType, e *encoder) (*decoder, error) {
+	c.tag++
+	if err := writeMessage(c.writer, t, c.tag, e.buf); err != nil {
+		c.t.Fatalf("couldn't send a message of type %d: %v", t, err)
+	}
+	replyType, tag, body, err := readMessage(c.reader, maxMessageSize)
+	if err != nil {
+		c.t.Fatalf("couldn't read the reply to a message of type %d: %v", t, err)
+	}
+	if tag != c.tag {
+		c.t.Fatalf("reply has tag %d, expected %d", tag, c.tag)
+	}
+	d := &decoder{buf: body}
+	if replyType == rlerror {
+		code := errno(d.u32())
+		c.finish(d)
+		return nil, code
+	}
+	if replyType != t+1 {
+		c.t.Fatalf("reply to a message of type %d has type %d", t, replyType)
+	}
+	return d, nil
+}
+
+func (c *testClient) finish(d *decoder) {
+	if err := d.finish(); err != nil {
+		c.t.Fatalf("malformed reply: %v", err)
+	}
+}
+
+func (c *testClient) version(msize uint32, version string) (uint32, string) {
+	e := &encoder{}
+	e.u32(msize)
+	e.string(version)
+	d, err := c.rpc(tversion, e)
+	if err != nil {
+		c.t.Fatalf("Tversion failed: %v", err)
+	}
+	msize, version = d.u32(), d.string()
+	c.finish(d)
+	return msize, version
+}
+
+func (c *testClient) attach(fid uint32) (qid, error) {
+	e := &encoder{}
+	e.u32(fid)
+	e.u32(noFid)
+	e.string("tester")
+	e.string("")
+	e.u32(0)
+	d, err := c.rpc(tattach, e)
+	if err != nil {
+		return qid{}, err
+	}
+	q := d.qid()
+	c.finish(d)
+	return q, nil
+}
+
+func (c *testClient) walk(fid uint32, newFid uint32, names ...string) ([]qid, error) {
+	e := &encoder{}
+	e.u32(fid)
+	e.u32(newFid)
+	e.u16(uint16(len(names)))
+	for _, name := range names {
+		e.string(name)
+	}
+	d, err := c.rpc(twalk, e)
+	if err != nil {
+		return nil, err
+	}
+	qids := make([]qid, d.u16())
+	for i := range qids {
+		qids[i] = d.qid()
+	}
+	c.finish(d)
+	return qids, nil
+}
+
+func (c *testClient) lopen(fid uint32, flags uint32) error {
+	e := &encoder{}
+	e.u32(fid)
+	e.u32(flags)
+	d, err := c.rpc(tlopen, e)
+	if err != nil {
+		return err
+	}
+	d.qid()
+	d.u32() // iounit
+	c.finish(d)
+	return nil
+}
+
+func (c *testClient) lcreate(fid uint32, name string, flags uint32) error {
+	e := &encoder{}
+	e.u32(fid)
+	e.string(name)
+	e.u32(flags)
+	e.u32(filePerm)
+	e.u32(0)
+	d, err := c.rpc(tlcreate, e)
+	if err != nil {
+		return err
+	}
+	d.qid()
+	d.u32() // iounit
+	c.finish(d)
+	return nil
+}
+
+func (c *testClient) read(fid uint32, offset uint64, count uint32) (string, error) {
+	e := &encoder{}
+	e.u32(fid)
+	e.u64(offset)
+	e.u32(count)
+	d, err := c.rpc(tread, e)
+	if err != nil {
+		return "", err
+	}
+	data := d.bytes(int(d.u32()))
+	c.finish(d)
+	return string(data), nil
+}
+
+func (c *testClient) write(fid uint32, offset uint64, data string) (uint32, error) {
+	e := &encoder{}
+	e.u32(fid)
+	e.u64(offset)
+	e.u32(uint32(len(data)))
+	e.buf = append(e.buf, data...)
+	d, err := c.rpc(twrite, e)
+	if err != nil {
+		return 0, err
+	}
+	count := d.u32()
+	c.finish(d)
+	return count, nil
+}
+
+// Clunk and remove, which take just a fid and reply with nothing.
+func (c *testClient) simple(t messageType, fid uint32) error {
+	e := &encoder{}
+	e.u32(fid)
+	d, err := c.rpc(t, e)
+	if err != nil {
+		return err
+	}
+	c.finish(d)
+	return nil
+}
+
+// Returns the mode and size from Rgetattr.
+func (c *testClient) getattr(fid uint32) (mode uint32, size uint64, err error) {
+	e := &encoder{}
+	e.u32(fid)
+	e.u64(getattrBasic)
+	d, err := c.rpc(tgetattr, e)
+	if err != nil {
+		return 0, 0, err
+	}
+	d.u64() // valid
+	d.qid()
+	mode = d.u32()
+	d.u32() // uid
+	d.u32() // gid
+	d.u64() // nlink
+	d.u64() // rdev
+	size = d.u64()
+	d.take(len(d.buf))
+	c.finish(d)
+	return mode, size, nil
+}
+
+type testDirent struct {
+	name   string
+	offset uint64
+	kind   uint8
+}
+
+func (c *testClient) readdir(fid uint32, offset uint64, count uint32) ([]testDirent, error) {
+	e := &encoder{}
+	e.u32(fid)
+	e.u64(offset)
+	e.u32(count)
+	d, err := c.rpc(treaddir, e)
+	if err != nil {
+		return nil, err
+	}
+	listing := &decoder{buf: d.bytes(int(d.u32()))}
+	c.finish(d)
+	entries := make([]testDirent, 0)
+	for len(listing.buf) > 0 {
+		listing.qid()
+		entry := testDirent{offset: listing.u64(), kind: listing.u8(), name: listing.string()}
+		entries = append(entries, entry)
+	}
+	c.finish(listing)
+	return entries, nil
+}
+
+func (c *testClient) mkdir(fid uint32, name string) error {
+	e := &encoder{}
+	e.u32(fid)
+	e.string(name)
+	e.u32(dirPerm)
+	e.u32(0)
+	d, err := c.rpc(tmkdir, e)
+	if err != nil {
+		return err
+	}
+	d.qid()
+	c.finish(d)
+	return nil
+}
+
+func (c *testClient) unlinkat(fid uint32, name string, flags uint32) error {
+	e := &encoder{}
+	e.u32(fid)
+	e.string(name)
+	e.u32(flags)
+	d, err := c.rpc(tunlinkat, e)
+	if err != nil {
+		return err
+	}
+	c.finish(d)
+	return nil
+}
+
+// The server offers at most maxMessageSize, doesn't know other versions, and wants Tversion before anything else.
+func TestVersion(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+
+	conn, err := net.Dial("tcp", cluster.server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+	defer conn.Close()
+
+	if _, err := c.attach(0); err != einval {
+		t.Fatalf("Tattach before Tversion returned %v, expected EINVAL", err)
+	}
+	if msize, version := c.version(16*1024*1024, "9P2000"); msize != maxMessageSize || version != unknownVersion {
+		t.Fatalf("Tversion for 9P2000 returned (%d, %q), expected (%d, %q)", msize, version, maxMessageSize,
+			unknownVersion)
+	}
+	if msize, version := c.version(8192, protocolVersion); msize != 8192 || version != protocolVersion {
+		t.Fatalf("Tversion returned (%d, %q), expected (%d, %q)", msize, version, 8192, protocolVersion)
+	}
+	if q, err := c.attach(0); err != nil || q.qidType != qidTypeDir {
+		t.Fatalf("Tattach returned (%+v, %v), expected a directory", q, err)
+	}
+}
+
+// Create, write and read a file, then find it again by walking.
+func TestFiles(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+	c := cluster.connect(t)
+	defer c.conn.Close()
+
+	if _, err := c.walk(0, 1); err != nil {
+		t.Fatalf("cloning the root failed: %v", err)
+	}
+	if err := c.lcreate(1, "hello.txt", openReadWrite); err != nil {
+		t.Fatalf("Tlcreate failed: %v", err)
+	}
+	if count, err := c.write(1, 0, "hello world"); err != nil || count != 11 {
+		t.Fatalf("Twrite returned (%d, %v), expected 11", count, err)
+	}
+	if _, err := c.write(1, 6, "there"); err != nil {
+		t.Fatalf("Twrite at an offset failed: %v", err)
+	}
+	if data, err := c.read(1, 0, 100); err != nil || data != "hello there" {
+		t.Fatalf("Tread returned (%q, %v), expected %q", data, err, "hello there")
+	}
+	if err := c.simple(tclunk, 1); err != nil {
+		t.Fatalf("Tclunk failed: %v", err)
+	}
+
+	qids, err := c.walk(0, 2, "hello.txt")
+	if err != nil || len(qids) != 1 || qids[0].qidType != qidTypeFile {
+		t.Fatalf("walking to the file returned (%+v, %v)", qids, err)
+	}
+	if mode, size, err := c.getattr(2); err != nil || mode != modeFile|filePerm || size != 11 {
+		t.Fatalf("Tgetattr returned (%o, %d, %v), expected (%o, 11)", mode, size, err, modeFile|filePerm)
+	}
+	if err := c.lopen(2, openReadOnly); err != nil {
+		t.Fatalf("Tlopen failed: %v", err)
+	}
+	if data, err := c.read(2, 6, 100); err != nil || data != "there" {
+		t.Fatalf("Tread at an offset returned (%q, %v), expected %q", data, err, "there")
+	}
+	if data, err := c.read(2, 100, 100); err != nil || data != "" {
+		t.Fatalf("Tread past the end returned (%q, %v), expected nothing", data, err)
+	}
+	if _, err := c.write(2, 0, "nope"); err != ebadf {
+		t.Fatalf("Twrite to a file opened for reading returned %v, expected EBADF", err)
+	}
+	if err := c.simple(tremove, 2); err != nil {
+		t.Fatalf("Tremove failed: %v", err)
+	}
+	if _, err := c.walk(0, 3, "hello.txt"); err != enoent {
+		t.Fatalf("walking to a removed file returned %v, expected ENOENT", err)
+	}
+}
+
+// Make directories, list them, walk through them and remove them.
+func TestDirectories(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+	c := cluster.connect(t)
+	defer c.conn.Close()
+
+	if err := c.mkdir(0, "docs"); err != nil {
+		t.Fatalf("Tmkdir failed: %v", err)
+	}
+	if err := c.mkdir(0, "docs"); err != eexist {
+		t.Fatalf("making a directory twice returned %v, expected EEXIST", err)
+	}
+	if _, err := c.walk(0, 1, "docs"); err != nil {
+		t.Fatalf("walking to the directory failed: %v", err)
+	}
+	if err := c.mkdir(1, "sub"); err != nil {
+		t.Fatalf("Tmkdir in a subdirectory failed: %v", err)
+	}
+	if err := c.lcreate(1, "a.txt", openWriteOnly); err != nil {
+		t.Fatalf("Tlcreate failed: %v", err)
+	}
+	if err := c.simple(tclunk, 1); err != nil {
+		t.Fatalf("Tclunk failed: %v", err)
+	}
+
+	qids, err := c.walk(0, 2, "docs", "sub", "..", ".")
+	if err != nil || len(qids) != 4 || qids[3] != qidFor("/docs", true) {
+		t.Fatalf("walking down and back up returned (%+v, %v)", qids, err)
+	}
+	if err := c.lopen(2, openReadOnly); err != nil {
+		t.Fatalf("Tlopen of a directory failed: %v", err)
+	}
+	expected := []testDirent{{"a.txt", 1, direntFile}, {"sub", 2, direntDir}}
+	if entries, err := c.readdir(2, 0, 4096); err != nil || !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("Treaddir returned (%+v, %v), expected %+v", entries, err, expected)
+	}
+	// room for just one entry at a time
+	first, err := c.readdir(2, 0, qidSize+8+1+2+5)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("a small Treaddir returned (%+v, %v), expected one entry", first, err)
+	}
+	rest, err := c.readdir(2, first[0].offset, 4096)
+	if err != nil || !reflect.DeepEqual(append(first, rest...), expected) {
+		t.Fatalf("continuing Treaddir returned (%+v, %v), expected the rest of %+v", rest, err, expected)
+	}
+	if entries, err := c.readdir(2, 2, 4096); err != nil || len(entries) != 0 {
+		t.Fatalf("Treaddir at the end returned (%+v, %v), expected nothing", entries, err)
+	}
+
+	// only the steps that exist get qids, and the new fid isn't made
+	if qids, err := c.walk(0, 3, "docs", "missing", "deeper"); err != nil || len(qids) != 1 {
+		t.Fatalf("a partly failed walk returned (%+v, %v), expected one qid", qids, err)
+	}
+	if _, _, err := c.getattr(3); err != ebadf {
+		t.Fatalf("a fid from a partly failed walk returned %v, expected EBADF", err)
+	}
+
+	if err := c.unlinkat(0, "docs", unlinkRemoveDir); err != enotempty {
+		t.Fatalf("removing a directory that isn't empty returned %v, expected ENOTEMPTY", err)
+	}
+	if err := c.unlinkat(2, "sub", 0); err != eisdir {
+		t.Fatalf("unlinking a directory without AT_REMOVEDIR returned %v, expected EISDIR", err)
+	}
+	if err := c.unlinkat(2, "sub", unlinkRemoveDir); err != nil {
+		t.Fatalf("Tunlinkat of a directory failed: %v", err)
+	}
+	if err := c.unlinkat(2, "a.txt", 0); err != nil {
+		t.Fatalf("Tunlinkat of a file failed: %v", err)
+	}
+	if err := c.unlinkat(0, "docs", unlinkRemoveDir); err != nil {
+		t.Fatalf("Tunlinkat of an emptied directory failed: %v", err)
+	}
+}
+
+// A file is open through one fid at a time, and a connection that goes away gives up its files.
+func TestOpenFiles(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+
+	first := cluster.connect(t)
+	if _, err := first.walk(0, 1); err != nil {
+		t.Fatalf("cloning the root failed: %v", err)
+	}
+	if err := first.lcreate(1, "shared", openReadWrite); err != nil {
+		t.Fatalf("Tlcreate failed: %v", err)
+	}
+	if _, err := first.walk(0, 2, "shared"); err != nil {
+		t.Fatalf("walking to the file failed: %v", err)
+	}
+	if err := first.lopen(2, openReadOnly); err != ebusy {
+		t.Fatalf("opening a file that is open through another fid returned %v, expected EBUSY", err)
+	}
+
+	second := cluster.connect(t)
+	defer second.conn.Close()
+	if _, err := second.walk(0, 1, "shared"); err != nil {
+		t.Fatalf("walking to the file failed: %v", err)
+	}
+	if err := second.lopen(1, openReadOnly); err != ebusy {
+		t.Fatalf("opening a file that is open on another connection returned %v, expected EBUSY", err)
+	}
+
+	first.conn.Close()
+	for start := time.Now(); ; time.Sleep(50 * time.Millisecond) {
+		err := second.lopen(1, openReadOnly)
+		if err == nil {
+			break
+		}
+		if err != ebusy || time.Since(start) > 5*time.Second {
+			t.Fatalf("after the other connection closed, Tlopen returned %v", err)
+		}
+	}
+	if err := second.simple(tclunk, 1); err != nil {
+		t.Fatalf("Tclunk failed: %v", err)
+	}
+	if _, err := second.read(1, 0, 10); err != ebadf {
+		t.Fatalf("reading through a clunked fid returned %v, expected EBADF", err)
+	}
+}
+
+// Every ErrorCode has an errno, so none of them falls back to EIO by accident.
+func TestErrnos(t *testing.T) {
+	for code := filesystem.NotFound; code <= filesystem.WrongMode; code++ {
+		if _, found := errorCodesToErrnos[code]; !found {
+			t.Errorf("%v has no errno", code)
+		}
+	}
+	if e := errnoForError(os.ErrClosed); e != eio {
+		t.Errorf("an error that isn't an ErrorCode got errno %d, expected EIO", e)
+	}
+}
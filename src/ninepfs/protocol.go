@@ -0,0 +1,330 @@
+package ninepfs
+
+// The parts of 9P2000.L that the server speaks, as described in the Linux kernel's Documentation/filesystems/9p.rst
+// and diod's protocol.md.
+//
+// Every message is a uint32 size (which counts itself), a u8 type, a u16 tag and then the message's fields.
+// Integers are little-endian; a string is a u16 length followed by that many bytes of UTF-8; a qid is a u8 type, a
+// u32 version and a u64 path. A reply has the type of its request plus one and the same tag, or it is an Rlerror
+// holding a Linux errno.
+//
+// These are the requests the server understands. Any other request gets an Rlerror with EOPNOTSUPP.
+//
+//	Tversion  Tattach  Twalk  Tlopen  Tlcreate  Tread  Twrite  Tclunk  Tremove
+//	Tgetattr  Tsetattr  Treaddir  Tmkdir  Tunlinkat  Tstatfs  Tfsync  Tflush
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"filesystem"
+	"fmt"
+	"hash/fnv"
+	"io"
+)
+
+type messageType uint8
+
+const (
+	rlerror   messageType = 7
+	tstatfs   messageType = 8
+	tlopen    messageType = 12
+	tlcreate  messageType = 14
+	tgetattr  messageType = 24
+	tsetattr  messageType = 26
+	treaddir  messageType = 40
+	tfsync    messageType = 50
+	tmkdir    messageType = 72
+	tunlinkat messageType = 76
+	tversion  messageType = 100
+	tauth     messageType = 102
+	tattach   messageType = 104
+	tflush    messageType = 108
+	twalk     messageType = 110
+	tread     messageType = 116
+	twrite    messageType = 118
+	tclunk    messageType = 120
+	tremove   messageType = 122
+)
+
+const (
+	protocolVersion = "9P2000.L"
+	unknownVersion  = "unknown"
+
+	// The largest message the server sends or accepts. Clients can ask for less in Tversion.
+	maxMessageSize = 1024*1024 + ioHeaderSize
+
+	// The bytes in an Rread or Twrite before the data: size, type, tag, then fid, offset and count for Twrite.
+	ioHeaderSize = 4 + 1 + 2 + 4 + 8 + 4
+
+	// A fid that stands for no fid, e.g. the afid of a Tattach without authentication.
+	noFid = ^uint32(0)
+
+	// The longest walk a single Twalk may ask for.
+	maxWalkElements = 16
+)
+
+// Bits in a qid's type.
+const (
+	qidTypeDir  = 0x80
+	qidTypeFile = 0x00
+)
+
+// Linux's open(2) flags, as they arrive in Tlopen and Tlcreate.
+const (
+	openAccessModeMask = 3
+	openReadOnly       = 0
+	openWriteOnly      = 1
+	openReadWrite      = 2
+	openTruncate       = 01000
+	openAppend         = 02000
+)
+
+// Linux file types and permissions, for Tgetattr's mode.
+const (
+	modeDir  = 0040000
+	modeFile = 0100000
+	dirPerm  = 0755
+	filePerm = 0644
+)
+
+// Directory entry types, for Treaddir.
+const (
+	direntDir  = 4
+	direntFile = 8
+)
+
+const (
+	getattrBasic    = 0x7ff // every field up to and including the block count
+	setattrSize     = 0x8
+	unlinkRemoveDir = 0x200
+	v9fsMagic       = 0x01021997
+	blockSize       = 4096
+	maxNameLength   = filesystem.MaxNameLength
+)
+
+// Linux errnos. These are the numbers on the wire whatever the server runs on, so they aren't taken from syscall.
+type errno uint32
+
+const (
+	enoent     errno = 2
+	eio        errno = 5
+	ebadf      errno = 9
+	eagain     errno = 11
+	ebusy      errno = 16
+	eexist     errno = 17
+	enotdir    errno = 20
+	eisdir     errno = 21
+	einval     errno = 22
+	emfile     errno = 24
+	efbig      errno = 27
+	enospc     errno = 28
+	enotempty  errno = 39
+	eloop      errno = 40
+	emsgsize   errno = 90
+	eopnotsupp errno = 95
+)
+
+var errorCodesToErrnos = map[filesystem.ErrorCode]errno{
+	filesystem.NotFound:          enoent,
+	filesystem.IsDirectory:       eisdir,
+	filesystem.TooManyFDsOpen:    emfile,
+	filesystem.InactiveFD:        ebadf,
+	filesystem.IllegalArgument:   einval,
+	filesystem.TryAgain:          eagain,
+	filesystem.IOError:           eio,
+	filesystem.FileTooLarge:      efbig,
+	filesystem.NoMoreSpace:       enospc,
+	filesystem.DirectoryNotEmpty: enotempty,
+	filesystem.AlreadyExists:     eexist,
+	filesystem.AlreadyOpen:       ebusy,
+	filesystem.WriteTooLarge:     emsgsize,
+	filesystem.WrongMode:         ebadf,
+	filesystem.TooManyLinks:      eloop,
+}
+
+func (e errno) Error() string {
+	return fmt.Sprintf("errno %d", uint32(e))
+}
+
+// The errno to report for err. Errors that aren't a filesystem.ErrorCode or an errno are reported as EIO.
+func errnoForError(err error) errno {
+	switch err := err.(type) {
+	case errno:
+		return err
+	case filesystem.ErrorCode:
+		if e, found := errorCodesToErrnos[err]; found {
+			return e
+		}
+	}
+	return eio
+}
+
+type qid struct {
+	qidType uint8
+	version uint32
+	path    uint64
+}
+
+// The qid for the file or directory at path. There are no inode numbers to use, so the qid's path is a hash of
+// the file's path, which stays the same for as long as the file does.
+func qidFor(path string, isDir bool) qid {
+	hash := fnv.New64a()
+	hash.Write([]byte(path))
+	q := qid{qidType: qidTypeFile, path: hash.Sum64()}
+	if isDir {
+		q.qidType = qidTypeDir
+	}
+	return q
+}
+
+// The size of a qid on the wire.
+const qidSize = 1 + 4 + 8
+
+// Returned while decoding a message that is too short or holds impossible values.
+var errMalformed = errors.New("malformed message")
+
+// Write one message and flush it.
+func writeMessage(w *bufio.Writer, t messageType, tag uint16, body []byte) error {
+	var header [7]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(header)+len(body)))
+	header[4] = uint8(t)
+	binary.LittleEndian.PutUint16(header[5:7], tag)
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Read one message of at most maxSize bytes, and return its type, its tag and the rest of it.
+func readMessage(r *bufio.Reader, maxSize int) (t messageType, tag uint16, body []byte, err error) {
+	var header [7]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	size := int(binary.LittleEndian.Uint32(header[0:4]))
+	if size < len(header) || size > maxSize {
+		return 0, 0, nil, fmt.Errorf("message of %d bytes is outside the limits of %d to %d", size, len(header),
+			maxSize)
+	}
+	body = make([]byte, size-len(header))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return messageType(header[4]), binary.LittleEndian.Uint16(header[5:7]), body, nil
+}
+
+// Builds the fields of a message.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) u8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) u16(v uint16) {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) u32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) u64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) string(v string) {
+	e.u16(uint16(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) qid(v qid) {
+	e.u8(v.qidType)
+	e.u32(v.version)
+	e.u64(v.path)
+}
+
+// Takes apart the fields of a message. Once something goes wrong, err is set and every method returns zero values,
+// so callers can decode every field and check err once at the end.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.buf) {
+		d.err = errMalformed
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) u8() uint8 {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) u16() uint16 {
+	b := d.take(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (d *decoder) u32() uint32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (d *decoder) u64() uint64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+// The next n bytes, copied so the caller can keep them.
+func (d *decoder) bytes(n int) []byte {
+	b := d.take(n)
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes(int(d.u16())))
+}
+
+func (d *decoder) qid() qid {
+	return qid{qidType: d.u8(), version: d.u32(), path: d.u64()}
+}
+
+// Check that the whole message was used, and return the first thing that went wrong.
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.buf) != 0 {
+		d.err = errMalformed
+	}
+	return d.err
+}
@@ -0,0 +1,765 @@
+package ninepfs
+
+import (
+	"ad"
+	"bufio"
+	"filesystem"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Serves 9P2000.L, so that a cluster can be mounted with the kernel's v9fs or used through any other 9P client, e.g.
+//
+//	mount -t 9p -o trans=tcp,port=5640,version=9p2000.L 127.0.0.1 /mnt/dfs
+//
+// Each connection gets its own filesystem, usually an fsraft.Clerk, and its fids are turned into calls on it. A fid
+// that is opened holds one of the filesystem's file descriptors until it is clunked, so, as everywhere else, a file
+// can only be opened through one fid at a time; a second open gets EBUSY.
+type Server struct {
+	newFileSystem func() filesystem.FileSystem
+	listener      net.Listener
+	lock          sync.Mutex
+	conns         map[net.Conn]bool // open connections, so Close() can drop them
+	closed        bool
+}
+
+// Serve 9P on address, e.g. "127.0.0.1:0" for any free port on loopback. newFileSystem is called once per
+// connection, e.g. func() filesystem.FileSystem { return fsraft.MakeFsClerk(servers) }.
+func Listen(address string, newFileSystem func() filesystem.FileSystem) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{newFileSystem: newFileSystem, listener: listener, conns: make(map[net.Conn]bool)}
+	go s.acceptConnections()
+	return s, nil
+}
+
+// The address the server is serving on, e.g. "127.0.0.1:41234".
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Stop accepting connections and close the open ones. A request that is in progress finishes, but its reply is
+// dropped.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return s.listener.Close()
+}
+
+func (s *Server) acceptConnections() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return // closed
+		}
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.lock.Unlock()
+		go s.serveConnection(conn)
+	}
+}
+
+// What a fid refers to.
+type fidState struct {
+	path    string
+	isDir   bool
+	opened  bool
+	fd      int                   // the filesystem's file descriptor, for an opened file
+	entries []filesystem.FileInfo // an opened directory's entries, as of the Treaddir at offset 0
+}
+
+// One connection's filesystem and fids.
+type session struct {
+	fs      filesystem.FileSystem
+	msize   int
+	fids    map[uint32]*fidState
+	version bool // whether a Tversion has been answered, which has to come first
+}
+
+// Answer the requests on conn one at a time until it closes, then clunk the fids it left behind.
+//
+// Handling requests in order means a Tflush always arrives after the request it names has been answered, so it can
+// be answered straight away.
+func (s *Server) serveConnection(conn net.Conn) {
+	sess := &session{fs: s.newFileSystem(), msize: maxMessageSize, fids: make(map[uint32]*fidState)}
+	ad.Debug(ad.RPC, "Server accepted a connection from %v", conn.RemoteAddr())
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		conn.Close()
+		sess.clunkAll()
+		ad.Debug(ad.RPC, "Server closed the connection from %v", conn.RemoteAddr())
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		t, tag, body, err := readMessage(reader, sess.msize)
+		if err != nil {
+			if err != io.EOF {
+				ad.Debug(ad.WARN, "Server couldn't read a message from %v, hanging up: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		replyType, reply := sess.handle(t, body)
+		if writeMessage(writer, replyType, tag, reply) != nil {
+			return
+		}
+	}
+}
+
+// Carry out one request and return the reply's type and fields.
+func (sess *session) handle(t messageType, body []byte) (messageType, []byte) {
+	d := &decoder{buf: body}
+	e := &encoder{}
+	var err error
+	if t != tversion && !sess.version {
+		err = einval
+	} else {
+		switch t {
+		case tversion:
+			err = sess.version9P(d, e)
+		case tauth:
+			err = eopnotsupp
+		case tattach:
+			err = sess.attach(d, e)
+		case tflush:
+			d.u16() // oldtag, long since answered
+			err = d.finish()
+		case twalk:
+			err = sess.walk(d, e)
+		case tlopen:
+			err = sess.lopen(d, e)
+		case tlcreate:
+			err = sess.lcreate(d, e)
+		case tread:
+			err = sess.read(d, e)
+		case twrite:
+			err = sess.write(d, e)
+		case tclunk:
+			err = sess.clunk(d)
+		case tremove:
+			err = sess.remove(d)
+		case tgetattr:
+			err = sess.getattr(d, e)
+		case tsetattr:
+			err = sess.setattr(d)
+		case treaddir:
+			err = sess.readdir(d, e)
+		case tmkdir:
+			err = sess.mkdir(d, e)
+		case tunlinkat:
+			err = sess.unlinkat(d)
+		case tstatfs:
+			err = sess.statfs(d, e)
+		case tfsync:
+			err = sess.fsync(d)
+		default:
+			ad.Debug(ad.WARN, "Server got a message of unsupported type %d", t)
+			err = eopnotsupp
+		}
+	}
+
+	if err == errMalformed {
+		ad.Debug(ad.WARN, "Server got a malformed message of type %d", t)
+		err = einval
+	}
+	if err != nil {
+		e = &encoder{}
+		e.u32(uint32(errnoForError(err)))
+		return rlerror, e.buf
+	}
+	return t + 1, e.buf
+}
+
+func (sess *session) version9P(d *decoder, e *encoder) error {
+	msize := int(d.u32())
+	version := d.string()
+	if d.finish() != nil {
+		return d.err
+	}
+	// a Tversion starts the session over
+	sess.clunkAll()
+	if msize < ioHeaderSize+qidSize {
+		return einval
+	}
+	if msize < sess.msize {
+		sess.msize = msize
+	}
+	e.u32(uint32(sess.msize))
+	if version != protocolVersion {
+		e.string(unknownVersion)
+		return nil
+	}
+	sess.version = true
+	e.string(protocolVersion)
+	return nil
+}
+
+func (sess *session) attach(d *decoder, e *encoder) error {
+	fid := d.u32()
+	d.u32()    // afid, which has to be noFid since Tauth isn't supported
+	d.string() // uname
+	d.string() // aname
+	d.u32()    // n_uname
+	if d.finish() != nil {
+		return d.err
+	}
+	if _, inUse := sess.fids[fid]; inUse {
+		return einval
+	}
+	if _, err := sess.fs.Stat("/"); err != nil {
+		return err
+	}
+	sess.fids[fid] = &fidState{path: "/", isDir: true}
+	e.qid(qidFor("/", true))
+	return nil
+}
+
+// Walk from fid through each name, binding newfid to where the walk ends if every step succeeded. If only the first
+// few steps succeed, their qids are returned and newfid is left alone.
+func (sess *session) walk(d *decoder, e *encoder) error {
+	fid := d.u32()
+	newFid := d.u32()
+	names := make([]string, d.u16())
+	for i := range names {
+		names[i] = d.string()
+	}
+	if d.finish() != nil {
+		return d.err
+	}
+	from, err := sess.lookup(fid)
+	if err != nil {
+		return err
+	}
+	if from.opened || len(names) > maxWalkElements {
+		return einval
+	}
+	if _, inUse := sess.fids[newFid]; inUse && newFid != fid {
+		return einval
+	}
+	if len(names) > 0 && !from.isDir {
+		return enotdir
+	}
+
+	path, isDir := from.path, from.isDir
+	qids := make([]qid, 0, len(names))
+	for _, name := range names {
+		nextPath, err := walkOne(path, name)
+		if err == nil {
+			var info filesystem.FileInfo
+			info, err = sess.fs.Stat(nextPath)
+			isDir = info.IsDir
+		}
+		if err != nil {
+			if len(qids) == 0 {
+				return err
+			}
+			break
+		}
+		path = nextPath
+		qids = append(qids, qidFor(path, isDir))
+		if !isDir {
+			break
+		}
+	}
+
+	if len(qids) == len(names) {
+		sess.fids[newFid] = &fidState{path: path, isDir: isDir}
+	}
+	e.u16(uint16(len(qids)))
+	for _, q := range qids {
+		e.qid(q)
+	}
+	return nil
+}
+
+func (sess *session) lopen(d *decoder, e *encoder) error {
+	fid := d.u32()
+	flags := d.u32()
+	if d.finish() != nil {
+		return d.err
+	}
+	state, err := sess.lookup(fid)
+	if err != nil {
+		return err
+	}
+	if state.opened {
+		return einval
+	}
+	if state.isDir {
+		if flags&openAccessModeMask != openReadOnly {
+			return eisdir
+		}
+		state.opened = true
+		state.entries = nil
+	} else {
+		mode, openFlags, err := translateOpenFlags(flags)
+		if err != nil {
+			return err
+		}
+		fd, err := sess.fs.Open(state.path, mode, openFlags)
+		if err != nil {
+			return err
+		}
+		state.opened = true
+		state.fd = fd
+	}
+	e.qid(qidFor(state.path, state.isDir))
+	e.u32(uint32(sess.msize - ioHeaderSize))
+	return nil
+}
+
+// Create a file in the directory fid refers to, open it, and make fid refer to it.
+func (sess *session) lcreate(d *decoder, e *encoder) error {
+	fid := d.u32()
+	name := d.string()
+	flags := d.u32()
+	d.u32() // mode, since there are no permissions
+	d.u32() // gid
+	if d.finish() != nil {
+		return d.err
+	}
+	state, err := sess.lookup(fid)
+	if err != nil {
+		return err
+	}
+	if state.opened || !state.isDir {
+		return einval
+	}
+	path, err := childPath(state.path, name)
+	if err != nil {
+		return err
+	}
+	mode, openFlags, err := translateOpenFlags(flags)
+	if err != nil {
+		return err
+	}
+	if _, err := sess.fs.Stat(path); err == nil {
+		return eexist
+	} else if err != filesystem.NotFound {
+		return err
+	}
+	fd, err := sess.fs.Open(path, mode, openFlags|filesystem.Create)
+	if err != nil {
+		return err
+	}
+	*state = fidState{path: path, opened: true, fd: fd}
+	e.qid(qidFor(path, false))
+	e.u32(uint32(sess.msize - ioHeaderSize))
+	return nil
+}
+
+func (sess *session) read(d *decoder, e *encoder) error {
+	fid := d.u32()
+	offset := d.u64()
+	count := int(d.u32())
+	if d.finish() != nil {
+		return d.err
+	}
+	state, err := sess.lookupOpened(fid)
+	if err != nil {
+		return err
+	}
+	if state.isDir {
+		return eisdir
+	}
+	if count > sess.msize-ioHeaderSize {
+		count = sess.msize - ioHeaderSize
+	}
+	bytesRead, data, err := sess.fs.Pread(state.fd, int(offset), count)
+	if err != nil {
+		return err
+	}
+	e.u32(uint32(bytesRead))
+	e.buf = append(e.buf, data[:bytesRead]...)
+	return nil
+}
+
+func (sess *session) write(d *decoder, e *encoder) error {
+	fid := d.u32()
+	offset := d.u64()
+	data := d.bytes(int(d.u32()))
+	if d.finish() != nil {
+		return d.err
+	}
+	state, err := sess.lookupOpened(fid)
+	if err != nil {
+		return err
+	}
+	if state.isDir {
+		return eisdir
+	}
+	// Seek and Write rather than Pwrite, so that a file opened with O_APPEND is appended to
+	if _, err := sess.fs.Seek(state.fd, int(offset), filesystem.FromBeginning); err != nil {
+		return err
+	}
+	bytesWritten, err := sess.fs.Write(state.fd, len(data), data)
+	if err != nil {
+		return err
+	}
+	e.u32(uint32(bytesWritten))
+	return nil
+}
+
+// Forget fid, closing its file if it is open. The fid is gone even if closing fails.
+func (sess *session) clunk(d *decoder) error {
+	fid := d.u32()
+	if d.finish() != nil {
+		return d.err
+	}
+	state, err := sess.lookup(fid)
+	if err != nil {
+		return err
+	}
+	delete(sess.fids, fid)
+	return sess.closeFid(state)
+}
+
+// Delete what fid refers to, and clunk it. The fid is gone even if deleting fails.
+func (sess *session) remove(d *decoder) error {
+	fid := d.u32()
+	if d.finish() != nil {
+		return d.err
+	}
+	state, err := sess.lookup(fid)
+	if err != nil {
+		return err
+	}
+	delete(sess.fids, fid)
+	if err := sess.closeFid(state); err != nil {
+		return err
+	}
+	_, err = sess.fs.Delete(state.path)
+	return err
+}
+
+func (sess *session) getattr(d *decoder, e *encoder) error {
+	fid := d.u32()
+	d.u64() // request_mask; everything in getattrBasic is always returned
+	if d.finish() != nil {
+		return d.err
+	}
+	state, err := sess.lookup(fid)
+	if err != nil {
+		return err
+	}
+	info, err := sess.fs.Stat(state.path)
+	if err != nil {
+		return err
+	}
+	mode, nlink := uint32(modeFile|filePerm), uint64(1)
+	if info.IsDir {
+		mode, nlink = modeDir|dirPerm, 2
+	}
+	e.u64(getattrBasic)
+	e.qid(qidFor(state.path, info.IsDir))
+	e.u32(mode)
+	e.u32(0) // uid
+	e.u32(0) // gid
+	e.u64(nlink)
+	e.u64(0) // rdev
+	e.u64(uint64(info.Size))
+	e.u64(blockSize)
+	e.u64(uint64((info.Size + 511) / 512)) // blocks, which are always 512 bytes here
+	for i := 0; i < 12; i++ {
+		e.u64(0) // atime, mtime, ctime and btime, gen and data_version, none of which are kept
+	}
+	return nil
+}
+
+// Times, permissions and owners aren't kept, so changes to them are accepted and dropped. Setting the size
+// truncates or extends the file.
+func (sess *session) setattr(d *decoder) error {
+	fid := d.u32()
+	valid := d.u32()
+	d.u32() // mode
+	d.u32() // uid
+	d.u32() // gid
+	size := d.u64()
+	d.u64() // atime_sec
+	d.u64() // atime_nsec
+	d.u64() // mtime_sec
+	d.u64() // mtime_nsec
+	if d.finish() != nil {
+		return d.err
+	}
+	state, err := sess.lookup(fid)
+	if err != nil {
+		return err
+	}
+	if valid&setattrSize == 0 {
+		return nil
+	}
+	if size > math.MaxInt64 {
+		return einval
+	}
+	_, err = sess.fs.Truncate(state.path, int(size))
+	return err
+}
+
+// List an opened directory. offset is 0 to start, and otherwise the offset of the last entry returned so far.
+func (sess *session) readdir(d *decoder, e *encoder) error {
+	fid := d.u32()
+	offset := d.u64()
+	count := int(d.u32())
+	if d.finish() != nil {
+		return d.err
+	}
+	state, err := sess.lookupOpened(fid)
+	if err != nil {
+		return err
+	}
+	if !state.isDir {
+		return enotdir
+	}
+	if offset == 0 || state.entries == nil {
+		entries, err := sess.fs.ReadDir(state.path)
+		if err != nil {
+			return err
+		}
+		state.entries = entries
+	}
+	if count > sess.msize-ioHeaderSize {
+		count = sess.msize - ioHeaderSize
+	}
+
+	listing := &encoder{}
+	for i := int(offset); i >= 0 && i < len(state.entries); i++ {
+		entry := state.entries[i]
+		if len(listing.buf)+qidSize+8+1+2+len(entry.Name) > count {
+			break
+		}
+		path, _ := childPath(state.path, entry.Name)
+		listing.qid(qidFor(path, entry.IsDir))
+		listing.u64(uint64(i + 1))
+		if entry.IsDir {
+			listing.u8(direntDir)
+		} else {
+			listing.u8(direntFile)
+		}
+		listing.string(entry.Name)
+	}
+	e.u32(uint32(len(listing.buf)))
+	e.buf = append(e.buf, listing.buf...)
+	return nil
+}
+
+func (sess *session) mkdir(d *decoder, e *encoder) error {
+	fid := d.u32()
+	name := d.string()
+	d.u32() // mode
+	d.u32() // gid
+	if d.finish() != nil {
+		return d.err
+	}
+	state, err := sess.lookup(fid)
+	if err != nil {
+		return err
+	}
+	if !state.isDir {
+		return enotdir
+	}
+	path, err := childPath(state.path, name)
+	if err != nil {
+		return err
+	}
+	if _, err := sess.fs.Mkdir(path); err != nil {
+		return err
+	}
+	e.qid(qidFor(path, true))
+	return nil
+}
+
+func (sess *session) unlinkat(d *decoder) error {
+	fid := d.u32()
+	name := d.string()
+	flags := d.u32()
+	if d.finish() != nil {
+		return d.err
+	}
+	state, err := sess.lookup(fid)
+	if err != nil {
+		return err
+	}
+	if !state.isDir {
+		return enotdir
+	}
+	path, err := childPath(state.path, name)
+	if err != nil {
+		return err
+	}
+	info, err := sess.fs.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir && flags&unlinkRemoveDir == 0 {
+		return eisdir
+	}
+	if !info.IsDir && flags&unlinkRemoveDir != 0 {
+		return enotdir
+	}
+	_, err = sess.fs.Delete(path)
+	return err
+}
+
+// Writes are applied as soon as they're answered, so there's nothing to flush.
+func (sess *session) fsync(d *decoder) error {
+	fid := d.u32()
+	d.u32() // datasync
+	if d.finish() != nil {
+		return d.err
+	}
+	_, err := sess.lookup(fid)
+	return err
+}
+
+// Report the filesystem's Limits as its capacity. Anything it has no limit on is reported as 0, free or not.
+func (sess *session) statfs(d *decoder, e *encoder) error {
+	fid := d.u32()
+	if d.finish() != nil {
+		return d.err
+	}
+	if _, err := sess.lookup(fid); err != nil {
+		return err
+	}
+	info, err := sess.fs.Statfs()
+	if err != nil {
+		return err
+	}
+	blocks, freeBlocks := capacity(info.Limits.MaxBytes/blockSize, (info.Bytes+blockSize-1)/blockSize)
+	files, freeFiles := capacity(info.Limits.MaxInodes, info.Inodes)
+	e.u32(v9fsMagic)
+	e.u32(blockSize)
+	e.u64(blocks)
+	e.u64(freeBlocks)
+	e.u64(freeBlocks) // bavail
+	e.u64(files)
+	e.u64(freeFiles)
+	e.u64(0) // fsid
+	e.u32(maxNameLength)
+	return nil
+}
+
+// How much of limit there is, and how much of it is free after used.
+func capacity(limit int, used int) (total uint64, free uint64) {
+	if limit <= used {
+		return uint64(limit), 0
+	}
+	return uint64(limit), uint64(limit - used)
+}
+
+func (sess *session) lookup(fid uint32) (*fidState, error) {
+	state, found := sess.fids[fid]
+	if !found {
+		return nil, ebadf
+	}
+	return state, nil
+}
+
+func (sess *session) lookupOpened(fid uint32) (*fidState, error) {
+	state, err := sess.lookup(fid)
+	if err != nil {
+		return nil, err
+	}
+	if !state.opened {
+		return nil, ebadf
+	}
+	return state, nil
+}
+
+// Close the file an opened fid holds, if it holds one.
+func (sess *session) closeFid(state *fidState) error {
+	if !state.opened || state.isDir {
+		return nil
+	}
+	state.opened = false
+	_, err := sess.fs.Close(state.fd)
+	return err
+}
+
+// Clunk every fid, in order, so that files left open are closed and other clients can open them.
+func (sess *session) clunkAll() {
+	fids := make([]int, 0, len(sess.fids))
+	for fid := range sess.fids {
+		fids = append(fids, int(fid))
+	}
+	sort.Ints(fids)
+	for _, fid := range fids {
+		state := sess.fids[uint32(fid)]
+		delete(sess.fids, uint32(fid))
+		if err := sess.closeFid(state); err != nil {
+			ad.Debug(ad.WARN, "Server couldn't close %v when clunking fid %d: %v", state.path, fid, err)
+		}
+	}
+}
+
+// The filesystem's mode and flags for Linux open flags. Flags that don't mean anything here, like O_CREAT (which
+// Tlcreate implies) and O_NOFOLLOW, are ignored.
+func translateOpenFlags(flags uint32) (filesystem.OpenMode, filesystem.OpenFlags, error) {
+	var mode filesystem.OpenMode
+	switch flags & openAccessModeMask {
+	case openReadOnly:
+		mode = filesystem.ReadOnly
+	case openWriteOnly:
+		mode = filesystem.WriteOnly
+	case openReadWrite:
+		mode = filesystem.ReadWrite
+	default:
+		return 0, 0, einval
+	}
+	var openFlags filesystem.OpenFlags
+	if flags&openTruncate != 0 {
+		openFlags |= filesystem.Truncate
+	}
+	if flags&openAppend != 0 {
+		openFlags |= filesystem.Append
+	}
+	return mode, openFlags, nil
+}
+
+// The path of the entry called name in the directory at dir.
+func childPath(dir string, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") || len(name) > maxNameLength {
+		return "", einval
+	}
+	if dir == "/" {
+		return "/" + name, nil
+	}
+	return dir + "/" + name, nil
+}
+
+// Where one step of a walk from path leads. Unlike childPath, this allows "." and "..".
+func walkOne(path string, name string) (string, error) {
+	switch name {
+	case ".":
+		return path, nil
+	case "..":
+		if path == "/" {
+			return "/", nil
+		}
+		parent := path[:strings.LastIndex(path, "/")]
+		if parent == "" {
+			return "/", nil
+		}
+		return parent, nil
+	default:
+		return childPath(path, name)
+	}
+}
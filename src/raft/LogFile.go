@@ -0,0 +1,254 @@
+package raft
+
+import (
+	"bytes"
+	"fmt"
+	"labgob"
+	"os"
+)
+
+// A LogStore that keeps its entries in a file instead of in memory, so that a long log doesn't have to fit in memory.
+// Only the offset and term of each uncompressed entry are kept in memory.
+// This log is one-indexed and is not threadsafe.
+// Providing zero as an index will cause any method to panic.
+//
+// The file is scratch space: it is not fsynced, and it is emptied when it is opened, because Raft rebuilds its log
+// from its Storage after a restart. Each entry is stored as a checksummed frame (see FilePersister). Compressed entries
+// are left at the beginning of the file until they outgrow the uncompressed ones, and then the file is rewritten.
+type LogFile struct {
+	logClaim
+	file                   *os.File
+	FirstUncompressedIndex int     // The index of the first uncompressed entry
+	LastCompressedTerm     int     // The term of the last compressed entry
+	offsets                []int64 // offsets[i] is where the entry with index FirstUncompressedIndex+i starts in the file
+	terms                  []int   // terms[i] is the term of the entry with index FirstUncompressedIndex+i
+	size                   int64   // where the last entry ends in the file
+}
+
+// Rewrite the file once the compressed entries at its beginning are at least this many times bigger than the rest...
+const logFileCompactionRatio = 1
+
+// ...and at least this many bytes long.
+const minLogFileCompactionSize = 1 << 20
+
+// Constructor ================================================================
+
+// Make an empty LogFile stored at path. Anything already in the file is thrown away.
+func MakeLogFile(path string) (*LogFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &LogFile{file: file, FirstUncompressedIndex: 1}, nil
+}
+
+// Close the file. The LogFile can't be used afterwards.
+func (log *LogFile) Close() error {
+	return log.file.Close()
+}
+
+// Mutator methods ============================================================
+
+// Append an entry to the log.
+func (log *LogFile) append(entry LogEntry) {
+	byteBuffer := new(bytes.Buffer)
+	encoder := labgob.NewEncoder(byteBuffer)
+	if err := encoder.Encode(entry); err != nil {
+		panic(fmt.Sprintf("Error encoding %+v: %v", entry, err))
+	}
+	frame := encodeFrame(logEntryFrame, byteBuffer.Bytes())
+	if _, err := log.file.WriteAt(frame, log.size); err != nil {
+		panic(fmt.Sprintf("Error appending to %v: %v", log.file.Name(), err))
+	}
+	log.offsets = append(log.offsets, log.size)
+	log.terms = append(log.terms, entry.Term)
+	log.size += int64(len(frame))
+}
+
+// Append several entries to the log.
+func (log *LogFile) appendAll(entries []LogEntry) {
+	for _, entry := range entries {
+		log.append(entry)
+	}
+}
+
+// Compress all Entries up to and including a specified index.
+// Compressed Entries cannot be accessed, but still count for the purposes of indexing with get().
+// If index > lastIndex, compresses all entries.
+// Panics if index is already compressed.
+func (log *LogFile) compressEntriesUpTo(index int) {
+	log.assertNotCompressed(index)
+	if index < log.lastIndex() {
+		numEntriesToCompress := index - log.FirstUncompressedIndex + 1
+		log.LastCompressedTerm = log.terms[numEntriesToCompress-1]
+		log.offsets = log.offsets[numEntriesToCompress:]
+		log.terms = log.terms[numEntriesToCompress:]
+		log.FirstUncompressedIndex = index + 1
+		log.maybeCompactFile()
+	} else {
+		log.reset(index, log.lastTerm())
+	}
+}
+
+// Delete all Entries after, but not including, lastIndexToKeep.
+// Panics if lastIndexToKeep + 1 has already been compressed.
+// Does nothing if index > log.lastIndex().
+func (log *LogFile) truncateAfter(lastIndexToKeep int) {
+	if lastIndexToKeep >= log.length() {
+		return
+	}
+	if lastIndexToKeep == log.lastCompressedIndex() {
+		log.reset(log.lastCompressedIndex(), log.LastCompressedTerm)
+		return
+	}
+	log.assertNotCompressed(lastIndexToKeep)
+	numEntriesToKeep := lastIndexToKeep - log.FirstUncompressedIndex + 1
+	log.size = log.offsets[numEntriesToKeep]
+	log.offsets = log.offsets[:numEntriesToKeep]
+	log.terms = log.terms[:numEntriesToKeep]
+	if err := log.file.Truncate(log.size); err != nil {
+		panic(fmt.Sprintf("Error truncating %v: %v", log.file.Name(), err))
+	}
+}
+
+// Throw away every entry, leaving a log in which everything up to and including lastCompressedIndex is compressed.
+func (log *LogFile) reset(lastCompressedIndex int, lastCompressedTerm int) {
+	if err := log.file.Truncate(0); err != nil {
+		panic(fmt.Sprintf("Error truncating %v: %v", log.file.Name(), err))
+	}
+	log.FirstUncompressedIndex = lastCompressedIndex + 1
+	log.LastCompressedTerm = lastCompressedTerm
+	log.offsets = nil
+	log.terms = nil
+	log.size = 0
+}
+
+// Observer methods ===========================================================
+
+// Get the entry at a specified index.
+// Panics if that entry has already been compressed or if index <= 0.
+func (log *LogFile) get(index int) LogEntry {
+	log.assertNotCompressed(index)
+	indexIntoEntries := index - log.FirstUncompressedIndex
+	if indexIntoEntries >= len(log.offsets) {
+		panic(fmt.Sprintf("Index %d is out of bounds, lastIndex=%d", index, log.lastIndex()))
+	}
+	end := log.size
+	if indexIntoEntries+1 < len(log.offsets) {
+		end = log.offsets[indexIntoEntries+1]
+	}
+	frame := make([]byte, end-log.offsets[indexIntoEntries])
+	if _, err := log.file.ReadAt(frame, log.offsets[indexIntoEntries]); err != nil {
+		panic(fmt.Sprintf("Error reading index %d from %v: %v", index, log.file.Name(), err))
+	}
+	kind, payload, _, err := decodeFrame(frame)
+	if err != nil || kind != logEntryFrame {
+		panic(fmt.Sprintf("Index %d in %v is corrupt", index, log.file.Name()))
+	}
+
+	var entry LogEntry
+	decoder := labgob.NewDecoder(bytes.NewBuffer(payload))
+	if err := decoder.Decode(&entry); err != nil {
+		panic(fmt.Sprintf("Error decoding index %d from %v: %v", index, log.file.Name(), err))
+	}
+	return entry
+}
+
+// Return a slice starting at index index and all entries after that.
+// Panics if index > log.length() or index has been compressed.
+func (log *LogFile) getIndicesIncludingAndAfter(index int) []LogEntry {
+	log.assertNotCompressed(index)
+	var toReturn []LogEntry
+	for i := index; i <= log.lastIndex(); i++ {
+		toReturn = append(toReturn, log.get(i))
+	}
+	return toReturn
+}
+
+// Get the length of the log, including compressed Entries.
+func (log *LogFile) length() int {
+	return log.FirstUncompressedIndex + len(log.offsets) - 1 // for the starter 1
+}
+
+// Get the last index of the log.
+// Returns 0 if the log is empty.
+func (log *LogFile) lastIndex() int {
+	return log.length()
+}
+
+func (log *LogFile) lastTerm() int {
+	if len(log.terms) == 0 {
+		return log.LastCompressedTerm
+	}
+	return log.terms[len(log.terms)-1]
+}
+
+// Returns true iff an index has been compressed.
+// If false, that index can be gotten with get().
+func (log *LogFile) indexIsCompressed(index int) bool {
+	return index < log.FirstUncompressedIndex
+}
+
+// Returns true iff an index is not compressed.
+// If true, that index can be gotten with get().
+func (log *LogFile) indexIsUncompressed(index int) bool {
+	return !log.indexIsCompressed(index)
+}
+
+// Returns the index of the last compressed log entry,
+// or 0 if no entries have been compressed.
+func (log *LogFile) lastCompressedIndex() int {
+	return log.FirstUncompressedIndex - 1
+}
+
+// Returns the term of the last compressed log entry,
+// or 0 if no entries have been compressed.
+func (log *LogFile) lastCompressedTerm() int {
+	return log.LastCompressedTerm
+}
+
+// Return the size of the uncompressed Entries in the file, in bytes.
+func (log *LogFile) sizeBytes() int {
+	return int(log.size - log.firstOffset())
+}
+
+// Private helper methods =====================================================
+
+func (log *LogFile) assertNotCompressed(index int) {
+	if index < 1 {
+		panic(fmt.Sprintf("Illegal index %d!", index))
+	}
+	if log.indexIsCompressed(index) {
+		panic(fmt.Sprintf("Index %d has been compressed already!", index))
+	}
+}
+
+// Where the first uncompressed entry starts in the file. Everything before it is compressed entries.
+func (log *LogFile) firstOffset() int64 {
+	if len(log.offsets) == 0 {
+		return log.size
+	}
+	return log.offsets[0]
+}
+
+// Rewrite the file without the compressed entries at its beginning, if they take up enough space to be worth it.
+func (log *LogFile) maybeCompactFile() {
+	compressedBytes := log.firstOffset()
+	if compressedBytes < minLogFileCompactionSize || compressedBytes < logFileCompactionRatio*int64(log.sizeBytes()) {
+		return
+	}
+	uncompressed := make([]byte, log.sizeBytes())
+	if _, err := log.file.ReadAt(uncompressed, compressedBytes); err != nil {
+		panic(fmt.Sprintf("Error reading %v: %v", log.file.Name(), err))
+	}
+	if _, err := log.file.WriteAt(uncompressed, 0); err != nil {
+		panic(fmt.Sprintf("Error compacting %v: %v", log.file.Name(), err))
+	}
+	if err := log.file.Truncate(int64(len(uncompressed))); err != nil {
+		panic(fmt.Sprintf("Error truncating %v: %v", log.file.Name(), err))
+	}
+	for i := range log.offsets {
+		log.offsets[i] -= compressedBytes
+	}
+	log.size -= compressedBytes
+}
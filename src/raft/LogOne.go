@@ -6,10 +6,11 @@ import (
 	"labgob"
 )
 
-// An abstraction of a []LogEntry that can forget about past Entries.
+// A LogStore that keeps its entries in memory.
 // This log is one-indexed and is not threadsafe.
 // Providing zero as an index will cause any method to panic.
 type LogOne struct {
+	logClaim
 	FirstUncompressedIndex int // The index of the first uncompressed entry stored in Entries
 	LastCompressedTerm     int // The term of the last compressed entry
 	UncompressedEntries    []LogEntry
@@ -17,8 +18,8 @@ type LogOne struct {
 
 // Constructor ================================================================
 
-func makeEmptyLogOne() LogOne {
-	var log LogOne
+func makeEmptyLogOne() *LogOne {
+	log := &LogOne{}
 	log.FirstUncompressedIndex = 1 // because we're 1-indexing
 	log.LastCompressedTerm = 0
 	// Entries does not need to be initialized because it defaults to empty.
@@ -83,6 +84,13 @@ func (log *LogOne) truncateAfter(lastIndexToKeep int) {
 	log.UncompressedEntries = log.UncompressedEntries[:lastIndexToKeep-log.FirstUncompressedIndex+1]
 }
 
+// Throw away every entry, leaving a log in which everything up to and including lastCompressedIndex is compressed.
+func (log *LogOne) reset(lastCompressedIndex int, lastCompressedTerm int) {
+	log.FirstUncompressedIndex = lastCompressedIndex + 1
+	log.LastCompressedTerm = lastCompressedTerm
+	log.UncompressedEntries = make([]LogEntry, 0)
+}
+
 // Observer methods ===========================================================
 
 // Get the entry at a specified index.
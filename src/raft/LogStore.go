@@ -0,0 +1,69 @@
+package raft
+
+import "sync/atomic"
+
+// Where Raft keeps its log: an abstraction of a []LogEntry that can forget about past Entries.
+// Implementations are one-indexed and are not threadsafe; Raft only calls them with its lock held.
+// Providing zero as an index will cause any method to panic.
+//
+// LogOne keeps the entries in memory and LogFile keeps them in a file on disk. Neither makes the log durable:
+// Raft saves the log through its Storage and rebuilds the LogStore from that after a restart.
+// The methods are unexported, so those two are the only LogStores: leave Config.Log nil for a LogOne, or make a
+// LogFile with MakeLogFile. Each peer needs a LogStore of its own.
+type LogStore interface {
+	// Append an entry to the log.
+	append(entry LogEntry)
+	// Append several entries to the log.
+	appendAll(entries []LogEntry)
+	// Delete all Entries after, but not including, lastIndexToKeep.
+	// Panics if lastIndexToKeep + 1 has already been compressed. Does nothing if lastIndexToKeep >= lastIndex().
+	truncateAfter(lastIndexToKeep int)
+	// Compress all Entries up to and including a specified index.
+	// Compressed Entries cannot be accessed, but still count for the purposes of indexing with get().
+	// If index > lastIndex, compresses all entries. Panics if index is already compressed.
+	compressEntriesUpTo(index int)
+	// Throw away every entry, leaving a log in which everything up to and including lastCompressedIndex is compressed
+	// and the last compressed entry has lastCompressedTerm.
+	reset(lastCompressedIndex int, lastCompressedTerm int)
+
+	// Get the entry at a specified index.
+	// Panics if that entry has already been compressed or if index <= 0.
+	get(index int) LogEntry
+	// Return a slice starting at index index and all entries after that.
+	// Panics if index has been compressed.
+	getIndicesIncludingAndAfter(index int) []LogEntry
+	// Get the length of the log, including compressed Entries.
+	length() int
+	// Get the last index of the log. Returns 0 if the log is empty.
+	lastIndex() int
+	// Get the term of the last entry, compressed or not. Returns 0 if the log is empty.
+	lastTerm() int
+	// Returns true iff an index has been compressed. If false, that index can be gotten with get().
+	indexIsCompressed(index int) bool
+	// Returns true iff an index is not compressed. If true, that index can be gotten with get().
+	indexIsUncompressed(index int) bool
+	// Returns the index of the last compressed log entry, or 0 if no entries have been compressed.
+	lastCompressedIndex() int
+	// Returns the term of the last compressed log entry, or 0 if no entries have been compressed.
+	lastCompressedTerm() int
+	// Return the size of the uncompressed Entries, in bytes.
+	sizeBytes() int
+
+	// Mark the LogStore as a peer's. Returns false if it already was, in which case nothing changes.
+	claim() bool
+	// Returns true iff claim() has been called.
+	isClaimed() bool
+}
+
+// Implements claim() and isClaimed() for the LogStores that embed it.
+type logClaim struct {
+	claimed int32
+}
+
+func (c *logClaim) claim() bool {
+	return atomic.CompareAndSwapInt32(&c.claimed, 0, 1)
+}
+
+func (c *logClaim) isClaimed() bool {
+	return atomic.LoadInt32(&c.claimed) == 1
+}
@@ -0,0 +1,456 @@
+package raft
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Every LogStore must pass these tests. Each test is run once against each implementation.
+
+type logStoreTest func(t *testing.T, makeLog func() LogStore)
+
+var logStoreImplementations = map[string]func(t *testing.T) LogStore{
+	"LogOne": func(t *testing.T) LogStore {
+		return makeEmptyLogOne()
+	},
+	"LogFile": func(t *testing.T) LogStore {
+		log, err := MakeLogFile(filepath.Join(t.TempDir(), "log"))
+		if err != nil {
+			t.Fatalf("MakeLogFile() failed: %v", err)
+		}
+		t.Cleanup(func() { log.Close() })
+		return log
+	},
+}
+
+func runLogStoreTest(t *testing.T, test logStoreTest) {
+	for name, makeLogStore := range logStoreImplementations {
+		t.Run(name, func(t *testing.T) {
+			test(t, func() LogStore { return makeLogStore(t) })
+		})
+	}
+}
+
+func assertSliceEquals(expected, actual []LogEntry) {
+	if !reflect.DeepEqual(expected, actual) {
+		panic(fmt.Sprintf("Expected %+v, got %+v\n", expected, actual))
+	}
+}
+
+// The number of entries that can still be gotten with get().
+func numUncompressed(log LogStore) int {
+	return log.lastIndex() - log.lastCompressedIndex()
+}
+
+func getTestLog(makeLog func() LogStore) (LogStore, []LogEntry) {
+	toReturn := makeLog()
+	entries := make([]LogEntry, 0)
+	numEntries := 5
+	for i := 1; i <= numEntries; i++ {
+		e := LogEntry{Index: i, Term: i}
+		entries = append(entries, e)
+		toReturn.append(e)
+	}
+	return toReturn, entries
+}
+
+func TestLogStoreEmpty(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log := makeLog()
+		assertEquals(0, log.length())
+		assertEquals(0, log.lastIndex())
+		assertEquals(0, log.lastTerm())
+		assertEquals(0, log.lastCompressedTerm())
+		assertEquals(0, log.lastCompressedIndex())
+	})
+}
+
+func TestLogStoreGetFromEmpty(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		defer func() { recover() }()
+		log := makeLog()
+		log.get(4)
+		t.Fatalf("Calling out of bounds get(4) did not panic.")
+	})
+}
+
+func TestLogStoreLengthOne(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log := makeLog()
+		e := LogEntry{}
+		log.append(e)
+		assertEquals(1, log.length())
+		assertEquals(1, log.lastIndex())
+		assertEquals(e, log.get(1))
+	})
+}
+
+func TestLogStoreGetIndicesIncludingAndAfterLengthOne(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log := makeLog()
+		e := LogEntry{}
+		log.append(e)
+		assertSliceEquals([]LogEntry{e}, log.getIndicesIncludingAndAfter(1))
+	})
+}
+
+func TestLogStoreLengthTwo(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log := makeLog()
+		e1 := LogEntry{Index: 1, Term: 1, Command: 100}
+		e2 := LogEntry{Index: 2, Term: 1, Command: 200}
+		log.appendAll([]LogEntry{e1, e2})
+
+		assertEquals(e1, log.get(1))
+		assertEquals(e2, log.get(2))
+		assertSliceEquals([]LogEntry{e1, e2}, log.getIndicesIncludingAndAfter(1))
+		assertSliceEquals([]LogEntry{e2}, log.getIndicesIncludingAndAfter(2))
+	})
+}
+
+func TestLogStoreLastTerm(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+		assertEquals(5, log.lastTerm())
+		log.truncateAfter(3)
+		assertEquals(3, log.lastTerm())
+		log.compressEntriesUpTo(3)
+		assertEquals(3, log.lastTerm())
+	})
+}
+
+func TestLogStoreTruncate(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log := makeLog()
+		e1 := LogEntry{Index: 1}
+		e2 := LogEntry{Index: 2}
+		log.append(e1)
+		log.append(e2)
+
+		assertEquals(2, log.length())
+		log.truncateAfter(1)
+		assertEquals(1, log.length())
+		assertEquals(e1, log.get(1))
+
+		// entries appended after truncating replace the ones that were removed.
+		e3 := LogEntry{Index: 2, Term: 3}
+		log.append(e3)
+		assertSliceEquals([]LogEntry{e1, e3}, log.getIndicesIncludingAndAfter(1))
+	})
+}
+
+func TestLogStoreTruncateOutOfBounds(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log := makeLog()
+		assertEquals(0, log.length())
+		log.truncateAfter(5)
+		assertEquals(0, log.length())
+
+		log.append(LogEntry{})
+		assertEquals(1, log.length())
+		log.truncateAfter(5)
+		assertEquals(1, log.length())
+	})
+}
+
+func TestLogStoreCompressEntriesDoesNotChangeLaterEntries(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, entries := getTestLog(makeLog)
+		assertSliceEquals(entries, log.getIndicesIncludingAndAfter(1))
+
+		log.compressEntriesUpTo(2)
+
+		assertEquals(len(entries), log.length())
+		// they're different because of one-indexing
+		assertEquals(entries[3], log.get(4))
+		assertEquals(entries[4], log.get(5))
+	})
+}
+
+func TestLogStoreIndexIsCompressed(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+		log.compressEntriesUpTo(2)
+
+		assertEquals(true, log.indexIsCompressed(1))
+		assertEquals(true, log.indexIsCompressed(2))
+		assertEquals(false, log.indexIsCompressed(3))
+		assertEquals(false, log.indexIsCompressed(4))
+		assertEquals(false, log.indexIsCompressed(5))
+		assertEquals(true, log.indexIsUncompressed(3))
+	})
+}
+
+func TestLogStoreLastCompressedIndex(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+
+		assertEquals(0, log.lastCompressedIndex())
+		log.compressEntriesUpTo(3)
+		assertEquals(3, log.lastCompressedIndex())
+	})
+}
+
+func TestLogStoreCompressReducesSize(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+
+		initialSize := log.sizeBytes()
+		originalString := fmt.Sprintf("%+v", log)
+
+		log.compressEntriesUpTo(log.lastIndex())
+
+		assertEquals(0, numUncompressed(log))
+		if log.sizeBytes() >= initialSize {
+			t.Fatalf("Compressing all entries in a log changed it from %v (%d bytes) to %+v (%d bytes)!",
+				originalString, initialSize, log, log.sizeBytes())
+		}
+	})
+}
+
+func TestLogStoreCannotGetCompressedEntry(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+
+		numEntries := 5
+		entryToCompressUpTo := 3
+		log.compressEntriesUpTo(entryToCompressUpTo)
+
+		for i := 1; i <= numEntries; i++ {
+			// need a separate func so that panic/recover doesn't lose track of our count
+			func(i int) {
+				if i <= entryToCompressUpTo {
+					// expect a failure
+					defer func() { recover() }()
+					assertEquals(true, log.indexIsCompressed(i))
+					log.get(i)
+					t.Fatalf("get() on compressed entry with index %d did not panic.\n", i)
+				} else {
+					assertEquals(false, log.indexIsCompressed(i))
+					e := log.get(i)
+					assertEquals(i, e.Index) // make sure they're not out of order
+				}
+			}(i)
+		}
+	})
+}
+
+func TestLogStoreCannotGetSliceCompressed(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+		log.compressEntriesUpTo(3)
+		defer func() { recover() }()
+		log.getIndicesIncludingAndAfter(1)
+		t.Fatalf("Able to get slice that included compressed elements.")
+	})
+}
+
+func TestLogStoreCompressOneIndex(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+
+		assertEquals(5, log.length())
+		assertEquals(5, log.lastIndex())
+		assertEquals(0, log.lastCompressedIndex())
+		assertEquals(0, log.lastCompressedTerm())
+		assertEquals(false, log.indexIsCompressed(1))
+
+		log.compressEntriesUpTo(1) // should compress the first entry
+
+		assertEquals(5, log.length())
+		assertEquals(5, log.lastIndex())
+		assertEquals(1, log.lastCompressedIndex())
+		assertEquals(1, log.lastCompressedTerm())
+		assertEquals(true, log.indexIsCompressed(1))
+	})
+}
+
+func TestLogStoreCannotCompressNegativeIndex(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log := makeLog()
+		defer func() { recover() }()
+		log.compressEntriesUpTo(-1)
+		t.Fatalf("Able to compress indices up to and including -1.")
+	})
+}
+
+func TestLogStoreCannotCallZeroIndex(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+
+		func() {
+			defer func() { recover() }()
+			log.get(0)
+			t.Fatalf("Able to get 0!")
+		}()
+		func() {
+			defer func() { recover() }()
+			log.compressEntriesUpTo(0)
+			t.Fatalf("Able to compressEntriesUpTo 0!")
+		}()
+		func() {
+			defer func() { recover() }()
+			log.getIndicesIncludingAndAfter(0)
+			t.Fatalf("Able to getIndicesIncludingAndAfter 0!")
+		}()
+	})
+}
+
+func TestLogStoreTruncateAfterZero(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+		log.truncateAfter(0)
+		assertEquals(0, log.length())
+
+		defer func() { recover() }()
+		log2, _ := getTestLog(makeLog)
+		log2.compressEntriesUpTo(1)
+		log2.truncateAfter(0)
+		t.Fatalf("Able to truncateAfter(0) when entries have been compressed!")
+	})
+}
+
+func TestLogStoreLastCompressedTerm(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+		log.compressEntriesUpTo(1)
+		assertEquals(1, log.lastCompressedTerm())
+
+		log.compressEntriesUpTo(2)
+		assertEquals(2, log.lastCompressedTerm())
+	})
+}
+
+func TestLogStoreCannotCompressAlreadyCompressed(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+		log.compressEntriesUpTo(3)
+
+		defer func() { recover() }() // error expected
+		log.compressEntriesUpTo(2)
+		t.Fatalf("Able to compress already-compressed entry!\n")
+	})
+}
+
+func TestLogStoreCompressAllEntriesEmpty(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log := makeLog()
+		big := 999
+
+		log.compressEntriesUpTo(big)
+		assertEquals(big, log.length())
+		assertEquals(big, log.lastCompressedIndex())
+		assertEquals(0, log.lastCompressedTerm())
+
+		e := LogEntry{}
+		log.append(e)
+		assertEquals(e, log.get(big+1))
+	})
+}
+
+func TestLogStoreCompressAllEntriesLastTerm(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+
+		big := 999
+		log.compressEntriesUpTo(big)
+		assertEquals(5, log.lastCompressedTerm())
+	})
+}
+
+func TestLogStoreCompressAllEntries(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+
+		big := 999
+		log.compressEntriesUpTo(big)
+
+		assertEquals(0, numUncompressed(log))
+		assertEquals(big, log.lastCompressedIndex())
+		assertEquals(big, log.length())
+	})
+}
+
+func TestLogStoreCompressAllEntriesMultipleTimes(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+
+		big := 999
+		log.compressEntriesUpTo(big)
+		bigger := 9999999999
+		log.compressEntriesUpTo(bigger)
+
+		assertEquals(bigger, log.length())
+		assertEquals(0, numUncompressed(log))
+		assertEquals(bigger, log.lastCompressedIndex())
+		assertEquals(5, log.lastCompressedTerm())
+	})
+}
+
+func TestLogStoreCompressAllEntriesLength(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+
+		assertEquals(5, log.length())
+		assertEquals(5, log.lastIndex())
+
+		log.compressEntriesUpTo(5)
+		assertEquals(0, numUncompressed(log))
+		assertEquals(5, log.length())
+		assertEquals(5, log.lastIndex())
+
+		big := 999
+		log.compressEntriesUpTo(big)
+		assertEquals(big, log.length())
+		assertEquals(big, log.lastIndex())
+	})
+}
+
+func TestLogStoreTruncateNoncompressed(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+
+		log.compressEntriesUpTo(3)
+		log.truncateAfter(3)
+		assertEquals(3, log.length())
+		assertEquals(0, numUncompressed(log))
+	})
+}
+
+func TestLogStoreReset(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log, _ := getTestLog(makeLog)
+		log.reset(10, 7)
+
+		assertEquals(10, log.length())
+		assertEquals(10, log.lastCompressedIndex())
+		assertEquals(7, log.lastCompressedTerm())
+		assertEquals(7, log.lastTerm())
+		assertEquals(0, numUncompressed(log))
+
+		e := LogEntry{Index: 11, Term: 8}
+		log.append(e)
+		assertEquals(e, log.get(11))
+	})
+}
+
+// A long log that is compressed bit by bit should still return the right entries, even after the LogFile rewrites its
+// file to drop the compressed ones.
+func TestLogStoreCompressManyTimes(t *testing.T) {
+	runLogStoreTest(t, func(t *testing.T, makeLog func() LogStore) {
+		log := makeLog()
+		padding := string(make([]byte, 1000))
+		for i := 1; i <= 5000; i++ {
+			log.append(LogEntry{Index: i, Term: i / 100, Command: padding})
+			if i%100 == 0 {
+				log.compressEntriesUpTo(i - 50)
+				assertEquals(i-49, log.get(i-49).Index)
+				assertEquals(i, log.get(i).Index)
+			}
+		}
+		assertEquals(50, numUncompressed(log))
+		assertEquals(49, log.lastCompressedTerm())
+	})
+}
@@ -36,15 +36,16 @@ func makeSeed() int64 {
 
 type config struct {
 	mu        sync.Mutex
-	t         *testing.T
+	t         testing.TB
 	net       *labrpc.Network
 	n         int
+	config    Config // passed to every MakeWithConfig()
 	rafts     []*Raft
 	applyErr  []string // from apply channel readers
 	connected []bool   // whether each server is on the net
 	saved     []*Persister
 	endnames  [][]string    // the port file names each sends to
-	logs      []map[int]int // copy of each server's committed Entries
+	logs      []map[int]int // copy of each server's committed Entries, with membershipPlaceholder for Memberships
 	start     time.Time     // time at which make_config() was called
 	// begin()/end() statistics
 	t0        time.Time // time at which test_test.go called cfg.begin()
@@ -56,7 +57,22 @@ type config struct {
 
 var ncpu_once sync.Once
 
-func make_config(t *testing.T, n int, unreliable bool) *config {
+// What cfg.logs holds at the index of a committed Membership.
+const membershipPlaceholder = -1
+
+func make_config(t testing.TB, n int, unreliable bool) *config {
+	return make_config_with_learners(t, n, nil, unreliable)
+}
+
+// like make_config(), but the servers listed in learners are non-voting learners.
+func make_config_with_learners(t testing.TB, n int, learners []int, unreliable bool) *config {
+	raftConfig := DefaultConfig()
+	raftConfig.Learners = learners
+	return make_config_with_raft_config(t, n, unreliable, raftConfig)
+}
+
+// like make_config(), but every server is made with raftConfig.
+func make_config_with_raft_config(t testing.TB, n int, unreliable bool, raftConfig Config) *config {
 	ncpu_once.Do(func() {
 		if runtime.NumCPU() < 2 {
 			fmt.Printf("warning: only one CPU, which may conceal locking bugs\n")
@@ -68,6 +84,7 @@ func make_config(t *testing.T, n int, unreliable bool) *config {
 	cfg.t = t
 	cfg.net = labrpc.MakeNetwork()
 	cfg.n = n
+	cfg.config = raftConfig
 	cfg.applyErr = make([]string, cfg.n)
 	cfg.rafts = make([]*Raft, cfg.n)
 	cfg.connected = make([]bool, cfg.n)
@@ -121,8 +138,10 @@ func (cfg *config) crash1(i int) {
 
 	if cfg.saved[i] != nil {
 		raftlog := cfg.saved[i].ReadRaftState()
+		metadata := cfg.saved[i].ReadMetadata()
 		cfg.saved[i] = &Persister{}
 		cfg.saved[i].SaveRaftState(raftlog)
+		cfg.saved[i].SaveMetadata(metadata)
 	}
 }
 
@@ -145,7 +164,7 @@ func (cfg *config) start1(i int) {
 	}
 
 	// a fresh set of ClientEnds.
-	ends := make([]*labrpc.ClientEnd, cfg.n)
+	ends := make([]labrpc.Endpoint, cfg.n)
 	for j := 0; j < cfg.n; j++ {
 		ends[j] = cfg.net.MakeEnd(cfg.endnames[i][j])
 		cfg.net.Connect(cfg.endnames[i][j], j)
@@ -172,7 +191,11 @@ func (cfg *config) start1(i int) {
 			err_msg := ""
 			if m.CommandValid == false {
 				// ignore other types of ApplyMsg
-			} else if v, ok := (m.Command).(int); ok {
+			} else if v, ok := (m.Command).(int); ok || m.Purpose == MEMBERSHIP {
+				if m.Purpose == MEMBERSHIP {
+					// tests only start positive ints, so this can't be mistaken for a command.
+					v = membershipPlaceholder
+				}
 				cfg.mu.Lock()
 				for j := 0; j < len(cfg.logs); j++ {
 					if old, oldok := cfg.logs[j][m.CommandIndex]; oldok && old != v {
@@ -204,7 +227,7 @@ func (cfg *config) start1(i int) {
 		}
 	}()
 
-	rf := Make(ends, i, cfg.saved[i], applyCh)
+	rf := MakeWithConfig(ends, i, cfg.saved[i], applyCh, cfg.config)
 
 	cfg.mu.Lock()
 	cfg.rafts[i] = rf
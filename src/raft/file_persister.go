@@ -0,0 +1,441 @@
+package raft
+
+import (
+	"ad"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Storage that keeps its state in a data directory, so that it survives a real process restart.
+//
+// The directory holds one generation of state at a time, plus the metadata:
+//
+//	CURRENT           the number of the current generation. Only ever replaced by an atomic rename.
+//	wal-<gen>         an append-only write-ahead log of Raft state records.
+//	snapshot-<gen>    the service's snapshot. Written to a temporary file and renamed into place, never modified.
+//	metadata          the latest metadata (Raft's term and vote). Only ever replaced by an atomic rename.
+//
+// SaveRaftState and AppendRaftState append a record to the WAL and fsync it. SaveStateAndSnapshot writes a whole new
+// generation and then switches CURRENT over to it, so a crash leaves either the old state and snapshot or the new
+// ones, never a mix.
+//
+// Every file is made of frames, each protected by a CRC-32 checksum. A frame that was torn by a crash in the middle of
+// a write fails its checksum; when the directory is next opened, the WAL is truncated back to its last good record.
+//
+// A FilePersister panics if the disk fails underneath it, because Raft cannot keep its promises without stable storage.
+type FilePersister struct {
+	mu         sync.Mutex
+	dir        string
+	generation int
+	wal        *os.File // open for appending, positioned at walSize
+	walSize    int64    // total bytes of the records in the WAL
+	raftstate  []byte   // the Raft state described by the records in the WAL
+	snapshot   []byte
+	metadata   []byte
+	closed     bool
+}
+
+const currentFileName = "CURRENT"
+const metadataFileName = "metadata"
+
+// Compact the WAL once it is at least this many times bigger than the state it holds...
+const walCompactionRatio = 4
+
+// ...and at least this many bytes long, so that small states don't cause constant rewriting.
+const minWALCompactionSize = 1 << 16
+
+// Frames =============================================================================================================
+
+// Each frame is a header followed by the payload:
+//
+//	4 bytes   length of the payload, big-endian
+//	1 byte    the kind of frame
+//	4 bytes   CRC-32 (Castagnoli) of the kind and the payload, big-endian
+const frameHeaderSize = 9
+
+type frameKind byte
+
+const (
+	raftStateFrame       frameKind = iota + 1 // a WAL record holding the entire Raft state
+	snapshotFrame                             // the contents of a snapshot file
+	generationFrame                           // the contents of the CURRENT file
+	raftStateAppendFrame                      // a WAL record holding data to add to the end of the Raft state
+	metadataFrame                             // the contents of the metadata file
+	logEntryFrame                             // one entry in a LogFile
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Returned when a frame is incomplete or its checksum does not match, which is what a torn write looks like.
+var errTornFrame = errors.New("torn or corrupt frame")
+
+func frameChecksum(kind frameKind, payload []byte) uint32 {
+	crc := crc32.Update(0, crcTable, []byte{byte(kind)})
+	return crc32.Update(crc, crcTable, payload)
+}
+
+func encodeFrame(kind frameKind, payload []byte) []byte {
+	frame := make([]byte, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	frame[4] = byte(kind)
+	binary.BigEndian.PutUint32(frame[5:9], frameChecksum(kind, payload))
+	copy(frame[frameHeaderSize:], payload)
+	return frame
+}
+
+// Decode the frame at the beginning of data.
+// Returns its kind, its payload, and the total number of bytes it takes up, or errTornFrame.
+func decodeFrame(data []byte) (kind frameKind, payload []byte, frameSize int, err error) {
+	if len(data) < frameHeaderSize {
+		return 0, nil, 0, errTornFrame
+	}
+	payloadSize := int64(binary.BigEndian.Uint32(data[0:4]))
+	if payloadSize > int64(len(data)-frameHeaderSize) {
+		return 0, nil, 0, errTornFrame
+	}
+	kind = frameKind(data[4])
+	payload = data[frameHeaderSize : frameHeaderSize+int(payloadSize)]
+	if binary.BigEndian.Uint32(data[5:9]) != frameChecksum(kind, payload) {
+		return 0, nil, 0, errTornFrame
+	}
+	return kind, payload, frameHeaderSize + int(payloadSize), nil
+}
+
+// Read a file that consists of a single frame of the given kind.
+func readFrameFile(path string, kind frameKind) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	actualKind, payload, frameSize, err := decodeFrame(data)
+	if err != nil || actualKind != kind || frameSize != len(data) {
+		return nil, fmt.Errorf("%v is corrupt", path)
+	}
+	return payload, nil
+}
+
+// Atomically replace the file at path with one holding data: write a temporary file, fsync it, rename it over path,
+// and fsync the directory so the rename itself is durable.
+func writeFileAtomically(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Opening ============================================================================================================
+
+// Open the FilePersister stored in dir, creating dir if it does not exist.
+// If the last write before a crash was torn, it is discarded and the WAL is truncated to its last good record.
+// Returns an error if the directory can't be used or if files other than the tail of the WAL are corrupt.
+func MakeFilePersister(dir string) (*FilePersister, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	fp := &FilePersister{dir: dir}
+
+	generationBytes, err := readFrameFile(fp.currentPath(), generationFrame)
+	switch {
+	case os.IsNotExist(err):
+		fp.generation = 0
+	case err != nil:
+		return nil, err
+	default:
+		fp.generation, err = strconv.Atoi(string(generationBytes))
+		if err != nil {
+			return nil, fmt.Errorf("%v is corrupt: %v", fp.currentPath(), err)
+		}
+	}
+
+	fp.snapshot, err = readFrameFile(fp.snapshotPath(fp.generation), snapshotFrame)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	fp.metadata, err = readFrameFile(filepath.Join(dir, metadataFileName), metadataFrame)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err = fp.openWAL(); err != nil {
+		return nil, err
+	}
+	fp.removeStaleFiles()
+	ad.Debug(ad.RPC, "Opened FilePersister in %v at generation %d with %d bytes of Raft state and a %d byte snapshot",
+		dir, fp.generation, len(fp.raftstate), len(fp.snapshot))
+	return fp, nil
+}
+
+// Replay the WAL of the current generation, truncate any torn tail, and open it for appending.
+func (fp *FilePersister) openWAL() error {
+	wal, err := os.OpenFile(fp.walPath(fp.generation), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(wal)
+	if err != nil {
+		wal.Close()
+		return err
+	}
+
+	validSize := 0
+	for validSize < len(data) {
+		kind, payload, frameSize, err := decodeFrame(data[validSize:])
+		if err != nil || (kind != raftStateFrame && kind != raftStateAppendFrame) {
+			ad.Debug(ad.WARN, "Discarding %d bytes of torn or corrupt records at the end of %v",
+				len(data)-validSize, wal.Name())
+			break
+		}
+		if kind == raftStateFrame {
+			fp.raftstate = copyBytes(payload)
+		} else {
+			fp.raftstate = append(fp.raftstate, payload...)
+		}
+		validSize += frameSize
+	}
+
+	if validSize < len(data) {
+		if err = wal.Truncate(int64(validSize)); err != nil {
+			wal.Close()
+			return err
+		}
+		if err = wal.Sync(); err != nil {
+			wal.Close()
+			return err
+		}
+	}
+	if _, err = wal.Seek(int64(validSize), io.SeekStart); err != nil {
+		wal.Close()
+		return err
+	}
+	fp.wal = wal
+	fp.walSize = int64(validSize)
+	return nil
+}
+
+// Remove files left behind by older generations and by crashes in the middle of writeFileAtomically.
+func (fp *FilePersister) removeStaleFiles() {
+	names, err := ioutil.ReadDir(fp.dir)
+	if err != nil {
+		return
+	}
+	current := map[string]bool{
+		currentFileName:                               true,
+		metadataFileName:                              true,
+		filepath.Base(fp.walPath(fp.generation)):      true,
+		filepath.Base(fp.snapshotPath(fp.generation)): true,
+	}
+	for _, info := range names {
+		name := info.Name()
+		isOurs := strings.HasPrefix(name, "wal-") || strings.HasPrefix(name, "snapshot-") || strings.HasSuffix(name, ".tmp")
+		if isOurs && !current[name] {
+			os.Remove(filepath.Join(fp.dir, name))
+		}
+	}
+}
+
+func (fp *FilePersister) currentPath() string {
+	return filepath.Join(fp.dir, currentFileName)
+}
+
+func (fp *FilePersister) walPath(generation int) string {
+	return filepath.Join(fp.dir, fmt.Sprintf("wal-%d", generation))
+}
+
+func (fp *FilePersister) snapshotPath(generation int) string {
+	return filepath.Join(fp.dir, fmt.Sprintf("snapshot-%d", generation))
+}
+
+// Storage API ========================================================================================================
+
+func (fp *FilePersister) SaveRaftState(state []byte) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	if fp.closed {
+		ad.Debug(ad.WARN, "Dropping SaveRaftState() because the FilePersister in %v is closed", fp.dir)
+		return
+	}
+	fp.appendToWAL(raftStateFrame, state)
+	fp.raftstate = copyBytes(state)
+	fp.maybeCompactWAL()
+}
+
+func (fp *FilePersister) AppendRaftState(data []byte) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	if fp.closed {
+		ad.Debug(ad.WARN, "Dropping AppendRaftState() because the FilePersister in %v is closed", fp.dir)
+		return
+	}
+	fp.appendToWAL(raftStateAppendFrame, data)
+	fp.raftstate = append(fp.raftstate, data...)
+	fp.maybeCompactWAL()
+}
+
+func (fp *FilePersister) ReadRaftState() []byte {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return copyBytes(fp.raftstate)
+}
+
+func (fp *FilePersister) RaftStateSize() int {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return len(fp.raftstate)
+}
+
+func (fp *FilePersister) SaveMetadata(metadata []byte) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	if fp.closed {
+		ad.Debug(ad.WARN, "Dropping SaveMetadata() because the FilePersister in %v is closed", fp.dir)
+		return
+	}
+	if err := writeFileAtomically(filepath.Join(fp.dir, metadataFileName), encodeFrame(metadataFrame, metadata)); err != nil {
+		panic(fmt.Sprintf("Error writing metadata: %v", err))
+	}
+	fp.metadata = copyBytes(metadata)
+}
+
+func (fp *FilePersister) ReadMetadata() []byte {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return copyBytes(fp.metadata)
+}
+
+// Save both Raft state and K/V snapshot as a single atomic action.
+func (fp *FilePersister) SaveStateAndSnapshot(raftState []byte, snapshot []byte) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	if fp.closed {
+		ad.Debug(ad.WARN, "Dropping SaveStateAndSnapshot() because the FilePersister in %v is closed", fp.dir)
+		return
+	}
+
+	// Write the next generation off to the side, then switch over to it all at once by replacing CURRENT.
+	newGeneration := fp.generation + 1
+	if err := writeFileAtomically(fp.snapshotPath(newGeneration), encodeFrame(snapshotFrame, snapshot)); err != nil {
+		panic(fmt.Sprintf("Error writing snapshot: %v", err))
+	}
+	if err := writeFileAtomically(fp.walPath(newGeneration), encodeFrame(raftStateFrame, raftState)); err != nil {
+		panic(fmt.Sprintf("Error writing WAL: %v", err))
+	}
+	generationBytes := []byte(strconv.Itoa(newGeneration))
+	if err := writeFileAtomically(fp.currentPath(), encodeFrame(generationFrame, generationBytes)); err != nil {
+		panic(fmt.Sprintf("Error writing %v: %v", fp.currentPath(), err))
+	}
+
+	fp.wal.Close()
+	fp.generation = newGeneration
+	fp.raftstate = nil
+	if err := fp.openWAL(); err != nil {
+		panic(fmt.Sprintf("Error reopening WAL: %v", err))
+	}
+	ad.AssertEquals(len(raftState), len(fp.raftstate))
+	fp.snapshot = copyBytes(snapshot)
+	fp.removeStaleFiles()
+}
+
+func (fp *FilePersister) ReadSnapshot() []byte {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return copyBytes(fp.snapshot)
+}
+
+func (fp *FilePersister) SnapshotSize() int {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return len(fp.snapshot)
+}
+
+// Close the files in the data directory. Saves after Close are dropped, so that a killed Raft can't change the
+// directory out from under a new FilePersister opened on it.
+func (fp *FilePersister) Close() error {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	if fp.closed {
+		return nil
+	}
+	fp.closed = true
+	return fp.wal.Close()
+}
+
+// Private helper methods =============================================================================================
+
+// Append a record to the WAL and fsync it.
+// ONLY CALL WITH THE LOCK
+func (fp *FilePersister) appendToWAL(kind frameKind, payload []byte) {
+	frame := encodeFrame(kind, payload)
+	if _, err := fp.wal.Write(frame); err != nil {
+		panic(fmt.Sprintf("Error appending to %v: %v", fp.wal.Name(), err))
+	}
+	if err := fp.wal.Sync(); err != nil {
+		panic(fmt.Sprintf("Error syncing %v: %v", fp.wal.Name(), err))
+	}
+	fp.walSize += int64(len(frame))
+}
+
+// ONLY CALL WITH THE LOCK
+func (fp *FilePersister) maybeCompactWAL() {
+	if fp.walSize >= minWALCompactionSize && fp.walSize >= walCompactionRatio*int64(len(fp.raftstate)+frameHeaderSize) {
+		fp.compactWAL()
+	}
+}
+
+// Rewrite the WAL so that it holds a single record with the whole Raft state.
+// ONLY CALL WITH THE LOCK
+func (fp *FilePersister) compactWAL() {
+	ad.Debug(ad.TRACE, "Compacting %d byte WAL holding %d bytes of state", fp.walSize, len(fp.raftstate))
+	frame := encodeFrame(raftStateFrame, fp.raftstate)
+	if err := writeFileAtomically(fp.walPath(fp.generation), frame); err != nil {
+		panic(fmt.Sprintf("Error compacting WAL: %v", err))
+	}
+	fp.wal.Close()
+	if err := fp.openWAL(); err != nil {
+		panic(fmt.Sprintf("Error reopening WAL: %v", err))
+	}
+	ad.AssertEquals(int64(len(frame)), fp.walSize)
+}
+
+func copyBytes(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	dataCopy := make([]byte, len(data))
+	copy(dataCopy, data)
+	return dataCopy
+}
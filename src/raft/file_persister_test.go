@@ -0,0 +1,362 @@
+package raft
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"labrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The crash test re-runs this test binary as a child process that writes to the directory named by this variable.
+const crashDirEnvVarName = "DFS_FILE_PERSISTER_CRASH_DIR"
+
+func makeTestFilePersister(t *testing.T, dir string) *FilePersister {
+	fp, err := MakeFilePersister(dir)
+	if err != nil {
+		t.Fatalf("MakeFilePersister(%v) failed: %v", dir, err)
+	}
+	return fp
+}
+
+func TestFilePersisterEmpty(t *testing.T) {
+	fp := makeTestFilePersister(t, t.TempDir())
+	defer fp.Close()
+	if fp.RaftStateSize() != 0 || fp.SnapshotSize() != 0 || fp.ReadRaftState() != nil || fp.ReadSnapshot() != nil {
+		t.Fatalf("new FilePersister is not empty")
+	}
+}
+
+func TestFilePersisterReopen(t *testing.T) {
+	dir := t.TempDir()
+	fp := makeTestFilePersister(t, dir)
+	fp.SaveRaftState([]byte("state 1"))
+	fp.SaveStateAndSnapshot([]byte("state 2"), []byte("snapshot 2"))
+	fp.SaveRaftState([]byte("state 3"))
+	fp.Close()
+
+	fp = makeTestFilePersister(t, dir)
+	defer fp.Close()
+	if string(fp.ReadRaftState()) != "state 3" {
+		t.Fatalf("ReadRaftState() = %q after reopening, expected %q", fp.ReadRaftState(), "state 3")
+	}
+	if string(fp.ReadSnapshot()) != "snapshot 2" {
+		t.Fatalf("ReadSnapshot() = %q after reopening, expected %q", fp.ReadSnapshot(), "snapshot 2")
+	}
+	if fp.RaftStateSize() != len("state 3") || fp.SnapshotSize() != len("snapshot 2") {
+		t.Fatalf("sizes are wrong after reopening")
+	}
+
+	// only the current generation should be left in the directory.
+	names := make([]string, 0)
+	infos, _ := ioutil.ReadDir(dir)
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	if strings.Join(names, " ") != "CURRENT snapshot-1 wal-1" {
+		t.Fatalf("unexpected files in data directory: %v", names)
+	}
+}
+
+func TestFilePersisterSavesAfterCloseAreDropped(t *testing.T) {
+	dir := t.TempDir()
+	fp := makeTestFilePersister(t, dir)
+	fp.SaveRaftState([]byte("kept"))
+	fp.Close()
+	fp.SaveRaftState([]byte("dropped"))
+	fp.SaveStateAndSnapshot([]byte("dropped"), []byte("dropped"))
+
+	fp = makeTestFilePersister(t, dir)
+	defer fp.Close()
+	if string(fp.ReadRaftState()) != "kept" || fp.ReadSnapshot() != nil {
+		t.Fatalf("a save after Close() changed the data directory")
+	}
+}
+
+func TestFilePersisterAppendAndMetadata(t *testing.T) {
+	dir := t.TempDir()
+	fp := makeTestFilePersister(t, dir)
+	fp.SaveRaftState([]byte("state"))
+	fp.AppendRaftState([]byte(" 1"))
+	fp.SaveMetadata([]byte("metadata 1"))
+	fp.AppendRaftState([]byte(" 2"))
+	fp.SaveMetadata([]byte("metadata 2"))
+	fp.Close()
+
+	fp = makeTestFilePersister(t, dir)
+	if string(fp.ReadRaftState()) != "state 1 2" {
+		t.Fatalf("ReadRaftState() = %q after reopening, expected %q", fp.ReadRaftState(), "state 1 2")
+	}
+	if string(fp.ReadMetadata()) != "metadata 2" {
+		t.Fatalf("ReadMetadata() = %q after reopening, expected %q", fp.ReadMetadata(), "metadata 2")
+	}
+
+	// a new generation starts from the saved state, and the metadata is unaffected.
+	fp.SaveStateAndSnapshot([]byte("new state"), []byte("snapshot"))
+	fp.AppendRaftState([]byte(" 3"))
+	fp.Close()
+	fp = makeTestFilePersister(t, dir)
+	defer fp.Close()
+	if string(fp.ReadRaftState()) != "new state 3" || string(fp.ReadMetadata()) != "metadata 2" {
+		t.Fatalf("ReadRaftState() = %q and ReadMetadata() = %q after a snapshot", fp.ReadRaftState(), fp.ReadMetadata())
+	}
+}
+
+func TestFilePersisterCompactsWAL(t *testing.T) {
+	dir := t.TempDir()
+	fp := makeTestFilePersister(t, dir)
+	state := bytes.Repeat([]byte("x"), 1000)
+	for i := 0; i < 1000; i++ {
+		state[0] = byte(i)
+		fp.SaveRaftState(state)
+	}
+	fp.Close()
+
+	info, err := os.Stat(fp.walPath(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > minWALCompactionSize+int64(len(state)+frameHeaderSize) {
+		t.Fatalf("WAL is %d bytes, expected it to be compacted", info.Size())
+	}
+	fp = makeTestFilePersister(t, dir)
+	defer fp.Close()
+	if !bytes.Equal(fp.ReadRaftState(), state) {
+		t.Fatalf("wrong state after compaction")
+	}
+}
+
+func TestFilePersisterTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	fp := makeTestFilePersister(t, dir)
+	fp.SaveRaftState([]byte("state 1"))
+	fp.SaveRaftState([]byte("state 2"))
+	fp.Close()
+	walPath := fp.walPath(0)
+	goodWAL, _ := ioutil.ReadFile(walPath)
+
+	// a record whose tail never made it to disk.
+	torn := encodeFrame(raftStateFrame, []byte("state 3"))
+	ioutil.WriteFile(walPath, append(append([]byte{}, goodWAL...), torn[:len(torn)-3]...), 0644)
+	fp = makeTestFilePersister(t, dir)
+	if string(fp.ReadRaftState()) != "state 2" {
+		t.Fatalf("ReadRaftState() = %q after a torn write, expected %q", fp.ReadRaftState(), "state 2")
+	}
+	fp.Close()
+	if walAfter, _ := ioutil.ReadFile(walPath); !bytes.Equal(walAfter, goodWAL) {
+		t.Fatalf("torn record was not truncated from the WAL")
+	}
+
+	// a record that is the right length but whose contents are garbled.
+	garbled := encodeFrame(raftStateFrame, []byte("state 3"))
+	garbled[len(garbled)-1] ^= 0xff
+	ioutil.WriteFile(walPath, append(append([]byte{}, goodWAL...), garbled...), 0644)
+	fp = makeTestFilePersister(t, dir)
+	if string(fp.ReadRaftState()) != "state 2" {
+		t.Fatalf("ReadRaftState() = %q after a garbled write, expected %q", fp.ReadRaftState(), "state 2")
+	}
+
+	// and new records go after the last good one.
+	fp.SaveRaftState([]byte("state 4"))
+	fp.Close()
+	fp = makeTestFilePersister(t, dir)
+	defer fp.Close()
+	if string(fp.ReadRaftState()) != "state 4" {
+		t.Fatalf("ReadRaftState() = %q, expected %q", fp.ReadRaftState(), "state 4")
+	}
+}
+
+func TestFilePersisterCorruptSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	fp := makeTestFilePersister(t, dir)
+	fp.SaveStateAndSnapshot([]byte("state"), []byte("snapshot"))
+	fp.Close()
+
+	data, _ := ioutil.ReadFile(fp.snapshotPath(1))
+	data[len(data)-1] ^= 0xff
+	ioutil.WriteFile(fp.snapshotPath(1), data, 0644)
+	if _, err := MakeFilePersister(dir); err == nil {
+		t.Fatalf("MakeFilePersister() succeeded with a corrupt snapshot")
+	}
+}
+
+// The state and snapshot the crash test writes for step i. The contents are derived from i so that a restarted
+// process can tell exactly what it should see.
+func crashTestState(i int) []byte {
+	return []byte(fmt.Sprintf("%d:%s", i, bytes.Repeat([]byte{byte(i)}, 4096+i%1000)))
+}
+
+func crashTestSnapshot(i int) []byte {
+	return []byte(fmt.Sprintf("%d:%s", i, bytes.Repeat([]byte{byte(i + 1)}, 8192+i%1000)))
+}
+
+// Parse and check something written by crashTestState or crashTestSnapshot. Returns -1 for nil.
+func crashTestStep(t *testing.T, data []byte, expected func(int) []byte) int {
+	if data == nil {
+		return -1
+	}
+	colon := bytes.IndexByte(data, ':')
+	i, err := strconv.Atoi(string(data[:max(colon, 0)]))
+	if colon < 0 || err != nil || !bytes.Equal(data, expected(i)) {
+		t.Fatalf("read back data that was never written: %q...", data[:min(len(data), 20)])
+	}
+	return i
+}
+
+// Not a real test: this is the child process for TestFilePersisterSurvivesKill, which kills it in the middle of a write.
+func TestFilePersisterCrashHelper(t *testing.T) {
+	dir := os.Getenv(crashDirEnvVarName)
+	if dir == "" {
+		t.Skip("only run as a child of TestFilePersisterSurvivesKill")
+	}
+	fp := makeTestFilePersister(t, dir)
+	i := crashTestStep(t, fp.ReadRaftState(), crashTestState) + 1
+	fmt.Println("ready")
+	for ; ; i++ {
+		if i%10 == 0 {
+			fp.SaveStateAndSnapshot(crashTestState(i), crashTestSnapshot(i))
+		} else {
+			fp.SaveRaftState(crashTestState(i))
+		}
+	}
+}
+
+func TestFilePersisterSurvivesKill(t *testing.T) {
+	dir := t.TempDir()
+	lastState := -1
+	for round := 0; round < 5; round++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestFilePersisterCrashHelper$")
+		cmd.Env = append(os.Environ(), crashDirEnvVarName+"="+dir)
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		// wait until the child has opened the directory, let it write for a while, and then kill it mid-write.
+		buf := make([]byte, len("ready"))
+		if _, err = stdout.Read(buf); err != nil {
+			t.Fatalf("child process failed to start: %v", err)
+		}
+		time.Sleep(time.Duration(100+50*round) * time.Millisecond)
+		cmd.Process.Kill()
+		cmd.Wait()
+
+		fp := makeTestFilePersister(t, dir)
+		state := crashTestStep(t, fp.ReadRaftState(), crashTestState)
+		snapshot := crashTestStep(t, fp.ReadSnapshot(), crashTestSnapshot)
+		fp.Close()
+
+		if state <= lastState {
+			t.Fatalf("round %d: no progress was saved (state %d, previously %d)", round, state, lastState)
+		}
+		// the snapshot was saved along with the state at the last multiple of 10.
+		if snapshot != state-state%10 && !(snapshot == -1 && state < 10) {
+			t.Fatalf("round %d: snapshot %d does not go with state %d", round, snapshot, state)
+		}
+		lastState = state
+	}
+}
+
+// Run a 3-peer Raft group on FilePersisters, kill every peer, and check that the restarted group still has the log.
+func TestFilePersisterRaftRestart(t *testing.T) {
+	const servers = 3
+	dirs := make([]string, servers)
+	for i := range dirs {
+		dirs[i] = t.TempDir()
+	}
+	net := labrpc.MakeNetwork()
+	defer net.Cleanup()
+
+	start := func(round int) ([]*Raft, []*FilePersister, []chan ApplyMsg) {
+		rafts := make([]*Raft, servers)
+		persisters := make([]*FilePersister, servers)
+		applyChs := make([]chan ApplyMsg, servers)
+		for i := 0; i < servers; i++ {
+			ends := make([]labrpc.Endpoint, servers)
+			for j := 0; j < servers; j++ {
+				endname := fmt.Sprintf("restart-%d-%d-%d", round, i, j)
+				ends[j] = net.MakeEnd(endname)
+				net.Connect(endname, j)
+				net.Enable(endname, true)
+			}
+			persisters[i] = makeTestFilePersister(t, dirs[i])
+			applyChs[i] = make(chan ApplyMsg, 100)
+			if round == 0 {
+				rafts[i] = Make(ends, i, persisters[i], applyChs[i])
+			} else {
+				// after restarting, rebuild the log in a LogFile instead of in memory.
+				log, err := MakeLogFile(filepath.Join(t.TempDir(), "log"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { log.Close() })
+				config := DefaultConfig()
+				config.Log = log
+				rafts[i] = MakeWithConfig(ends, i, persisters[i], applyChs[i], config)
+			}
+			srv := labrpc.MakeServer()
+			srv.AddService(labrpc.MakeService(rafts[i]))
+			net.AddServer(i, srv)
+		}
+		return rafts, persisters, applyChs
+	}
+
+	// submit cmd until it is committed, and return the commands the leader applied up to and including it.
+	commit := func(rafts []*Raft, applyChs []chan ApplyMsg, cmd int) []int {
+		for iters := 0; iters < 50; iters++ {
+			for i, rf := range rafts {
+				if _, _, isLeader := rf.Start(cmd); !isLeader {
+					continue
+				}
+				applied := make([]int, 0)
+				timeout := time.After(2 * RaftElectionTimeout)
+				for {
+					select {
+					case msg := <-applyChs[i]:
+						applied = append(applied, msg.Command.(int))
+						if msg.Command.(int) == cmd {
+							return applied
+						}
+					case <-timeout:
+						t.Fatalf("command %d was not committed", cmd)
+					}
+				}
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Fatalf("no leader was elected")
+		return nil
+	}
+
+	rafts, persisters, applyChs := start(0)
+	for cmd := 1; cmd <= 10; cmd++ {
+		commit(rafts, applyChs, cmd)
+	}
+	for i := 0; i < servers; i++ {
+		net.DeleteServer(i)
+		rafts[i].Kill()
+		persisters[i].Close()
+	}
+
+	rafts, persisters, applyChs = start(1)
+	defer func() {
+		for i := 0; i < servers; i++ {
+			rafts[i].Kill()
+			persisters[i].Close()
+		}
+	}()
+	// nothing was snapshotted, so a new leader applies the whole log again, which must still be there.
+	applied := commit(rafts, applyChs, 11)
+	expected := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	if fmt.Sprint(applied) != fmt.Sprint(expected) {
+		t.Fatalf("after restarting, applied %v, expected %v", applied, expected)
+	}
+}
@@ -13,10 +13,31 @@ import (
 	"sync"
 )
 
+// Storage is where a Raft peer keeps its persistent state and the service's snapshot.
+// Implementations must make each Save durable before returning, so that it survives a crash and restart.
+// Persister keeps everything in memory (for tests); FilePersister keeps it in a data directory on disk.
+type Storage interface {
+	SaveRaftState(state []byte)
+	// Add data to the end of the Raft state. ReadRaftState returns everything saved by the last SaveRaftState
+	// (or SaveStateAndSnapshot) followed by everything appended since then.
+	AppendRaftState(data []byte)
+	ReadRaftState() []byte
+	RaftStateSize() int
+	// Save a small piece of state, such as the current term and vote, separately from the Raft state.
+	SaveMetadata(metadata []byte)
+	ReadMetadata() []byte
+	// Save both Raft state and K/V snapshot as a single atomic action.
+	SaveStateAndSnapshot(raftState []byte, snapshot []byte)
+	ReadSnapshot() []byte
+	SnapshotSize() int
+}
+
+// An in-memory Storage. Nothing survives a process restart.
 type Persister struct {
 	mu        sync.Mutex
 	raftstate []byte
 	snapshot  []byte
+	metadata  []byte
 }
 
 func MakePersister() *Persister {
@@ -27,8 +48,10 @@ func (ps *Persister) Copy() *Persister {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 	np := MakePersister()
-	np.raftstate = ps.raftstate
+	// raftstate must be copied, not shared, because AppendRaftState may write into its spare capacity.
+	np.raftstate = copyBytes(ps.raftstate)
 	np.snapshot = ps.snapshot
+	np.metadata = ps.metadata
 	return np
 }
 
@@ -38,6 +61,12 @@ func (ps *Persister) SaveRaftState(state []byte) {
 	ps.raftstate = state
 }
 
+func (ps *Persister) AppendRaftState(data []byte) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.raftstate = append(ps.raftstate, data...)
+}
+
 func (ps *Persister) ReadRaftState() []byte {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
@@ -50,6 +79,18 @@ func (ps *Persister) RaftStateSize() int {
 	return len(ps.raftstate)
 }
 
+func (ps *Persister) SaveMetadata(metadata []byte) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.metadata = metadata
+}
+
+func (ps *Persister) ReadMetadata() []byte {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.metadata
+}
+
 // Save both Raft state and K/V snapshot as a single atomic action,
 // to help avoid them getting out of sync.
 func (ps *Persister) SaveStateAndSnapshot(raftState []byte, snapshot []byte) {
@@ -2,8 +2,10 @@ package raft
 
 import (
 	"ad"
+	"fmt"
 	"labrpc"
 	_ "net/http/pprof"
+	"sort"
 	"time"
 )
 
@@ -27,33 +29,41 @@ func (rf *Raft) Start(command interface{}) (int, int, bool) {
 	}
 	ad.DebugObj(rf, ad.TRACE, "Received Start(%+v)", command)
 
+	index := rf.startWithLock(command)
+	term := rf.CurrentTerm
+	isLeader := true
+
+	ad.DebugObj(rf, ad.RPC, "returning (%d, %d, %t) from Start(%+v)", index, term, isLeader, command)
+	ad.DebugObj(rf, ad.TRACE, "Log=%+v", rf.Log)
+
+	return index, term, isLeader
+}
+
+// Append command to the leader's log, send it to the peers, and return its index.
+// ONLY CALL WITH THE LOCK, and only on the leader.
+func (rf *Raft) startWithLock(command interface{}) int {
 	// +1 because it will go after the current last entry
 	entry := LogEntry{rf.CurrentTerm, command, rf.Log.length() + 1}
 	rf.Log.append(entry)
-	if rf.CurrentElectionState == Leader {
-		rf.matchIndex[rf.me] = rf.Log.length()
-	}
-	rf.writePersist()
+	rf.matchIndex[rf.me] = rf.Log.length()
+	rf.persistAppendedEntries([]LogEntry{entry})
+	rf.noteAppendedEntries([]LogEntry{entry})
 
 	ad.DebugObj(rf, ad.TRACE, "Sending new Log message to peers")
 	for peerNum, _ := range rf.peers {
 		go rf.sendAppendEntries(peerNum, true)
 	}
-
-	index := rf.lastLogIndex()
-	term := rf.CurrentTerm
-	isLeader := true
-
-	ad.DebugObj(rf, ad.RPC, "returning (%d, %d, %t) from Start(%+v)", index, term, isLeader, command)
-	ad.DebugObj(rf, ad.TRACE, "Log=%+v", rf.Log)
-
-	return index, term, isLeader
+	return entry.Index
 }
 
 // The tester calls Kill() when a Raft instance won't be needed again.
 func (rf *Raft) Kill() {
 	rf.lock()
 	ad.DebugObj(rf, ad.RPC, "Dying")
+	if rf.isAlive {
+		// otherwise the threads would wait forever, and keep the whole raft from being garbage collected
+		close(rf.dead)
+	}
 	rf.isAlive = false
 	rf.unlock()
 }
@@ -62,6 +72,8 @@ func (rf *Raft) Kill() {
 func (rf *Raft) ApplierThread() {
 	for {
 		select {
+		case <-rf.dead:
+			return
 		case <-rf.toApply:
 			rf.lock()
 
@@ -70,18 +82,37 @@ func (rf *Raft) ApplierThread() {
 				return
 			}
 
-			if rf.commitIndex > rf.lastApplied {
+			if rf.snapshotToApply != nil || rf.commitIndex > rf.lastApplied {
 				ad.DebugObj(rf, ad.TRACE, "ApplierThread has awoken! Ready to apply indices up to %d", rf.commitIndex)
 
-				for rf.lastApplied < rf.commitIndex {
+				for rf.snapshotToApply != nil || rf.lastApplied < rf.commitIndex {
+					if rf.snapshotToApply != nil {
+						// InstallSnapshot has already moved lastApplied past it.
+						applyMsg := *rf.snapshotToApply
+						rf.snapshotToApply = nil
+						ad.DebugObj(rf, ad.TRACE, "About to apply the snapshot ending at index %d", applyMsg.CommandIndex)
+						rf.unlock()
+
+						if !rf.sendToApplyCh(applyMsg) {
+							return
+						}
+
+						rf.lock()
+						continue
+					}
 					indexToApply := rf.lastApplied + 1
 					entryToApply := rf.Log.get(indexToApply)
 					applyMsg := ApplyMsg{true, entryToApply.Command, indexToApply, rf.CurrentTerm, COMMAND}
+					if _, ok := entryToApply.Command.(Membership); ok {
+						applyMsg.Purpose = MEMBERSHIP
+					}
 					ad.DebugObj(rf, ad.TRACE, "About to apply %+v at index %d", entryToApply, indexToApply)
 					rf.lastApplied = indexToApply
 					rf.unlock()
 
-					rf.applyCh <- applyMsg
+					if !rf.sendToApplyCh(applyMsg) {
+						return
+					}
 
 					rf.lock() // for the next iteration
 				}
@@ -94,6 +125,26 @@ func (rf *Raft) ApplierThread() {
 	}
 }
 
+// Tell the ApplierThread that there is something to apply. Call it in its own goroutine, since it waits until the
+// ApplierThread is listening, or the raft is killed.
+func (rf *Raft) wakeApplier() {
+	select {
+	case rf.toApply <- true:
+	case <-rf.dead:
+	}
+}
+
+// Send applyMsg on applyCh, unless the raft is killed first, in which case the service has probably stopped reading
+// it. Returns whether it was sent. ONLY CALL WITHOUT THE LOCK.
+func (rf *Raft) sendToApplyCh(applyMsg ApplyMsg) bool {
+	select {
+	case rf.applyCh <- applyMsg:
+		return true
+	case <-rf.dead:
+		return false
+	}
+}
+
 // Keeps track of running for election every so often.
 func (rf *Raft) ElectionThread() {
 	for {
@@ -104,12 +155,15 @@ func (rf *Raft) ElectionThread() {
 			rf.unlock()
 
 			// blocking read
-			<-rf.becomeFollower
+			select {
+			case <-rf.dead:
+				return
+			case <-rf.becomeFollower:
+			}
 
 			rf.lock()
 			ad.DebugObj(rf, ad.RPC, "Becoming Follower")
 			rf.CurrentElectionState = Follower
-			rf.writePersist()
 			rf.resetElectionTimeout()
 		}
 
@@ -119,8 +173,12 @@ func (rf *Raft) ElectionThread() {
 		}
 
 		if time.Now().After(rf.candidateDeclareTime) {
-			ad.DebugObj(rf, ad.TRACE, "I should run for election")
-			go rf.runForElection()
+			if rf.CurrentElectionState == Learner {
+				ad.DebugObj(rf, ad.TRACE, "Haven't heard from a leader in a while, but I am a learner so I won't run for election")
+			} else {
+				ad.DebugObj(rf, ad.TRACE, "I should run for election")
+				go rf.runForElection()
+			}
 			rf.resetElectionTimeout()
 		}
 		sleepDuration := time.Until(rf.candidateDeclareTime)
@@ -140,7 +198,12 @@ func (rf *Raft) HeartbeatThread() {
 
 			// blocking read
 		waitForBecomeLeader:
-			term := <-rf.becomeLeader
+			var term int
+			select {
+			case <-rf.dead:
+				return
+			case term = <-rf.becomeLeader:
+			}
 
 			rf.lock()
 			if term < rf.CurrentTerm {
@@ -157,10 +220,11 @@ func (rf *Raft) HeartbeatThread() {
 			assert(term == rf.CurrentTerm)
 			ad.DebugObj(rf, ad.RPC, "Becoming leader")
 			rf.CurrentElectionState = Leader
-			rf.writePersist()
+			rf.noteLeader(rf.me)
 			for peerNum, _ := range rf.peers {
 				rf.nextIndex[peerNum] = rf.lastLogIndex() + 1
 				rf.matchIndex[peerNum] = 0
+				rf.learnerReportedCaughtUp[peerNum] = false
 			}
 			rf.matchIndex[rf.me] = rf.Log.length()
 		}
@@ -176,7 +240,7 @@ func (rf *Raft) HeartbeatThread() {
 			go rf.sendAppendEntries(peerNum, true)
 		}
 		rf.unlock()
-		time.Sleep(getHeartbeatTimeout())
+		time.Sleep(rf.config.HeartbeatInterval)
 
 	}
 }
@@ -186,9 +250,10 @@ func (rf *Raft) runForElection() {
 	rf.lock()
 	rf.CurrentTerm += 1
 	rf.VotedFor = -1
+	rf.leaderId = -1
 	rf.CurrentElectionState = Candidate
 	ad.DebugObj(rf, ad.RPC, "Starting election and advancing term to %d", rf.CurrentTerm)
-	rf.writePersist()
+	rf.persistMetadata()
 	repliesChan := make(chan *RequestVoteReply, len(rf.peers)-1)
 	// The term the election was started in
 	electionTerm := rf.CurrentTerm
@@ -199,8 +264,10 @@ func (rf *Raft) runForElection() {
 			rf.lock()
 			rf.VotedFor = rf.me
 			ad.DebugObj(rf, ad.TRACE, "voting for itself")
-			rf.writePersist()
+			rf.persistMetadata()
 			rf.unlock()
+		} else if rf.isLearner[peerNum] {
+			// learners don't vote, so there's no point in asking them
 		} else {
 			go func(peerNum int, repliesChan chan *RequestVoteReply) {
 				rf.sendRequestVote(peerNum, repliesChan)
@@ -236,13 +303,17 @@ func (rf *Raft) runForElection() {
 			// non-blocking send
 			// send the term number to prevent a bug where the raft advances to a new term before it notices it's
 			// become a leader, so it becomes a second false leader.
-			go func(term int) { rf.becomeLeader <- term }(rf.CurrentTerm)
+			go func(term int) {
+				select {
+				case rf.becomeLeader <- term:
+				case <-rf.dead:
+				}
+			}(rf.CurrentTerm)
 			rf.unlock()
 			return
 		} else if noVotes >= requiredToWin {
 			ad.DebugObj(rf, ad.RPC, "Got %d no votes, can't win election. Reverting to follower", noVotes)
 			rf.CurrentElectionState = Follower
-			rf.writePersist()
 			rf.unlock()
 			return
 		} else {
@@ -252,9 +323,24 @@ func (rf *Raft) runForElection() {
 	}
 }
 
+// Create a raft server with DefaultConfig(), in which every peer is a voter.
+func Make(peers []labrpc.Endpoint, me int, persister Storage, applyCh chan ApplyMsg) *Raft {
+	return MakeWithConfig(peers, me, persister, applyCh, DefaultConfig())
+}
+
 // Create a raft server.
+// Panics if config is invalid (see Config.Validate).
+func MakeWithConfig(peers []labrpc.Endpoint, me int, persister Storage, applyCh chan ApplyMsg, config Config) *Raft {
+	if err := config.Validate(); err != nil {
+		panic(fmt.Sprintf("Invalid raft.Config: %v", err))
+	}
+	if config.Log == nil {
+		config.Log = makeEmptyLogOne()
+	}
+	if !config.Log.claim() {
+		panic("Invalid raft.Config: Log is already used by another peer")
+	}
 
-func Make(peers []*labrpc.ClientEnd, me int, persister *Persister, applyCh chan ApplyMsg) *Raft {
 	rf := &Raft{}
 	rf.lock() // i don't think this matters but i'm not taking chances
 
@@ -263,23 +349,36 @@ func Make(peers []*labrpc.ClientEnd, me int, persister *Persister, applyCh chan
 	rf.me = me
 	rf.isAlive = true
 	rf.applyCh = applyCh
+	rf.config = config
+	rf.rand = config.makeRand()
 
 	rf.toApply = make(chan bool)
 	rf.becomeLeader = make(chan int)
 	rf.becomeFollower = make(chan bool)
+	rf.dead = make(chan struct{})
+	rf.isLearner = make([]bool, len(peers))
+	rf.learnerReportedCaughtUp = make([]bool, len(peers))
+	rf.compressedLearners = append([]int{}, config.Learners...)
+	sort.Ints(rf.compressedLearners)
+	for _, learner := range config.Learners {
+		rf.isLearner[learner] = true
+	}
 
 	rf.VotedFor = -1
-	rf.Log = makeEmptyLogOne()
+	rf.leaderId = -1
+	rf.Log = config.Log
+	rf.Log.reset(0, 0)
 	rf.commitIndex = 0
 	rf.lastApplied = 0
-	rf.CurrentElectionState = Follower
-	rf.candidateDeclareTime = time.Now().Add(getElectionTimeout())
+	rf.CurrentElectionState = rf.passiveElectionState()
+	rf.resetElectionTimeout()
 	rf.CurrentTerm = 0
 	rf.nextIndex = make([]int, len(peers))
 	rf.matchIndex = make([]int, len(peers))
 
 	// initialize from state persisted before a crash
-	rf.readPersist(persister.ReadRaftState())
+	rf.readPersist(persister.ReadMetadata(), persister.ReadRaftState())
+	rf.updateMembershipFromLog()
 
 	// store the state in case we crash immediately
 	rf.writePersist()
@@ -69,10 +69,14 @@ func (rf *Raft) sendAppendEntries(peerNum int, includeEntries bool) {
 	var entries []LogEntry
 	if includeEntries {
 		indexOfFirstEntry := args.PrevLogIndex + 1
-		numMissingEntries := rf.Log.length() - indexOfFirstEntry + 1
-		entries = make([]LogEntry, numMissingEntries)
-		numCopiedEntries := copy(entries, rf.Log.getIndicesIncludingAndAfter(indexOfFirstEntry))
-		assertEquals(numMissingEntries, numCopiedEntries)
+		numEntriesToSend := rf.Log.length() - indexOfFirstEntry + 1
+		if rf.config.MaxEntriesPerAppendEntries > 0 {
+			numEntriesToSend = min(numEntriesToSend, rf.config.MaxEntriesPerAppendEntries)
+		}
+		entries = make([]LogEntry, numEntriesToSend)
+		for i := range entries {
+			entries[i] = rf.Log.get(indexOfFirstEntry + i)
+		}
 	} else {
 		// no need to do anything because an uninitialized slice is empty and ready to use
 	}
@@ -80,7 +84,11 @@ func (rf *Raft) sendAppendEntries(peerNum int, includeEntries bool) {
 	reply := &AppendEntriesReply{}
 
 	sendTime := time.Now().Format("03:04:05.000")
-	rfLastLogIndexBeforeSendingRPC := rf.lastLogIndex()
+	// if the follower accepts, it will have everything up to here.
+	lastIndexSent := rf.lastLogIndex()
+	if includeEntries {
+		lastIndexSent = args.PrevLogIndex + len(args.Entries)
+	}
 	ad.DebugObj(rf, ad.RPC, "Sending AppendEntries with %d entries to %v", len(args.Entries), peerNum)
 	rf.unlock()
 
@@ -109,9 +117,15 @@ func (rf *Raft) sendAppendEntries(peerNum int, includeEntries bool) {
 	ad.DebugObj(rf, ad.TRACE, "received %+v, ok=%t from AppendEntries to %d sent in term %d at %v",
 		reply, ok, peerNum, args.Term, sendTime)
 	if ok && reply.Success {
-		rf.nextIndex[peerNum] = rfLastLogIndexBeforeSendingRPC + 1
-		rf.matchIndex[peerNum] = rfLastLogIndexBeforeSendingRPC
+		rf.nextIndex[peerNum] = lastIndexSent + 1
+		rf.matchIndex[peerNum] = lastIndexSent
 		ad.DebugObj(rf, ad.TRACE, "reply success, nextIndex=%+v, matchIndex=%+v", rf.nextIndex, rf.matchIndex)
+		rf.noteLearnerProgress(peerNum)
+		if rf.config.MaxEntriesPerAppendEntries > 0 && len(args.Entries) == rf.config.MaxEntriesPerAppendEntries &&
+			lastIndexSent < rf.lastLogIndex() {
+			// MaxEntriesPerAppendEntries held some entries back, so send the rest right away.
+			go rf.sendAppendEntries(peerNum, true)
+		}
 
 		// If there exists an N such that N > commitIndex, a majority of matchIndex[i] ≥ N, and
 		// Log[N].term == CurrentTerm: set commitIndex = N (§5.3, §5.4).
@@ -122,7 +136,8 @@ func (rf *Raft) sendAppendEntries(peerNum int, includeEntries bool) {
 				numMatchIndexAtLeastN := 0
 				ad.DebugObj(rf, ad.TRACE, "matchIndex=%+v", rf.matchIndex)
 				for peerNum, _ := range rf.peers {
-					if rf.matchIndex[peerNum] >= n {
+					// learners' matchIndex is tracked, but they don't count toward a majority
+					if !rf.isLearner[peerNum] && rf.matchIndex[peerNum] >= n {
 						numMatchIndexAtLeastN++
 					}
 				}
@@ -130,7 +145,7 @@ func (rf *Raft) sendAppendEntries(peerNum int, includeEntries bool) {
 					ad.DebugObj(rf, ad.TRACE, "%d peers matchIndex %d, increasing commitIndex from %d to %d",
 						numMatchIndexAtLeastN, n, rf.commitIndex, n)
 					rf.commitIndex = n
-					go func() { rf.toApply <- true }()
+					go rf.wakeApplier()
 				} else {
 					ad.DebugObj(rf, ad.TRACE, "only %d peers match up to index %d, can't commit", numMatchIndexAtLeastN, n)
 					break
@@ -192,6 +207,9 @@ func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply
 
 	rf.updateTermIfNecessary(args.Term)
 	reply.Term = rf.CurrentTerm
+	if args.Term == rf.CurrentTerm {
+		rf.noteLeader(args.LeaderID)
+	}
 
 	var reason string
 	switch {
@@ -234,7 +252,7 @@ func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply
 
 	if reply.Success {
 		assert(rf.CurrentElectionState != Leader)
-		rf.CurrentElectionState = Follower
+		rf.CurrentElectionState = rf.passiveElectionState()
 
 		// Log optimization: these should default to -1, not 0 (the default for integers)
 		reply.ConflictingTerm = -1
@@ -266,7 +284,8 @@ func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply
 					ad.DebugObj(rf, ad.TRACE, "ConflictingTerm=%d and firstIndexOfConflictingTerm=%d",
 						reply.ConflictingTerm, reply.FirstIndexOfConflictingTerm)
 
-					rf.writePersist()
+					rf.persistTruncation(entry.Index - 1)
+					rf.updateMembershipFromLog()
 					reply.Success = false
 				}
 			} // end if
@@ -285,12 +304,11 @@ func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply
 			entryAlreadyInLog := false
 			// We know that these can't be compressed entries; if they were, then args.PrevLogIndex would be
 			// < rf.lastIndexInSnapshot() and the RPC would have been rejected.
-			for _, uncompressedEntryInLog := range rf.Log.UncompressedEntries {
-				//if entryFromLeader == uncompressedEntryInLog {
-				if LogEntryEquals(entryFromLeader, uncompressedEntryInLog) {
-					ad.DebugObj(rf, ad.TRACE, "%+v is already in the log (uncompressed)", entryFromLeader)
-					entryAlreadyInLog = true
-				}
+			// Entries are only equal if their indices are, so the only candidate is the one at the same index.
+			if entryFromLeader.Index <= rf.lastLogIndex() && rf.Log.indexIsUncompressed(entryFromLeader.Index) &&
+				LogEntryEquals(entryFromLeader, rf.Log.get(entryFromLeader.Index)) {
+				ad.DebugObj(rf, ad.TRACE, "%+v is already in the log (uncompressed)", entryFromLeader)
+				entryAlreadyInLog = true
 			}
 			if !entryAlreadyInLog {
 				toAppend = append(toAppend, entryFromLeader)
@@ -300,7 +318,8 @@ func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply
 			}
 		}
 		rf.Log.appendAll(toAppend)
-		rf.writePersist()
+		rf.persistAppendedEntries(toAppend)
+		rf.noteAppendedEntries(toAppend)
 		ad.DebugObj(rf, ad.TRACE, "done appending, Log=%+v", rf.Log)
 	} else {
 		ad.DebugObj(rf, ad.TRACE, "No Log Entries in AppendEntries")
@@ -313,7 +332,7 @@ func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply
 		assert(newCommitIndex >= rf.commitIndex) // make sure commitIndex only increases
 		rf.commitIndex = newCommitIndex
 		if rf.commitIndex > rf.lastApplied {
-			go func() { rf.toApply <- true }()
+			go rf.wakeApplier()
 		}
 	}
 
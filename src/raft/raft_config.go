@@ -0,0 +1,65 @@
+package raft
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Settings for a Raft peer. Start from DefaultConfig() and change what you need.
+type Config struct {
+	Learners []int    // indices into peers[] of the initial non-voting members. Every peer must be given the same learners.
+	Log      LogStore // where to keep the log, which no other peer may use. Whatever it holds is replaced with the log saved in the Storage. nil for in memory.
+
+	MinElectionTimeout time.Duration // a follower that hears nothing from a leader for a random time between these
+	MaxElectionTimeout time.Duration // two runs for election.
+	HeartbeatInterval  time.Duration // how often a leader sends AppendEntries to every peer. Must be < MinElectionTimeout.
+
+	MaxEntriesPerAppendEntries int   // at most this many log entries are sent in one AppendEntries RPC, 0 for no limit.
+	SnapshotChunkSize          int   // a snapshot is sent in InstallSnapshot RPCs of this many bytes, 0 to send it in one.
+	Seed                       int64 // seeds the randomness in election timeouts, 0 to pick a seed.
+}
+
+// The settings used by Make().
+func DefaultConfig() Config {
+	return Config{
+		MinElectionTimeout: 500 * time.Millisecond,
+		MaxElectionTimeout: 1000 * time.Millisecond,
+		HeartbeatInterval:  150 * time.Millisecond,
+	}
+}
+
+// Returns an error describing the first problem with the config, or nil if there are none.
+func (config Config) Validate() error {
+	switch {
+	case config.MinElectionTimeout <= 0:
+		return fmt.Errorf("MinElectionTimeout must be positive, not %v", config.MinElectionTimeout)
+	case config.MaxElectionTimeout <= config.MinElectionTimeout:
+		return fmt.Errorf("MaxElectionTimeout (%v) must be greater than MinElectionTimeout (%v)",
+			config.MaxElectionTimeout, config.MinElectionTimeout)
+	case config.HeartbeatInterval <= 0:
+		return fmt.Errorf("HeartbeatInterval must be positive, not %v", config.HeartbeatInterval)
+	case config.HeartbeatInterval >= config.MinElectionTimeout:
+		// otherwise followers would time out and start elections while the leader is healthy.
+		return fmt.Errorf("HeartbeatInterval (%v) must be less than MinElectionTimeout (%v)",
+			config.HeartbeatInterval, config.MinElectionTimeout)
+	case config.MaxEntriesPerAppendEntries < 0:
+		return fmt.Errorf("MaxEntriesPerAppendEntries must not be negative, not %d", config.MaxEntriesPerAppendEntries)
+	case config.SnapshotChunkSize < 0:
+		return fmt.Errorf("SnapshotChunkSize must not be negative, not %d", config.SnapshotChunkSize)
+	case config.Log != nil && config.Log.isClaimed():
+		// peers that share a LogStore would overwrite each other's entries.
+		return fmt.Errorf("Log is already used by another peer")
+	}
+	return nil
+}
+
+// Make the source of randomness for a peer's election timeouts.
+func (config Config) makeRand() *rand.Rand {
+	seed := config.Seed
+	if seed == 0 {
+		// drawn from the global source, which is seeded (and the seed printed) in init(), so runs can be reproduced.
+		seed = rand.Int63()
+	}
+	return rand.New(rand.NewSource(seed))
+}
@@ -0,0 +1,154 @@
+package raft
+
+import (
+	"bytes"
+	"fmt"
+	"labrpc"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("DefaultConfig() is invalid: %v", err)
+	}
+
+	invalid := map[string]func(config *Config){
+		"zero election timeout":      func(config *Config) { config.MinElectionTimeout = 0 },
+		"empty election range":       func(config *Config) { config.MaxElectionTimeout = config.MinElectionTimeout },
+		"zero heartbeat":             func(config *Config) { config.HeartbeatInterval = 0 },
+		"heartbeat slower than vote": func(config *Config) { config.HeartbeatInterval = config.MinElectionTimeout },
+		"negative entries":           func(config *Config) { config.MaxEntriesPerAppendEntries = -1 },
+		"negative chunk size":        func(config *Config) { config.SnapshotChunkSize = -1 },
+		"log in use": func(config *Config) {
+			config.Log = makeEmptyLogOne()
+			config.Log.claim()
+		},
+	}
+	for name, change := range invalid {
+		config := DefaultConfig()
+		change(&config)
+		if config.Validate() == nil {
+			t.Errorf("%v: Validate() accepted %+v", name, config)
+		}
+	}
+}
+
+// Two peers made from copies of one Config can't end up writing to the same LogStore.
+func TestConfigSharedLog(t *testing.T) {
+	config := DefaultConfig()
+	config.Log = makeEmptyLogOne()
+	rf := MakeWithConfig([]labrpc.Endpoint{nil}, 0, MakePersister(), make(chan ApplyMsg, 10), config)
+	defer rf.Kill()
+	if err := config.Validate(); err == nil {
+		t.Fatalf("Validate() accepted a Log that a peer is using")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("MakeWithConfig() accepted a Log that a peer is using")
+		}
+	}()
+	other := MakeWithConfig([]labrpc.Endpoint{nil}, 0, MakePersister(), make(chan ApplyMsg, 10), config)
+	other.Kill()
+}
+
+// A fast-ticking config still elects a leader and agrees, even when entries trickle out a few at a time.
+func TestConfigFastTimingSmallBatches(t *testing.T) {
+	config := DefaultConfig()
+	config.MinElectionTimeout = 150 * time.Millisecond
+	config.MaxElectionTimeout = 300 * time.Millisecond
+	config.HeartbeatInterval = 50 * time.Millisecond
+	config.MaxEntriesPerAppendEntries = 2
+	config.Seed = 1
+	cfg := make_config_with_raft_config(t, 3, false, config)
+	defer cfg.cleanup()
+
+	cfg.checkOneLeader()
+	// a disconnected follower misses many entries, which then reach it two at a time.
+	cfg.disconnect(2)
+	for i := 1; i <= 20; i++ {
+		cfg.one(i, 2, true)
+	}
+	cfg.connect(2)
+	cfg.one(21, 3, true)
+}
+
+// A follower that missed everything before a snapshot receives the snapshot in SnapshotChunkSize pieces.
+func TestConfigSnapshotInChunks(t *testing.T) {
+	const servers = 3
+	const straggler = 2
+	const numCommands = 10
+	config := DefaultConfig()
+	config.SnapshotChunkSize = 16
+
+	net := labrpc.MakeNetwork()
+	defer net.Cleanup()
+	endname := func(from, to int) string { return fmt.Sprintf("chunks-%d-%d", from, to) }
+	rafts := make([]*Raft, servers)
+	applyChs := make([]chan ApplyMsg, servers)
+	for i := 0; i < servers; i++ {
+		ends := make([]labrpc.Endpoint, servers)
+		for j := 0; j < servers; j++ {
+			ends[j] = net.MakeEnd(endname(i, j))
+			net.Connect(endname(i, j), j)
+			net.Enable(endname(i, j), i != straggler && j != straggler)
+		}
+		applyChs[i] = make(chan ApplyMsg, 100)
+		rafts[i] = MakeWithConfig(ends, i, MakePersister(), applyChs[i], config)
+		srv := labrpc.MakeServer()
+		srv.AddService(labrpc.MakeService(rafts[i]))
+		net.AddServer(i, srv)
+	}
+	defer func() {
+		for _, rf := range rafts {
+			rf.Kill()
+		}
+	}()
+
+	// the two connected peers commit some commands and snapshot all of them.
+	for cmd := 1; cmd <= numCommands; cmd++ {
+		for !startOnAny(rafts[:straggler], cmd) {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	snapshot := bytes.Repeat([]byte("snapshot"), 10)
+	for i := 0; i < straggler; i++ {
+		waitForApply(t, applyChs[i], func(msg ApplyMsg) bool { return msg.CommandIndex == numCommands })
+		rafts[i].Snapshot(snapshot, numCommands)
+	}
+
+	for i := 0; i < servers; i++ {
+		net.Enable(endname(i, straggler), true)
+		net.Enable(endname(straggler, i), true)
+	}
+	msg := waitForApply(t, applyChs[straggler], func(msg ApplyMsg) bool { return msg.Purpose == STATE_RESET })
+	if !bytes.Equal(msg.Command.([]byte), snapshot) || msg.CommandIndex != numCommands {
+		t.Fatalf("straggler installed snapshot %q at index %d, expected %q at index %d",
+			msg.Command, msg.CommandIndex, snapshot, numCommands)
+	}
+}
+
+// Start cmd on whichever of rafts is the leader. Returns false if none of them is.
+func startOnAny(rafts []*Raft, cmd int) bool {
+	for _, rf := range rafts {
+		if _, _, isLeader := rf.Start(cmd); isLeader {
+			return true
+		}
+	}
+	return false
+}
+
+// Read from applyCh until done returns true for a message, and return that message.
+func waitForApply(t *testing.T, applyCh chan ApplyMsg, done func(msg ApplyMsg) bool) ApplyMsg {
+	timeout := time.After(5 * RaftElectionTimeout)
+	for {
+		select {
+		case msg := <-applyCh:
+			if done(msg) {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting to apply")
+		}
+	}
+}
@@ -10,11 +10,15 @@ type InstallSnapshotArgs struct {
 	LeaderId          int    //so follower can redirect clients
 	LastIncludedIndex int    //the snapshot replaces all entries up through and including this index
 	LastIncludedTerm  int    //term of lastIncludedIndex
+	Offset            int    //byte offset where chunk is positioned in the snapshot file
 	Data              []byte //raw bytes of the snapshot chunk, starting at offset
+	Done              bool   //true if this is the last chunk
+	Learners          []int  //the learners as of lastIncludedIndex
 }
 
 type InstallSnapshotReply struct {
-	Term int // follower's term, for leader to update itself
+	Term    int  // follower's term, for leader to update itself
+	Success bool // false if the chunk was rejected, in which case the leader should stop sending this snapshot
 }
 
 func (rf *Raft) sendInstallSnapshot(peerNum int) {
@@ -25,13 +29,15 @@ func (rf *Raft) sendInstallSnapshot(peerNum int) {
 		return
 	}
 
-	args := InstallSnapshotArgs{}
-	args.Term = rf.CurrentTerm
-	args.LeaderId = rf.me
-	args.LastIncludedIndex = rf.lastIndexInSnapshot()
-	args.LastIncludedTerm = rf.Log.lastCompressedTerm()
-	args.Data = rf.persister.ReadSnapshot()
-	reply := InstallSnapshotReply{}
+	term := rf.CurrentTerm
+	lastIncludedIndex := rf.lastIndexInSnapshot()
+	lastIncludedTerm := rf.Log.lastCompressedTerm()
+	learners := rf.compressedLearners
+	snapshot := rf.persister.ReadSnapshot()
+	chunkSize := len(snapshot)
+	if rf.config.SnapshotChunkSize > 0 {
+		chunkSize = rf.config.SnapshotChunkSize
+	}
 
 	//if rf.matchIndex[peerNum] >= rf.lastIndexInSnapshot() {
 	//	ad.DebugObj(rf, ad.TRACE, "Would send InstallSnapshot to %d, LastIncludedIndex=%d, but they already match indices through %d, "+
@@ -40,24 +46,48 @@ func (rf *Raft) sendInstallSnapshot(peerNum int) {
 	//	return
 	//}
 
-	ad.DebugObj(rf, ad.RPC, "Sending InstallSnapshot to %d, LastIncludedIndex = %d", peerNum, args.LastIncludedIndex)
+	ad.DebugObj(rf, ad.RPC, "Sending InstallSnapshot to %d, LastIncludedIndex = %d", peerNum, lastIncludedIndex)
 	rf.unlock()
 
-	ok := rf.peers[peerNum].Call("Raft.InstallSnapshot", &args, &reply)
-
-	rf.lock()
-	defer rf.unlock()
-
-	if !(rf.isAlive && rf.CurrentElectionState == Leader) {
-		return
-	}
-	if ok {
-		rf.updateTermIfNecessary(reply.Term)
-		ad.DebugObj(rf, ad.TRACE, "Received successful response to InstallSnapshot sent to %d with LastIncludedIndex=%d", peerNum, args.LastIncludedIndex)
-		rf.matchIndex[peerNum] = args.LastIncludedIndex
-		rf.nextIndex[peerNum] = args.LastIncludedIndex + 1
-	} else {
-		ad.DebugObj(rf, ad.TRACE, "Received failed response to InstallSnapshot sent to %d with LastIncludedIndex=%d", peerNum, args.LastIncludedIndex)
+	// send the snapshot in chunks of at most chunkSize bytes, stopping at the first one that fails.
+	for offset := 0; ; offset += chunkSize {
+		args := InstallSnapshotArgs{}
+		args.Term = term
+		args.LeaderId = rf.me
+		args.LastIncludedIndex = lastIncludedIndex
+		args.LastIncludedTerm = lastIncludedTerm
+		args.Offset = offset
+		args.Data = snapshot[offset:min(offset+chunkSize, len(snapshot))]
+		args.Done = offset+chunkSize >= len(snapshot)
+		args.Learners = learners
+		reply := InstallSnapshotReply{}
+
+		ok := rf.peers[peerNum].Call("Raft.InstallSnapshot", &args, &reply)
+
+		rf.lock()
+		if !(rf.isAlive && rf.CurrentElectionState == Leader && rf.CurrentTerm == term) {
+			rf.unlock()
+			return
+		}
+		if ok {
+			rf.updateTermIfNecessary(reply.Term)
+		}
+		if !(ok && reply.Success) {
+			ad.DebugObj(rf, ad.TRACE, "Received failed response to InstallSnapshot sent to %d with LastIncludedIndex=%d, "+
+				"Offset=%d", peerNum, lastIncludedIndex, offset)
+			rf.unlock()
+			return
+		}
+		if args.Done {
+			ad.DebugObj(rf, ad.TRACE, "Received successful response to InstallSnapshot sent to %d with LastIncludedIndex=%d",
+				peerNum, lastIncludedIndex)
+			rf.matchIndex[peerNum] = lastIncludedIndex
+			rf.nextIndex[peerNum] = lastIncludedIndex + 1
+			rf.noteLearnerProgress(peerNum)
+			rf.unlock()
+			return
+		}
+		rf.unlock()
 	}
 }
 
@@ -74,20 +104,37 @@ func (rf *Raft) InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapsho
 	debugStr := fmt.Sprintf("InstallSnapshot from %d, LastIncludedIndex=%d", args.LeaderId, args.LastIncludedIndex)
 	ad.DebugObj(rf, ad.RPC, "Received %v", debugStr)
 	rf.updateTermIfNecessary(args.Term)
+	rf.noteLeader(args.LeaderId)
 	rf.resetElectionTimeout()
 	if args.Term == rf.CurrentTerm && rf.CurrentElectionState == Leader {
 		panic("Received InstallSnapshot from another leader in the same term?!")
 	}
 
 	// 2. Create new snapshot file if first chunk (offset is 0)
-	// offset is always 0
-	snapshotInProgress := make([]byte, len(args.Data))
+	if args.Offset == 0 {
+		rf.snapshotInProgress = make([]byte, 0, len(args.Data))
+		rf.snapshotInProgressIndex = args.LastIncludedIndex
+	}
 
 	// 3. Write data into snapshot file at given offset
-	copy(snapshotInProgress, args.Data)
+	if args.Offset != len(rf.snapshotInProgress) || args.LastIncludedIndex != rf.snapshotInProgressIndex {
+		// An earlier chunk went missing, or this chunk belongs to a different snapshot. The leader will start over.
+		ad.DebugObj(rf, ad.RPC, "Rejecting %v because it has Offset=%d but I have %d bytes of the snapshot ending at %d",
+			debugStr, args.Offset, len(rf.snapshotInProgress), rf.snapshotInProgressIndex)
+		rf.snapshotInProgress = nil
+		rf.unlock()
+		return
+	}
+	rf.snapshotInProgress = append(rf.snapshotInProgress, args.Data...)
+	reply.Success = true
 
 	// 4. Reply and wait for more data chunks if done is false
-	// N/A, done is always true
+	if !args.Done {
+		rf.unlock()
+		return
+	}
+	snapshotInProgress := rf.snapshotInProgress
+	rf.snapshotInProgress = nil
 
 	//5. Save snapshot file, discard any existing or partial snapshot with a smaller index
 	//if args.LastIncludedIndex <= rf.lastIndexInSnapshot() {
@@ -127,7 +174,7 @@ func (rf *Raft) InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapsho
 		// This is a slightly newer snapshot, but we don't need to tell the state machine about it.
 		ad.DebugObj(rf, ad.RPC, "Snapshot ends with applied but not compressed entries, updating stored snapshot with %v "+
 			"and changing nothing else", debugStr)
-		rf.snapshotWithLock(args.Data, args.LastIncludedIndex)
+		rf.snapshotWithLock(snapshotInProgress, args.LastIncludedIndex, args.Learners)
 		rf.unlock()
 		return
 
@@ -136,7 +183,7 @@ func (rf *Raft) InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapsho
 		// Update lastApplied so that the next command applied is the one that follows this snapshot.
 		ad.DebugObj(rf, ad.TRACE, "Snapshot ends with committed but not applied entries, Updating LastApplied to %d", args.LastIncludedIndex)
 		rf.lastApplied = args.LastIncludedIndex
-		rf.snapshotWithLock(args.Data, args.LastIncludedIndex)
+		rf.snapshotWithLock(snapshotInProgress, args.LastIncludedIndex, args.Learners)
 
 	case rf.commitIndex < args.LastIncludedIndex &&
 		args.LastIncludedIndex < rf.lastLogIndex():
@@ -145,21 +192,23 @@ func (rf *Raft) InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapsho
 			args.LastIncludedIndex)
 		rf.lastApplied = args.LastIncludedIndex
 		rf.commitIndex = args.LastIncludedIndex
-		rf.snapshotWithLock(args.Data, args.LastIncludedIndex)
+		rf.snapshotWithLock(snapshotInProgress, args.LastIncludedIndex, args.Learners)
 
 	case rf.lastLogIndex() <= args.LastIncludedIndex:
 		// Discard the entire log because it is obselete at this point.
 		ad.DebugObj(rf, ad.TRACE, "Snapshot ends with entries after the end of my log, replacing entire log.")
 		rf.lastApplied = args.LastIncludedIndex
 		rf.commitIndex = args.LastIncludedIndex
-		rf.snapshotWithLock(args.Data, args.LastIncludedIndex) // automatically handles compression and log replacement
-		assertEquals(0, len(rf.Log.UncompressedEntries))
+		rf.snapshotWithLock(snapshotInProgress, args.LastIncludedIndex, args.Learners) // automatically handles compression and log replacement
+		assertEquals(rf.Log.lastCompressedIndex(), rf.Log.lastIndex())
 	}
 
 	// 8. Reset state machine using snapshot contents
 	ad.DebugObj(rf, ad.RPC, "Finished InstallSnapshot from %d, LastIncludedIndex=%d, resetting state machine state", args.LeaderId,
 		args.LastIncludedIndex)
 
+	// The ApplierThread sends it, so that the entries after it can't overtake it on applyCh.
+	rf.snapshotToApply = &ApplyMsg{true, snapshotInProgress, args.LastIncludedIndex, rf.CurrentTerm, STATE_RESET}
 	rf.unlock()
-	rf.applyCh <- ApplyMsg{true, snapshotInProgress, args.LastIncludedIndex, rf.CurrentTerm, STATE_RESET}
+	go rf.wakeApplier()
 }
@@ -0,0 +1,100 @@
+package raft
+
+import (
+	"ad"
+	"time"
+)
+
+// Leadership transfer hands leadership to a chosen voter, e.g. before the leader's server is taken down, as in §3.10
+// of Ongaro's dissertation. The leader brings the peer's log up to date and then sends it TimeoutNow, which makes it
+// run for election right away instead of waiting for its election timeout. Its log is as up to date as anyone's, and
+// its term is higher than the leader's, so it almost always wins.
+
+type TimeoutNowArgs struct {
+	Term     int // leader's term
+	LeaderId int
+}
+
+type TimeoutNowReply struct {
+	Term    int  // receiver's CurrentTerm, for the leader to update itself
+	Started bool // true iff the receiver started an election
+}
+
+// Make the voter peerNum the leader. Returns once peerNum has started an election, which it almost always wins.
+// Returns nil without doing anything if peerNum is already the leader as far as this peer knows, so it is safe to
+// retry. Fails with ErrNotCaughtUp if peerNum's log can't be brought up to date within MaxElectionTimeout.
+func (rf *Raft) TransferLeadership(peerNum int) error {
+	rf.lock()
+	defer rf.unlock()
+
+	switch {
+	case peerNum < 0 || peerNum >= len(rf.peers):
+		return ErrNoSuchPeer
+	case rf.leaderId == peerNum:
+		ad.DebugObj(rf, ad.TRACE, "Not transferring leadership to %d because it is already the leader", peerNum)
+		return nil
+	case rf.CurrentElectionState != Leader:
+		return ErrNotLeader
+	case rf.isLearner[peerNum]:
+		return ErrPeerIsLearner
+	}
+
+	ad.DebugObj(rf, ad.RPC, "Transferring leadership to %d", peerNum)
+	term := rf.CurrentTerm
+	deadline := time.Now().Add(rf.config.MaxElectionTimeout)
+	for rf.matchIndex[peerNum] < rf.lastLogIndex() {
+		if time.Now().After(deadline) {
+			ad.DebugObj(rf, ad.RPC, "Giving up on transferring leadership to %d because it only matches up to %d of %d",
+				peerNum, rf.matchIndex[peerNum], rf.lastLogIndex())
+			return ErrNotCaughtUp
+		}
+		go rf.sendAppendEntries(peerNum, true)
+		rf.unlock()
+		time.Sleep(rf.config.HeartbeatInterval)
+		rf.lock()
+		if rf.CurrentElectionState != Leader || rf.CurrentTerm != term || rf.isLearner[peerNum] {
+			if rf.leaderId == peerNum {
+				return nil
+			}
+			return ErrNotLeader
+		}
+	}
+
+	args := TimeoutNowArgs{Term: term, LeaderId: rf.me}
+	reply := TimeoutNowReply{}
+	rf.unlock()
+	ok := rf.peers[peerNum].Call("Raft.TimeoutNow", &args, &reply)
+	rf.lock()
+	if !ok {
+		return ErrTransferFailed
+	}
+	rf.updateTermIfNecessary(reply.Term)
+	if !reply.Started {
+		return ErrTransferFailed
+	}
+	return nil
+}
+
+// TimeoutNow RPC handler.
+func (rf *Raft) TimeoutNow(args *TimeoutNowArgs, reply *TimeoutNowReply) {
+	rf.lock()
+	defer rf.unlock()
+
+	if !rf.isAlive {
+		ad.DebugObj(rf, ad.TRACE, "Ignoring TimeoutNow from %d because I am dead", args.LeaderId)
+		return
+	}
+
+	rf.updateTermIfNecessary(args.Term)
+	reply.Term = rf.CurrentTerm
+	if args.Term < rf.CurrentTerm || rf.CurrentElectionState != Follower {
+		ad.DebugObj(rf, ad.RPC, "Ignoring TimeoutNow from %d in term %d because I am a %v in term %d",
+			args.LeaderId, args.Term, rf.CurrentElectionState, rf.CurrentTerm)
+		return
+	}
+
+	ad.DebugObj(rf, ad.RPC, "Got TimeoutNow from %d, running for election", args.LeaderId)
+	reply.Started = true
+	rf.resetElectionTimeout()
+	go rf.runForElection()
+}
@@ -0,0 +1,85 @@
+package raft
+
+import "ad"
+
+// Learners are non-voting members of the cluster. They receive log entries and snapshots from the leader
+// just like followers, but they never run for election, never grant votes, and are not counted when the
+// leader decides whether an entry is committed. Once a learner has caught up with the leader, it is
+// ready to be promoted to a voter with Promote (see raft_membership.go).
+
+// How many entries a learner's matchIndex may trail the leader's commitIndex by and still be considered caught up.
+const learnerCatchUpMargin = 5
+
+// Returns true iff this Raft is a learner.
+func (rf *Raft) IsLearner() bool {
+	rf.lock()
+	defer rf.unlock()
+	return rf.isLearner[rf.me]
+}
+
+// Returns true iff this Raft is the leader and the learner peerNum has caught up with it closely enough to be
+// promoted to a voter. Returns false for peers that are not learners.
+func (rf *Raft) LearnerIsCaughtUp(peerNum int) bool {
+	rf.lock()
+	defer rf.unlock()
+	return rf.learnerIsCaughtUp(peerNum)
+}
+
+// Returns the learners that have caught up with this leader, in increasing order.
+// Returns an empty slice if this Raft is not the leader.
+func (rf *Raft) CaughtUpLearners() []int {
+	rf.lock()
+	defer rf.unlock()
+	caughtUp := make([]int, 0)
+	for peerNum := range rf.peers {
+		if rf.learnerIsCaughtUp(peerNum) {
+			caughtUp = append(caughtUp, peerNum)
+		}
+	}
+	return caughtUp
+}
+
+// ONLY CALL WITH THE LOCK
+func (rf *Raft) learnerIsCaughtUp(peerNum int) bool {
+	if rf.CurrentElectionState != Leader || !rf.isLearner[peerNum] {
+		return false
+	}
+	// matchIndex is reset to 0 when we become leader, so a learner we have not heard from yet is not caught up
+	// unless there is nothing to catch up on.
+	heardFrom := rf.matchIndex[peerNum] > 0 || rf.commitIndex == 0
+	return heardFrom && rf.matchIndex[peerNum]+learnerCatchUpMargin >= rf.commitIndex
+}
+
+// Report a learner the first time it catches up during this term of leadership.
+// Call whenever matchIndex[peerNum] advances.
+// ONLY CALL WITH THE LOCK
+func (rf *Raft) noteLearnerProgress(peerNum int) {
+	if !rf.isLearner[peerNum] || rf.learnerReportedCaughtUp[peerNum] {
+		return
+	}
+	if rf.learnerIsCaughtUp(peerNum) {
+		ad.DebugObj(rf, ad.RPC, "Learner %d has caught up (matchIndex=%d, commitIndex=%d) and is ready to be promoted",
+			peerNum, rf.matchIndex[peerNum], rf.commitIndex)
+		rf.learnerReportedCaughtUp[peerNum] = true
+	}
+}
+
+// The number of peers (including this one) that vote and count toward a majority.
+func (rf *Raft) numVoters() int {
+	numVoters := 0
+	for _, isLearner := range rf.isLearner {
+		if !isLearner {
+			numVoters++
+		}
+	}
+	return numVoters
+}
+
+// The state to fall back to when this Raft is neither the leader nor a candidate.
+// ONLY CALL WITH THE LOCK
+func (rf *Raft) passiveElectionState() ElectionState {
+	if rf.isLearner[rf.me] {
+		return Learner
+	}
+	return Follower
+}
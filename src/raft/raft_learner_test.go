@@ -0,0 +1,108 @@
+package raft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLearnerNeverLeads(t *testing.T) {
+	servers := 3
+	learner := 2
+	cfg := make_config_with_learners(t, servers, []int{learner}, false)
+	defer cfg.cleanup()
+
+	cfg.begin("Test (learner): learners never become leader")
+
+	leader1 := cfg.checkOneLeader()
+	if leader1 == learner {
+		t.Fatalf("learner %d became leader", learner)
+	}
+	if !cfg.rafts[learner].IsLearner() {
+		t.Fatalf("server %d should report itself as a learner", learner)
+	}
+
+	// the only other voter can't win an election on its own, and the learner won't try.
+	cfg.disconnect(leader1)
+	time.Sleep(2 * RaftElectionTimeout)
+	cfg.checkNoLeader()
+
+	cfg.connect(leader1)
+	leader2 := cfg.checkOneLeader()
+	if leader2 == learner {
+		t.Fatalf("learner %d became leader", learner)
+	}
+
+	cfg.end()
+}
+
+func TestLearnerDoesNotCountTowardQuorum(t *testing.T) {
+	servers := 5
+	learners := []int{3, 4}
+	cfg := make_config_with_learners(t, servers, learners, false)
+	defer cfg.cleanup()
+
+	cfg.begin("Test (learner): learners don't count toward a majority")
+
+	cfg.one(101, servers, false)
+
+	// two of three voters are enough to commit.
+	leader := cfg.checkOneLeader()
+	other := (leader + 1) % 3
+	cfg.disconnect(other)
+	cfg.one(102, servers-1, false)
+
+	// one voter and two learners is not a majority, even though it is 3 of 5 servers.
+	cfg.connect(other)
+	leader = cfg.checkOneLeader()
+	for i := 0; i < 3; i++ {
+		if i != leader {
+			cfg.disconnect(i)
+		}
+	}
+	index, _, ok := cfg.rafts[leader].Start(103)
+	if !ok {
+		t.Fatalf("leader rejected Start()")
+	}
+	time.Sleep(2 * RaftElectionTimeout)
+	if n, _ := cfg.nCommitted(index); n > 0 {
+		t.Fatalf("%v committed with only one voter", n)
+	}
+
+	cfg.end()
+}
+
+func TestLearnerCatchesUp(t *testing.T) {
+	servers := 3
+	learner := 2
+	cfg := make_config_with_learners(t, servers, []int{learner}, false)
+	defer cfg.cleanup()
+
+	cfg.begin("Test (learner): learner catches up and is reported")
+
+	cfg.disconnect(learner)
+	index := 0
+	for i := 0; i < 20; i++ {
+		index = cfg.one(i, servers-1, true)
+	}
+	leader := cfg.checkOneLeader()
+	if cfg.rafts[leader].LearnerIsCaughtUp(learner) {
+		t.Fatalf("disconnected learner reported as caught up")
+	}
+
+	cfg.connect(learner)
+	cfg.wait(index, servers, -1)
+
+	caughtUp := false
+	for iters := 0; iters < 20 && !caughtUp; iters++ {
+		time.Sleep(100 * time.Millisecond)
+		caughtUp = cfg.rafts[leader].LearnerIsCaughtUp(learner)
+	}
+	if !caughtUp {
+		t.Fatalf("learner never reported as caught up")
+	}
+	if got := cfg.rafts[leader].CaughtUpLearners(); len(got) != 1 || got[0] != learner {
+		t.Fatalf("CaughtUpLearners() = %v, want [%v]", got, learner)
+	}
+
+	cfg.end()
+}
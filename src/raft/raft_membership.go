@@ -0,0 +1,205 @@
+package raft
+
+import (
+	"ad"
+	"errors"
+	"labgob"
+	"sort"
+)
+
+// Membership changes move peers between voters and learners while the cluster runs. The set of peers is still
+// fixed when each Raft is made; only their roles change.
+//
+// A change is a Membership entry in the log holding every learner after the change. As in §4.1 of Ongaro's
+// dissertation, each peer uses the latest Membership in its log as soon as it is appended, whether or not it has
+// been committed, and the leader only starts a change once the previous one has been committed. Changing one peer
+// at a time keeps the majorities of the old and new memberships overlapping, so there is no joint consensus.
+//
+// Membership entries reach the state machine as ApplyMsgs with Purpose MEMBERSHIP, so that it still sees every
+// index. Once they are compressed into a snapshot, the learners as of the snapshot's last index are saved along with
+// the log image and sent in InstallSnapshot.
+
+// A log entry that sets which peers are learners.
+type Membership struct {
+	Learners []int // indices into peers[] of the non-voting members, in increasing order
+}
+
+// Errors from the methods that change the cluster: Promote, Demote and TransferLeadership.
+var (
+	ErrNotLeader                  = errors.New("not the leader")
+	ErrNoSuchPeer                 = errors.New("no such peer")
+	ErrLeaderCannotBeDemoted      = errors.New("the leader can't be demoted; transfer leadership first")
+	ErrMembershipChangeInProgress = errors.New("the previous membership change has not been committed yet")
+	ErrNotCaughtUp                = errors.New("the peer has not caught up with the leader")
+	ErrPeerIsLearner              = errors.New("the peer is a learner")
+	ErrTransferFailed             = errors.New("the peer did not start an election")
+)
+
+func init() {
+	labgob.Register(Membership{})
+}
+
+// Make the learner peerNum a voter. It must have caught up with the leader (see LearnerIsCaughtUp).
+// Returns nil without changing anything if peerNum is already a voter, so it is safe to retry.
+// Only the leader can change the membership; it returns once the change is in the leader's log, and the change is
+// final once that entry is committed.
+func (rf *Raft) Promote(peerNum int) error {
+	rf.lock()
+	defer rf.unlock()
+
+	if err := rf.checkCanChangeMembership(peerNum); err != nil {
+		return err
+	}
+	if !rf.isLearner[peerNum] {
+		ad.DebugObj(rf, ad.TRACE, "Not promoting %d because it is already a voter", peerNum)
+		return nil
+	}
+	if err := rf.checkNoMembershipChangeInProgress(); err != nil {
+		return err
+	}
+	if !rf.learnerIsCaughtUp(peerNum) {
+		return ErrNotCaughtUp
+	}
+
+	learners := make([]int, 0)
+	for _, learner := range rf.learners() {
+		if learner != peerNum {
+			learners = append(learners, learner)
+		}
+	}
+	rf.startMembershipChange(learners)
+	return nil
+}
+
+// Make the voter peerNum a learner. The leader can't demote itself.
+// Returns nil without changing anything if peerNum is already a learner, so it is safe to retry.
+// Like Promote, it returns once the change is in the leader's log.
+func (rf *Raft) Demote(peerNum int) error {
+	rf.lock()
+	defer rf.unlock()
+
+	if err := rf.checkCanChangeMembership(peerNum); err != nil {
+		return err
+	}
+	if rf.isLearner[peerNum] {
+		ad.DebugObj(rf, ad.TRACE, "Not demoting %d because it is already a learner", peerNum)
+		return nil
+	}
+	if peerNum == rf.me {
+		return ErrLeaderCannotBeDemoted
+	}
+	if err := rf.checkNoMembershipChangeInProgress(); err != nil {
+		return err
+	}
+
+	learners := append(rf.learners(), peerNum)
+	sort.Ints(learners)
+	rf.startMembershipChange(learners)
+	return nil
+}
+
+// ONLY CALL WITH THE LOCK
+func (rf *Raft) checkCanChangeMembership(peerNum int) error {
+	if peerNum < 0 || peerNum >= len(rf.peers) {
+		return ErrNoSuchPeer
+	}
+	if rf.CurrentElectionState != Leader {
+		return ErrNotLeader
+	}
+	return nil
+}
+
+// If a previous leader's change is still uncommitted and nothing else is coming in this term to commit it (see
+// §5.4.2), repeat the current membership in this term, so that an idle cluster doesn't refuse changes forever.
+// ONLY CALL WITH THE LOCK, and only on the leader.
+func (rf *Raft) checkNoMembershipChangeInProgress() error {
+	if rf.membershipIndex > rf.commitIndex {
+		ad.DebugObj(rf, ad.TRACE, "Can't change the membership because the change at index %d isn't committed",
+			rf.membershipIndex)
+		if rf.Log.lastTerm() < rf.CurrentTerm {
+			rf.startMembershipChange(rf.learners())
+		}
+		return ErrMembershipChangeInProgress
+	}
+	return nil
+}
+
+// Append a Membership entry and start using it.
+// ONLY CALL WITH THE LOCK, and only on the leader.
+func (rf *Raft) startMembershipChange(learners []int) {
+	ad.DebugObj(rf, ad.RPC, "Changing the learners from %v to %v", rf.learners(), learners)
+	rf.startWithLock(Membership{learners})
+}
+
+// The current learners, in increasing order.
+// ONLY CALL WITH THE LOCK
+func (rf *Raft) learners() []int {
+	learners := make([]int, 0)
+	for peerNum := range rf.peers {
+		if rf.isLearner[peerNum] {
+			learners = append(learners, peerNum)
+		}
+	}
+	return learners
+}
+
+// The learners as of index, which must be uncompressed or the last compressed index.
+// ONLY CALL WITH THE LOCK
+func (rf *Raft) learnersAt(index int) []int {
+	for i := index; i > rf.Log.lastCompressedIndex(); i-- {
+		if membership, ok := rf.Log.get(i).Command.(Membership); ok {
+			return membership.Learners
+		}
+	}
+	return rf.compressedLearners
+}
+
+// Start using any Membership among entries, which were just appended to the log.
+// ONLY CALL WITH THE LOCK
+func (rf *Raft) noteAppendedEntries(entries []LogEntry) {
+	for _, entry := range entries {
+		if membership, ok := entry.Command.(Membership); ok {
+			rf.membershipIndex = entry.Index
+			rf.setLearners(membership.Learners)
+		}
+	}
+}
+
+// Go back to the latest Membership left in the log, or the one in the snapshot, after the log was truncated or
+// replaced.
+// ONLY CALL WITH THE LOCK
+func (rf *Raft) updateMembershipFromLog() {
+	rf.membershipIndex = 0
+	for i := rf.lastLogIndex(); i > rf.Log.lastCompressedIndex(); i-- {
+		if _, ok := rf.Log.get(i).Command.(Membership); ok {
+			rf.membershipIndex = i
+			break
+		}
+	}
+	rf.setLearners(rf.learnersAt(rf.lastLogIndex()))
+}
+
+// ONLY CALL WITH THE LOCK
+func (rf *Raft) setLearners(learners []int) {
+	isLearner := make([]bool, len(rf.peers))
+	for _, learner := range learners {
+		isLearner[learner] = true
+	}
+	for peerNum := range rf.peers {
+		if isLearner[peerNum] && !rf.isLearner[peerNum] {
+			ad.DebugObj(rf, ad.RPC, "Peer %d is now a learner", peerNum)
+			rf.learnerReportedCaughtUp[peerNum] = false
+		} else if !isLearner[peerNum] && rf.isLearner[peerNum] {
+			ad.DebugObj(rf, ad.RPC, "Peer %d is now a voter", peerNum)
+		}
+	}
+	rf.isLearner = isLearner
+
+	// a leader is never demoted (see Demote), but a follower or candidate may be, and a learner may be promoted.
+	switch {
+	case rf.CurrentElectionState == Learner && !isLearner[rf.me]:
+		rf.CurrentElectionState = Follower
+	case rf.CurrentElectionState != Leader && isLearner[rf.me]:
+		rf.CurrentElectionState = Learner
+	}
+}
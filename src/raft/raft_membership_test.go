@@ -0,0 +1,209 @@
+package raft
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Wait for every connected peer to hear about the latest Membership and check that they agree on the learners.
+func (cfg *config) checkLearners(expected []int) {
+	time.Sleep(2 * RaftElectionTimeout / 5)
+	for i := 0; i < cfg.n; i++ {
+		if !cfg.connected[i] {
+			continue
+		}
+		if learners := cfg.rafts[i].Status().Learners; fmt.Sprint(learners) != fmt.Sprint(expected) {
+			cfg.t.Fatalf("peer %d reported learners %v, expected %v", i, learners, expected)
+		}
+	}
+}
+
+func TestPromoteLearner(t *testing.T) {
+	servers := 3
+	learner := 2
+	cfg := make_config_with_learners(t, servers, []int{learner}, false)
+	defer cfg.cleanup()
+
+	cfg.begin("Test (membership): a learner that has caught up can be promoted")
+
+	cfg.one(101, servers, false)
+	leader := cfg.checkOneLeader()
+	if err := cfg.rafts[(leader+1)%2].Promote(learner); err != ErrNotLeader {
+		t.Fatalf("Promote() on a follower returned %v, expected ErrNotLeader", err)
+	}
+
+	// a learner that has fallen behind can't be promoted.
+	cfg.disconnect(learner)
+	for i := 0; i < 2*learnerCatchUpMargin; i++ {
+		cfg.one(102+i, servers-1, false)
+	}
+	if err := cfg.rafts[leader].Promote(learner); err != ErrNotCaughtUp {
+		t.Fatalf("Promote() of a learner that is behind returned %v, expected ErrNotCaughtUp", err)
+	}
+
+	cfg.connect(learner)
+	for start := time.Now(); ; time.Sleep(50 * time.Millisecond) {
+		err := cfg.rafts[leader].Promote(learner)
+		if err == nil {
+			break
+		}
+		if err != ErrNotCaughtUp || time.Since(start) > RaftElectionTimeout {
+			t.Fatalf("Promote() returned %v", err)
+		}
+	}
+	// promoting a voter does nothing, so it's safe to retry.
+	if err := cfg.rafts[leader].Promote(learner); err != nil {
+		t.Fatalf("Promote() of a voter returned %v", err)
+	}
+	cfg.one(201, servers, false)
+	cfg.checkLearners([]int{})
+	if cfg.rafts[learner].IsLearner() {
+		t.Fatalf("peer %d still reports itself as a learner", learner)
+	}
+
+	// now the promoted peer counts toward a majority of 2 out of 3.
+	cfg.disconnect(1 - leader)
+	cfg.one(202, servers-1, false)
+
+	cfg.end()
+}
+
+func TestDemoteVoter(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	cfg.begin("Test (membership): a voter can be demoted")
+
+	cfg.one(101, servers, false)
+	leader := cfg.checkOneLeader()
+	if err := cfg.rafts[leader].Demote(leader); err != ErrLeaderCannotBeDemoted {
+		t.Fatalf("Demote() of the leader returned %v, expected ErrLeaderCannotBeDemoted", err)
+	}
+	if err := cfg.rafts[leader].Demote(servers); err != ErrNoSuchPeer {
+		t.Fatalf("Demote(%d) returned %v, expected ErrNoSuchPeer", servers, err)
+	}
+
+	demoted := (leader + 1) % servers
+	if err := cfg.rafts[leader].Demote(demoted); err != nil {
+		t.Fatalf("Demote() returned %v", err)
+	}
+	if err := cfg.rafts[leader].Demote(demoted); err != nil {
+		t.Fatalf("Demote() of a learner returned %v", err)
+	}
+	cfg.one(102, servers, false)
+	cfg.checkLearners([]int{demoted})
+	if status := cfg.rafts[demoted].Status(); status.Role != Learner {
+		t.Fatalf("demoted peer %d reported role %v", demoted, status.Role)
+	}
+
+	// the leader and the demoted peer aren't a majority of the remaining two voters.
+	other := (leader + 2) % servers
+	cfg.disconnect(other)
+	index, _, ok := cfg.rafts[leader].Start(103)
+	if !ok {
+		t.Fatalf("leader rejected Start()")
+	}
+	time.Sleep(RaftElectionTimeout)
+	if n, _ := cfg.nCommitted(index); n > 0 {
+		t.Fatalf("%d peers committed index %d without a majority of voters", n, index)
+	}
+
+	cfg.end()
+}
+
+func TestMembershipChangeInProgress(t *testing.T) {
+	servers := 3
+	cfg := make_config(t, servers, false)
+	defer cfg.cleanup()
+
+	cfg.begin("Test (membership): only one membership change at a time")
+
+	cfg.one(101, servers, false)
+	leader := cfg.checkOneLeader()
+	cfg.disconnect((leader + 1) % servers)
+	cfg.disconnect((leader + 2) % servers)
+
+	// the first change can't be committed without the other voter, so the second has to wait.
+	if err := cfg.rafts[leader].Demote((leader + 1) % servers); err != nil {
+		t.Fatalf("Demote() returned %v", err)
+	}
+	if err := cfg.rafts[leader].Demote((leader + 2) % servers); err != ErrMembershipChangeInProgress {
+		t.Fatalf("second Demote() returned %v, expected ErrMembershipChangeInProgress", err)
+	}
+
+	cfg.end()
+}
+
+func TestMembershipSurvivesRestart(t *testing.T) {
+	servers := 3
+	learner := 2
+	cfg := make_config_with_learners(t, servers, []int{learner}, false)
+	defer cfg.cleanup()
+
+	cfg.begin("Test (membership): membership changes are persisted")
+
+	cfg.one(101, servers, false)
+	time.Sleep(2 * RaftElectionTimeout / 5)
+	leader := cfg.checkOneLeader()
+	if err := cfg.rafts[leader].Promote(learner); err != nil {
+		t.Fatalf("Promote() returned %v", err)
+	}
+	cfg.one(102, servers, false)
+
+	// every peer is restarted with the original learners, but the log says otherwise.
+	for i := 0; i < servers; i++ {
+		cfg.crash1(i)
+	}
+	for i := 0; i < servers; i++ {
+		cfg.start1(i)
+		cfg.connect(i)
+	}
+	cfg.one(103, servers, false)
+	cfg.checkLearners([]int{})
+
+	cfg.end()
+}
+
+func TestTransferLeadership(t *testing.T) {
+	servers := 4
+	learner := 3
+	cfg := make_config_with_learners(t, servers, []int{learner}, false)
+	defer cfg.cleanup()
+
+	cfg.begin("Test (membership): leadership can be transferred")
+
+	cfg.one(101, servers, false)
+	leader := cfg.checkOneLeader()
+	target := (leader + 1) % 3
+	if err := cfg.rafts[leader].TransferLeadership(learner); err != ErrPeerIsLearner {
+		t.Fatalf("TransferLeadership() to a learner returned %v, expected ErrPeerIsLearner", err)
+	}
+	if err := cfg.rafts[target].TransferLeadership((leader + 2) % 3); err != ErrNotLeader {
+		t.Fatalf("TransferLeadership() on a follower returned %v, expected ErrNotLeader", err)
+	}
+
+	// the target almost always wins, but may lose to a peer that happened to time out at the same moment.
+	for attempt := 0; ; attempt++ {
+		leader = cfg.checkOneLeader()
+		if leader == target {
+			break
+		}
+		if attempt == 3 {
+			t.Fatalf("leadership wasn't transferred to %d, %d is still the leader", target, leader)
+		}
+		if err := cfg.rafts[leader].TransferLeadership(target); err != nil {
+			t.Fatalf("TransferLeadership() returned %v", err)
+		}
+		time.Sleep(RaftElectionTimeout / 2)
+	}
+
+	// a transfer to the leader does nothing, so it's safe to retry.
+	if err := cfg.rafts[target].TransferLeadership(target); err != nil {
+		t.Fatalf("TransferLeadership() to the leader returned %v", err)
+	}
+	cfg.one(102, servers, false)
+
+	cfg.end()
+}
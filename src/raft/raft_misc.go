@@ -27,6 +27,8 @@ func (rf *Raft) DebugPrefix() string {
 		electionState = "C"
 	case Follower:
 		electionState = "F"
+	case Learner:
+		electionState = "N"
 	}
 	return fmt.Sprintf("R%d %v%d %d/%d/%d/%d", rf.me, electionState, rf.CurrentTerm,
 		rf.lastIndexInSnapshot(), rf.lastApplied, rf.commitIndex, rf.Log.length())
@@ -64,8 +66,10 @@ func (rf *Raft) lastLogIndex() int {
 	return rf.Log.lastIndex()
 }
 
+// The number of votes (or matching logs) needed to win an election (or commit an entry).
+// Learners do not count toward a majority.
 func (rf *Raft) majoritySize() int {
-	return int(math.Ceil((float64(len(rf.peers) + 1)) / 2))
+	return int(math.Ceil((float64(rf.numVoters() + 1)) / 2))
 }
 
 // Returns the last index in the snapshot, or -1 if no snapshot.
@@ -79,12 +83,27 @@ func (rf *Raft) updateTermIfNecessary(otherTerm int) {
 	if otherTerm > rf.CurrentTerm {
 		rf.CurrentTerm = otherTerm
 		rf.VotedFor = -1
+		rf.leaderId = -1
 		if rf.CurrentElectionState == Leader {
-			go func() { rf.becomeFollower <- true }()
+			go func() {
+				select {
+				case rf.becomeFollower <- true:
+				case <-rf.dead:
+				}
+			}()
 		}
-		rf.CurrentElectionState = Follower
+		rf.CurrentElectionState = rf.passiveElectionState()
 		ad.DebugObj(rf, ad.RPC, "Updating term to %d and becoming follower", rf.CurrentTerm)
-		rf.writePersist()
+		rf.persistMetadata()
+	}
+}
+
+// Record that leader is the leader in CurrentTerm.
+// ONLY CALL WITH THE LOCK
+func (rf *Raft) noteLeader(leader int) {
+	if leader != rf.leaderId {
+		rf.leaderId = leader
+		rf.leaderChanges++
 	}
 }
 
@@ -100,17 +119,14 @@ func assertEquals(expected, actual interface{}) {
 	}
 }
 
-func getElectionTimeout() time.Duration {
-	ms := minElectionTimeout + rand.Intn(maxElectionTimeout-minElectionTimeout)
-	return time.Duration(ms) * time.Millisecond
-}
-
-func getHeartbeatTimeout() time.Duration {
-	return time.Duration(heartbeatTime) * time.Millisecond
+// ONLY CALL WITH THE LOCK
+func (rf *Raft) getElectionTimeout() time.Duration {
+	spread := rf.config.MaxElectionTimeout - rf.config.MinElectionTimeout
+	return rf.config.MinElectionTimeout + time.Duration(rf.rand.Int63n(int64(spread)))
 }
 
 func (rf *Raft) resetElectionTimeout() {
-	rf.candidateDeclareTime = time.Now().Add(getElectionTimeout())
+	rf.candidateDeclareTime = time.Now().Add(rf.getElectionTimeout())
 }
 
 func min(x, y int) int {
@@ -3,68 +3,192 @@ package raft
 import (
 	"ad"
 	"bytes"
+	"encoding/binary"
+	"fmt"
 	"labgob"
 )
 
-// Get the state that is persisted.
+// Raft's persistent state is saved in two parts, so that no single save has to re-encode the whole log:
+//
+//   - metadata: CurrentTerm and VotedFor, replaced with persister.SaveMetadata() whenever either changes.
+//   - raft state: a sequence of log records. The first is an image of the entire Log, written with SaveRaftState()
+//     (or SaveStateAndSnapshot()). Each later record is added with AppendRaftState() and describes one change to
+//     the Log: either some entries were appended or the Log was truncated.
+//
+// Once the appended records outgrow the image, the image is rewritten, so the cost of each save stays O(1) amortized.
+// The records are one labgob stream of logRecords that starts again with each image, so the types are only described
+// once, in the image, rather than in every record. Each record's part of the stream is preceded by its length as 4
+// big-endian bytes, so that a record that was only partly saved can be recognized.
+
+type logRecordKind int
+
+const (
+	logImageRecord    logRecordKind = iota // the entire log: Entries follow the compressed entries up to LastCompressedIndex
+	logAppendRecord                        // Entries were appended to the end of the log
+	logTruncateRecord                      // the log was truncated after LastIndexToKeep
+)
+
+type logRecord struct {
+	Kind                logRecordKind
+	Entries             []LogEntry
+	LastIndexToKeep     int
+	LastCompressedIndex int
+	LastCompressedTerm  int
+	CompressedLearners  []int // the learners as of LastCompressedIndex, in images
+	HasLearners         bool  // false in images saved before the membership could change, which use Config.Learners
+}
+
+type persistedMetadata struct {
+	CurrentTerm int
+	VotedFor    int
+}
+
+// Rewrite the log image once the records appended after it are at least this many times bigger than it...
+const logRecordCompactionRatio = 2
+
+// ...and at least this many bytes long.
+const minLogRecordCompactionSize = 1 << 16
+
+// Encodes the records of one persisted raft state, from its image on.
+type logRecordEncoder struct {
+	byteBuffer *bytes.Buffer
+	encoder    *labgob.LabEncoder
+}
+
+func makeLogRecordEncoder() *logRecordEncoder {
+	byteBuffer := new(bytes.Buffer)
+	return &logRecordEncoder{byteBuffer, labgob.NewEncoder(byteBuffer)}
+}
+
+// Encode the next record in the stream, with its length in front.
+func (enc *logRecordEncoder) encode(record logRecord) []byte {
+	if err := enc.encoder.Encode(record); err != nil {
+		panic(fmt.Sprintf("Error encoding log record: %v", err))
+	}
+	framed := make([]byte, 4+enc.byteBuffer.Len())
+	binary.BigEndian.PutUint32(framed[0:4], uint32(enc.byteBuffer.Len()))
+	copy(framed[4:], enc.byteBuffer.Bytes())
+	enc.byteBuffer.Reset()
+	return framed
+}
+
+// Encode a record that starts a stream of its own, such as an image.
+func encodeLogRecord(record logRecord) []byte {
+	return makeLogRecordEncoder().encode(record)
+}
+
+// Get the log image that starts the raft state, and start a new stream with it for the records appended after it.
+// The caller must save the image.
 // ONLY CALL WITH THE LOCK
 func (rf *Raft) getPersistState() []byte {
-	byteBuffer := new(bytes.Buffer)
-	encoder := labgob.NewEncoder(byteBuffer)
-	encoder.Encode(rf.CurrentTerm)
-	encoder.Encode(rf.VotedFor)
-	encoder.Encode(rf.Log)
-	return byteBuffer.Bytes()
+	rf.recordEncoder = makeLogRecordEncoder()
+	return rf.recordEncoder.encode(logRecord{
+		Kind:                logImageRecord,
+		Entries:             rf.Log.getIndicesIncludingAndAfter(rf.Log.lastCompressedIndex() + 1),
+		LastCompressedIndex: rf.Log.lastCompressedIndex(),
+		LastCompressedTerm:  rf.Log.lastCompressedTerm(),
+		CompressedLearners:  rf.compressedLearners,
+		HasLearners:         true,
+	})
 }
 
-// save Raft's persistent state to stable storage, where it can later be retrieved after a crash and restart.
+// save all of Raft's persistent state to stable storage, where it can later be retrieved after a crash and restart.
 // ONLY call when you have the lock!
 func (rf *Raft) writePersist() {
+	rf.persistMetadata()
 	persistentState := rf.getPersistState()
 	rf.persister.SaveRaftState(persistentState)
+	rf.notePersistedImage(persistentState)
 }
 
-// restore previously persisted state.
-// ONLY call with the lock!
-func (rf *Raft) readPersist(data []byte) {
-	if data == nil || len(data) < 1 {
+// Note that the state now begins with a new log image, e.g. because it was just saved along with a snapshot.
+// ONLY call when you have the lock!
+func (rf *Raft) notePersistedImage(image []byte) {
+	rf.persistedImageBytes = len(image)
+	rf.persistedRecordBytes = 0
+}
+
+// save CurrentTerm and VotedFor. Call whenever either of them changes.
+// ONLY call when you have the lock!
+func (rf *Raft) persistMetadata() {
+	byteBuffer := new(bytes.Buffer)
+	encoder := labgob.NewEncoder(byteBuffer)
+	encoder.Encode(persistedMetadata{rf.CurrentTerm, rf.VotedFor})
+	rf.persister.SaveMetadata(byteBuffer.Bytes())
+}
+
+// save entries that were just appended to the end of the Log.
+// ONLY call when you have the lock!
+func (rf *Raft) persistAppendedEntries(entries []LogEntry) {
+	if len(entries) == 0 {
 		return
 	}
+	rf.appendLogRecord(logRecord{Kind: logAppendRecord, Entries: entries})
+}
 
-	byteBuffer := bytes.NewBuffer(data)
-	decoder := labgob.NewDecoder(byteBuffer)
+// save the fact that the Log was just truncated after lastIndexToKeep.
+// ONLY call when you have the lock!
+func (rf *Raft) persistTruncation(lastIndexToKeep int) {
+	rf.appendLogRecord(logRecord{Kind: logTruncateRecord, LastIndexToKeep: lastIndexToKeep})
+}
+
+// ONLY call when you have the lock!
+func (rf *Raft) appendLogRecord(record logRecord) {
+	encoded := rf.recordEncoder.encode(record)
+	rf.persistedRecordBytes += len(encoded)
+	if rf.persistedRecordBytes >= minLogRecordCompactionSize &&
+		rf.persistedRecordBytes >= logRecordCompactionRatio*rf.persistedImageBytes {
+		ad.DebugObj(rf, ad.TRACE, "Log records are %d bytes but the log image is only %d bytes, rewriting the image",
+			rf.persistedRecordBytes, rf.persistedImageBytes)
+		rf.writePersist()
+		return
+	}
+	rf.persister.AppendRaftState(encoded)
+}
 
-	var currentTerm int
-	if decoder.Decode(&currentTerm) != nil {
-		panic("Error decoding currentTerm!")
-	} else {
-		rf.CurrentTerm = currentTerm
+// restore previously persisted state.
+// ONLY call with the lock!
+func (rf *Raft) readPersist(metadata []byte, data []byte) {
+	if len(metadata) > 0 {
+		decoder := labgob.NewDecoder(bytes.NewBuffer(metadata))
+		var meta persistedMetadata
+		if decoder.Decode(&meta) != nil {
+			panic("Error decoding metadata!")
+		}
+		rf.CurrentTerm = meta.CurrentTerm
+		rf.VotedFor = meta.VotedFor
 	}
 
-	var votedFor int
-	if decoder.Decode(&votedFor) != nil {
-		panic("Error decoding votedFor!")
-	} else {
-		rf.VotedFor = votedFor
+	stream := new(bytes.Buffer)
+	for len(data) > 0 {
+		if len(data) < 4 || int(binary.BigEndian.Uint32(data[0:4])) > len(data)-4 {
+			panic("Error decoding log record: truncated record!")
+		}
+		recordSize := int(binary.BigEndian.Uint32(data[0:4]))
+		stream.Write(data[4 : 4+recordSize])
+		data = data[4+recordSize:]
 	}
 
-	//log := LogOne{}
-	//var LastCompressedTerm int
-	//decoder.Decode(&LastCompressedTerm)
-	//log.LastCompressedTerm = LastCompressedTerm
-	//var FirstUncompressedIndex int
-	//decoder.Decode(&FirstUncompressedIndex)
-	//log.FirstUncompressedIndex = LastCompressedTerm
-	//var UncompressedEntries []LogEntry
-	//decoder.Decode(&UncompressedEntries)
-	//log.UncompressedEntries = UncompressedEntries
-	//rf.Log = log
-
-	var log LogOne
-	if decoder.Decode(&log) != nil {
-		panic("Error decoding log!")
-	} else {
-		rf.Log = log
+	decoder := labgob.NewDecoder(stream)
+	for stream.Len() > 0 {
+		var record logRecord
+		if decoder.Decode(&record) != nil {
+			panic("Error decoding log record!")
+		}
+		switch record.Kind {
+		case logImageRecord:
+			rf.Log.reset(record.LastCompressedIndex, record.LastCompressedTerm)
+			rf.Log.appendAll(record.Entries)
+			if record.HasLearners {
+				rf.compressedLearners = record.CompressedLearners
+			}
+		case logAppendRecord:
+			rf.Log.appendAll(record.Entries)
+		case logTruncateRecord:
+			rf.Log.truncateAfter(record.LastIndexToKeep)
+		default:
+			panic(fmt.Sprintf("Unknown log record kind %d!", record.Kind))
+		}
 	}
 
 	// These will be 0 if this is a newly created raft, but if reading from storage and there are compressed entries,
@@ -0,0 +1,101 @@
+package raft
+
+import (
+	"fmt"
+	"labrpc"
+	"testing"
+)
+
+// A leader with no peers, so that Start() does nothing but append and persist.
+func makeBenchmarkLeader(b *testing.B, logSize int) *Raft {
+	rf := &Raft{
+		persister:            MakePersister(),
+		peers:                make([]labrpc.Endpoint, 1),
+		Log:                  makeEmptyLogOne(),
+		CurrentElectionState: Leader,
+		nextIndex:            make([]int, 1),
+		matchIndex:           make([]int, 1),
+	}
+	rf.writePersist()
+	for i := 0; i < logSize; i++ {
+		if _, _, isLeader := rf.Start(i); !isLeader {
+			b.Fatalf("Start() failed while filling the log")
+		}
+	}
+	return rf
+}
+
+// Start() persists each new entry before returning, so its throughput shows how the cost of persisting grows with the
+// log. Because entries are persisted incrementally, it should stay roughly flat as the log gets longer.
+func BenchmarkStart(b *testing.B) {
+	for _, logSize := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("log=%d", logSize), func(b *testing.B) {
+			rf := makeBenchmarkLeader(b, logSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rf.Start(logSize + i)
+			}
+		})
+	}
+}
+
+// For comparison, re-encoding the whole log (as a snapshot does) grows linearly with it.
+func BenchmarkWritePersist(b *testing.B) {
+	for _, logSize := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("log=%d", logSize), func(b *testing.B) {
+			rf := makeBenchmarkLeader(b, logSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rf.writePersist()
+			}
+		})
+	}
+}
+
+// Replaying the persisted records should rebuild the same log that was persisted incrementally.
+func TestPersistLogRecords(t *testing.T) {
+	persister := MakePersister()
+	rf := &Raft{persister: persister, Log: makeEmptyLogOne(), CurrentTerm: 7, VotedFor: 2}
+	rf.writePersist()
+	for i := 1; i <= 5; i++ {
+		entries := []LogEntry{{Term: i, Command: i, Index: rf.Log.length() + 1}}
+		rf.Log.appendAll(entries)
+		rf.persistAppendedEntries(entries)
+	}
+	rf.Log.truncateAfter(3)
+	rf.persistTruncation(3)
+	entries := []LogEntry{{Term: 9, Command: 9, Index: 4}}
+	rf.Log.appendAll(entries)
+	rf.persistAppendedEntries(entries)
+
+	restored := &Raft{Log: makeEmptyLogOne()}
+	restored.readPersist(persister.ReadMetadata(), persister.ReadRaftState())
+	if restored.CurrentTerm != 7 || restored.VotedFor != 2 {
+		t.Fatalf("restored term %d and vote %d, expected 7 and 2", restored.CurrentTerm, restored.VotedFor)
+	}
+	if fmt.Sprintf("%+v", restored.Log) != fmt.Sprintf("%+v", rf.Log) {
+		t.Fatalf("restored log %+v, expected %+v", restored.Log, rf.Log)
+	}
+}
+
+// The learners as of the last compressed index are saved with the log image, since their Membership is gone.
+func TestPersistCompressedLearners(t *testing.T) {
+	persister := MakePersister()
+	rf := &Raft{persister: persister, Log: makeEmptyLogOne(), compressedLearners: []int{1, 3}}
+	rf.Log.reset(10, 2)
+	rf.writePersist()
+
+	restored := &Raft{Log: makeEmptyLogOne(), compressedLearners: []int{4}}
+	restored.readPersist(persister.ReadMetadata(), persister.ReadRaftState())
+	if fmt.Sprint(restored.compressedLearners) != "[1 3]" {
+		t.Fatalf("restored learners %v, expected [1 3]", restored.compressedLearners)
+	}
+
+	// an image without learners, like one saved before they were, keeps the configured learners.
+	persister.SaveRaftState(encodeLogRecord(logRecord{Kind: logImageRecord, LastCompressedIndex: 10, LastCompressedTerm: 2}))
+	restored = &Raft{Log: makeEmptyLogOne(), compressedLearners: []int{4}}
+	restored.readPersist(persister.ReadMetadata(), persister.ReadRaftState())
+	if fmt.Sprint(restored.compressedLearners) != "[4]" {
+		t.Fatalf("restored learners %v from an image without them, expected [4]", restored.compressedLearners)
+	}
+}
@@ -61,7 +61,6 @@ func (rf *Raft) sendRequestVote(peerNum int, repliesChan chan *RequestVoteReply)
 // RequestVote RPC handler.
 func (rf *Raft) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) {
 	rf.lock()
-	defer rf.writePersist()
 	defer rf.unlock()
 
 	if !rf.isAlive {
@@ -88,6 +87,10 @@ func (rf *Raft) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) {
 		reason = fmt.Sprintf("candidate's term %v < voter's term %v", args.Term, rf.CurrentTerm)
 		reply.VoteGranted = false
 
+	case rf.CurrentElectionState == Learner:
+		reason = "I am a learner, and learners don't vote"
+		reply.VoteGranted = false
+
 	case args.Term == rf.CurrentTerm && rf.VotedFor != args.CandidateId && rf.VotedFor != -1:
 		reason = fmt.Sprintf("voter already voted for someone else (%v) in term %d", rf.VotedFor, rf.CurrentTerm)
 		reply.VoteGranted = false
@@ -109,7 +112,7 @@ func (rf *Raft) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) {
 		rf.VotedFor = args.CandidateId
 		rf.resetElectionTimeout()
 		ad.DebugObj(rf, ad.RPC, "voting for %v and returning from RequestVote RPC", args.CandidateId)
-		rf.writePersist()
+		rf.persistMetadata()
 	} else {
 		ad.DebugObj(rf, ad.RPC, "not voting for %v in term %v because %v", args.CandidateId, args.Term, reason)
 	}
@@ -15,20 +15,25 @@ func (rf *Raft) Snapshot(stateMachineState []byte, lastIncludedIndex int) {
 	defer rf.unlock()
 
 	if lastIncludedIndex > rf.Log.lastCompressedIndex() {
-		rf.snapshotWithLock(stateMachineState, lastIncludedIndex)
+		rf.snapshotWithLock(stateMachineState, lastIncludedIndex, rf.learnersAt(lastIncludedIndex))
 	} else {
 		ad.DebugObj(rf, ad.TRACE, "Ignoring snapshot request because lastIncludedIndex %d <= my last snapshot index %d",
 			lastIncludedIndex, rf.Log.lastCompressedIndex())
 	}
 }
 
-func (rf *Raft) snapshotWithLock(stateMachineState []byte, lastIncludedIndex int) {
+// learners are the learners as of lastIncludedIndex, which are saved with the snapshot.
+func (rf *Raft) snapshotWithLock(stateMachineState []byte, lastIncludedIndex int, learners []int) {
 	ad.DebugObj(rf, ad.RPC, "Snapshotting. lastIncludedIndex=%d, lastIncludedTerm=%d", lastIncludedIndex, rf.Log.lastCompressedTerm())
 	assert(lastIncludedIndex > rf.Log.lastCompressedIndex()) // can't snapshot a subset of the existing snapshot
 	assert(lastIncludedIndex <= rf.lastApplied)              // can't snapshot something that hasn't been sent to the state machine yet
 
 	rf.Log.compressEntriesUpTo(lastIncludedIndex) // automatically handles lastIncludedTerm.
+	rf.compressedLearners = learners
+	rf.updateMembershipFromLog()
 	rf.assertInvariants()
-	rf.persister.SaveStateAndSnapshot(rf.getPersistState(), stateMachineState)
+	persistentState := rf.getPersistState()
+	rf.persister.SaveStateAndSnapshot(persistentState, stateMachineState)
+	rf.notePersistedImage(persistentState)
 	ad.DebugObj(rf, ad.TRACE, "Done with snapshot.")
 }
@@ -0,0 +1,85 @@
+package raft
+
+import (
+	"fmt"
+)
+
+// A snapshot of one Raft peer's state, for monitoring and debugging.
+// Unlike DebugPrefix(), every field is exported so that a Status can be sent over RPC.
+type Status struct {
+	Me            int
+	Role          ElectionState
+	Term          int
+	Leader        int // the peer this one believes is the leader in Term, or -1 if it doesn't know
+	LeaderChanges int // how many times Leader has changed to a known peer (possibly this one), e.g. after an election
+	CommitIndex   int
+	LastApplied   int
+	SnapshotIndex int   // the last index compressed into a snapshot, 0 if there is none
+	LastLogIndex  int   // including compressed entries
+	LogSizeBytes  int   // the uncompressed part of the log
+	Peers         int   // how many peers there are, voters and learners alike
+	Learners      []int // indices into peers[] of the non-voting members
+
+	// Only set on leaders; nil otherwise. Indexed by peer.
+	NextIndex  []int
+	MatchIndex []int
+}
+
+func (state ElectionState) String() string {
+	switch state {
+	case Leader:
+		return "Leader"
+	case Candidate:
+		return "Candidate"
+	case Follower:
+		return "Follower"
+	case Learner:
+		return "Learner"
+	default:
+		panic(fmt.Sprintf("Unrecognized ElectionState %d!", int(state)))
+	}
+}
+
+// Return a snapshot of this peer's state.
+func (rf *Raft) Status() Status {
+	rf.lock()
+	defer rf.unlock()
+
+	status := Status{
+		Me:            rf.me,
+		Role:          rf.CurrentElectionState,
+		Term:          rf.CurrentTerm,
+		Leader:        rf.leaderId,
+		LeaderChanges: rf.leaderChanges,
+		CommitIndex:   rf.commitIndex,
+		LastApplied:   rf.lastApplied,
+		SnapshotIndex: rf.lastIndexInSnapshot(),
+		LastLogIndex:  rf.lastLogIndex(),
+		LogSizeBytes:  rf.Log.sizeBytes(),
+		Peers:         len(rf.peers),
+		Learners:      rf.learners(),
+	}
+	if rf.CurrentElectionState == Leader {
+		status.NextIndex = append([]int{}, rf.nextIndex...)
+		status.MatchIndex = append([]int{}, rf.matchIndex...)
+	}
+	return status
+}
+
+// Format a Status as a few human-readable lines, e.g. for a command-line tool.
+func (status Status) String() string {
+	leader := "unknown"
+	if status.Leader != -1 {
+		leader = fmt.Sprintf("%d", status.Leader)
+	}
+	str := fmt.Sprintf("peer %d: %v in term %d, leader %v\n", status.Me, status.Role, status.Term, leader)
+	str += fmt.Sprintf("log: snapshot=%d applied=%d commit=%d last=%d (%d bytes uncompressed)\n",
+		status.SnapshotIndex, status.LastApplied, status.CommitIndex, status.LastLogIndex, status.LogSizeBytes)
+	if len(status.Learners) > 0 {
+		str += fmt.Sprintf("learners: %v\n", status.Learners)
+	}
+	for peerNum := range status.NextIndex {
+		str += fmt.Sprintf("peer %d: next=%d match=%d\n", peerNum, status.NextIndex[peerNum], status.MatchIndex[peerNum])
+	}
+	return str
+}
@@ -0,0 +1,63 @@
+package raft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatusReportsLeader(t *testing.T) {
+	servers := 4
+	learner := 3
+	cfg := make_config_with_learners(t, servers, []int{learner}, false)
+	defer cfg.cleanup()
+
+	cfg.begin("Test (status): every peer reports the leader and its indices")
+
+	cfg.one(101, servers, false)
+	leader := cfg.checkOneLeader()
+
+	// followers learn who the leader is from its next heartbeat.
+	time.Sleep(2 * RaftElectionTimeout / 5)
+	for i := 0; i < servers; i++ {
+		status := cfg.rafts[i].Status()
+		if status.Me != i || status.Leader != leader {
+			t.Fatalf("peer %d reported Me=%d, Leader=%d, expected Leader=%d", i, status.Me, status.Leader, leader)
+		}
+		if status.CommitIndex != 1 || status.LastApplied != 1 || status.LastLogIndex != 1 {
+			t.Fatalf("peer %d reported commit=%d applied=%d last=%d, expected all to be 1",
+				i, status.CommitIndex, status.LastApplied, status.LastLogIndex)
+		}
+		if len(status.Learners) != 1 || status.Learners[0] != learner {
+			t.Fatalf("peer %d reported learners %v, expected [%d]", i, status.Learners, learner)
+		}
+		switch {
+		case i == leader && status.Role != Leader:
+			t.Fatalf("leader %d reported role %v", i, status.Role)
+		case i == learner && status.Role != Learner:
+			t.Fatalf("learner %d reported role %v", i, status.Role)
+		case i != leader && (status.NextIndex != nil || status.MatchIndex != nil):
+			t.Fatalf("non-leader %d reported nextIndex=%v matchIndex=%v", i, status.NextIndex, status.MatchIndex)
+		}
+	}
+
+	status := cfg.rafts[leader].Status()
+	for peer := 0; peer < servers; peer++ {
+		if peer != leader && (status.MatchIndex[peer] != 1 || status.NextIndex[peer] != 2) {
+			t.Fatalf("leader reported nextIndex=%v matchIndex=%v, expected every peer to match index 1",
+				status.NextIndex, status.MatchIndex)
+		}
+	}
+
+	// the remaining voters elect a new leader, which reports itself.
+	cfg.disconnect(leader)
+	newLeader := cfg.checkOneLeader()
+	newStatus := cfg.rafts[newLeader].Status()
+	if newStatus.Leader != newLeader {
+		t.Fatalf("new leader %d doesn't report itself as leader", newLeader)
+	}
+	if newStatus.LeaderChanges < 2 {
+		t.Fatalf("new leader %d reported %d leader changes, expected at least 2", newLeader, newStatus.LeaderChanges)
+	}
+
+	cfg.end()
+}
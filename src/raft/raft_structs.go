@@ -2,6 +2,7 @@ package raft
 
 import (
 	"labrpc"
+	"math/rand"
 	"sync"
 	"time"
 )
@@ -14,6 +15,8 @@ type ApplyMsg struct {
 	CommandTerm  int
 	// If Purpose == COMMAND, then Command is a single command that has just been applied.
 	// If Purpose == STATE_RESET, then Command is a byte[] containing the entire state of the state machine.
+	// If Purpose == MEMBERSHIP, then Command is a Membership that has just been committed. It is only there so that
+	// the state machine sees every index, and there is nothing to apply.
 	Purpose ApplyMsgPurpose
 }
 
@@ -22,6 +25,7 @@ type ApplyMsgPurpose int
 const (
 	COMMAND ApplyMsgPurpose = iota
 	STATE_RESET
+	MEMBERSHIP
 )
 
 type LogEntry struct {
@@ -30,19 +34,13 @@ type LogEntry struct {
 	Index   int         // 0-index position in the log
 }
 
-// timeouts in milliseconds
-const (
-	minElectionTimeout = 500
-	maxElectionTimeout = 1000
-	heartbeatTime      = 150
-)
-
 type ElectionState int
 
 const (
 	Leader ElectionState = iota
 	Candidate
 	Follower
+	Learner // receives the log like a Follower, but never votes or runs for election
 )
 
 //
@@ -50,31 +48,46 @@ const (
 //
 type Raft struct {
 	// FINAL: never changed to point to new objects
-	mutex          sync.Mutex          // Lock to protect shared access to this peer's state
-	peers          []*labrpc.ClientEnd // RPC end points of all peers
-	persister      *Persister          // Object to hold this peer's persisted state
-	me             int                 // this peer's index into peers[]
-	isAlive        bool                // If false, suppresses debug output and stops doing things
-	applyCh        chan ApplyMsg       // A channel on which the tester or service expects ApplyMsg messages.
-	toApply        chan bool           // send on this channel to apply that entry to the state machine
-	becomeLeader   chan int            // broadcast when you become leader. int is the term in which you become leader.
-	becomeFollower chan bool           // broadcast when you become not the leader
+	mutex          sync.Mutex        // Lock to protect shared access to this peer's state
+	peers          []labrpc.Endpoint // RPC end points of all peers
+	persister      Storage           // Object to hold this peer's persisted state
+	me             int               // this peer's index into peers[]
+	isAlive        bool              // If false, suppresses debug output and stops doing things
+	applyCh        chan ApplyMsg     // A channel on which the tester or service expects ApplyMsg messages.
+	toApply        chan bool         // send on this channel to apply that entry to the state machine
+	becomeLeader   chan int          // broadcast when you become leader. int is the term in which you become leader.
+	becomeFollower chan bool         // broadcast when you become not the leader
+	dead           chan struct{}     // closed by Kill, so that nothing stays blocked on the channels above
+	isLearner      []bool            // isLearner[i] is true iff peer i is a non-voting learner, as of the latest Membership
+	config         Config            // timing and tuning settings
+	rand           *rand.Rand        // for election timeouts. ONLY USE WITH THE LOCK
 
 	// PERSISTENT: always update on stable storage before responding to RPCs
 	CurrentTerm          int           // latest term the server has seen, initialized to 0, only increases
 	VotedFor             int           // candidateID that I voted for in term CurrentTerm, -1 if none
-	Log                  LogOne        // the operations applied to this state machine
-	CurrentElectionState ElectionState // Leader, Candidate, or Follower
+	Log                  LogStore      // the operations applied to this state machine
+	CurrentElectionState ElectionState // Leader, Candidate, Follower, or Learner
 
 	// VOLATILE: does not need to be updated before replying to RPCs
-	commitIndex          int       // index of highest Log entry known to be committed (initialized to 0, increases monotonically)
-	lastApplied          int       //  index of highest Log entry applied to state machine (initialized to 0)
-	candidateDeclareTime time.Time // the time when this will declare itself a candidate.
-	//snapshotInProgress     []byte    // A snapshot that's being received through a sequence of InstallSnapshot RPCs.
+	commitIndex             int       // index of highest Log entry known to be committed (initialized to 0, increases monotonically)
+	lastApplied             int       //  index of highest Log entry applied to state machine (initialized to 0)
+	candidateDeclareTime    time.Time // the time when this will declare itself a candidate.
+	leaderId                int       // the peer I believe is the leader in CurrentTerm, or -1 if I don't know
+	leaderChanges           int       // how many times leaderId has been set to a new leader, for monitoring
+	persistedImageBytes     int       // size of the log image at the start of the persisted raft state
+	persistedRecordBytes    int       // size of the log records appended to the persisted raft state after the image
+	snapshotInProgress      []byte    // A snapshot that's being received through a sequence of InstallSnapshot RPCs.
+	snapshotInProgressIndex int       // The LastIncludedIndex of snapshotInProgress.
+	snapshotToApply         *ApplyMsg // An installed snapshot for the ApplierThread to send before any later entries.
+	compressedLearners      []int     // the learners as of the last compressed index (see raft_membership.go)
+	membershipIndex         int       // the index of the latest Membership in the log, 0 if it has been compressed
+	// continues the labgob stream that the persisted log image started (see raft_persist.go)
+	recordEncoder *logRecordEncoder
 
 	// VOLATILE ON LEADERS: reinitialized after election, nil on non-leaders
 	nextIndex []int // for each server, index of the next Log entry to send to that server
 	// nextIndex[me] doesn't matter. (initialized to leader last Log index + 1)
 	matchIndex []int // for each server, index of highest Log entry known to be replicated on server
 	// (initialized to 0, increases monotonically)
+	learnerReportedCaughtUp []bool // for each learner, whether this leader has already reported it as caught up
 }
@@ -0,0 +1,137 @@
+package s3fs
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SigV4 clients that sign as they stream, like the AWS CLI and SDKs, send bodies in aws-chunked encoding: each chunk
+// is a line holding its size in hex and an extension with its signature, then the data and a CRLF, until a chunk of
+// size 0, which may be followed by trailing headers (like a checksum) and an empty line. For example:
+//
+//	5;chunk-signature=0123abcd...\r\n
+//	hello\r\n
+//	0;chunk-signature=4567ef01...\r\n
+//	\r\n
+//
+// Signatures aren't checked, so decoding just means dropping everything but the data.
+
+var errBadChunk = errors.New("malformed aws-chunked body")
+
+// The longest chunk header or trailer line to accept.
+const maxChunkLineLength = 4096
+
+// The body of request, decoded if it is in aws-chunked encoding.
+func requestBody(request *http.Request) (io.Reader, error) {
+	contentSHA256 := request.Header.Get("X-Amz-Content-Sha256")
+	if !strings.HasPrefix(contentSHA256, "STREAMING-") &&
+		!strings.Contains(request.Header.Get("Content-Encoding"), "aws-chunked") {
+		return request.Body, nil
+	}
+	return &chunkedReader{reader: bufio.NewReader(request.Body)}, nil
+}
+
+// Decodes an aws-chunked body.
+type chunkedReader struct {
+	reader    *bufio.Reader
+	remaining int // bytes left in the current chunk
+	done      bool
+	err       error
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.done {
+		return 0, io.EOF
+	}
+	if r.remaining == 0 {
+		if r.err = r.nextChunk(); r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+	}
+	if len(p) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= n
+	if r.remaining == 0 && err == nil {
+		err = r.expectCRLF()
+	}
+	if err == io.EOF {
+		err = errBadChunk // cut off in the middle of a chunk
+	}
+	r.err = err
+	return n, err
+}
+
+// Read a chunk's header line. After the last chunk, skip the trailers and set done.
+func (r *chunkedReader) nextChunk() error {
+	line, err := r.readLine()
+	if err == io.EOF {
+		return errBadChunk // cut off before the last chunk
+	} else if err != nil {
+		return err
+	}
+	if semicolon := strings.Index(line, ";"); semicolon >= 0 {
+		line = line[:semicolon]
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 32)
+	if err != nil || size < 0 {
+		return errBadChunk
+	}
+	if size > 0 {
+		r.remaining = int(size)
+		return nil
+	}
+	for {
+		trailer, err := r.readLine()
+		if err == io.EOF {
+			break // some clients leave off the final empty line
+		}
+		if err != nil {
+			return err
+		}
+		if trailer == "" {
+			break
+		}
+	}
+	r.done = true
+	return nil
+}
+
+func (r *chunkedReader) expectCRLF() error {
+	line, err := r.readLine()
+	if err == io.EOF {
+		return errBadChunk
+	} else if err != nil {
+		return err
+	}
+	if line != "" {
+		return errBadChunk
+	}
+	return nil
+}
+
+// Read one CRLF-terminated line, without the CRLF.
+func (r *chunkedReader) readLine() (string, error) {
+	line, err := r.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > maxChunkLineLength {
+		return "", errBadChunk
+	}
+	if err == io.EOF && len(line) > 0 {
+		return "", errBadChunk
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
@@ -0,0 +1,431 @@
+package s3fs
+
+import (
+	"ad"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"filesystem"
+	"hash"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Serves a filesystem through the parts of the S3 API that most tools use, with path-style addressing
+// (http://host/bucket/key):
+//
+//	GET /                              ListBuckets
+//	PUT, HEAD, DELETE /bucket          CreateBucket, HeadBucket, DeleteBucket
+//	GET /bucket?list-type=2            ListObjectsV2, with prefix, delimiter, max-keys, start-after and
+//	                                   continuation-token
+//	PUT, GET, HEAD, DELETE /bucket/key PutObject, GetObject (with a Range), HeadObject, DeleteObject
+//	POST /bucket/key?uploads           CreateMultipartUpload, then UploadPart, CompleteMultipartUpload and
+//	                                   AbortMultipartUpload (see multipart.go)
+//
+// A bucket is a directory at the top of the filesystem and a key is a path inside it, so "photos/2018/cat.jpg" in
+// bucket "pics" is the file /pics/photos/2018/cat.jpg. PutObject makes the directories above a key as needed, and
+// listings only show files, so the directories stay out of sight. Since a path can't be both a file and a
+// directory, "a" and "a/b" can't both be keys in the same bucket.
+//
+// There's no access control: requests are accepted whatever their Authorization header says, but bodies that SigV4
+// clients send in aws-chunked encoding are decoded, so that stock clients work. Files have no modification times,
+// so every LastModified is the Unix epoch, and ETags are only known for objects as they're written.
+type Handler struct {
+	fs filesystem.FileSystem // shared by every request, so it has to be safe to use from many goroutines
+}
+
+// Make a handler for fs, usually an fsraft.Clerk.
+func MakeHandler(fs filesystem.FileSystem) *Handler {
+	return &Handler{fs}
+}
+
+// How much to read from or write to the filesystem in one call.
+const chunkSize = 64 * 1024
+
+// The LastModified of every object, since the filesystem doesn't keep modification times.
+var lastModified = time.Unix(0, 0).UTC()
+
+// An S3 error response.
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+	status  int
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newError(status int, code string, message string) *s3Error {
+	return &s3Error{Code: code, Message: message, status: status}
+}
+
+var errorCodesToS3Errors = map[filesystem.ErrorCode]*s3Error{
+	filesystem.NotFound:          newError(http.StatusNotFound, "NoSuchKey", "The specified key does not exist."),
+	filesystem.IsDirectory:       newError(http.StatusNotFound, "NoSuchKey", "The specified key does not exist."),
+	filesystem.TooManyFDsOpen:    newError(http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate."),
+	filesystem.TryAgain:          newError(http.StatusServiceUnavailable, "ServiceUnavailable", "Please try again."),
+	filesystem.AlreadyOpen:       newError(http.StatusConflict, "OperationAborted", "The object is open elsewhere."),
+	filesystem.NoMoreSpace:       newError(http.StatusInsufficientStorage, "InsufficientStorage", "The filesystem is full."),
+	filesystem.FileTooLarge:      newError(http.StatusBadRequest, "EntityTooLarge", "The object is too large."),
+	filesystem.WriteTooLarge:     newError(http.StatusBadRequest, "EntityTooLarge", "The object is too large."),
+	filesystem.DirectoryNotEmpty: newError(http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty."),
+	filesystem.IllegalArgument:   newError(http.StatusBadRequest, "InvalidArgument", "Invalid argument."),
+}
+
+// The S3 error to send for err. Errors from the filesystem that have no S3 equivalent are sent as InternalError.
+func s3ErrorFor(err error) *s3Error {
+	switch err := err.(type) {
+	case *s3Error:
+		return err
+	case filesystem.ErrorCode:
+		if s3Err, found := errorCodesToS3Errors[err]; found {
+			return s3Err
+		}
+		return newError(http.StatusInternalServerError, "InternalError", err.Error())
+	}
+	return newError(http.StatusInternalServerError, "InternalError", err.Error())
+}
+
+var (
+	errNoSuchBucket = newError(http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+	errKeyConflict  = newError(http.StatusConflict, "InvalidRequest",
+		"The key is inside another key, or another key is inside it.")
+	errNotImplemented = newError(http.StatusNotImplemented, "NotImplemented",
+		"A header or query you provided implies functionality that is not implemented.")
+)
+
+func (handler *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	bucket, key, err := splitPath(request.URL.Path)
+	if err == nil {
+		ad.Debug(ad.TRACE, "%v bucket %q key %q %v", request.Method, bucket, key, request.URL.RawQuery)
+		err = handler.route(writer, request, bucket, key)
+	}
+	if err != nil {
+		s3Err := s3ErrorFor(err)
+		ad.Debug(ad.RPC, "%v %v failed with %d: %v", request.Method, request.URL.Path, s3Err.status, err)
+		writer.Header().Set("Content-Type", "application/xml")
+		writer.WriteHeader(s3Err.status)
+		if request.Method != http.MethodHead {
+			writeXML(writer, s3Err)
+		}
+	}
+}
+
+func (handler *Handler) route(writer http.ResponseWriter, request *http.Request, bucket string, key string) error {
+	query := request.URL.Query()
+	switch {
+	case bucket == "" && request.Method == http.MethodGet:
+		return handler.listBuckets(writer)
+	case bucket == "":
+		return errNotImplemented
+	case key == "":
+		switch request.Method {
+		case http.MethodGet:
+			if query.Get("list-type") != "2" {
+				return errNotImplemented
+			}
+			return handler.listObjects(writer, request, bucket)
+		case http.MethodPut:
+			return handler.createBucket(writer, bucket)
+		case http.MethodHead:
+			_, err := handler.checkBucket(bucket)
+			return err
+		case http.MethodDelete:
+			return handler.deleteBucket(writer, bucket)
+		}
+	case query.Get("uploadId") != "":
+		switch request.Method {
+		case http.MethodPut:
+			return handler.uploadPart(writer, request, bucket, key)
+		case http.MethodPost:
+			return handler.completeMultipartUpload(writer, request, bucket, key)
+		case http.MethodDelete:
+			return handler.abortMultipartUpload(writer, request, bucket, key)
+		}
+	case hasQuery(request, "uploads"):
+		if request.Method == http.MethodPost {
+			return handler.createMultipartUpload(writer, bucket, key)
+		}
+	default:
+		switch request.Method {
+		case http.MethodPut:
+			if request.Header.Get("X-Amz-Copy-Source") != "" {
+				return errNotImplemented
+			}
+			return handler.putObject(writer, request, bucket, key)
+		case http.MethodGet, http.MethodHead:
+			return handler.getObject(writer, request, bucket, key)
+		case http.MethodDelete:
+			return handler.deleteObject(writer, bucket, key)
+		}
+	}
+	return errNotImplemented
+}
+
+// Whether the query has a parameter called name, even one without a value like ?uploads.
+func hasQuery(request *http.Request, name string) bool {
+	_, found := request.URL.Query()[name]
+	return found
+}
+
+// Split a request path into a bucket and a key. Either may be empty. Keys have to name paths in the filesystem, so
+// they can't start or end with a slash, or have empty, "." or ".." parts.
+func splitPath(urlPath string) (bucket string, key string, err error) {
+	trimmed := strings.TrimPrefix(urlPath, "/")
+	parts := strings.SplitN(trimmed, "/", 2)
+	bucket = parts[0]
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	if bucket == "" {
+		if key != "" {
+			return "", "", newError(http.StatusBadRequest, "InvalidBucketName", "The bucket name is empty.")
+		}
+		return "", "", nil
+	}
+	if !validBucketName(bucket) {
+		return "", "", newError(http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid.")
+	}
+	if key != "" {
+		for _, part := range strings.Split(key, "/") {
+			if part == "" || part == "." || part == ".." {
+				return "", "", newError(http.StatusBadRequest, "InvalidArgument",
+					"Keys with empty, . or .. parts, or that start or end with /, are not supported.")
+			}
+		}
+	}
+	return bucket, key, nil
+}
+
+// Bucket names follow S3's rules: 3 to 63 lowercase letters, digits, dots and hyphens, starting and ending with a
+// letter or digit. In particular, they can't start with a dot, which keeps multipart uploads out of the way.
+func validBucketName(name string) bool {
+	if len(name) < 3 || len(name) > 63 {
+		return false
+	}
+	for i, c := range name {
+		isLetterOrDigit := (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+		if !isLetterOrDigit && ((c != '.' && c != '-') || i == 0 || i == len(name)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+func bucketPath(bucket string) string {
+	return "/" + bucket
+}
+
+func objectPath(bucket string, key string) string {
+	return "/" + bucket + "/" + key
+}
+
+// Check that the bucket exists, and return its path.
+func (handler *Handler) checkBucket(bucket string) (string, error) {
+	info, err := handler.fs.Stat(bucketPath(bucket))
+	if err == filesystem.NotFound || (err == nil && !info.IsDir) {
+		return "", errNoSuchBucket
+	}
+	return bucketPath(bucket), err
+}
+
+func (handler *Handler) createBucket(writer http.ResponseWriter, bucket string) error {
+	if _, err := handler.fs.Mkdir(bucketPath(bucket)); err == filesystem.AlreadyExists {
+		return newError(http.StatusConflict, "BucketAlreadyOwnedByYou", "The bucket already exists.")
+	} else if err != nil {
+		return err
+	}
+	writer.Header().Set("Location", bucketPath(bucket))
+	return nil
+}
+
+func (handler *Handler) deleteBucket(writer http.ResponseWriter, bucket string) error {
+	if _, err := handler.checkBucket(bucket); err != nil {
+		return err
+	}
+	// directories that PutObject made and the objects in them have since left don't count
+	if err := handler.pruneEmptyDirectories(bucketPath(bucket)); err != nil {
+		return err
+	}
+	if _, err := handler.fs.Delete(bucketPath(bucket)); err != nil {
+		return err
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Delete every directory below dir that holds no files, however deep.
+func (handler *Handler) pruneEmptyDirectories(dir string) error {
+	entries, err := handler.fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir {
+			continue
+		}
+		child := path.Join(dir, entry.Name)
+		if err := handler.pruneEmptyDirectories(child); err != nil {
+			return err
+		}
+		if _, err := handler.fs.Delete(child); err != nil && err != filesystem.DirectoryNotEmpty {
+			return err
+		}
+	}
+	return nil
+}
+
+// Make the directories above filePath, up to but not including the bucket.
+func (handler *Handler) makeParents(bucket string, filePath string) error {
+	dir := path.Dir(filePath)
+	if dir == bucketPath(bucket) {
+		return nil
+	}
+	if err := handler.makeParents(bucket, dir); err != nil {
+		return err
+	}
+	if _, err := handler.fs.Mkdir(dir); err != nil && err != filesystem.AlreadyExists {
+		return err
+	}
+	return nil
+}
+
+func (handler *Handler) putObject(writer http.ResponseWriter, request *http.Request, bucket string, key string) error {
+	if _, err := handler.checkBucket(bucket); err != nil {
+		return err
+	}
+	body, err := requestBody(request)
+	if err != nil {
+		return err
+	}
+	if err := handler.makeParents(bucket, objectPath(bucket, key)); err != nil {
+		return err
+	}
+	etag, err := handler.writeFile(objectPath(bucket, key), body)
+	if err != nil {
+		return err
+	}
+	writer.Header().Set("ETag", etag)
+	return nil
+}
+
+// Replace the file at filePath with what reader holds. Returns its ETag, the quoted MD5 of the contents.
+func (handler *Handler) writeFile(filePath string, reader io.Reader) (string, error) {
+	fd, err := handler.fs.Open(filePath, filesystem.WriteOnly, filesystem.Create|filesystem.Truncate)
+	if err == filesystem.NotFound || err == filesystem.IsDirectory {
+		// a file is where a directory needs to be, or the other way around
+		return "", errKeyConflict
+	} else if err != nil {
+		return "", err
+	}
+	sum := md5.New()
+	_, err = handler.fs.Seek(fd, 0, filesystem.FromBeginning)
+	if err == nil {
+		err = copyIn(handler.fs, fd, reader, sum)
+	}
+	if _, closeErr := handler.fs.Close(fd); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(sum.Sum(nil)) + `"`, nil
+}
+
+// Write everything from reader to fd a chunk at a time, adding it to sum along the way.
+func copyIn(fs filesystem.FileSystem, fd int, reader io.Reader, sum hash.Hash) error {
+	buffer := make([]byte, chunkSize)
+	for {
+		n, readErr := io.ReadFull(reader, buffer)
+		if n > 0 {
+			sum.Write(buffer[:n])
+			if _, err := fs.Write(fd, n, buffer[:n]); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if readErr != nil {
+			return newError(http.StatusBadRequest, "IncompleteBody", "Couldn't read the request body.")
+		}
+	}
+}
+
+// Send an object, or the byte range of it in the Range header. http.ServeContent does the Range and HEAD handling.
+func (handler *Handler) getObject(writer http.ResponseWriter, request *http.Request, bucket string, key string) error {
+	if _, err := handler.checkBucket(bucket); err != nil {
+		return err
+	}
+	filePath := objectPath(bucket, key)
+	fd, err := handler.fs.Open(filePath, filesystem.ReadOnly, 0)
+	if err != nil {
+		return err
+	}
+	defer handler.fs.Close(fd)
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(writer, request, key, lastModified, &fileReader{handler.fs, fd})
+	return nil
+}
+
+// S3 answers 204 whether or not the key existed.
+func (handler *Handler) deleteObject(writer http.ResponseWriter, bucket string, key string) error {
+	if _, err := handler.checkBucket(bucket); err != nil {
+		return err
+	}
+	info, err := handler.fs.Stat(objectPath(bucket, key))
+	if err == nil && !info.IsDir {
+		_, err = handler.fs.Delete(objectPath(bucket, key))
+	}
+	if err != nil && err != filesystem.NotFound {
+		return err
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Reads an open file through the filesystem, for http.ServeContent.
+type fileReader struct {
+	fs filesystem.FileSystem
+	fd int
+}
+
+func (r *fileReader) Read(p []byte) (int, error) {
+	numBytes := len(p)
+	if numBytes > chunkSize {
+		numBytes = chunkSize
+	}
+	bytesRead, data, err := r.fs.Read(r.fd, numBytes)
+	if err != nil {
+		return 0, err
+	}
+	if bytesRead == 0 && numBytes > 0 {
+		return 0, io.EOF
+	}
+	return copy(p, data[:bytesRead]), nil
+}
+
+func (r *fileReader) Seek(offset int64, whence int) (int64, error) {
+	base := filesystem.FromBeginning
+	switch whence {
+	case io.SeekCurrent:
+		base = filesystem.FromCurrent
+	case io.SeekEnd:
+		base = filesystem.FromEnd
+	}
+	newPosition, err := r.fs.Seek(r.fd, int(offset), base)
+	return int64(newPosition), err
+}
+
+// Write an XML document with its header.
+func writeXML(writer http.ResponseWriter, document interface{}) {
+	writer.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(writer).Encode(document); err != nil {
+		ad.Debug(ad.WARN, "Couldn't write an XML response: %v", err)
+	}
+}
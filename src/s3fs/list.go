@@ -0,0 +1,189 @@
+package s3fs
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"filesystem"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// The most keys ListObjectsV2 returns at once, and the default for max-keys.
+const maxListKeys = 1000
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name       `xml:"ListAllMyBucketsResult"`
+	Xmlns   string         `xml:"xmlns,attr"`
+	Owner   owner          `xml:"Owner"`
+	Buckets []bucketResult `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucketResult struct {
+	Name         string    `xml:"Name"`
+	CreationDate time.Time `xml:"CreationDate"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	KeyCount              int            `xml:"KeyCount"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []object       `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int       `xml:"Size"`
+	StorageClass string    `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// List the directories at the top of the filesystem. Ones whose names aren't valid bucket names, like the one
+// multipart uploads are kept in, are left out.
+func (handler *Handler) listBuckets(writer http.ResponseWriter) error {
+	entries, err := handler.fs.ReadDir("/")
+	if err != nil {
+		return err
+	}
+	result := listAllMyBucketsResult{Xmlns: s3Namespace, Owner: owner{"dfs", "dfs"}, Buckets: make([]bucketResult, 0)}
+	for _, entry := range entries {
+		if entry.IsDir && validBucketName(entry.Name) {
+			result.Buckets = append(result.Buckets, bucketResult{entry.Name, lastModified})
+		}
+	}
+	writer.Header().Set("Content-Type", "application/xml")
+	writeXML(writer, result)
+	return nil
+}
+
+// ListObjectsV2. Keys come back in lexicographic order. With a delimiter, keys that have it somewhere after the
+// prefix are rolled up into a common prefix that ends at the delimiter. The continuation token is just the last key
+// or common prefix returned, encoded.
+func (handler *Handler) listObjects(writer http.ResponseWriter, request *http.Request, bucket string) error {
+	dir, err := handler.checkBucket(bucket)
+	if err != nil {
+		return err
+	}
+	query := request.URL.Query()
+	result := listBucketResult{
+		Xmlns:             s3Namespace,
+		Name:              bucket,
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		MaxKeys:           maxListKeys,
+		Contents:          make([]object, 0),
+		CommonPrefixes:    make([]commonPrefix, 0),
+	}
+	if maxKeys := query.Get("max-keys"); maxKeys != "" {
+		result.MaxKeys, err = strconv.Atoi(maxKeys)
+		if err != nil || result.MaxKeys < 0 {
+			return newError(http.StatusBadRequest, "InvalidArgument", "max-keys must be a number from 0 up.")
+		}
+		if result.MaxKeys > maxListKeys {
+			result.MaxKeys = maxListKeys
+		}
+	}
+	after := result.StartAfter
+	if result.ContinuationToken != "" {
+		decoded, err := base64.URLEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			return newError(http.StatusBadRequest, "InvalidArgument", "The continuation token is not valid.")
+		}
+		after = string(decoded)
+	}
+
+	// only the part of the tree that the prefix's directories lead to can hold matching keys
+	start := ""
+	if slash := strings.LastIndex(result.Prefix, "/"); slash >= 0 {
+		start = result.Prefix[:slash]
+	}
+	sizes := make(map[string]int)
+	if err := handler.collectKeys(dir, start, sizes); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(sizes))
+	for key := range sizes {
+		if strings.HasPrefix(key, result.Prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	last := ""
+	for _, key := range keys {
+		entry := key
+		if result.Delimiter != "" {
+			if i := strings.Index(key[len(result.Prefix):], result.Delimiter); i >= 0 {
+				entry = key[:len(result.Prefix)+i+len(result.Delimiter)]
+			}
+		}
+		if entry <= after || entry == last {
+			continue
+		}
+		if result.KeyCount == result.MaxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if entry == key {
+			result.Contents = append(result.Contents, object{key, lastModified, sizes[key], "STANDARD"})
+		} else {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{entry})
+		}
+		result.KeyCount++
+		last = entry
+	}
+	if result.IsTruncated {
+		result.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(last))
+	}
+
+	writer.Header().Set("Content-Type", "application/xml")
+	writeXML(writer, result)
+	return nil
+}
+
+// Add the key and size of every file below the directory at bucketDir/start to sizes. A start that doesn't lead to
+// a directory holds no keys.
+func (handler *Handler) collectKeys(bucketDir string, start string, sizes map[string]int) error {
+	entries, err := handler.fs.ReadDir(path.Join(bucketDir, start))
+	if err == filesystem.NotFound && start != "" {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		key := path.Join(start, entry.Name)
+		if entry.IsDir {
+			if err := handler.collectKeys(bucketDir, key, sizes); err != nil {
+				return err
+			}
+		} else {
+			sizes[key] = entry.Size
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package s3fs
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"filesystem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Multipart uploads keep their parts in the cluster until they're completed or aborted, so any gateway can take
+// any part. Each upload has a directory in uploadsDir named by its id, holding a file called "target" with the
+// bucket and key it is for, and for each part, a file named part-<number> and another with its ETag named
+// part-<number>.etag. Completing the upload writes the parts, in order, into the target file, and then deletes the
+// upload's directory.
+const uploadsDir = "/.s3-uploads"
+
+const maxPartNumber = 10000
+
+var errNoSuchUpload = newError(http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+func (handler *Handler) createMultipartUpload(writer http.ResponseWriter, bucket string, key string) error {
+	if _, err := handler.checkBucket(bucket); err != nil {
+		return err
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	uploadID := hex.EncodeToString(random)
+	if _, err := handler.fs.Mkdir(uploadsDir); err != nil && err != filesystem.AlreadyExists {
+		return err
+	}
+	if _, err := handler.fs.Mkdir(path.Join(uploadsDir, uploadID)); err != nil {
+		return err
+	}
+	target := strings.NewReader(bucket + "\n" + key)
+	if _, err := handler.writeFile(path.Join(uploadsDir, uploadID, "target"), target); err != nil {
+		return err
+	}
+
+	writer.Header().Set("Content-Type", "application/xml")
+	writeXML(writer, initiateMultipartUploadResult{Xmlns: s3Namespace, Bucket: bucket, Key: key, UploadID: uploadID})
+	return nil
+}
+
+// Check that the request's uploadId names an upload of bucket/key, and return the upload's directory.
+func (handler *Handler) findUpload(request *http.Request, bucket string, key string) (string, error) {
+	uploadID := request.URL.Query().Get("uploadId")
+	if _, err := hex.DecodeString(uploadID); err != nil {
+		return "", errNoSuchUpload
+	}
+	dir := path.Join(uploadsDir, uploadID)
+	target, err := handler.readFile(path.Join(dir, "target"))
+	if err == filesystem.NotFound {
+		return "", errNoSuchUpload
+	} else if err != nil {
+		return "", err
+	}
+	if target != bucket+"\n"+key {
+		return "", errNoSuchUpload
+	}
+	return dir, nil
+}
+
+func partPath(dir string, partNumber int) string {
+	return path.Join(dir, fmt.Sprintf("part-%05d", partNumber))
+}
+
+// Store a part, replacing any earlier upload of the same part number.
+func (handler *Handler) uploadPart(writer http.ResponseWriter, request *http.Request, bucket string, key string) error {
+	partNumber, err := strconv.Atoi(request.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		return newError(http.StatusBadRequest, "InvalidArgument",
+			fmt.Sprintf("Part number must be an integer between 1 and %d, inclusive.", maxPartNumber))
+	}
+	dir, err := handler.findUpload(request, bucket, key)
+	if err != nil {
+		return err
+	}
+	body, err := requestBody(request)
+	if err != nil {
+		return err
+	}
+	etag, err := handler.writeFile(partPath(dir, partNumber), body)
+	if err != nil {
+		return err
+	}
+	if _, err := handler.writeFile(partPath(dir, partNumber)+".etag", strings.NewReader(etag)); err != nil {
+		return err
+	}
+	writer.Header().Set("ETag", etag)
+	return nil
+}
+
+// Write the parts the request lists into the target file, in order. Every part has to have been uploaded with the
+// ETag given for it.
+func (handler *Handler) completeMultipartUpload(writer http.ResponseWriter, request *http.Request, bucket string,
+	key string) error {
+	dir, err := handler.findUpload(request, bucket, key)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return newError(http.StatusBadRequest, "IncompleteBody", "Couldn't read the request body.")
+	}
+	var completion completeMultipartUpload
+	if err := xml.Unmarshal(body, &completion); err != nil || len(completion.Parts) == 0 {
+		return newError(http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed.")
+	}
+	partPaths := make([]string, len(completion.Parts))
+	md5s := make([]byte, 0, md5.Size*len(completion.Parts))
+	for i, part := range completion.Parts {
+		if i > 0 && part.PartNumber <= completion.Parts[i-1].PartNumber {
+			return newError(http.StatusBadRequest, "InvalidPartOrder", "The parts must be in ascending order.")
+		}
+		partPaths[i] = partPath(dir, part.PartNumber)
+		etag, err := handler.readFile(partPaths[i] + ".etag")
+		if err == filesystem.NotFound || (err == nil && strings.Trim(etag, `"`) != strings.Trim(part.ETag, `"`)) {
+			return newError(http.StatusBadRequest, "InvalidPart", "One or more of the parts could not be found.")
+		} else if err != nil {
+			return err
+		}
+		sum, _ := hex.DecodeString(strings.Trim(etag, `"`))
+		md5s = append(md5s, sum...)
+	}
+
+	filePath := objectPath(bucket, key)
+	if err := handler.makeParents(bucket, filePath); err != nil {
+		return err
+	}
+	if err := handler.concatenate(filePath, partPaths); err != nil {
+		return err
+	}
+	if err := handler.deleteUpload(dir); err != nil {
+		return err
+	}
+
+	sum := md5.Sum(md5s)
+	etag := fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(partPaths))
+	writer.Header().Set("Content-Type", "application/xml")
+	writeXML(writer, completeMultipartUploadResult{Xmlns: s3Namespace, Location: filePath, Bucket: bucket, Key: key,
+		ETag: etag})
+	return nil
+}
+
+func (handler *Handler) abortMultipartUpload(writer http.ResponseWriter, request *http.Request, bucket string,
+	key string) error {
+	dir, err := handler.findUpload(request, bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := handler.deleteUpload(dir); err != nil {
+		return err
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Delete an upload's directory and the files in it, the target last so that the upload can be found until the end.
+func (handler *Handler) deleteUpload(dir string) error {
+	entries, err := handler.fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name == "target" {
+			continue
+		}
+		if _, err := handler.fs.Delete(path.Join(dir, entry.Name)); err != nil {
+			return err
+		}
+	}
+	if _, err := handler.fs.Delete(path.Join(dir, "target")); err != nil {
+		return err
+	}
+	_, err = handler.fs.Delete(dir)
+	return err
+}
+
+// Replace the file at filePath with the files at sources, one after the other.
+func (handler *Handler) concatenate(filePath string, sources []string) error {
+	target, err := handler.fs.Open(filePath, filesystem.WriteOnly, filesystem.Create|filesystem.Truncate)
+	if err == filesystem.NotFound || err == filesystem.IsDirectory {
+		return errKeyConflict
+	} else if err != nil {
+		return err
+	}
+	_, err = handler.fs.Seek(target, 0, filesystem.FromBeginning)
+	for _, source := range sources {
+		if err != nil {
+			break
+		}
+		err = handler.appendFile(target, source)
+	}
+	if _, closeErr := handler.fs.Close(target); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Write the whole file at source to the open file target.
+func (handler *Handler) appendFile(target int, source string) error {
+	fd, err := handler.fs.Open(source, filesystem.ReadOnly, 0)
+	if err != nil {
+		return err
+	}
+	defer handler.fs.Close(fd)
+	if _, err := handler.fs.Seek(fd, 0, filesystem.FromBeginning); err != nil {
+		return err
+	}
+	for {
+		bytesRead, data, err := handler.fs.Read(fd, chunkSize)
+		if err != nil || bytesRead == 0 {
+			return err
+		}
+		if _, err := handler.fs.Write(target, bytesRead, data[:bytesRead]); err != nil {
+			return err
+		}
+	}
+}
+
+// Read a whole small file.
+func (handler *Handler) readFile(filePath string) (string, error) {
+	fd, err := handler.fs.Open(filePath, filesystem.ReadOnly, 0)
+	if err != nil {
+		return "", err
+	}
+	defer handler.fs.Close(fd)
+	if _, err := handler.fs.Seek(fd, 0, filesystem.FromBeginning); err != nil {
+		return "", err
+	}
+	contents, err := ioutil.ReadAll(&fileReader{handler.fs, fd})
+	return string(contents), err
+}
@@ -0,0 +1,329 @@
+package s3fs
+
+import (
+	"ad"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"fsraft"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	if _, isSet := os.LookupEnv("DFS_DEFAULT_DEBUG_LEVEL"); !isSet {
+		ad.SetDebugLevel(ad.WARN)
+	}
+	os.Exit(m.Run())
+}
+
+// Three FileServers, with an S3 server in front of a clerk.
+type testCluster struct {
+	server *httptest.Server
+}
+
+func startTestCluster(t *testing.T) *testCluster {
+	raftCluster := fsraft.StartTestCluster(t, 3, fsraft.DefaultFileServerConfig())
+	return &testCluster{httptest.NewServer(MakeHandler(raftCluster.MakeClerk()))}
+}
+
+func (cluster *testCluster) stop() {
+	cluster.server.Close()
+}
+
+type testResponse struct {
+	status int
+	header http.Header
+	body   string
+}
+
+// The Code in an S3 error response, or "" if it isn't one.
+func (r testResponse) errorCode() string {
+	var s3Err s3Error
+	if xml.Unmarshal([]byte(r.body), &s3Err) != nil {
+		return ""
+	}
+	return s3Err.Code
+}
+
+// Make a request with the given headers, given as name and value pairs. Every request claims to be signed, the way
+// stock clients sign them, to check that the signature doesn't get in the way.
+func (cluster *testCluster) do(t *testing.T, method string, target string, body string, headers ...string) testResponse {
+	request, err := http.NewRequest(method, cluster.server.URL+target, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20180101/us-east-1/s3/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, Signature=0123456789abcdef")
+	request.Header.Set("X-Amz-Date", "20180101T000000Z")
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("%v %v failed: %v", method, target, err)
+	}
+	defer response.Body.Close()
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("couldn't read the response to %v %v: %v", method, target, err)
+	}
+	return testResponse{response.StatusCode, response.Header, string(responseBody)}
+}
+
+// Make a request and fail unless it gets the expected status.
+func (cluster *testCluster) expect(t *testing.T, expectedStatus int, method string, target string, body string,
+	headers ...string) testResponse {
+	response := cluster.do(t, method, target, body, headers...)
+	if response.status != expectedStatus {
+		t.Fatalf("%v %v returned %d (%q), expected %d", method, target, response.status, response.body,
+			expectedStatus)
+	}
+	return response
+}
+
+// Make a request and fail unless it gets the expected S3 error.
+func (cluster *testCluster) expectError(t *testing.T, expectedStatus int, expectedCode string, method string,
+	target string, body string, headers ...string) {
+	response := cluster.expect(t, expectedStatus, method, target, body, headers...)
+	if response.errorCode() != expectedCode {
+		t.Fatalf("%v %v returned %q, expected the error %v", method, target, response.body, expectedCode)
+	}
+}
+
+func quotedMD5(data string) string {
+	sum := md5.Sum([]byte(data))
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func TestBuckets(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+
+	cluster.expect(t, http.StatusOK, "PUT", "/my-bucket", "")
+	cluster.expectError(t, http.StatusConflict, "BucketAlreadyOwnedByYou", "PUT", "/my-bucket", "")
+	cluster.expectError(t, http.StatusBadRequest, "InvalidBucketName", "PUT", "/No_Such.Bucket", "")
+	cluster.expect(t, http.StatusOK, "HEAD", "/my-bucket", "")
+	cluster.expect(t, http.StatusNotFound, "HEAD", "/other-bucket", "")
+	cluster.expect(t, http.StatusOK, "PUT", "/other-bucket", "")
+
+	var buckets struct {
+		Names []string `xml:"Buckets>Bucket>Name"`
+	}
+	if err := xml.Unmarshal([]byte(cluster.expect(t, http.StatusOK, "GET", "/", "").body), &buckets); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"my-bucket", "other-bucket"}; !reflect.DeepEqual(buckets.Names, expected) {
+		t.Fatalf("ListBuckets returned %v, expected %v", buckets.Names, expected)
+	}
+
+	cluster.expect(t, http.StatusOK, "PUT", "/my-bucket/deep/down/key", "data")
+	cluster.expectError(t, http.StatusConflict, "BucketNotEmpty", "DELETE", "/my-bucket", "")
+	cluster.expect(t, http.StatusNoContent, "DELETE", "/my-bucket/deep/down/key", "")
+	// the directories the key was in go away along with the bucket
+	cluster.expect(t, http.StatusNoContent, "DELETE", "/my-bucket", "")
+	cluster.expectError(t, http.StatusNotFound, "NoSuchBucket", "DELETE", "/my-bucket", "")
+}
+
+func TestObjects(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+	cluster.expect(t, http.StatusOK, "PUT", "/bucket", "")
+
+	response := cluster.expect(t, http.StatusOK, "PUT", "/bucket/dir/sub/hello.txt", "hello world")
+	if etag := response.header.Get("ETag"); etag != quotedMD5("hello world") {
+		t.Fatalf("PutObject returned the ETag %v, expected %v", etag, quotedMD5("hello world"))
+	}
+	if body := cluster.expect(t, http.StatusOK, "GET", "/bucket/dir/sub/hello.txt", "").body; body != "hello world" {
+		t.Fatalf("GetObject returned %q, expected %q", body, "hello world")
+	}
+	response = cluster.expect(t, http.StatusOK, "HEAD", "/bucket/dir/sub/hello.txt", "")
+	if response.header.Get("Content-Length") != "11" || response.body != "" {
+		t.Fatalf("HeadObject returned (Content-Length %q, %q)", response.header.Get("Content-Length"), response.body)
+	}
+	response = cluster.expect(t, http.StatusPartialContent, "GET", "/bucket/dir/sub/hello.txt", "", "Range", "bytes=6-")
+	if response.body != "world" || response.header.Get("Content-Range") != "bytes 6-10/11" {
+		t.Fatalf("GetObject with a Range returned (%q, Content-Range %q)", response.body,
+			response.header.Get("Content-Range"))
+	}
+	cluster.expect(t, http.StatusRequestedRangeNotSatisfiable, "GET", "/bucket/dir/sub/hello.txt", "",
+		"Range", "bytes=20-")
+
+	cluster.expect(t, http.StatusOK, "PUT", "/bucket/dir/sub/hello.txt", "bye")
+	if body := cluster.expect(t, http.StatusOK, "GET", "/bucket/dir/sub/hello.txt", "").body; body != "bye" {
+		t.Fatalf("GetObject after replacing returned %q, expected %q", body, "bye")
+	}
+
+	cluster.expectError(t, http.StatusNotFound, "NoSuchKey", "GET", "/bucket/missing", "")
+	cluster.expectError(t, http.StatusNotFound, "NoSuchKey", "GET", "/bucket/dir", "")
+	cluster.expect(t, http.StatusNotFound, "HEAD", "/bucket/missing", "")
+	cluster.expectError(t, http.StatusNotFound, "NoSuchBucket", "GET", "/nope/key", "")
+	cluster.expectError(t, http.StatusNotFound, "NoSuchBucket", "PUT", "/nope/key", "data")
+	cluster.expectError(t, http.StatusConflict, "InvalidRequest", "PUT", "/bucket/dir/sub/hello.txt/inside", "x")
+	cluster.expectError(t, http.StatusConflict, "InvalidRequest", "PUT", "/bucket/dir/sub", "x")
+	cluster.expectError(t, http.StatusBadRequest, "InvalidArgument", "PUT", "/bucket/dir//x", "x")
+
+	cluster.expect(t, http.StatusNoContent, "DELETE", "/bucket/dir/sub/hello.txt", "")
+	cluster.expect(t, http.StatusNoContent, "DELETE", "/bucket/dir/sub/hello.txt", "")
+	cluster.expectError(t, http.StatusNotFound, "NoSuchKey", "GET", "/bucket/dir/sub/hello.txt", "")
+}
+
+type listing struct {
+	Keys                  []string `xml:"Contents>Key"`
+	Prefixes              []string `xml:"CommonPrefixes>Prefix"`
+	KeyCount              int      `xml:"KeyCount"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+}
+
+func (cluster *testCluster) list(t *testing.T, query url.Values) listing {
+	query.Set("list-type", "2")
+	body := cluster.expect(t, http.StatusOK, "GET", "/bucket?"+query.Encode(), "").body
+	var result listing
+	if err := xml.Unmarshal([]byte(body), &result); err != nil {
+		t.Fatalf("couldn't parse %q: %v", body, err)
+	}
+	return result
+}
+
+func TestListObjectsV2(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+	cluster.expect(t, http.StatusOK, "PUT", "/bucket", "")
+	keys := []string{"a.txt", "a/b", "a/c/d", "b", "photos/2018/x", "photos/2019/y"}
+	for _, key := range keys {
+		cluster.expect(t, http.StatusOK, "PUT", "/bucket/"+key, key)
+	}
+
+	lists := []struct {
+		query    url.Values
+		keys     []string
+		prefixes []string
+	}{
+		{url.Values{}, keys, nil},
+		{url.Values{"delimiter": {"/"}}, []string{"a.txt", "b"}, []string{"a/", "photos/"}},
+		{url.Values{"prefix": {"photos/"}, "delimiter": {"/"}}, nil, []string{"photos/2018/", "photos/2019/"}},
+		{url.Values{"prefix": {"a"}}, []string{"a.txt", "a/b", "a/c/d"}, nil},
+		{url.Values{"prefix": {"a/c/"}}, []string{"a/c/d"}, nil},
+		{url.Values{"prefix": {"nothing/here"}}, nil, nil},
+		{url.Values{"start-after": {"a/c/d"}}, []string{"b", "photos/2018/x", "photos/2019/y"}, nil},
+	}
+	for _, l := range lists {
+		result := cluster.list(t, l.query)
+		if !reflect.DeepEqual(result.Keys, l.keys) || !reflect.DeepEqual(result.Prefixes, l.prefixes) ||
+			result.IsTruncated {
+			t.Errorf("listing with %v returned %+v, expected keys %v and prefixes %v", l.query, result, l.keys,
+				l.prefixes)
+		}
+	}
+
+	// two at a time, with a common prefix taking up a place like a key does
+	pages := make([][]string, 0)
+	query := url.Values{"delimiter": {"/"}, "max-keys": {"2"}}
+	for {
+		result := cluster.list(t, query)
+		pages = append(pages, append(result.Keys, result.Prefixes...))
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	if expected := [][]string{{"a.txt", "a/"}, {"b", "photos/"}}; !reflect.DeepEqual(pages, expected) {
+		t.Fatalf("listing two at a time returned %v, expected %v", pages, expected)
+	}
+
+	cluster.expectError(t, http.StatusNotImplemented, "NotImplemented", "GET", "/bucket", "")
+}
+
+func TestMultipartUpload(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+	cluster.expect(t, http.StatusOK, "PUT", "/bucket", "")
+
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	body := cluster.expect(t, http.StatusOK, "POST", "/bucket/big/file?uploads", "").body
+	if err := xml.Unmarshal([]byte(body), &initiated); err != nil || initiated.UploadID == "" {
+		t.Fatalf("CreateMultipartUpload returned %q", body)
+	}
+	part := func(key string, number int, data string) string {
+		target := fmt.Sprintf("/bucket/%s?partNumber=%d&uploadId=%s", key, number, initiated.UploadID)
+		return cluster.expect(t, http.StatusOK, "PUT", target, data).header.Get("ETag")
+	}
+	etag2 := part("big/file", 2, "second part")
+	part("big/file", 1, "first try, ")
+	etag1 := part("big/file", 1, "first part, ")
+	if etag1 != quotedMD5("first part, ") {
+		t.Fatalf("UploadPart returned the ETag %v, expected %v", etag1, quotedMD5("first part, "))
+	}
+	// nothing shows until the upload is complete
+	if result := cluster.list(t, url.Values{}); len(result.Keys) != 0 {
+		t.Fatalf("listing during an upload returned %v", result.Keys)
+	}
+
+	complete := func(etags ...string) string {
+		document := "<CompleteMultipartUpload>"
+		for i, etag := range etags {
+			document += fmt.Sprintf("<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, etag)
+		}
+		return document + "</CompleteMultipartUpload>"
+	}
+	target := "/bucket/big/file?uploadId=" + initiated.UploadID
+	cluster.expectError(t, http.StatusBadRequest, "InvalidPart", "POST", target, complete(quotedMD5("first try, "),
+		etag2))
+	cluster.expectError(t, http.StatusNotFound, "NoSuchUpload", "POST", "/bucket/other?uploadId="+initiated.UploadID,
+		complete(etag1, etag2))
+	body = cluster.expect(t, http.StatusOK, "POST", target, complete(etag1, etag2)).body
+	var completed struct {
+		ETag string `xml:"ETag"`
+	}
+	if err := xml.Unmarshal([]byte(body), &completed); err != nil || !strings.HasSuffix(completed.ETag, `-2"`) {
+		t.Fatalf("CompleteMultipartUpload returned %q", body)
+	}
+	if body := cluster.expect(t, http.StatusOK, "GET", "/bucket/big/file", "").body; body != "first part, second part" {
+		t.Fatalf("GetObject after a multipart upload returned %q", body)
+	}
+	cluster.expectError(t, http.StatusNotFound, "NoSuchUpload", "POST", target, complete(etag1, etag2))
+
+	// an aborted upload leaves nothing behind
+	body = cluster.expect(t, http.StatusOK, "POST", "/bucket/abandoned?uploads", "").body
+	if err := xml.Unmarshal([]byte(body), &initiated); err != nil {
+		t.Fatal(err)
+	}
+	part("abandoned", 1, "never mind")
+	cluster.expect(t, http.StatusNoContent, "DELETE", "/bucket/abandoned?uploadId="+initiated.UploadID, "")
+	cluster.expectError(t, http.StatusNotFound, "NoSuchUpload", "DELETE",
+		"/bucket/abandoned?uploadId="+initiated.UploadID, "")
+	cluster.expectError(t, http.StatusNotFound, "NoSuchKey", "GET", "/bucket/abandoned", "")
+	if body := cluster.expect(t, http.StatusOK, "GET", "/", "").body; strings.Contains(body, uploadsDir[1:]) {
+		t.Fatalf("ListBuckets showed where uploads are kept: %q", body)
+	}
+}
+
+// Bodies that SigV4 clients stream in aws-chunked encoding are decoded.
+func TestAwsChunked(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+	cluster.expect(t, http.StatusOK, "PUT", "/bucket", "")
+
+	chunked := "6;chunk-signature=aaaa\r\nhello \r\n5;chunk-signature=bbbb\r\nworld\r\n0;chunk-signature=cccc\r\n" +
+		"x-amz-checksum-crc32:DUoRhQ==\r\n\r\n"
+	cluster.expect(t, http.StatusOK, "PUT", "/bucket/signed", chunked,
+		"X-Amz-Content-Sha256", "STREAMING-AWS4-HMAC-SHA256-PAYLOAD", "X-Amz-Decoded-Content-Length", "11")
+	if body := cluster.expect(t, http.StatusOK, "GET", "/bucket/signed", "").body; body != "hello world" {
+		t.Fatalf("GetObject of an aws-chunked upload returned %q, expected %q", body, "hello world")
+	}
+
+	cluster.expectError(t, http.StatusBadRequest, "IncompleteBody", "PUT", "/bucket/cut-off",
+		"6;chunk-signature=aaaa\r\nhel", "Content-Encoding", "aws-chunked")
+	cluster.expectError(t, http.StatusBadRequest, "IncompleteBody", "PUT", "/bucket/garbled",
+		"zz\r\nhello\r\n0\r\n\r\n", "Content-Encoding", "aws-chunked")
+}
@@ -0,0 +1,365 @@
+package socketfs
+
+import (
+	"bufio"
+	"filesystem"
+	"net"
+	"sync"
+	"time"
+)
+
+// A connection to a Gateway, which implements filesystem.FileSystem. This is the reference client for the protocol
+// described in protocol.go.
+//
+// It is safe to use a Client from many goroutines; their requests take turns on the connection. If the connection
+// breaks, every later call returns IOError.
+type Client struct {
+	lock   sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+	broken bool
+}
+
+// Connect to the gateway at address.
+func Dial(address string) (*Client, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}, nil
+}
+
+// Hang up. The gateway closes any file descriptors that were opened through this Client and are still open.
+func (c *Client) Disconnect() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.broken = true
+	return c.conn.Close()
+}
+
+// See the spec for FileSystem::Mkdir.
+func (c *Client) Mkdir(path string) (success bool, err error) {
+	e := c.request(mkdirOp)
+	e.string(path)
+	_, err = c.call(e)
+	return err == nil, err
+}
+
+// See the spec for FileSystem::Open.
+//
+// The Block flag is handled here, by retrying until the file is no longer AlreadyOpen, so that other goroutines can
+// use this Client in the meantime (for instance, to close the file).
+func (c *Client) Open(path string, mode filesystem.OpenMode, flags filesystem.OpenFlags) (fileDescriptor int, err error) {
+	if filesystem.FlagIsSet(flags, filesystem.Block) {
+		flagsWithoutBlock := flags ^ filesystem.Block
+		for {
+			fileDescriptor, err = c.Open(path, mode, flagsWithoutBlock)
+			if err != filesystem.AlreadyOpen {
+				return fileDescriptor, err
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	e := c.request(openOp)
+	e.string(path)
+	e.u8(uint8(mode))
+	e.u8(uint8(flags))
+	d, err := c.call(e)
+	if err != nil {
+		return -1, err
+	}
+	fileDescriptor = d.i64()
+	if err := c.finish(d); err != nil {
+		return -1, err
+	}
+	return fileDescriptor, nil
+}
+
+// See the spec for FileSystem::Close.
+func (c *Client) Close(fileDescriptor int) (success bool, err error) {
+	e := c.request(closeOp)
+	e.i64(fileDescriptor)
+	_, err = c.call(e)
+	return err == nil, err
+}
+
+// See the spec for FileSystem::Seek.
+func (c *Client) Seek(fileDescriptor int, offset int, base filesystem.SeekMode) (newPosition int, err error) {
+	e := c.request(seekOp)
+	e.i64(fileDescriptor)
+	e.i64(offset)
+	e.u8(uint8(base))
+	d, err := c.call(e)
+	if err != nil {
+		return -1, err
+	}
+	newPosition = d.i64()
+	if err := c.finish(d); err != nil {
+		return -1, err
+	}
+	return newPosition, nil
+}
+
+// See the spec for FileSystem::Read.
+func (c *Client) Read(fileDescriptor int, numBytes int) (bytesRead int, data []byte, err error) {
+	e := c.request(readOp)
+	e.i64(fileDescriptor)
+	e.i64(numBytes)
+	d, err := c.call(e)
+	if err != nil {
+		return -1, make([]byte, 0), err
+	}
+	data = d.bytes()
+	if err := c.finish(d); err != nil {
+		return -1, make([]byte, 0), err
+	}
+	return len(data), data, nil
+}
+
+// See the spec for FileSystem::Write.
+func (c *Client) Write(fileDescriptor int, numBytes int, data []byte) (bytesWritten int, err error) {
+	e := c.request(writeOp)
+	e.i64(fileDescriptor)
+	e.i64(numBytes)
+	e.bytes(data)
+	d, err := c.call(e)
+	if err != nil {
+		return -1, err
+	}
+	bytesWritten = d.i64()
+	if err := c.finish(d); err != nil {
+		return -1, err
+	}
+	return bytesWritten, nil
+}
+
+// See the spec for FileSystem::Pread.
+func (c *Client) Pread(fileDescriptor int, offset int, numBytes int) (bytesRead int, data []byte, err error) {
+	e := c.request(preadOp)
+	e.i64(fileDescriptor)
+	e.i64(offset)
+	e.i64(numBytes)
+	d, err := c.call(e)
+	if err != nil {
+		return -1, make([]byte, 0), err
+	}
+	data = d.bytes()
+	if err := c.finish(d); err != nil {
+		return -1, make([]byte, 0), err
+	}
+	return len(data), data, nil
+}
+
+// See the spec for FileSystem::Pwrite.
+func (c *Client) Pwrite(fileDescriptor int, offset int, data []byte) (bytesWritten int, err error) {
+	e := c.request(pwriteOp)
+	e.i64(fileDescriptor)
+	e.i64(offset)
+	e.bytes(data)
+	d, err := c.call(e)
+	if err != nil {
+		return -1, err
+	}
+	bytesWritten = d.i64()
+	if err := c.finish(d); err != nil {
+		return -1, err
+	}
+	return bytesWritten, nil
+}
+
+// See the spec for FileSystem::Delete.
+func (c *Client) Delete(path string) (success bool, err error) {
+	e := c.request(deleteOp)
+	e.string(path)
+	_, err = c.call(e)
+	return err == nil, err
+}
+
+// See the spec for FileSystem::Rename.
+func (c *Client) Rename(from string, to string) (success bool, err error) {
+	e := c.request(renameOp)
+	e.string(from)
+	e.string(to)
+	_, err = c.call(e)
+	return err == nil, err
+}
+
+// See the spec for FileSystem::Stat.
+func (c *Client) Stat(path string) (info filesystem.FileInfo, err error) {
+	e := c.request(statOp)
+	e.string(path)
+	d, err := c.call(e)
+	if err != nil {
+		return filesystem.FileInfo{}, err
+	}
+	info = d.info()
+	return info, c.finish(d)
+}
+
+// See the spec for FileSystem::ReadDir.
+func (c *Client) ReadDir(path string) (entries []filesystem.FileInfo, err error) {
+	e := c.request(readDirOp)
+	e.string(path)
+	d, err := c.call(e)
+	if err != nil {
+		return make([]filesystem.FileInfo, 0), err
+	}
+	count := d.u32()
+	entries = make([]filesystem.FileInfo, 0)
+	for i := 0; i < count && d.err == nil; i++ {
+		entries = append(entries, d.info())
+	}
+	return entries, c.finish(d)
+}
+
+// See the spec for FileSystem::Truncate.
+func (c *Client) Truncate(path string, size int) (success bool, err error) {
+	e := c.request(truncateOp)
+	e.string(path)
+	e.i64(size)
+	_, err = c.call(e)
+	return err == nil, err
+}
+
+// See the spec for FileSystem::Ftruncate.
+func (c *Client) Ftruncate(fileDescriptor int, size int) (success bool, err error) {
+	e := c.request(ftruncateOp)
+	e.i64(fileDescriptor)
+	e.i64(size)
+	_, err = c.call(e)
+	return err == nil, err
+}
+
+// See the spec for FileSystem::Fallocate.
+func (c *Client) Fallocate(fileDescriptor int, mode filesystem.FallocateMode, offset int, length int) (success bool,
+	err error) {
+	e := c.request(fallocateOp)
+	e.i64(fileDescriptor)
+	e.u8(uint8(mode))
+	e.i64(offset)
+	e.i64(length)
+	_, err = c.call(e)
+	return err == nil, err
+}
+
+// See the spec for FileSystem::Statfs.
+func (c *Client) Statfs() (info filesystem.FsInfo, err error) {
+	d, err := c.call(c.request(statfsOp))
+	if err != nil {
+		return filesystem.FsInfo{}, err
+	}
+	info.Bytes = d.i64()
+	info.Inodes = d.i64()
+	info.Limits.MaxBytes = d.i64()
+	info.Limits.MaxInodes = d.i64()
+	info.Limits.MaxFileSize = d.i64()
+	info.Limits.MaxDirEntries = d.i64()
+	return info, c.finish(d)
+}
+
+// See the spec for FileSystem::SetQuota.
+func (c *Client) SetQuota(path string, maxBytes int, maxInodes int) (success bool, err error) {
+	e := c.request(setQuotaOp)
+	e.string(path)
+	e.i64(maxBytes)
+	e.i64(maxInodes)
+	_, err = c.call(e)
+	return err == nil, err
+}
+
+// See the spec for FileSystem::GetQuota.
+func (c *Client) GetQuota(path string) (quota filesystem.Quota, err error) {
+	e := c.request(getQuotaOp)
+	e.string(path)
+	d, err := c.call(e)
+	if err != nil {
+		return filesystem.Quota{}, err
+	}
+	quota.MaxBytes = d.i64()
+	quota.MaxInodes = d.i64()
+	quota.Bytes = d.i64()
+	quota.Inodes = d.i64()
+	return quota, c.finish(d)
+}
+
+// See the spec for FileSystem::Symlink.
+func (c *Client) Symlink(target string, linkPath string) (success bool, err error) {
+	e := c.request(symlinkOp)
+	e.string(target)
+	e.string(linkPath)
+	_, err = c.call(e)
+	return err == nil, err
+}
+
+// See the spec for FileSystem::Readlink.
+func (c *Client) Readlink(path string) (target string, err error) {
+	e := c.request(readlinkOp)
+	e.string(path)
+	d, err := c.call(e)
+	if err != nil {
+		return "", err
+	}
+	target = d.string()
+	return target, c.finish(d)
+}
+
+// See the spec for FileSystem::Lstat.
+func (c *Client) Lstat(path string) (info filesystem.FileInfo, err error) {
+	e := c.request(lstatOp)
+	e.string(path)
+	d, err := c.call(e)
+	if err != nil {
+		return filesystem.FileInfo{}, err
+	}
+	info = d.info()
+	return info, c.finish(d)
+}
+
+// Start building a request for op.
+func (c *Client) request(op opCode) *encoder {
+	e := &encoder{}
+	e.u8(uint8(op))
+	return e
+}
+
+// Send a request and wait for its reply. Returns a decoder for the results if the operation succeeded, the
+// filesystem's error if it failed, or IOError if the gateway couldn't be reached.
+func (c *Client) call(e *encoder) (*decoder, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.broken {
+		return nil, filesystem.IOError
+	}
+	if err := writeFrame(c.writer, e.buf); err != nil {
+		c.breakLocked()
+		return nil, filesystem.IOError
+	}
+	reply, err := readFrame(c.reader)
+	if err != nil || len(reply) == 0 || reply[0] == statusBadRequest {
+		c.breakLocked()
+		return nil, filesystem.IOError
+	}
+	if reply[0] != statusOK {
+		return nil, errorForStatus(reply[0])
+	}
+	return &decoder{buf: reply[1:]}, nil
+}
+
+// Check that a reply held exactly the results expected. If it didn't, the two sides disagree about the protocol,
+// so hang up and return IOError.
+func (c *Client) finish(d *decoder) error {
+	if d.finish() != nil {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		c.breakLocked()
+		return filesystem.IOError
+	}
+	return nil
+}
+
+func (c *Client) breakLocked() {
+	c.broken = true
+	c.conn.Close()
+}
@@ -0,0 +1,328 @@
+package socketfs
+
+import (
+	"ad"
+	"bufio"
+	"filesystem"
+	"net"
+	"sort"
+	"sync"
+)
+
+// Serves the protocol described in protocol.go, turning each connection's requests into calls on its own
+// filesystem, usually an fsraft.Clerk.
+type Gateway struct {
+	newFileSystem func() filesystem.FileSystem
+	listener      net.Listener
+	lock          sync.Mutex
+	conns         map[net.Conn]bool // open connections, so Close() can drop them
+	closed        bool
+}
+
+// Serve the protocol on address, e.g. "127.0.0.1:0" for any free port on loopback. newFileSystem is called once
+// per connection, e.g. func() filesystem.FileSystem { return fsraft.MakeFsClerk(servers) }.
+func Listen(address string, newFileSystem func() filesystem.FileSystem) (*Gateway, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	g := &Gateway{newFileSystem: newFileSystem, listener: listener, conns: make(map[net.Conn]bool)}
+	go g.acceptConnections()
+	return g, nil
+}
+
+// The address the gateway is serving on, e.g. "127.0.0.1:41234".
+func (g *Gateway) Addr() string {
+	return g.listener.Addr().String()
+}
+
+// Stop accepting connections and close the open ones. A request that is in progress finishes, but its reply is
+// dropped.
+func (g *Gateway) Close() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.closed = true
+	for conn := range g.conns {
+		conn.Close()
+	}
+	return g.listener.Close()
+}
+
+func (g *Gateway) acceptConnections() {
+	for {
+		conn, err := g.listener.Accept()
+		if err != nil {
+			return // closed
+		}
+		g.lock.Lock()
+		if g.closed {
+			g.lock.Unlock()
+			conn.Close()
+			return
+		}
+		g.conns[conn] = true
+		g.lock.Unlock()
+		go g.serveConnection(conn)
+	}
+}
+
+// One connection's filesystem and the file descriptors opened through it.
+type gatewaySession struct {
+	fs      filesystem.FileSystem
+	openFDs map[int]bool
+}
+
+// Answer the requests on conn one at a time until it closes, then close the fds it left open.
+func (g *Gateway) serveConnection(conn net.Conn) {
+	sess := gatewaySession{g.newFileSystem(), make(map[int]bool)}
+	ad.Debug(ad.RPC, "Gateway accepted a connection from %v", conn.RemoteAddr())
+	defer func() {
+		g.lock.Lock()
+		delete(g.conns, conn)
+		g.lock.Unlock()
+		conn.Close()
+		sess.closeOpenFDs()
+		ad.Debug(ad.RPC, "Gateway closed the connection from %v", conn.RemoteAddr())
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		request, err := readFrame(reader)
+		if err != nil {
+			return
+		}
+		reply, ok := sess.handle(request)
+		if writeFrame(writer, reply) != nil || !ok {
+			return
+		}
+	}
+}
+
+// Carry out one request and return the reply. ok is false if the request was malformed.
+func (sess *gatewaySession) handle(request []byte) (reply []byte, ok bool) {
+	d := &decoder{buf: request}
+	e := &encoder{}
+	var err error
+	switch opCode(d.u8()) {
+	case mkdirOp:
+		path := d.string()
+		if d.finish() != nil {
+			break
+		}
+		_, err = sess.fs.Mkdir(path)
+	case openOp:
+		path := d.string()
+		mode := filesystem.OpenMode(d.u8())
+		flags := filesystem.OpenFlags(d.u8())
+		if d.finish() != nil || mode > filesystem.ReadWrite {
+			d.err = errMalformed
+			break
+		}
+		var fd int
+		fd, err = sess.fs.Open(path, mode, flags)
+		if err == nil {
+			sess.openFDs[fd] = true
+			e.i64(fd)
+		}
+	case closeOp:
+		fd := d.i64()
+		if d.finish() != nil {
+			break
+		}
+		_, err = sess.fs.Close(fd)
+		if err == nil {
+			delete(sess.openFDs, fd)
+		}
+	case seekOp:
+		fd := d.i64()
+		offset := d.i64()
+		base := filesystem.SeekMode(d.u8())
+		if d.finish() != nil || base > filesystem.FromEnd {
+			d.err = errMalformed
+			break
+		}
+		var newPosition int
+		newPosition, err = sess.fs.Seek(fd, offset, base)
+		e.i64(newPosition)
+	case readOp:
+		fd := d.i64()
+		numBytes := d.i64()
+		if d.finish() != nil {
+			break
+		}
+		var bytesRead int
+		var data []byte
+		bytesRead, data, err = sess.fs.Read(fd, numBytes)
+		if err == nil {
+			e.bytes(data[:bytesRead])
+		}
+	case writeOp:
+		fd := d.i64()
+		numBytes := d.i64()
+		data := d.bytes()
+		if d.finish() != nil {
+			break
+		}
+		var bytesWritten int
+		bytesWritten, err = sess.fs.Write(fd, numBytes, data)
+		e.i64(bytesWritten)
+	case preadOp:
+		fd := d.i64()
+		offset := d.i64()
+		numBytes := d.i64()
+		if d.finish() != nil {
+			break
+		}
+		var bytesRead int
+		var data []byte
+		bytesRead, data, err = sess.fs.Pread(fd, offset, numBytes)
+		if err == nil {
+			e.bytes(data[:bytesRead])
+		}
+	case pwriteOp:
+		fd := d.i64()
+		offset := d.i64()
+		data := d.bytes()
+		if d.finish() != nil {
+			break
+		}
+		var bytesWritten int
+		bytesWritten, err = sess.fs.Pwrite(fd, offset, data)
+		e.i64(bytesWritten)
+	case deleteOp:
+		path := d.string()
+		if d.finish() != nil {
+			break
+		}
+		_, err = sess.fs.Delete(path)
+	case renameOp:
+		from := d.string()
+		to := d.string()
+		if d.finish() != nil {
+			break
+		}
+		_, err = sess.fs.Rename(from, to)
+	case statOp:
+		path := d.string()
+		if d.finish() != nil {
+			break
+		}
+		var info filesystem.FileInfo
+		info, err = sess.fs.Stat(path)
+		e.info(info)
+	case readDirOp:
+		path := d.string()
+		if d.finish() != nil {
+			break
+		}
+		var entries []filesystem.FileInfo
+		entries, err = sess.fs.ReadDir(path)
+		e.u32(len(entries))
+		for _, entry := range entries {
+			e.info(entry)
+		}
+	case truncateOp:
+		path := d.string()
+		size := d.i64()
+		if d.finish() != nil {
+			break
+		}
+		_, err = sess.fs.Truncate(path, size)
+	case ftruncateOp:
+		fd := d.i64()
+		size := d.i64()
+		if d.finish() != nil {
+			break
+		}
+		_, err = sess.fs.Ftruncate(fd, size)
+	case fallocateOp:
+		fd := d.i64()
+		mode := filesystem.FallocateMode(d.u8())
+		offset := d.i64()
+		length := d.i64()
+		if d.finish() != nil {
+			break
+		}
+		_, err = sess.fs.Fallocate(fd, mode, offset, length)
+	case statfsOp:
+		if d.finish() != nil {
+			break
+		}
+		var info filesystem.FsInfo
+		info, err = sess.fs.Statfs()
+		e.i64(info.Bytes)
+		e.i64(info.Inodes)
+		e.i64(info.Limits.MaxBytes)
+		e.i64(info.Limits.MaxInodes)
+		e.i64(info.Limits.MaxFileSize)
+		e.i64(info.Limits.MaxDirEntries)
+	case setQuotaOp:
+		path := d.string()
+		maxBytes := d.i64()
+		maxInodes := d.i64()
+		if d.finish() != nil {
+			break
+		}
+		_, err = sess.fs.SetQuota(path, maxBytes, maxInodes)
+	case getQuotaOp:
+		path := d.string()
+		if d.finish() != nil {
+			break
+		}
+		var quota filesystem.Quota
+		quota, err = sess.fs.GetQuota(path)
+		e.i64(quota.MaxBytes)
+		e.i64(quota.MaxInodes)
+		e.i64(quota.Bytes)
+		e.i64(quota.Inodes)
+	case symlinkOp:
+		target := d.string()
+		linkPath := d.string()
+		if d.finish() != nil {
+			break
+		}
+		_, err = sess.fs.Symlink(target, linkPath)
+	case readlinkOp:
+		path := d.string()
+		if d.finish() != nil {
+			break
+		}
+		var target string
+		target, err = sess.fs.Readlink(path)
+		e.string(target)
+	case lstatOp:
+		path := d.string()
+		if d.finish() != nil {
+			break
+		}
+		var info filesystem.FileInfo
+		info, err = sess.fs.Lstat(path)
+		e.info(info)
+	default:
+		d.err = errMalformed
+	}
+
+	if d.err != nil {
+		ad.Debug(ad.WARN, "Gateway got a malformed %d-byte request, hanging up", len(request))
+		return []byte{statusBadRequest}, false
+	}
+	if err != nil {
+		return []byte{statusForError(err)}, true
+	}
+	return append([]byte{statusOK}, e.buf...), true
+}
+
+// Close the fds this connection opened and didn't close, so that other clients can open the files.
+func (sess *gatewaySession) closeOpenFDs() {
+	fds := make([]int, 0, len(sess.openFDs))
+	for fd := range sess.openFDs {
+		fds = append(fds, fd)
+	}
+	sort.Ints(fds)
+	for _, fd := range fds {
+		if _, err := sess.fs.Close(fd); err != nil {
+			ad.Debug(ad.WARN, "Gateway couldn't close fd %d after its connection closed: %v", fd, err)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package socketfs
+
+// The wire protocol spoken between the gateway and its clients, simple enough to implement in C.
+//
+// A client opens a TCP connection and sends requests; the gateway answers each request with exactly one reply, in
+// the order the requests arrived, and does not start on a request until it has replied to the one before it. All of
+// a connection's requests go through one Clerk, so they are applied to the filesystem in the order they were sent.
+//
+// Every request and reply is a frame: a uint32 holding the number of bytes that follow, then that many bytes.
+// Integers are big-endian. Inside a frame, the fields are laid out back to back with these types:
+//
+//	u8      1 byte
+//	i64     8 bytes, two's complement
+//	bytes   a u32 length, then that many bytes
+//	string  laid out like bytes, holding UTF-8
+//	info    a string name, a u8 holding 1 if it is a directory plus 2 if it is open plus 4 if it is a symbolic link,
+//	        and an i64 size
+//
+// A request is a u8 op followed by its arguments, and a successful reply is a u8 status of 0 followed by its
+// results:
+//
+//	op  name       arguments                                       results
+//	1   Mkdir      path string                                     (none)
+//	2   Open       path string, mode u8, flags u8                  fd i64
+//	3   Close      fd i64                                          (none)
+//	4   Seek       fd i64, offset i64, base u8                     newPosition i64
+//	5   Read       fd i64, numBytes i64                            data bytes
+//	6   Write      fd i64, numBytes i64, data bytes                bytesWritten i64
+//	7   Delete     path string                                     (none)
+//	8   Stat       path string                                     info
+//	9   ReadDir    path string                                     a u32 count, then that many infos
+//	10  Truncate   path string, size i64                           (none)
+//	11  Ftruncate  fd i64, size i64                                (none)
+//	12  Fallocate  fd i64, mode u8, offset i64, length i64         (none)
+//	13  Pread      fd i64, offset i64, numBytes i64                data bytes
+//	14  Pwrite     fd i64, offset i64, data bytes                  bytesWritten i64
+//	15  Statfs     (none)                                          bytes i64, inodes i64, then the limits: maxBytes i64,
+//	                                                               maxInodes i64, maxFileSize i64, maxDirEntries i64
+//	16  SetQuota   path string, maxBytes i64, maxInodes i64        (none)
+//	17  GetQuota   path string                                     maxBytes i64, maxInodes i64, bytes i64, inodes i64
+//	18  Symlink    target string, linkPath string                  (none)
+//	19  Readlink   path string                                     target string
+//	20  Lstat      path string                                     info
+//	21  Rename     from string, to string                          (none)
+//
+// The arguments and results mean what they do in filesystem.FileSystem. Modes are 0 for ReadOnly, 1 for WriteOnly
+// and 2 for ReadWrite; flags are 1 for Append, 2 for Create, 4 for Truncate and 8 for Block, OR'd together; bases
+// are 0 for FromBeginning, 1 for FromCurrent and 2 for FromEnd; fallocate modes are 0 for Allocate and 1 for
+// PunchHole.
+//
+// An Open with the Block flag holds up the whole connection until the file is free, so a client must not use it on
+// a file that the same connection has open; the Go client avoids this by retrying non-blocking opens instead.
+//
+// If the operation fails, the reply is just a u8 status holding one plus the filesystem.ErrorCode:
+//
+//	1 NotFound          5 IllegalArgument   9 NoMoreSpace         13 WriteTooLarge
+//	2 IsDirectory       6 TryAgain          10 DirectoryNotEmpty  14 WrongMode
+//	3 TooManyFDsOpen    7 IOError           11 AlreadyExists      15 TooManyLinks
+//	4 InactiveFD        8 FileTooLarge      12 AlreadyOpen
+//
+// A request the gateway can't make sense of gets a reply with status 255, and then the gateway hangs up.
+// When a connection closes, the gateway closes any file descriptors that were opened on it and are still open.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"filesystem"
+	"fmt"
+	"io"
+)
+
+type opCode uint8
+
+const (
+	mkdirOp opCode = iota + 1
+	openOp
+	closeOp
+	seekOp
+	readOp
+	writeOp
+	deleteOp
+	statOp
+	readDirOp
+	truncateOp
+	ftruncateOp
+	fallocateOp
+	preadOp
+	pwriteOp
+	statfsOp
+	setQuotaOp
+	getQuotaOp
+	symlinkOp
+	readlinkOp
+	lstatOp
+	renameOp
+)
+
+const (
+	statusOK         = 0
+	statusBadRequest = 255
+)
+
+// Bits in the u8 that describes a FileInfo.
+const (
+	infoIsDir     = 1
+	infoIsOpen    = 2
+	infoIsSymlink = 4
+)
+
+// The largest frame either side will accept, which leaves room for writing 10MB at a time.
+const maxFrameSize = 64 * 1024 * 1024
+
+// Returned while decoding a frame that is too short or holds impossible values.
+var errMalformed = errors.New("malformed frame")
+
+// Write one frame holding payload and flush it.
+func writeFrame(w *bufio.Writer, payload []byte) error {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Read one frame and return its payload.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes is larger than the limit of %d", size, maxFrameSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// Builds the payload of a frame.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) u8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) u32(v int) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) i64(v int) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(int64(v)))
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) bytes(v []byte) {
+	e.u32(len(v))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) string(v string) {
+	e.bytes([]byte(v))
+}
+
+func (e *encoder) info(v filesystem.FileInfo) {
+	e.string(v.Name)
+	var bits uint8
+	if v.IsDir {
+		bits |= infoIsDir
+	}
+	if v.IsOpen {
+		bits |= infoIsOpen
+	}
+	if v.IsSymlink {
+		bits |= infoIsSymlink
+	}
+	e.u8(bits)
+	e.i64(v.Size)
+}
+
+// Takes apart the payload of a frame. Once something goes wrong, err is set and every method returns zero values,
+// so callers can decode every field and check err once at the end.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.buf) {
+		d.err = errMalformed
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) u8() uint8 {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) u32() int {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) i64() int {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return int(int64(binary.BigEndian.Uint64(b)))
+}
+
+func (d *decoder) bytes() []byte {
+	b := d.take(d.u32())
+	if b == nil {
+		return nil
+	}
+	// copy so the caller can keep it
+	return append([]byte{}, b...)
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) info() filesystem.FileInfo {
+	name := d.string()
+	bits := d.u8()
+	size := d.i64()
+	return filesystem.FileInfo{Name: name, IsDir: bits&infoIsDir != 0, Size: size, IsOpen: bits&infoIsOpen != 0,
+		IsSymlink: bits&infoIsSymlink != 0}
+}
+
+// Check that the whole payload was used, and return the first thing that went wrong.
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.buf) != 0 {
+		d.err = errMalformed
+	}
+	return d.err
+}
+
+// The status a reply carries for err. Errors that aren't a filesystem.ErrorCode are reported as IOError.
+func statusForError(err error) uint8 {
+	if err == nil {
+		return statusOK
+	}
+	code, isErrorCode := err.(filesystem.ErrorCode)
+	if !isErrorCode {
+		code = filesystem.IOError
+	}
+	return uint8(code) + 1
+}
+
+// The error a reply's status stands for, or nil if it is statusOK.
+func errorForStatus(status uint8) error {
+	if status == statusOK {
+		return nil
+	}
+	return filesystem.ErrorCode(status - 1)
+}
@@ -0,0 +1,135 @@
+package socketfs
+
+import (
+	"ad"
+	"bufio"
+	"filesystem"
+	"fsraft"
+	"memoryFS"
+	"net"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// at the RPC level, every byte of the conformance tests' 10MB writes gets logged
+	if _, isSet := os.LookupEnv("DFS_DEFAULT_DEBUG_LEVEL"); !isSet {
+		ad.SetDebugLevel(ad.WARN)
+	}
+	os.Exit(m.Run())
+}
+
+// Three FileServers on localhost behind a Gateway, so tests go client -> gateway -> clerk -> Raft.
+type testCluster struct {
+	gateway *Gateway
+}
+
+func startTestCluster(t *testing.T) *testCluster {
+	raftCluster := fsraft.StartTestCluster(t, 3, fsraft.DefaultFileServerConfig())
+	gateway, err := Listen("127.0.0.1:0", func() filesystem.FileSystem {
+		return raftCluster.MakeClerk()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCluster{gateway}
+}
+
+func (cluster *testCluster) dial(t *testing.T) *Client {
+	client, err := Dial(cluster.gateway.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func (cluster *testCluster) stop() {
+	cluster.gateway.Close()
+}
+
+// Every functionality test passes through the socket client, each against a gateway in front of a fresh MemoryFS.
+// (Putting a Raft cluster behind each one as well runs the 10MB write tests out of memory.)
+func TestConformance(t *testing.T) {
+	for _, functionalityTest := range filesystem.FunctionalityTests {
+		name := runtime.FuncForPC(reflect.ValueOf(functionalityTest).Pointer()).Name()
+		name = name[strings.LastIndex(name, ".")+1:]
+		t.Run(name, func(t *testing.T) {
+			mfs := memoryFS.CreateEmptyMemoryFS()
+			gateway, err := Listen("127.0.0.1:0", func() filesystem.FileSystem { return &mfs })
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer gateway.Close()
+			client, err := Dial(gateway.Addr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Disconnect()
+			functionalityTest(t, client)
+		})
+	}
+}
+
+// A file left open by a client that hangs up is closed by the gateway.
+func TestDisconnectClosesFiles(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+
+	first := cluster.dial(t)
+	if _, err := first.Open("/left-open", filesystem.ReadWrite, filesystem.Create); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	second := cluster.dial(t)
+	defer second.Disconnect()
+	if _, err := second.Open("/left-open", filesystem.ReadOnly, 0); err != filesystem.AlreadyOpen {
+		t.Fatalf("opening a file that is open elsewhere returned %v, expected AlreadyOpen", err)
+	}
+
+	first.Disconnect()
+	for start := time.Now(); ; time.Sleep(50 * time.Millisecond) {
+		_, err := second.Open("/left-open", filesystem.ReadOnly, 0)
+		if err == nil {
+			break
+		}
+		if err != filesystem.AlreadyOpen || time.Since(start) > 5*time.Second {
+			t.Fatalf("after the other client hung up, Open returned %v", err)
+		}
+	}
+	if _, err := first.Mkdir("/too-late"); err != filesystem.IOError {
+		t.Fatalf("a disconnected client returned %v, expected IOError", err)
+	}
+}
+
+// A request the gateway doesn't understand gets status 255, and the gateway hangs up.
+func TestMalformedRequest(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+
+	conn, err := net.Dial("tcp", cluster.gateway.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	// a Mkdir whose path claims to be longer than the frame
+	request := &encoder{}
+	request.u8(uint8(mkdirOp))
+	request.u32(100)
+	request.buf = append(request.buf, "/short"...)
+	if err := writeFrame(writer, request.buf); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := readFrame(reader)
+	if err != nil || len(reply) != 1 || reply[0] != statusBadRequest {
+		t.Fatalf("got reply (%v, %v), expected status %d", reply, err, statusBadRequest)
+	}
+	if _, err := readFrame(reader); err == nil {
+		t.Fatalf("gateway kept the connection open after a malformed request")
+	}
+}
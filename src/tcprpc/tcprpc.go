@@ -0,0 +1,295 @@
+package tcprpc
+
+//
+// RPC over real TCP connections, so that Raft, the file server
+// and its clerks can run as separate processes.
+//
+// a drop-in for labrpc: a ClientEnd here has the same Call()
+// as labrpc.ClientEnd, and requests are dispatched to the same
+// labrpc.Server (by reflection, to the services added to it),
+// so either can be passed wherever a labrpc.Endpoint is wanted.
+//
+// srv := labrpc.MakeServer() -- add services to it as usual.
+// l, err := tcprpc.Listen("127.0.0.1:0", srv) -- serve it.
+// l.Addr() -- the address it is listening on.
+// l.Close() -- stop serving, and drop every connection.
+//
+// end := tcprpc.MakeEnd(address) -- talk to one server.
+// end.Call("Raft.AppendEntries", &args, &reply) -- send an RPC, wait for reply.
+//
+// Call() returns false if the server could not be reached,
+// the connection broke, the server has no such method, or
+// no reply came back within the end's timeout. It is OK to
+// have many Call()s in progress on the same ClientEnd; they
+// share one connection. After a failure the next Call()
+// connects again.
+//
+// each request and reply is a labgob-encoded frame on the
+// connection's gob stream, carrying the labgob-encoded
+// arguments or reply, so the same types work over both
+// transports (remember to labgob.Register() what goes in
+// an interface{}).
+//
+
+import (
+	"ad"
+	"bytes"
+	"labgob"
+	"labrpc"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// how long Call() waits to connect and then for a reply,
+// unless the ClientEnd was made with a different timeout.
+const DefaultCallTimeout = 3 * time.Second
+
+type requestFrame struct {
+	Seq     uint64 // matches the reply to the request
+	SvcMeth string // e.g. "Raft.AppendEntries"
+	Args    []byte
+}
+
+type replyFrame struct {
+	Seq   uint64
+	Ok    bool // false if the server has no such method
+	Reply []byte
+}
+
+// Client side ================================================================
+
+type ClientEnd struct {
+	address string
+	timeout time.Duration
+	mu      sync.Mutex
+	conn    *clientConn // nil until the first Call(), or after the connection breaks
+}
+
+// one connection from a ClientEnd, shared by all its Call()s.
+type clientConn struct {
+	conn    net.Conn
+	mu      sync.Mutex // protects everything below, and writes to conn
+	encoder *labgob.LabEncoder
+	nextSeq uint64
+	pending map[uint64]chan replyFrame // closed without a reply if the connection breaks
+	broken  bool
+}
+
+func MakeEnd(address string) *ClientEnd {
+	return MakeEndWithTimeout(address, DefaultCallTimeout)
+}
+
+func MakeEndWithTimeout(address string, timeout time.Duration) *ClientEnd {
+	return &ClientEnd{address: address, timeout: timeout}
+}
+
+func (e *ClientEnd) Address() string {
+	return e.address
+}
+
+// send an RPC, wait for the reply.
+// the return value indicates success; false means that
+// no reply was received from the server.
+func (e *ClientEnd) Call(svcMeth string, args interface{}, reply interface{}) bool {
+	qb := new(bytes.Buffer)
+	qe := labgob.NewEncoder(qb)
+	if err := qe.Encode(args); err != nil {
+		log.Fatalf("Encode error: %v", err.Error())
+	}
+
+	c := e.connect()
+	if c == nil {
+		return false
+	}
+	seq, replyCh := c.send(svcMeth, qb.Bytes())
+	if replyCh == nil {
+		return false
+	}
+
+	select {
+	case rep, ok := <-replyCh:
+		if !ok || !rep.Ok {
+			return false
+		}
+		rd := labgob.NewDecoder(bytes.NewBuffer(rep.Reply))
+		if err := rd.Decode(reply); err != nil {
+			// a reply we can't decode is as good as no reply at all
+			ad.Debug(ad.WARN, "tcprpc.ClientEnd.Call(%v): decode reply: %v", svcMeth, err)
+			return false
+		}
+		return true
+	case <-time.After(e.timeout):
+		c.forget(seq)
+		return false
+	}
+}
+
+// return the end's connection, dialing a new one if there
+// is none or it has broken. returns nil if the dial fails.
+func (e *ClientEnd) connect() *clientConn {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil && !e.conn.isBroken() {
+		return e.conn
+	}
+	conn, err := net.DialTimeout("tcp", e.address, e.timeout)
+	if err != nil {
+		return nil
+	}
+	c := &clientConn{conn: conn, encoder: labgob.NewEncoder(conn), pending: map[uint64]chan replyFrame{}}
+	go c.readReplies()
+	e.conn = c
+	return c
+}
+
+// write a request and return the channel its reply will arrive
+// on, or nil if the connection is broken.
+func (c *clientConn) send(svcMeth string, args []byte) (uint64, chan replyFrame) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.broken {
+		return 0, nil
+	}
+	c.nextSeq++
+	seq := c.nextSeq
+	replyCh := make(chan replyFrame, 1)
+	c.pending[seq] = replyCh
+	if err := c.encoder.Encode(requestFrame{seq, svcMeth, args}); err != nil {
+		c.breakLocked()
+		return 0, nil
+	}
+	return seq, replyCh
+}
+
+func (c *clientConn) readReplies() {
+	decoder := labgob.NewDecoder(c.conn)
+	for {
+		rep := replyFrame{}
+		if err := decoder.Decode(&rep); err != nil {
+			c.mu.Lock()
+			c.breakLocked()
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Lock()
+		if replyCh, ok := c.pending[rep.Seq]; ok {
+			replyCh <- rep // buffered, so never blocks
+			delete(c.pending, rep.Seq)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// stop waiting for the reply to a request that timed out.
+func (c *clientConn) forget(seq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, seq)
+}
+
+func (c *clientConn) isBroken() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.broken
+}
+
+// close the connection and fail every Call() waiting on it.
+// ONLY CALL WITH THE LOCK
+func (c *clientConn) breakLocked() {
+	if c.broken {
+		return
+	}
+	c.broken = true
+	c.conn.Close()
+	for seq, replyCh := range c.pending {
+		close(replyCh)
+		delete(c.pending, seq)
+	}
+}
+
+// Server side ================================================================
+
+type Listener struct {
+	server   *labrpc.Server
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[net.Conn]bool // open connections, so Close() can drop them
+	closed   bool
+}
+
+// serve the services added to server on address, e.g.
+// "127.0.0.1:0" for any free port on loopback.
+func Listen(address string, server *labrpc.Server) (*Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{server: server, listener: listener, conns: map[net.Conn]bool{}}
+	go l.acceptConnections()
+	return l, nil
+}
+
+// the address the listener is serving on, e.g. "127.0.0.1:41234".
+func (l *Listener) Addr() string {
+	return l.listener.Addr().String()
+}
+
+// stop accepting connections and close the open ones, so to
+// clients the server looks like it has crashed. handlers that
+// are still running finish, but their replies are dropped.
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	for conn := range l.conns {
+		conn.Close()
+	}
+	return l.listener.Close()
+}
+
+func (l *Listener) acceptConnections() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return // closed
+		}
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			conn.Close()
+			return
+		}
+		l.conns[conn] = true
+		l.mu.Unlock()
+		go l.serveConnection(conn)
+	}
+}
+
+func (l *Listener) serveConnection(conn net.Conn) {
+	defer func() {
+		l.mu.Lock()
+		delete(l.conns, conn)
+		l.mu.Unlock()
+		conn.Close()
+	}()
+
+	var writeMu sync.Mutex
+	encoder := labgob.NewEncoder(conn)
+	decoder := labgob.NewDecoder(conn)
+	for {
+		req := requestFrame{}
+		if err := decoder.Decode(&req); err != nil {
+			return
+		}
+		// handlers may block for a long time (e.g. waiting
+		// for a command to commit), so run each on its own.
+		go func(req requestFrame) {
+			reply, ok := l.server.DispatchEncoded(req.SvcMeth, req.Args)
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			encoder.Encode(replyFrame{req.Seq, ok, reply}) // if this fails, the read loop will notice
+		}(req)
+	}
+}
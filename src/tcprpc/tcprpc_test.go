@@ -0,0 +1,185 @@
+package tcprpc
+
+import (
+	"labrpc"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type JunkArgs struct {
+	X int
+}
+type JunkReply struct {
+	X string
+}
+
+type JunkServer struct {
+	mu   sync.Mutex
+	log1 []string
+}
+
+func (js *JunkServer) Handler1(args string, reply *int) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.log1 = append(js.log1, args)
+	*reply, _ = strconv.Atoi(args)
+}
+
+func (js *JunkServer) Handler2(args *JunkArgs, reply *JunkReply) {
+	reply.X = "handler2-" + strconv.Itoa(args.X)
+}
+
+// sleeps for args.X milliseconds.
+func (js *JunkServer) Slow(args *JunkArgs, reply *JunkReply) {
+	time.Sleep(time.Duration(args.X) * time.Millisecond)
+	reply.X = "slow"
+}
+
+func makeJunkListener(t *testing.T, address string) (*JunkServer, *Listener) {
+	js := &JunkServer{}
+	rs := labrpc.MakeServer()
+	rs.AddService(labrpc.MakeService(js))
+	l, err := Listen(address, rs)
+	if err != nil {
+		t.Fatalf("Listen(%v) failed: %v", address, err)
+	}
+	return js, l
+}
+
+func TestBasic(t *testing.T) {
+	_, l := makeJunkListener(t, "127.0.0.1:0")
+	defer l.Close()
+	var e labrpc.Endpoint = MakeEnd(l.Addr())
+
+	{
+		reply := 0
+		if !e.Call("JunkServer.Handler1", "9099", &reply) || reply != 9099 {
+			t.Fatalf("wrong reply from Handler1")
+		}
+	}
+
+	{
+		reply := JunkReply{}
+		if !e.Call("JunkServer.Handler2", &JunkArgs{111}, &reply) || reply.X != "handler2-111" {
+			t.Fatalf("wrong reply from Handler2")
+		}
+	}
+}
+
+func TestUnknownMethod(t *testing.T) {
+	_, l := makeJunkListener(t, "127.0.0.1:0")
+	defer l.Close()
+	e := MakeEnd(l.Addr())
+
+	reply := JunkReply{}
+	if e.Call("JunkServer.NoSuchHandler", &JunkArgs{1}, &reply) {
+		t.Fatalf("call to a missing method succeeded")
+	}
+	if e.Call("NoSuchServer.Handler2", &JunkArgs{1}, &reply) {
+		t.Fatalf("call to a missing service succeeded")
+	}
+	// the connection is still usable
+	if !e.Call("JunkServer.Handler2", &JunkArgs{2}, &reply) || reply.X != "handler2-2" {
+		t.Fatalf("wrong reply from Handler2")
+	}
+}
+
+// a reply that doesn't decode into the caller's reply type fails the call, but not the end.
+func TestUndecodableReply(t *testing.T) {
+	_, l := makeJunkListener(t, "127.0.0.1:0")
+	defer l.Close()
+	e := MakeEnd(l.Addr())
+
+	wrongReply := 0
+	if e.Call("JunkServer.Handler2", &JunkArgs{1}, &wrongReply) {
+		t.Fatalf("call with the wrong reply type succeeded")
+	}
+	reply := JunkReply{}
+	if !e.Call("JunkServer.Handler2", &JunkArgs{2}, &reply) || reply.X != "handler2-2" {
+		t.Fatalf("wrong reply from Handler2 after a failed call")
+	}
+}
+
+// many concurrent calls share one connection, and each gets its own reply.
+func TestConcurrentCalls(t *testing.T) {
+	js, l := makeJunkListener(t, "127.0.0.1:0")
+	defer l.Close()
+	e := MakeEnd(l.Addr())
+
+	const ncalls = 100
+	var wg sync.WaitGroup
+	for i := 0; i < ncalls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reply := 0
+			if !e.Call("JunkServer.Handler1", strconv.Itoa(i), &reply) || reply != i {
+				t.Errorf("call %d got reply %d", i, reply)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if len(js.log1) != ncalls {
+		t.Fatalf("server handled %d calls, expected %d", len(js.log1), ncalls)
+	}
+}
+
+// a slow handler doesn't hold up other calls, and a call that outlives the timeout fails.
+func TestTimeout(t *testing.T) {
+	_, l := makeJunkListener(t, "127.0.0.1:0")
+	defer l.Close()
+	e := MakeEndWithTimeout(l.Addr(), 200*time.Millisecond)
+
+	slowDone := make(chan bool)
+	go func() {
+		reply := JunkReply{}
+		slowDone <- e.Call("JunkServer.Slow", &JunkArgs{1000}, &reply)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	reply := JunkReply{}
+	if !e.Call("JunkServer.Handler2", &JunkArgs{3}, &reply) || reply.X != "handler2-3" {
+		t.Fatalf("a fast call was held up by a slow one")
+	}
+
+	start := time.Now()
+	if <-slowDone {
+		t.Fatalf("call that took longer than the timeout succeeded")
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("call took %v to time out", time.Since(start))
+	}
+}
+
+// calls fail while the server is down, and succeed again once it is back at the same address.
+func TestServerRestart(t *testing.T) {
+	_, l := makeJunkListener(t, "127.0.0.1:0")
+	address := l.Addr()
+	e := MakeEnd(address)
+
+	reply := JunkReply{}
+	if !e.Call("JunkServer.Handler2", &JunkArgs{4}, &reply) {
+		t.Fatalf("call failed")
+	}
+
+	l.Close()
+	start := time.Now()
+	if e.Call("JunkServer.Handler2", &JunkArgs{5}, &reply) {
+		t.Fatalf("call to a closed server succeeded")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("call to a closed server took %v to fail", time.Since(start))
+	}
+
+	_, l = makeJunkListener(t, address)
+	defer l.Close()
+	reply = JunkReply{}
+	if !e.Call("JunkServer.Handler2", &JunkArgs{6}, &reply) || reply.X != "handler2-6" {
+		t.Fatalf("call after the server restarted failed")
+	}
+}
@@ -101,6 +101,32 @@ echo Begin Core MemoryFS Tests
  run_test "TestMemoryFS_TestWrite1MBytes" 1
  run_test "TestMemoryFS_TestOpenCloseDeleteRoot" 1
  run_test "TestMemoryFS_TestWrite10MBytes10Mx1" 1
+ run_test "TestMemoryFS_TestFallocatePunchHole" 1
+ run_test "TestMemoryFS_TestPathNormalization" 1
+ run_test "TestMemoryFS_TestPathValidation" 1
+ run_test "TestMemoryFS_TestPathsThatNameTheRoot" 1
+ run_test "TestMemoryFS_TestPreadPwrite" 1
+ run_test "TestMemoryFS_TestPreadPwriteErrors" 1
+ run_test "TestMemoryFS_TestPreadPwriteHugeArguments" 1
+ run_test "TestMemoryFS_TestQuota" 1
+ run_test "TestMemoryFS_TestQuotaErrors" 1
+ run_test "TestMemoryFS_TestQuotaRename" 1
+ run_test "TestMemoryFS_TestReadDir" 1
+ run_test "TestMemoryFS_TestRename" 1
+ run_test "TestMemoryFS_TestRenameErrors" 1
+ run_test "TestMemoryFS_TestRenameOpenFile" 1
+ run_test "TestMemoryFS_TestRenameReplace" 1
+ run_test "TestMemoryFS_TestStat" 1
+ run_test "TestMemoryFS_TestStatfs" 1
+ run_test "TestMemoryFS_TestSymlinkAbsolute" 1
+ run_test "TestMemoryFS_TestSymlinkDangling" 1
+ run_test "TestMemoryFS_TestSymlinkErrors" 1
+ run_test "TestMemoryFS_TestSymlinkItself" 1
+ run_test "TestMemoryFS_TestSymlinkLoop" 1
+ run_test "TestMemoryFS_TestSymlinkRelative" 1
+ run_test "TestMemoryFS_TestTruncateBelowOffset" 1
+ run_test "TestMemoryFS_TestTruncateExtendsWithZeros" 1
+ run_test "TestMemoryFS_TestWriteAtHugeOffset" 1
 cd $SCRIPT_DIR/../fsraft
 echo Begin Raft Difficulty 1 Tests - Reliable Network - Clerk_OneClerkThreeServersNoErrors Tests
  run_test "TestClerk_OneClerkThreeServersNoErrors_TestOpenCloseLeastFD" 1
@@ -161,6 +187,32 @@ echo Begin Raft Difficulty 1 Tests - Reliable Network - Clerk_OneClerkThreeServe
  run_test "TestClerk_OneClerkThreeServersNoErrors_TestDeleteCannotDeleteRootDir" 1
  run_test "TestClerk_OneClerkThreeServersNoErrors_TestWrite1Byte" 1
  run_test "TestClerk_OneClerkThreeServersNoErrors_TestWrite10MBytes128Kx80" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestFallocatePunchHole" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestPathNormalization" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestPathValidation" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestPathsThatNameTheRoot" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestPreadPwrite" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestPreadPwriteErrors" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestPreadPwriteHugeArguments" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestQuota" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestQuotaErrors" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestQuotaRename" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestReadDir" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestRename" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestRenameErrors" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestRenameOpenFile" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestRenameReplace" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestStat" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestStatfs" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestSymlinkAbsolute" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestSymlinkDangling" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestSymlinkErrors" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestSymlinkItself" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestSymlinkLoop" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestSymlinkRelative" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestTruncateBelowOffset" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestTruncateExtendsWithZeros" 1
+ run_test "TestClerk_OneClerkThreeServersNoErrors_TestWriteAtHugeOffset" 1
 echo Begin Raft Difficulty 2 Tests - Lossy Network - Clerk_OneClerkFiveServersUnreliableNet Tests
  run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestOpenRWClose64" 1
  run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestOpenTruncate" 1
@@ -220,6 +272,32 @@ echo Begin Raft Difficulty 2 Tests - Lossy Network - Clerk_OneClerkFiveServersUn
  run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestOpenRWClose" 1
  run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestOpenCloseDeleteMaxFD" 1
  run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestOpenBlockMultipleWaiting" 0
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestFallocatePunchHole" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestPathNormalization" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestPathValidation" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestPathsThatNameTheRoot" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestPreadPwrite" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestPreadPwriteErrors" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestPreadPwriteHugeArguments" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestQuota" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestQuotaErrors" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestQuotaRename" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestReadDir" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestRename" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestRenameErrors" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestRenameOpenFile" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestRenameReplace" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestStat" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestStatfs" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestSymlinkAbsolute" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestSymlinkDangling" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestSymlinkErrors" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestSymlinkItself" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestSymlinkLoop" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestSymlinkRelative" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestTruncateBelowOffset" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestTruncateExtendsWithZeros" 1
+ run_test "TestClerk_OneClerkFiveServersUnreliableNet_TestWriteAtHugeOffset" 1
  echo "Begin Singleton Tests"
  run_test "TestOneClerkFiveServersPartition" 1
  run_test "TestKVBasic" 1
@@ -0,0 +1,142 @@
+package webdavfs
+
+import (
+	"encoding/xml"
+	"filesystem"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// The multistatus document PROPFIND answers with. Every property is sent whatever the request body asks for, as if
+// it were <allprop/>.
+type multistatus struct {
+	XMLName   xml.Name   `xml:"D:multistatus"`
+	Namespace string     `xml:"xmlns:D,attr"`
+	Responses []response `xml:"D:response"`
+}
+
+type response struct {
+	Href     string   `xml:"D:href"`
+	Propstat propstat `xml:"D:propstat"`
+}
+
+type propstat struct {
+	Prop   prop   `xml:"D:prop"`
+	Status string `xml:"D:status"`
+}
+
+type prop struct {
+	DisplayName   string       `xml:"D:displayname"`
+	ResourceType  resourceType `xml:"D:resourcetype"`
+	ContentLength *int         `xml:"D:getcontentlength,omitempty"`
+	ContentType   string       `xml:"D:getcontenttype,omitempty"`
+}
+
+type resourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
+
+// Describe filePath and, with Depth: 1, what is directly inside it. Depth: infinity isn't supported, since listing
+// a whole tree could take a very long time.
+func (handler *Handler) propfind(writer http.ResponseWriter, request *http.Request, filePath string) error {
+	depth := request.Header.Get("Depth")
+	if depth == "" || depth == "infinity" {
+		return httpError{http.StatusForbidden, "PROPFIND needs a Depth of 0 or 1"}
+	}
+	if depth != "0" && depth != "1" {
+		return httpError{http.StatusBadRequest, "Depth must be 0, 1 or infinity"}
+	}
+	// the body says which properties to send, but they all are
+	io.Copy(ioutil.Discard, request.Body)
+
+	info, err := handler.fs.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	result := multistatus{Namespace: "DAV:", Responses: []response{describe(filePath, info)}}
+	if depth == "1" && info.IsDir {
+		entries, err := handler.fs.ReadDir(filePath)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			result.Responses = append(result.Responses, describe(path.Join(filePath, entry.Name), entry))
+		}
+	}
+
+	body, err := xml.Marshal(result)
+	if err != nil {
+		return err
+	}
+	writer.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	writer.WriteHeader(http.StatusMultiStatus)
+	writer.Write([]byte(xml.Header))
+	writer.Write(body)
+	return nil
+}
+
+func describe(filePath string, info filesystem.FileInfo) response {
+	p := prop{DisplayName: info.Name}
+	if info.IsDir {
+		p.ResourceType.Collection = &struct{}{}
+	} else {
+		size := info.Size
+		p.ContentLength = &size
+		p.ContentType = "application/octet-stream"
+	}
+	return response{Href: href(filePath, info.IsDir), Propstat: propstat{Prop: p, Status: "HTTP/1.1 200 OK"}}
+}
+
+// Move filePath to the path in the Destination header, replacing what is there unless the Overwrite header is F.
+//
+// The filesystem's Rename moves it all at once, and symbolic links are moved as links. Replacing something Rename
+// can't replace, such as a non-empty directory, deletes it first.
+func (handler *Handler) move(writer http.ResponseWriter, request *http.Request, filePath string) error {
+	destination, err := url.Parse(request.Header.Get("Destination"))
+	if err != nil || request.Header.Get("Destination") == "" {
+		return httpError{http.StatusBadRequest, "MOVE needs a Destination header"}
+	}
+	if destination.Host != "" && destination.Host != request.Host {
+		return httpError{http.StatusBadGateway, "the Destination is on another server"}
+	}
+	destinationPath, ok := cleanPath(destination.Path)
+	if !ok {
+		return httpError{http.StatusBadRequest, "bad Destination path"}
+	}
+	if filePath == "/" || destinationPath == filePath || strings.HasPrefix(destinationPath, filePath+"/") {
+		return httpError{http.StatusForbidden, "can't move something into itself"}
+	}
+
+	if _, err := handler.fs.Lstat(filePath); err != nil {
+		return err
+	}
+	_, err = handler.fs.Lstat(destinationPath)
+	existed := err == nil
+	if existed && request.Header.Get("Overwrite") == "F" {
+		return httpError{http.StatusPreconditionFailed, "the Destination exists and Overwrite is F"}
+	} else if !existed && err != filesystem.NotFound {
+		return err
+	}
+
+	_, err = handler.fs.Rename(filePath, destinationPath)
+	if existed && (err == filesystem.IsDirectory || err == filesystem.DirectoryNotEmpty) {
+		// Rename only replaces a file with a file or an empty directory with a directory, but MOVE replaces anything
+		if err := handler.deleteTree(destinationPath); err != nil {
+			return err
+		}
+		_, err = handler.fs.Rename(filePath, destinationPath)
+	}
+	if err != nil {
+		return err
+	}
+	if existed {
+		writer.WriteHeader(http.StatusNoContent)
+	} else {
+		writer.WriteHeader(http.StatusCreated)
+	}
+	return nil
+}
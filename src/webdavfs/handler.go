@@ -0,0 +1,389 @@
+package webdavfs
+
+import (
+	"ad"
+	"filesystem"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Serves a filesystem over HTTP and the parts of WebDAV (RFC 4918) that browsers, CI runners and WebDAV clients need:
+//
+//	GET, HEAD   read a file, or a byte range of it; on a directory, list it one name per line
+//	PUT         write a whole file, or with a Content-Range header, part of one
+//	DELETE      delete a file, or a directory and everything in it
+//	MKCOL       make a directory
+//	PROPFIND    describe a file or directory, and with Depth: 1, what is in it
+//	MOVE        move a file or directory to the path in the Destination header
+//	OPTIONS     list the methods
+//
+// Errors from the filesystem are sent as HTTP statuses (see statusForError) with the ErrorCode's name as the body.
+// Every request opens and closes its own file descriptor, so a file that is open elsewhere gets 423 Locked.
+//
+// Register it on whatever mux and port the process uses, for example http.Handle("/", handler). It expects to see
+// the whole path, so it can't be mounted under a prefix.
+type Handler struct {
+	fs filesystem.FileSystem // shared by every request, so it has to be safe to use from many goroutines
+}
+
+// Make a handler for fs, usually an fsraft.Clerk.
+func MakeHandler(fs filesystem.FileSystem) *Handler {
+	return &Handler{fs}
+}
+
+// How much to read from or write to the filesystem in one call.
+const chunkSize = 64 * 1024
+
+const allowedMethods = "OPTIONS, GET, HEAD, PUT, DELETE, MKCOL, PROPFIND, MOVE"
+
+var errorCodesToStatuses = map[filesystem.ErrorCode]int{
+	filesystem.NotFound:          http.StatusNotFound,
+	filesystem.IsDirectory:       http.StatusConflict,
+	filesystem.TooManyFDsOpen:    http.StatusServiceUnavailable,
+	filesystem.InactiveFD:        http.StatusInternalServerError,
+	filesystem.IllegalArgument:   http.StatusBadRequest,
+	filesystem.TryAgain:          http.StatusServiceUnavailable,
+	filesystem.IOError:           http.StatusInternalServerError,
+	filesystem.FileTooLarge:      http.StatusRequestEntityTooLarge,
+	filesystem.NoMoreSpace:       http.StatusInsufficientStorage,
+	filesystem.DirectoryNotEmpty: http.StatusConflict,
+	filesystem.AlreadyExists:     http.StatusConflict,
+	filesystem.AlreadyOpen:       http.StatusLocked,
+	filesystem.WriteTooLarge:     http.StatusRequestEntityTooLarge,
+	filesystem.WrongMode:         http.StatusInternalServerError,
+}
+
+// The HTTP status to send for err. Errors that aren't a filesystem.ErrorCode are sent as 500.
+func statusForError(err error) int {
+	if code, isErrorCode := err.(filesystem.ErrorCode); isErrorCode {
+		if status, found := errorCodesToStatuses[code]; found {
+			return status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+func (handler *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	filePath, ok := cleanPath(request.URL.Path)
+	if !ok {
+		http.Error(writer, "bad path", http.StatusBadRequest)
+		return
+	}
+	ad.Debug(ad.TRACE, "%v %v", request.Method, filePath)
+
+	var err error
+	switch request.Method {
+	case http.MethodOptions:
+		writer.Header().Set("Allow", allowedMethods)
+		writer.Header().Set("DAV", "1")
+	case http.MethodGet, http.MethodHead:
+		err = handler.get(writer, request, filePath)
+	case http.MethodPut:
+		err = handler.put(writer, request, filePath)
+	case http.MethodDelete:
+		err = handler.deleteTree(filePath)
+		if err == nil {
+			writer.WriteHeader(http.StatusNoContent)
+		}
+	case "MKCOL":
+		err = handler.mkcol(writer, request, filePath)
+	case "PROPFIND":
+		err = handler.propfind(writer, request, filePath)
+	case "MOVE":
+		err = handler.move(writer, request, filePath)
+	default:
+		writer.Header().Set("Allow", allowedMethods)
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+	}
+
+	if err != nil {
+		status := statusForError(err)
+		if httpErr, isHTTPError := err.(httpError); isHTTPError {
+			status = httpErr.status
+		}
+		ad.Debug(ad.RPC, "%v %v failed with %d: %v", request.Method, filePath, status, err)
+		http.Error(writer, err.Error(), status)
+	}
+}
+
+// An error that isn't the filesystem's, like a malformed header, along with the status to send for it.
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e httpError) Error() string {
+	return e.message
+}
+
+// The filesystem path for a URL path, without a trailing slash. ok is false if it isn't absolute.
+func cleanPath(urlPath string) (filePath string, ok bool) {
+	if !strings.HasPrefix(urlPath, "/") {
+		return "", false
+	}
+	return path.Clean(urlPath), true
+}
+
+// The href for a filesystem path, with a trailing slash if it is a directory.
+func href(filePath string, isDir bool) string {
+	if isDir && filePath != "/" {
+		filePath += "/"
+	}
+	return (&url.URL{Path: filePath}).EscapedPath()
+}
+
+// Send a file, or the one byte range of it that the Range header asks for, or a listing of a directory.
+func (handler *Handler) get(writer http.ResponseWriter, request *http.Request, filePath string) error {
+	info, err := handler.fs.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		return handler.list(writer, request, filePath)
+	}
+
+	fd, err := handler.fs.Open(filePath, filesystem.ReadOnly, 0)
+	if err != nil {
+		return err
+	}
+	defer handler.fs.Close(fd)
+	// the size may have changed before the Open
+	size, err := handler.fs.Seek(fd, 0, filesystem.FromEnd)
+	if err != nil {
+		return err
+	}
+
+	start, length, status := 0, size, http.StatusOK
+	if rangeHeader := request.Header.Get("Range"); rangeHeader != "" {
+		var satisfiable, parsed bool
+		start, length, satisfiable, parsed = parseRange(rangeHeader, size)
+		if parsed && !satisfiable {
+			writer.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			return httpError{http.StatusRequestedRangeNotSatisfiable, "range not satisfiable"}
+		}
+		if parsed {
+			status = http.StatusPartialContent
+			writer.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+		} else {
+			start, length = 0, size
+		}
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(filePath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	writer.Header().Set("Content-Type", contentType)
+	writer.Header().Set("Content-Length", strconv.Itoa(length))
+	writer.Header().Set("Accept-Ranges", "bytes")
+	writer.WriteHeader(status)
+	if request.Method == http.MethodHead {
+		return nil
+	}
+
+	if _, err := handler.fs.Seek(fd, start, filesystem.FromBeginning); err != nil {
+		ad.Debug(ad.WARN, "Couldn't seek in %v after sending the headers: %v", filePath, err)
+		return nil
+	}
+	for remaining := length; remaining > 0; {
+		numBytes := chunkSize
+		if remaining < numBytes {
+			numBytes = remaining
+		}
+		bytesRead, data, err := handler.fs.Read(fd, numBytes)
+		if err != nil || bytesRead == 0 {
+			// too late to send an error status, so cut the response short
+			ad.Debug(ad.WARN, "Couldn't read %v after sending the headers: %v", filePath, err)
+			return nil
+		}
+		if _, err := writer.Write(data[:bytesRead]); err != nil {
+			return nil // the client went away
+		}
+		remaining -= bytesRead
+	}
+	return nil
+}
+
+// Parse a Range header of a single byte range: "bytes=first-last", "bytes=first-" or "bytes=-suffixLength". parsed is
+// false for anything else, including several ranges, which are then ignored in favor of sending the whole file.
+func parseRange(header string, size int) (start int, length int, satisfiable bool, parsed bool) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	dash := strings.Index(spec, "-")
+	if spec == header || strings.Contains(spec, ",") || dash < 0 {
+		return 0, 0, false, false
+	}
+	first, last := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+
+	if first == "" {
+		suffixLength, err := strconv.Atoi(last)
+		if err != nil || suffixLength < 0 {
+			return 0, 0, false, false
+		}
+		if suffixLength == 0 || size == 0 {
+			return 0, 0, false, true
+		}
+		if suffixLength > size {
+			suffixLength = size
+		}
+		return size - suffixLength, suffixLength, true, true
+	}
+
+	start, err := strconv.Atoi(first)
+	if err != nil || start < 0 {
+		return 0, 0, false, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.Atoi(last)
+		if err != nil || end < start {
+			return 0, 0, false, false
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, false, true
+	}
+	return start, end - start + 1, true, true
+}
+
+// Send a plain-text listing of a directory, one name per line, with a trailing slash on directories.
+func (handler *Handler) list(writer http.ResponseWriter, request *http.Request, filePath string) error {
+	entries, err := handler.fs.ReadDir(filePath)
+	if err != nil {
+		return err
+	}
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if request.Method == http.MethodHead {
+		return nil
+	}
+	for _, entry := range entries {
+		if entry.IsDir {
+			fmt.Fprintf(writer, "%s/\n", entry.Name)
+		} else {
+			fmt.Fprintf(writer, "%s\n", entry.Name)
+		}
+	}
+	return nil
+}
+
+// Write the request body to a file, creating it if need be. Without a Content-Range header the body replaces the
+// file; with "Content-Range: bytes first-last/*" (or with the total size instead of *), it is written at first.
+func (handler *Handler) put(writer http.ResponseWriter, request *http.Request, filePath string) error {
+	offset, flags := 0, filesystem.Create|filesystem.Truncate
+	if contentRange := request.Header.Get("Content-Range"); contentRange != "" {
+		first, last, ok := parseContentRange(contentRange)
+		if !ok || request.ContentLength != int64(last-first+1) {
+			return httpError{http.StatusBadRequest, "Content-Range must be bytes first-last/* and match the body"}
+		}
+		offset, flags = first, filesystem.Create
+	}
+
+	_, statErr := handler.fs.Stat(filePath)
+	created := statErr == filesystem.NotFound
+	fd, err := handler.fs.Open(filePath, filesystem.WriteOnly, flags)
+	if err != nil {
+		return err
+	}
+	_, err = handler.fs.Seek(fd, offset, filesystem.FromBeginning)
+	if err == nil {
+		err = copyIn(handler.fs, fd, request.Body)
+	}
+	if _, closeErr := handler.fs.Close(fd); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if created {
+		writer.WriteHeader(http.StatusCreated)
+	} else {
+		writer.WriteHeader(http.StatusNoContent)
+	}
+	return nil
+}
+
+// Parse "bytes first-last/total", where total may be *.
+func parseContentRange(header string) (first int, last int, ok bool) {
+	spec := strings.TrimPrefix(header, "bytes ")
+	slash := strings.Index(spec, "/")
+	if spec == header || slash < 0 {
+		return 0, 0, false
+	}
+	bounds := strings.SplitN(spec[:slash], "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, false
+	}
+	first, err := strconv.Atoi(bounds[0])
+	if err != nil || first < 0 {
+		return 0, 0, false
+	}
+	last, err = strconv.Atoi(bounds[1])
+	if err != nil || last < first {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
+// Write everything from reader to fd, a chunk at a time.
+func copyIn(fs filesystem.FileSystem, fd int, reader io.Reader) error {
+	buffer := make([]byte, chunkSize)
+	for {
+		n, readErr := io.ReadFull(reader, buffer)
+		if n > 0 {
+			if _, err := fs.Write(fd, n, buffer[:n]); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if readErr != nil {
+			return httpError{http.StatusBadRequest, "couldn't read the request body"}
+		}
+	}
+}
+
+func (handler *Handler) mkcol(writer http.ResponseWriter, request *http.Request, filePath string) error {
+	if request.ContentLength > 0 {
+		return httpError{http.StatusUnsupportedMediaType, "MKCOL doesn't take a body"}
+	}
+	if _, err := handler.fs.Mkdir(filePath); err != nil {
+		return err
+	}
+	writer.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// Delete a file or a symbolic link, or a directory after everything in it. A link to a directory is deleted without
+// touching what is in the directory.
+func (handler *Handler) deleteTree(filePath string) error {
+	if filePath == "/" {
+		return filesystem.IllegalArgument
+	}
+	info, err := handler.fs.Lstat(filePath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		entries, err := handler.fs.ReadDir(filePath)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := handler.deleteTree(path.Join(filePath, entry.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = handler.fs.Delete(filePath)
+	return err
+}
@@ -0,0 +1,290 @@
+package webdavfs
+
+import (
+	"ad"
+	"encoding/xml"
+	"filesystem"
+	"fsraft"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	if _, isSet := os.LookupEnv("DFS_DEFAULT_DEBUG_LEVEL"); !isSet {
+		ad.SetDebugLevel(ad.WARN)
+	}
+	os.Exit(m.Run())
+}
+
+// Three FileServers, with an HTTP server in front of a clerk.
+type testCluster struct {
+	*fsraft.TestCluster
+	server *httptest.Server
+}
+
+func startTestCluster(t *testing.T) *testCluster {
+	cluster := &testCluster{TestCluster: fsraft.StartTestCluster(t, 3, fsraft.DefaultFileServerConfig())}
+	cluster.server = httptest.NewServer(MakeHandler(cluster.MakeClerk()))
+	return cluster
+}
+
+func (cluster *testCluster) stop() {
+	cluster.server.Close()
+}
+
+// Make a request with the given headers, given as name and value pairs, and return the status and body.
+func (cluster *testCluster) do(t *testing.T, method string, path string, body string, headers ...string) (int,
+	http.Header, string) {
+	request, err := http.NewRequest(method, cluster.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("%v %v failed: %v", method, path, err)
+	}
+	defer response.Body.Close()
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("couldn't read the response to %v %v: %v", method, path, err)
+	}
+	return response.StatusCode, response.Header, string(responseBody)
+}
+
+// Make a request and fail unless it gets the expected status. Returns the body.
+func (cluster *testCluster) expect(t *testing.T, expectedStatus int, method string, path string, body string,
+	headers ...string) string {
+	status, _, responseBody := cluster.do(t, method, path, body, headers...)
+	if status != expectedStatus {
+		t.Fatalf("%v %v returned %d (%q), expected %d", method, path, status, responseBody, expectedStatus)
+	}
+	return responseBody
+}
+
+func TestPutGet(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+
+	cluster.expect(t, http.StatusCreated, "PUT", "/hello.txt", "hello world")
+	if body := cluster.expect(t, http.StatusOK, "GET", "/hello.txt", ""); body != "hello world" {
+		t.Fatalf("GET returned %q, expected %q", body, "hello world")
+	}
+	cluster.expect(t, http.StatusNoContent, "PUT", "/hello.txt", "bye")
+	if body := cluster.expect(t, http.StatusOK, "GET", "/hello.txt", ""); body != "bye" {
+		t.Fatalf("GET after replacing returned %q, expected %q", body, "bye")
+	}
+	status, header, body := cluster.do(t, "HEAD", "/hello.txt", "")
+	if status != http.StatusOK || header.Get("Content-Length") != "3" || body != "" {
+		t.Fatalf("HEAD returned (%d, Content-Length %q, %q)", status, header.Get("Content-Length"), body)
+	}
+
+	// a partial write goes where the Content-Range says, and leaves the rest alone
+	cluster.expect(t, http.StatusCreated, "PUT", "/part.txt", "0123456789")
+	cluster.expect(t, http.StatusNoContent, "PUT", "/part.txt", "abc", "Content-Range", "bytes 2-4/*")
+	cluster.expect(t, http.StatusNoContent, "PUT", "/part.txt", "XY", "Content-Range", "bytes 10-11/12")
+	if body := cluster.expect(t, http.StatusOK, "GET", "/part.txt", ""); body != "01abc56789XY" {
+		t.Fatalf("GET after partial writes returned %q, expected %q", body, "01abc56789XY")
+	}
+	cluster.expect(t, http.StatusBadRequest, "PUT", "/part.txt", "abc", "Content-Range", "bytes 2-10/*")
+
+	cluster.expect(t, http.StatusNotFound, "GET", "/missing.txt", "")
+	cluster.expect(t, http.StatusNotFound, "PUT", "/no/such/dir.txt", "data")
+	cluster.expect(t, http.StatusMethodNotAllowed, "PATCH", "/hello.txt", "")
+}
+
+func TestRanges(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+	cluster.expect(t, http.StatusCreated, "PUT", "/digits", "0123456789")
+
+	ranges := []struct {
+		header       string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"bytes=2-5", http.StatusPartialContent, "2345", "bytes 2-5/10"},
+		{"bytes=7-", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"bytes=-3", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"bytes=8-100", http.StatusPartialContent, "89", "bytes 8-9/10"},
+		{"bytes=-100", http.StatusPartialContent, "0123456789", "bytes 0-9/10"},
+		{"bytes=10-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"bytes=0-1,4-5", http.StatusOK, "0123456789", ""},
+		{"lines=1-2", http.StatusOK, "0123456789", ""},
+	}
+	for _, r := range ranges {
+		status, header, body := cluster.do(t, "GET", "/digits", "", "Range", r.header)
+		if status != r.status || header.Get("Content-Range") != r.contentRange {
+			t.Errorf("GET with Range %q returned (%d, Content-Range %q), expected (%d, %q)", r.header, status,
+				header.Get("Content-Range"), r.status, r.contentRange)
+		}
+		if status != http.StatusRequestedRangeNotSatisfiable && body != r.body {
+			t.Errorf("GET with Range %q returned %q, expected %q", r.header, body, r.body)
+		}
+	}
+}
+
+func TestCollections(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+
+	cluster.expect(t, http.StatusCreated, "MKCOL", "/docs", "")
+	cluster.expect(t, http.StatusConflict, "MKCOL", "/docs", "")
+	cluster.expect(t, http.StatusNotFound, "MKCOL", "/no/such", "")
+	cluster.expect(t, http.StatusCreated, "MKCOL", "/docs/sub/", "")
+	cluster.expect(t, http.StatusCreated, "PUT", "/docs/a b.txt", "12345")
+	if body := cluster.expect(t, http.StatusOK, "GET", "/docs/", ""); body != "a b.txt\nsub/\n" {
+		t.Fatalf("GET of a directory returned %q", body)
+	}
+
+	body := cluster.expect(t, http.StatusMultiStatus, "PROPFIND", "/docs", "", "Depth", "1")
+	var result struct {
+		Responses []struct {
+			Href          string    `xml:"href"`
+			DisplayName   string    `xml:"propstat>prop>displayname"`
+			Collection    *struct{} `xml:"propstat>prop>resourcetype>collection"`
+			ContentLength string    `xml:"propstat>prop>getcontentlength"`
+		} `xml:"response"`
+	}
+	if err := xml.Unmarshal([]byte(body), &result); err != nil {
+		t.Fatalf("couldn't parse the PROPFIND response %q: %v", body, err)
+	}
+	type entry struct {
+		href, name, length string
+		isDir              bool
+	}
+	got := make([]entry, 0)
+	for _, r := range result.Responses {
+		got = append(got, entry{r.Href, r.DisplayName, r.ContentLength, r.Collection != nil})
+	}
+	expected := []entry{{"/docs/", "docs", "", true}, {"/docs/a%20b.txt", "a b.txt", "5", false},
+		{"/docs/sub/", "sub", "", true}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("PROPFIND returned %+v, expected %+v", got, expected)
+	}
+	body = cluster.expect(t, http.StatusMultiStatus, "PROPFIND", "/docs", "", "Depth", "0")
+	if strings.Count(body, "<D:response>") != 1 {
+		t.Fatalf("PROPFIND with Depth 0 returned %q, expected one response", body)
+	}
+	cluster.expect(t, http.StatusForbidden, "PROPFIND", "/docs", "", "Depth", "infinity")
+	cluster.expect(t, http.StatusNotFound, "PROPFIND", "/nothing", "", "Depth", "0")
+
+	cluster.expect(t, http.StatusNoContent, "DELETE", "/docs", "")
+	cluster.expect(t, http.StatusNotFound, "GET", "/docs/a b.txt", "")
+	cluster.expect(t, http.StatusNotFound, "DELETE", "/docs", "")
+	cluster.expect(t, http.StatusBadRequest, "DELETE", "/", "")
+}
+
+func TestMove(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+
+	cluster.expect(t, http.StatusCreated, "PUT", "/old.txt", "contents")
+	cluster.expect(t, http.StatusCreated, "MOVE", "/old.txt", "", "Destination", cluster.server.URL+"/new.txt")
+	cluster.expect(t, http.StatusNotFound, "GET", "/old.txt", "")
+	if body := cluster.expect(t, http.StatusOK, "GET", "/new.txt", ""); body != "contents" {
+		t.Fatalf("GET of a moved file returned %q, expected %q", body, "contents")
+	}
+
+	cluster.expect(t, http.StatusCreated, "PUT", "/other.txt", "other")
+	cluster.expect(t, http.StatusPreconditionFailed, "MOVE", "/other.txt", "", "Destination", "/new.txt",
+		"Overwrite", "F")
+	cluster.expect(t, http.StatusNoContent, "MOVE", "/other.txt", "", "Destination", "/new.txt")
+	if body := cluster.expect(t, http.StatusOK, "GET", "/new.txt", ""); body != "other" {
+		t.Fatalf("GET of an overwritten file returned %q, expected %q", body, "other")
+	}
+
+	cluster.expect(t, http.StatusCreated, "MKCOL", "/dir", "")
+	cluster.expect(t, http.StatusCreated, "MKCOL", "/dir/inner", "")
+	cluster.expect(t, http.StatusCreated, "PUT", "/dir/inner/file", "deep")
+	cluster.expect(t, http.StatusForbidden, "MOVE", "/dir", "", "Destination", "/dir/inner/dir")
+	cluster.expect(t, http.StatusCreated, "MOVE", "/dir", "", "Destination", "/moved")
+	cluster.expect(t, http.StatusNotFound, "GET", "/dir", "")
+	if body := cluster.expect(t, http.StatusOK, "GET", "/moved/inner/file", ""); body != "deep" {
+		t.Fatalf("GET in a moved directory returned %q, expected %q", body, "deep")
+	}
+	cluster.expect(t, http.StatusBadRequest, "MOVE", "/moved", "")
+
+	// overwriting replaces a non-empty directory, or a file with a directory, as a whole
+	cluster.expect(t, http.StatusCreated, "MKCOL", "/replacement", "")
+	cluster.expect(t, http.StatusNoContent, "MOVE", "/replacement", "", "Destination", "/moved")
+	cluster.expect(t, http.StatusNotFound, "GET", "/moved/inner/file", "")
+	cluster.expect(t, http.StatusNoContent, "MOVE", "/moved", "", "Destination", "/new.txt")
+	cluster.expect(t, http.StatusNotFound, "GET", "/moved", "")
+}
+
+// Symbolic links are moved and deleted as links, without touching what they point to.
+func TestMoveAndDeleteSymlinks(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+	clerk := cluster.MakeClerk()
+
+	cluster.expect(t, http.StatusCreated, "MKCOL", "/dir", "")
+	cluster.expect(t, http.StatusCreated, "PUT", "/dir/file", "contents")
+	if _, err := clerk.Symlink("/dir", "/link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if _, err := clerk.Symlink("missing", "/dangling"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	cluster.expect(t, http.StatusCreated, "MOVE", "/link", "", "Destination", "/moved")
+	cluster.expect(t, http.StatusCreated, "MOVE", "/dangling", "", "Destination", "/stillDangling")
+	for linkPath, expected := range map[string]string{"/moved": "/dir", "/stillDangling": "missing"} {
+		if target, err := clerk.Readlink(linkPath); err != nil || target != expected {
+			t.Fatalf("after MOVE, Readlink(%v) returned (%q, %v), expected %q", linkPath, target, err, expected)
+		}
+	}
+
+	cluster.expect(t, http.StatusNoContent, "DELETE", "/moved", "")
+	if _, err := clerk.Lstat("/moved"); err != filesystem.NotFound {
+		t.Fatalf("the link is still there after DELETE: %v", err)
+	}
+	if body := cluster.expect(t, http.StatusOK, "GET", "/dir/file", ""); body != "contents" {
+		t.Fatalf("after deleting a link to it, GET in the directory returned %q, expected %q", body, "contents")
+	}
+}
+
+// A file that some other clerk has open is Locked, and so is writing over it.
+func TestLocked(t *testing.T) {
+	cluster := startTestCluster(t)
+	defer cluster.stop()
+	cluster.expect(t, http.StatusCreated, "PUT", "/busy", "data")
+
+	clerk := cluster.MakeClerk()
+	fd, err := clerk.Open("/busy", filesystem.ReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if body := cluster.expect(t, http.StatusLocked, "GET", "/busy", ""); body != "AlreadyOpen\n" {
+		t.Fatalf("GET of an open file returned %q, expected the ErrorCode's name", body)
+	}
+	cluster.expect(t, http.StatusLocked, "PUT", "/busy", "more")
+	if _, err := clerk.Close(fd); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	cluster.expect(t, http.StatusOK, "GET", "/busy", "")
+}
+
+func TestStatusForError(t *testing.T) {
+	for code := filesystem.NotFound; code <= filesystem.WrongMode; code++ {
+		if _, found := errorCodesToStatuses[code]; !found {
+			t.Errorf("%v has no status", code)
+		}
+	}
+	expected := map[error]int{filesystem.NotFound: 404, filesystem.AlreadyExists: 409, filesystem.AlreadyOpen: 423,
+		os.ErrClosed: 500}
+	for err, status := range expected {
+		if statusForError(err) != status {
+			t.Errorf("%v got status %d, expected %d", err, statusForError(err), status)
+		}
+	}
+}